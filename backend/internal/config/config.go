@@ -23,6 +23,7 @@ type Config struct {
 	DBSSLMode  string
 
 	// Redis Configuration
+	RedisEnabled  bool
 	RedisHost     string
 	RedisPort     string
 	RedisPassword string
@@ -33,6 +34,18 @@ type Config struct {
 	JWTExpiry        string
 	JWTRefreshExpiry string
 
+	// JWT signing keys: algorithm ("EdDSA", "RS256" or "HS256") and rotation interval ("0" disables rotation)
+	JWTSigningAlgorithm    string
+	JWTKeyRotationInterval string
+	// Until when tokens signed before the keyring (RFC 3339) are still accepted; empty rejects them
+	JWTLegacyTokensUntil string
+
+	// Token revocation backend: "auto", "redis", "postgres" or "memory"
+	TokenRevocationBackend string
+
+	// Login attempt and lockout backend: "auto", "redis", "postgres" or "memory"
+	LoginAttemptBackend string
+
 	// CORS Configuration
 	CORSAllowedOrigins []string
 	CORSAllowedMethods []string
@@ -104,6 +117,19 @@ type Config struct {
 	RokuAPIKey    string
 	RokuSecretKey string
 	RokuAppID     string
+
+	// OpenID Connect sign-in providers and the callback URL registered with them
+	OIDCProviders   []OIDCProvider
+	OIDCRedirectURL string
+}
+
+// OIDCProvider configures one OpenID Connect sign-in provider
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
 }
 
 // New creates a new Config instance with values from environment variables
@@ -124,6 +150,7 @@ func New() *Config {
 		DBSSLMode:  getEnv("DB_SSL_MODE", "disable"),
 
 		// Redis Configuration
+		RedisEnabled:  getEnvBool("REDIS_ENABLED", false),
 		RedisHost:     getEnv("REDIS_HOST", "localhost"),
 		RedisPort:     getEnv("REDIS_PORT", "6379"),
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
@@ -134,6 +161,14 @@ func New() *Config {
 		JWTExpiry:        getEnv("JWT_EXPIRY", "24h"),
 		JWTRefreshExpiry: getEnv("JWT_REFRESH_EXPIRY", "168h"),
 
+		JWTSigningAlgorithm:    getEnv("JWT_SIGNING_ALGORITHM", "EdDSA"),
+		JWTKeyRotationInterval: getEnv("JWT_KEY_ROTATION_INTERVAL", "720h"),
+		JWTLegacyTokensUntil:   getEnv("JWT_LEGACY_TOKENS_UNTIL", ""),
+
+		TokenRevocationBackend: getEnv("TOKEN_REVOCATION_BACKEND", "auto"),
+
+		LoginAttemptBackend: getEnv("LOGIN_ATTEMPT_BACKEND", "auto"),
+
 		// CORS Configuration
 		CORSAllowedOrigins: getEnvSlice("CORS_ALLOWED_ORIGINS", []string{"http://localhost:5173", "http://localhost:4173"}),
 		CORSAllowedMethods: getEnvSlice("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
@@ -205,6 +240,10 @@ func New() *Config {
 		RokuAPIKey:    getEnv("ROKU_API_KEY", ""),
 		RokuSecretKey: getEnv("ROKU_SECRET_KEY", ""),
 		RokuAppID:     getEnv("ROKU_APP_ID", ""),
+
+		// OpenID Connect Configuration
+		OIDCProviders:   getOIDCProviders(),
+		OIDCRedirectURL: getEnv("OIDC_REDIRECT_URL", "http://localhost:5173/auth/callback"),
 	}
 }
 
@@ -250,3 +289,30 @@ func getEnvSlice(key string, defaultValue []string) []string {
 	}
 	return defaultValue
 }
+
+// getOIDCProviders reads the providers named in OIDC_PROVIDERS (e.g. "google,apple"),
+// each configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and optional _SCOPES.
+// Providers without an issuer or client ID are skipped.
+func getOIDCProviders() []OIDCProvider {
+	providers := []OIDCProvider{}
+	for _, name := range getEnvSlice("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getEnvSlice(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
@@ -145,8 +145,32 @@ func (r *RealDataSource) GetYouTubeChannelInfo() (*ChannelInfo, error) {
 }
 
 func (r *RealDataSource) SearchYouTubeVideos(query string, limit int) ([]database.YouTubeVideo, error) {
-	// Implementation for real database search
-	return nil, fmt.Errorf("real search not implemented yet")
+	// Full-text search over the youtube_videos table
+	results, err := r.db.SearchVideos(database.VideoSearchParams{
+		Query:      query,
+		MediaTypes: []string{database.PlaybackMediaYouTube},
+		Limit:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	videos := make([]database.YouTubeVideo, 0, len(results.Results))
+	for _, result := range results.Results {
+		videos = append(videos, database.YouTubeVideo{
+			ID:           result.MediaID,
+			Title:        result.Title,
+			Description:  result.Description,
+			ThumbnailURL: result.ThumbnailURL,
+			VideoURL:     "https://www.youtube.com/watch?v=" + result.MediaID,
+			EmbedURL:     "https://www.youtube.com/embed/" + result.MediaID,
+			Duration:     fmt.Sprintf("PT%dS", result.Duration),
+			ViewCount:    result.ViewCount,
+			Category:     result.Category,
+			CreatedAt:    result.CreatedAt,
+		})
+	}
+	return videos, nil
 }
 
 func (r *RealDataSource) GetYouTubeVideosByCategory(category string, limit int) ([]database.YouTubeVideo, error) {
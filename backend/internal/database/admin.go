@@ -73,12 +73,6 @@ func (db *DB) UpdateUserRole(userID int, role string) error {
 	return err
 }
 
-// DeleteUser deletes a user and all associated data
-func (db *DB) DeleteUser(userID int) error {
-	_, err := db.Exec(`DELETE FROM users WHERE id = $1`, userID)
-	return err
-}
-
 // GetUserCount returns the total number of users
 func (db *DB) GetUserCount() (int, error) {
 	var count int
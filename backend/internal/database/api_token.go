@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API token kinds
+const (
+	APITokenKindPersonal = "personal"
+	APITokenKindAPIKey   = "api_key"
+)
+
+// APIToken represents a personal access token or API key. Only the hash of the secret is stored.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Kind       string
+	Name       string
+	Prefix     string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt sql.NullTime
+	LastUsedIP sql.NullString
+	CreatedBy  sql.NullInt64
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time
+
+	// Owner details, populated by GetAPITokenByPrefix
+	OwnerEmail         string
+	OwnerRole          string
+	OwnerEmailVerified bool
+	OwnerActive        bool
+}
+
+const apiTokenColumns = `t.id, t.user_id, t.kind, t.name, t.prefix, t.token_hash, COALESCE(t.scopes, '{}'), t.expires_at,
+	t.last_used_at, t.last_used_ip, t.created_by, t.revoked_at, t.created_at`
+
+// scanAPIToken scans a row selected with apiTokenColumns followed by any extra destinations
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }, extra ...interface{}) (*APIToken, error) {
+	token := &APIToken{}
+	dest := []interface{}{&token.ID, &token.UserID, &token.Kind, &token.Name, &token.Prefix, &token.TokenHash,
+		pq.Array(&token.Scopes), &token.ExpiresAt, &token.LastUsedAt, &token.LastUsedIP, &token.CreatedBy,
+		&token.RevokedAt, &token.CreatedAt}
+	if err := scanner.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// CreateAPIToken stores a new personal access token or API key
+func (db *DB) CreateAPIToken(token *APIToken) error {
+	return db.QueryRow(`
+		INSERT INTO api_tokens (user_id, kind, name, prefix, token_hash, scopes, expires_at, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		RETURNING id, created_at
+	`, token.UserID, token.Kind, token.Name, token.Prefix, token.TokenHash, pq.Array(token.Scopes), token.ExpiresAt, token.CreatedBy).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetAPITokenByPrefix retrieves a token and its owner by the token's public prefix
+func (db *DB) GetAPITokenByPrefix(prefix string) (*APIToken, error) {
+	var ownerEmail, ownerRole string
+	var ownerEmailVerified, ownerActive bool
+	row := db.QueryRow(`
+		SELECT `+apiTokenColumns+`, u.email, COALESCE(u.role, 'user'), COALESCE(u.email_verified, FALSE), COALESCE(u.is_active, TRUE)
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.prefix = $1
+	`, prefix)
+	token, err := scanAPIToken(row, &ownerEmail, &ownerRole, &ownerEmailVerified, &ownerActive)
+	if err != nil {
+		return nil, err
+	}
+	token.OwnerEmail = ownerEmail
+	token.OwnerRole = ownerRole
+	token.OwnerEmailVerified = ownerEmailVerified
+	token.OwnerActive = ownerActive
+	return token, nil
+}
+
+// GetAPITokens retrieves tokens of a kind, optionally limited to one owner (userID 0 lists all owners).
+// Revoked and expired tokens are included so their history stays visible.
+func (db *DB) GetAPITokens(kind string, userID int) ([]*APIToken, error) {
+	rows, err := db.Query(`
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens t
+		WHERE t.kind = $1 AND ($2 = 0 OR t.user_id = $2)
+		ORDER BY t.created_at DESC
+	`, kind, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes a token of a kind, optionally only if it belongs to userID (0 matches any owner).
+// It returns the revoked token, or sql.ErrNoRows if no active token matched.
+func (db *DB) RevokeAPIToken(tokenID int, kind string, userID int) (*APIToken, error) {
+	return scanAPIToken(db.QueryRow(`
+		UPDATE api_tokens t SET revoked_at = NOW()
+		WHERE t.id = $1 AND t.kind = $2 AND ($3 = 0 OR t.user_id = $3) AND t.revoked_at IS NULL
+		RETURNING `+apiTokenColumns, tokenID, kind, userID))
+}
+
+// RevokeUserAPITokens revokes every active token owned by a user
+func (db *DB) RevokeUserAPITokens(userID int) error {
+	_, err := db.Exec(`UPDATE api_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// TouchAPIToken records token usage. Updates are throttled to one per minute per token.
+func (db *DB) TouchAPIToken(tokenID int, ipAddress string) error {
+	_, err := db.Exec(`
+		UPDATE api_tokens SET last_used_at = NOW(), last_used_ip = $1
+		WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, ipAddress, tokenID)
+	return err
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// BunnySyncJob is a run of the Bunny Stream library synchronization. Status is queued,
+// running, completed or failed. In a dry run the counts describe the changes that
+// would have been made.
+type BunnySyncJob struct {
+	ID                int             `json:"id"`
+	Status            string          `json:"status"`
+	DryRun            bool            `json:"dry_run"`
+	MirrorCollections bool            `json:"mirror_collections"`
+	RequestedBy       sql.NullInt64   `json:"-"`
+	Phase             string          `json:"phase,omitempty"`
+	TotalItems        int             `json:"total_items"`
+	ProcessedItems    int             `json:"processed_items"`
+	Created           int             `json:"created"`
+	Updated           int             `json:"updated"`
+	Deleted           int             `json:"deleted"`
+	Unchanged         int             `json:"unchanged"`
+	Failed            int             `json:"failed"`
+	Changes           json.RawMessage `json:"changes"`
+	Attempts          int             `json:"attempts"`
+	LastError         string          `json:"last_error,omitempty"`
+	NextAttemptAt     time.Time       `json:"next_attempt_at"`
+	StartedAt         sql.NullTime    `json:"-"`
+	FinishedAt        sql.NullTime    `json:"-"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+// BunnyLinkedVideo holds the fields of a video that are kept in step with Bunny Stream
+type BunnyLinkedVideo struct {
+	ID                   int
+	BunnyVideoID         string
+	Title                string
+	Status               string
+	Duration             int
+	FileSize             int64
+	ThumbnailURL         string
+	Resolution           string
+	AvailableResolutions string
+	CreatedAt            time.Time
+}
+
+const bunnySyncJobColumns = `id, status, dry_run, requested_by, COALESCE(phase, ''), total_items, processed_items, created_count, updated_count, deleted_count, unchanged_count, failed_count, changes, attempts, COALESCE(last_error, ''), next_attempt_at, started_at, finished_at, created_at, updated_at, mirror_collections`
+
+func scanBunnySyncJob(row interface{ Scan(...interface{}) error }) (*BunnySyncJob, error) {
+	job := &BunnySyncJob{}
+	var changes []byte
+	err := row.Scan(&job.ID, &job.Status, &job.DryRun, &job.RequestedBy, &job.Phase, &job.TotalItems, &job.ProcessedItems, &job.Created, &job.Updated, &job.Deleted, &job.Unchanged, &job.Failed, &changes, &job.Attempts, &job.LastError, &job.NextAttemptAt, &job.StartedAt, &job.FinishedAt, &job.CreatedAt, &job.UpdatedAt, &job.MirrorCollections)
+	if err != nil {
+		return nil, err
+	}
+	job.Changes = changes
+	return job, nil
+}
+
+// CreateBunnySyncJob queues a sync. If a sync is already queued or running, that job
+// is returned instead and created is false.
+func (db *DB) CreateBunnySyncJob(dryRun, mirrorCollections bool, requestedBy *int) (*BunnySyncJob, bool, error) {
+	job, err := scanBunnySyncJob(db.QueryRow(`
+		INSERT INTO bunny_sync_jobs (status, dry_run, mirror_collections, requested_by, next_attempt_at, created_at, updated_at)
+		VALUES ('queued', $1, $2, $3, NOW(), NOW(), NOW())
+		ON CONFLICT DO NOTHING
+		RETURNING `+bunnySyncJobColumns, dryRun, mirrorCollections, requestedBy))
+	if err == nil {
+		return job, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	job, err = db.GetActiveBunnySyncJob()
+	if err != nil {
+		return nil, false, err
+	}
+	return job, false, nil
+}
+
+// GetBunnySyncJob retrieves a sync job by ID
+func (db *DB) GetBunnySyncJob(id int) (*BunnySyncJob, error) {
+	return scanBunnySyncJob(db.QueryRow(`SELECT `+bunnySyncJobColumns+` FROM bunny_sync_jobs WHERE id = $1`, id))
+}
+
+// GetActiveBunnySyncJob retrieves the sync that is queued or running
+func (db *DB) GetActiveBunnySyncJob() (*BunnySyncJob, error) {
+	return scanBunnySyncJob(db.QueryRow(`SELECT ` + bunnySyncJobColumns + ` FROM bunny_sync_jobs WHERE status IN ('queued', 'running')`))
+}
+
+// GetBunnySyncJobs lists sync jobs, newest first
+func (db *DB) GetBunnySyncJobs(limit, offset int) ([]*BunnySyncJob, error) {
+	rows, err := db.Query(`
+		SELECT `+bunnySyncJobColumns+` FROM bunny_sync_jobs
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*BunnySyncJob
+	for rows.Next() {
+		job, err := scanBunnySyncJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimBunnySyncJob marks the queued sync that is due as running and returns it, or
+// sql.ErrNoRows if there is none
+func (db *DB) ClaimBunnySyncJob() (*BunnySyncJob, error) {
+	return scanBunnySyncJob(db.QueryRow(`
+		UPDATE bunny_sync_jobs SET
+			status = 'running',
+			phase = NULL,
+			total_items = 0,
+			processed_items = 0,
+			created_count = 0,
+			updated_count = 0,
+			deleted_count = 0,
+			unchanged_count = 0,
+			failed_count = 0,
+			changes = '[]',
+			attempts = attempts + 1,
+			started_at = NOW(),
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM bunny_sync_jobs
+			WHERE status = 'queued' AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + bunnySyncJobColumns))
+}
+
+// UpdateBunnySyncJobProgress records a running sync's phase and counts
+func (db *DB) UpdateBunnySyncJobProgress(job *BunnySyncJob) error {
+	_, err := db.Exec(`
+		UPDATE bunny_sync_jobs SET
+			phase = $2, total_items = $3, processed_items = $4, created_count = $5, updated_count = $6,
+			deleted_count = $7, unchanged_count = $8, failed_count = $9, updated_at = NOW()
+		WHERE id = $1
+	`, job.ID, job.Phase, job.TotalItems, job.ProcessedItems, job.Created, job.Updated, job.Deleted, job.Unchanged, job.Failed)
+	return err
+}
+
+// CompleteBunnySyncJob records a finished sync and the changes it made
+func (db *DB) CompleteBunnySyncJob(job *BunnySyncJob) error {
+	if err := db.UpdateBunnySyncJobProgress(job); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE bunny_sync_jobs SET status = 'completed', phase = NULL, changes = $2, last_error = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, job.ID, string(job.Changes))
+	return err
+}
+
+// RetryBunnySyncJob queues a sync that failed to run again at nextAttempt
+func (db *DB) RetryBunnySyncJob(id int, reason string, nextAttempt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE bunny_sync_jobs SET status = 'queued', last_error = $2, next_attempt_at = $3, updated_at = NOW()
+		WHERE id = $1
+	`, id, reason, nextAttempt)
+	return err
+}
+
+// FailBunnySyncJob marks a sync as failed for good
+func (db *DB) FailBunnySyncJob(id int, reason string) error {
+	_, err := db.Exec(`
+		UPDATE bunny_sync_jobs SET status = 'failed', last_error = $2, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id, reason)
+	return err
+}
+
+// RequeueStaleBunnySyncJobs queues syncs again whose worker stopped reporting progress,
+// for example because the server restarted mid-run
+func (db *DB) RequeueStaleBunnySyncJobs(staleAfter time.Duration) error {
+	_, err := db.Exec(`
+		UPDATE bunny_sync_jobs SET status = 'queued', next_attempt_at = NOW(), last_error = 'sync was interrupted', updated_at = NOW()
+		WHERE status = 'running' AND updated_at < $1
+	`, time.Now().Add(-staleAfter))
+	return err
+}
+
+// GetBunnyLinkedVideos returns every video that refers to a Bunny Stream video
+func (db *DB) GetBunnyLinkedVideos() ([]*BunnyLinkedVideo, error) {
+	rows, err := db.Query(`
+		SELECT id, bunny_video_id, title, COALESCE(status, ''), COALESCE(duration, 0), COALESCE(file_size, 0),
+			COALESCE(thumbnail_url, ''), COALESCE(resolution, ''), COALESCE(available_resolutions, ''), created_at
+		FROM videos
+		WHERE bunny_video_id IS NOT NULL AND bunny_video_id != ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var videos []*BunnyLinkedVideo
+	for rows.Next() {
+		video := &BunnyLinkedVideo{}
+		if err := rows.Scan(&video.ID, &video.BunnyVideoID, &video.Title, &video.Status, &video.Duration, &video.FileSize, &video.ThumbnailURL, &video.Resolution, &video.AvailableResolutions, &video.CreatedAt); err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// BunnyWebhookEvent is a webhook received from Bunny Stream
+type BunnyWebhookEvent struct {
+	ID          int             `json:"id"`
+	EventKey    string          `json:"event_key"`
+	VideoGUID   string          `json:"video_guid"`
+	StatusCode  int             `json:"status_code"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt sql.NullTime    `json:"-"`
+}
+
+const bunnyWebhookEventColumns = `id, event_key, video_guid, status_code, payload, attempts, COALESCE(last_error, ''), received_at, processed_at`
+
+func scanBunnyWebhookEvent(row interface{ Scan(...interface{}) error }) (*BunnyWebhookEvent, error) {
+	event := &BunnyWebhookEvent{}
+	var payload []byte
+	err := row.Scan(&event.ID, &event.EventKey, &event.VideoGUID, &event.StatusCode, &payload, &event.Attempts, &event.LastError, &event.ReceivedAt, &event.ProcessedAt)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = payload
+	return event, nil
+}
+
+// RecordBunnyWebhookEvent stores a received webhook. If an event with the same key was
+// already stored, that event is returned instead and created is false.
+func (db *DB) RecordBunnyWebhookEvent(eventKey, videoGUID string, statusCode int, payload []byte) (*BunnyWebhookEvent, bool, error) {
+	event, err := scanBunnyWebhookEvent(db.QueryRow(`
+		INSERT INTO bunny_webhook_events (event_key, video_guid, status_code, payload, received_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (event_key) DO NOTHING
+		RETURNING `+bunnyWebhookEventColumns, eventKey, videoGUID, statusCode, string(payload)))
+	if err == nil {
+		return event, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	event, err = scanBunnyWebhookEvent(db.QueryRow(`SELECT `+bunnyWebhookEventColumns+` FROM bunny_webhook_events WHERE event_key = $1`, eventKey))
+	if err != nil {
+		return nil, false, err
+	}
+	return event, false, nil
+}
+
+// GetBunnyWebhookEvent retrieves a stored webhook by ID
+func (db *DB) GetBunnyWebhookEvent(id int) (*BunnyWebhookEvent, error) {
+	return scanBunnyWebhookEvent(db.QueryRow(`SELECT `+bunnyWebhookEventColumns+` FROM bunny_webhook_events WHERE id = $1`, id))
+}
+
+// GetBunnyWebhookEvents lists stored webhooks, newest first, optionally only those
+// that have not been processed successfully
+func (db *DB) GetBunnyWebhookEvents(unprocessedOnly bool, limit, offset int) ([]*BunnyWebhookEvent, error) {
+	rows, err := db.Query(`
+		SELECT `+bunnyWebhookEventColumns+` FROM bunny_webhook_events
+		WHERE NOT $1 OR processed_at IS NULL
+		ORDER BY received_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, unprocessedOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*BunnyWebhookEvent
+	for rows.Next() {
+		event, err := scanBunnyWebhookEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// CompleteBunnyWebhookEvent records a successful processing attempt
+func (db *DB) CompleteBunnyWebhookEvent(id int) error {
+	_, err := db.Exec(`
+		UPDATE bunny_webhook_events SET attempts = attempts + 1, last_error = NULL, processed_at = NOW()
+		WHERE id = $1
+	`, id)
+	return err
+}
+
+// FailBunnyWebhookEvent records a failed processing attempt so the event can be replayed
+func (db *DB) FailBunnyWebhookEvent(id int, reason string) error {
+	_, err := db.Exec(`
+		UPDATE bunny_webhook_events SET attempts = attempts + 1, last_error = $2, processed_at = NULL
+		WHERE id = $1
+	`, id, reason)
+	return err
+}
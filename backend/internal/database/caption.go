@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// VideoCaption is a caption or subtitle track for a video in one language
+type VideoCaption struct {
+	ID            int           `json:"id"`
+	VideoID       int           `json:"video_id"`
+	Language      string        `json:"language"`
+	Label         string        `json:"label"`
+	Kind          string        `json:"kind"`
+	SourceFormat  string        `json:"-"`
+	StorageKey    string        `json:"-"`
+	URL           string        `json:"url"`
+	CueCount      int           `json:"-"`
+	IsDefault     bool          `json:"default"`
+	BunnySyncedAt sql.NullTime  `json:"-"`
+	CreatedBy     sql.NullInt64 `json:"-"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+const videoCaptionColumns = `id, video_id, language, label, kind, source_format, storage_key, url, cue_count, is_default, bunny_synced_at, created_by, created_at, updated_at`
+
+func scanVideoCaption(row interface{ Scan(...interface{}) error }) (*VideoCaption, error) {
+	caption := &VideoCaption{}
+	err := row.Scan(&caption.ID, &caption.VideoID, &caption.Language, &caption.Label, &caption.Kind, &caption.SourceFormat, &caption.StorageKey,
+		&caption.URL, &caption.CueCount, &caption.IsDefault, &caption.BunnySyncedAt, &caption.CreatedBy, &caption.CreatedAt, &caption.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return caption, nil
+}
+
+// GetVideoCaptions returns a video's caption tracks, the default track first
+func (db *DB) GetVideoCaptions(videoID int) ([]*VideoCaption, error) {
+	rows, err := db.Query(`SELECT `+videoCaptionColumns+` FROM video_captions WHERE video_id = $1 ORDER BY is_default DESC, label`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captions := []*VideoCaption{}
+	for rows.Next() {
+		caption, err := scanVideoCaption(rows)
+		if err != nil {
+			return nil, err
+		}
+		captions = append(captions, caption)
+	}
+	return captions, rows.Err()
+}
+
+// GetPlayableVideoCaptions returns the caption tracks of a ready video whose required
+// tier is one of tiers. It returns sql.ErrNoRows for any other video.
+func (db *DB) GetPlayableVideoCaptions(videoID int, tiers []string) ([]*VideoCaption, error) {
+	var exists int
+	err := db.QueryRow(`SELECT 1 FROM videos WHERE id = $1 AND status = 'ready' AND required_tier = ANY($2)`, videoID, pq.Array(tiers)).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetVideoCaptions(videoID)
+}
+
+// SaveVideoCaption adds a caption track, replacing any track the video already has in
+// the same language, and refreshes the video's transcript. It returns the replaced
+// track, or nil, so its file can be removed.
+func (db *DB) SaveVideoCaption(caption *VideoCaption, transcript string) (*VideoCaption, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	previous, err := scanVideoCaption(tx.QueryRow(`
+		SELECT `+videoCaptionColumns+` FROM video_captions
+		WHERE video_id = $1 AND language = $2
+		FOR UPDATE
+	`, caption.VideoID, caption.Language))
+	if err == sql.ErrNoRows {
+		previous = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO video_captions (video_id, language, label, kind, source_format, storage_key, url, cue_count, transcript, is_default, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), NOW())
+		ON CONFLICT (video_id, language) DO UPDATE SET
+			label = EXCLUDED.label,
+			kind = EXCLUDED.kind,
+			source_format = EXCLUDED.source_format,
+			storage_key = EXCLUDED.storage_key,
+			url = EXCLUDED.url,
+			cue_count = EXCLUDED.cue_count,
+			transcript = EXCLUDED.transcript,
+			is_default = EXCLUDED.is_default,
+			bunny_synced_at = NULL,
+			created_by = EXCLUDED.created_by,
+			updated_at = NOW()
+		RETURNING id, created_at, updated_at
+	`, caption.VideoID, caption.Language, caption.Label, caption.Kind, caption.SourceFormat, caption.StorageKey, caption.URL,
+		caption.CueCount, transcript, caption.IsDefault, caption.CreatedBy).Scan(&caption.ID, &caption.CreatedAt, &caption.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	caption.BunnySyncedAt = sql.NullTime{}
+
+	if caption.IsDefault {
+		if _, err := tx.Exec(`UPDATE video_captions SET is_default = FALSE, updated_at = NOW() WHERE video_id = $1 AND id <> $2 AND is_default`, caption.VideoID, caption.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := refreshVideoTranscript(tx, caption.VideoID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// MarkVideoCaptionBunnySynced records that a caption track was sent to Bunny Stream
+func (db *DB) MarkVideoCaptionBunnySynced(captionID int) error {
+	result, err := db.Exec(`UPDATE video_captions SET bunny_synced_at = NOW() WHERE id = $1`, captionID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteVideoCaption removes a video's caption track in a language and refreshes the
+// video's transcript. It returns the removed track so its file can be removed.
+func (db *DB) DeleteVideoCaption(videoID int, language string) (*VideoCaption, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	caption, err := scanVideoCaption(tx.QueryRow(`
+		DELETE FROM video_captions WHERE video_id = $1 AND language = $2
+		RETURNING `+videoCaptionColumns, videoID, language))
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshVideoTranscript(tx, videoID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return caption, nil
+}
+
+// refreshVideoTranscript sets a video's searchable transcript from its default caption
+// track, or its oldest track if none is the default
+func refreshVideoTranscript(tx *sql.Tx, videoID int) error {
+	_, err := tx.Exec(`
+		UPDATE videos SET transcript = (
+			SELECT c.transcript FROM video_captions c
+			WHERE c.video_id = $1
+			ORDER BY c.is_default DESC, c.created_at
+			LIMIT 1
+		)
+		WHERE id = $1
+	`, videoID)
+	return err
+}
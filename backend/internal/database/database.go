@@ -104,6 +104,28 @@ func (db *DB) RunMigrations() error {
 		createAdBillingTable,
 		createAdAuditLogTable,
 		createIndexes,
+		addUserMFAFields,
+		createTokenRevocationTables,
+		createRefreshTokenFamilies,
+		useRoleSessionLimits,
+		createRoleTables,
+		createSigningKeysTable,
+		createAPITokensTable,
+		createMagicLinkTokensTable,
+		createUserIdentitiesTables,
+		createLoginProtectionTables,
+		createAccountPrivacyTables,
+		createUserInvitationsTable,
+		createEmailChangeRequestsTable,
+		createBunnyWebhookEventsTable,
+		createVideoUploadsTable,
+		createBunnySyncJobsTable,
+		createPlaybackProgressTable,
+		createVideoViewClaimsTable,
+		createSeriesTables,
+		createVideoSearchColumns,
+		createVideoRecommendationsTable,
+		createVideoCaptionsTable,
 	}
 
 	for i, migration := range migrations {
@@ -686,3 +708,650 @@ CREATE INDEX IF NOT EXISTS idx_ad_audit_log_entity ON ad_audit_log(entity_type,
 CREATE INDEX IF NOT EXISTS idx_ad_audit_log_actor ON ad_audit_log(actor_id, actor_type);
 CREATE INDEX IF NOT EXISTS idx_ad_audit_log_created_at ON ad_audit_log(created_at);
 `
+
+const addUserMFAFields = `
+DO $$ 
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'mfa_enabled'
+    ) THEN
+        ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+    END IF;
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'mfa_secret'
+    ) THEN
+        ALTER TABLE users ADD COLUMN mfa_secret VARCHAR(64);
+    END IF;
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'mfa_recovery_codes'
+    ) THEN
+        ALTER TABLE users ADD COLUMN mfa_recovery_codes JSONB DEFAULT '[]';
+    END IF;
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'mfa_last_used_step'
+    ) THEN
+        ALTER TABLE users ADD COLUMN mfa_last_used_step BIGINT NOT NULL DEFAULT 0;
+    END IF;
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'mfa_enabled_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN mfa_enabled_at TIMESTAMP;
+    END IF;
+END $$;
+`
+
+const createTokenRevocationTables = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_token_watermarks (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+`
+
+const createRefreshTokenFamilies = `
+DO $$ 
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'user_sessions' AND column_name = 'family_id'
+    ) THEN
+        ALTER TABLE user_sessions ADD COLUMN family_id VARCHAR(64);
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_id VARCHAR(255) PRIMARY KEY,
+    family_id VARCHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id VARCHAR(255),
+    access_token_id VARCHAR(255),
+    replaced_by VARCHAR(255),
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_family_id ON user_sessions(family_id);
+`
+
+const useRoleSessionLimits = `
+-- max_sessions = 0 means the per-role session limit applies
+ALTER TABLE users ALTER COLUMN max_sessions SET DEFAULT 0;
+UPDATE users SET max_sessions = 0 WHERE max_sessions = 5 OR max_sessions IS NULL;
+`
+
+const createRoleTables = `
+CREATE TABLE IF NOT EXISTS roles (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT,
+    category VARCHAR(50),
+    level INTEGER DEFAULT 1,
+    is_system_role BOOLEAN DEFAULT FALSE,
+    color VARCHAR(20),
+    icon VARCHAR(50),
+    subsystem_access TEXT[] DEFAULT '{}',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id VARCHAR(100) PRIMARY KEY,
+    resource VARCHAR(50) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    description TEXT,
+    category VARCHAR(50),
+    subsystem VARCHAR(100),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id VARCHAR(64) NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id VARCHAR(100) NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id VARCHAR(64) NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    assigned_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- effect is 'grant' or 'deny'; a deny override wins over any role grant
+CREATE TABLE IF NOT EXISTS user_permission_overrides (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission_id VARCHAR(100) NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    effect VARCHAR(10) NOT NULL CHECK (effect IN ('grant', 'deny')),
+    reason TEXT,
+    granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
+CREATE INDEX IF NOT EXISTS idx_user_permission_overrides_expires_at ON user_permission_overrides(expires_at);
+`
+
+const createSigningKeysTable = `
+-- private_key is encrypted by the services layer; retired keys stay valid for verification until verify_until
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMPTZ,
+    verify_until TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_verify_until ON jwt_signing_keys(verify_until);
+
+-- activates_at lets a key be published before it signs anything; NULL means it was active on creation
+DO $$ 
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'jwt_signing_keys' AND column_name = 'activates_at'
+    ) THEN
+        ALTER TABLE jwt_signing_keys ADD COLUMN activates_at TIMESTAMPTZ;
+    END IF;
+END $$;
+`
+
+const createAPITokensTable = `
+-- kind is 'personal' (personal access token) or 'api_key'; only a SHA-256 hash of the secret is stored
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('personal', 'api_key')),
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(32) UNIQUE NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    scopes TEXT[] DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip VARCHAR(45),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_kind ON api_tokens(kind);
+`
+
+const createMagicLinkTokensTable = `
+-- token_id is the jti of the signed magic-link token; a link can be consumed once
+CREATE TABLE IF NOT EXISTS magic_link_tokens (
+    token_id VARCHAR(100) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requested_ip VARCHAR(45),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    used_ip VARCHAR(45),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_user_id ON magic_link_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_expires_at ON magic_link_tokens(expires_at);
+`
+
+const createUserIdentitiesTables = `
+-- External OpenID Connect identities linked to local users, one per provider
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    email_verified BOOLEAN DEFAULT FALSE,
+    last_login_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- Pending authorization requests; user_id is set when an authenticated user is linking a provider
+CREATE TABLE IF NOT EXISTS oidc_auth_states (
+    state VARCHAR(100) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce VARCHAR(100) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_auth_states_expires_at ON oidc_auth_states(expires_at);
+`
+
+const createLoginProtectionTables = `
+-- Failed-attempt counters and lockouts, keyed by limiter scope and account or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_expires_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Accounts that failed to sign in from each IP, used to detect credential stuffing
+CREATE TABLE IF NOT EXISTS login_ip_failures (
+    ip_address VARCHAR(45) NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ip_address, account)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_ip_failures_failed_at ON login_ip_failures(failed_at);
+
+-- Devices each user has signed in from, used to alert on sign-ins from new devices
+CREATE TABLE IF NOT EXISTS user_known_devices (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_fingerprint VARCHAR(64) NOT NULL,
+    user_agent TEXT,
+    first_ip VARCHAR(45),
+    last_ip VARCHAR(45),
+    first_seen_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, device_fingerprint)
+);
+`
+
+const createAccountPrivacyTables = `
+DO $$ 
+BEGIN
+    -- Set when an account has been erased but its row is kept for billing records
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'users' AND column_name = 'deleted_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+    END IF;
+END $$;
+
+-- Personal data export jobs; the archive in Spaces is removed once the link expires
+CREATE TABLE IF NOT EXISTS data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    file_key VARCHAR(500),
+    error TEXT,
+    requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+-- attempts and updated_at let exports interrupted by a restart be claimed again
+DO $$ 
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'data_exports' AND column_name = 'attempts'
+    ) THEN
+        ALTER TABLE data_exports ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+    END IF;
+
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'data_exports' AND column_name = 'updated_at'
+    ) THEN
+        ALTER TABLE data_exports ADD COLUMN updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_status ON data_exports(status);
+
+-- Accounts scheduled for erasure once their grace period ends
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    scheduled_for TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_deletions_scheduled_for ON account_deletions(scheduled_for);
+`
+
+const createUserInvitationsTable = `
+-- Invitations to create an account with a pre-assigned role
+CREATE TABLE IF NOT EXISTS user_invitations (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL,
+    token_id VARCHAR(255) UNIQUE NOT NULL,
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    accepted_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_invitations_email ON user_invitations(email);
+CREATE INDEX IF NOT EXISTS idx_user_invitations_expires_at ON user_invitations(expires_at);
+`
+
+const createEmailChangeRequestsTable = `
+-- Pending email address changes, applied once the new address is confirmed
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    token_id VARCHAR(255) UNIQUE NOT NULL,
+    requested_ip VARCHAR(45),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+const createBunnyWebhookEventsTable = `
+DO $$ 
+BEGIN
+    -- Encoded resolution and renditions, refreshed from Bunny Stream
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'videos' AND column_name = 'resolution'
+    ) THEN
+        ALTER TABLE videos ADD COLUMN resolution VARCHAR(20);
+    END IF;
+
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'videos' AND column_name = 'available_resolutions'
+    ) THEN
+        ALTER TABLE videos ADD COLUMN available_resolutions VARCHAR(255);
+    END IF;
+END $$;
+
+-- Every Bunny Stream webhook received, kept so events can be deduplicated and replayed
+CREATE TABLE IF NOT EXISTS bunny_webhook_events (
+    id SERIAL PRIMARY KEY,
+    event_key VARCHAR(64) UNIQUE NOT NULL,
+    video_guid VARCHAR(255) NOT NULL,
+    status_code INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_bunny_webhook_events_video_guid ON bunny_webhook_events(video_guid);
+CREATE INDEX IF NOT EXISTS idx_bunny_webhook_events_received_at ON bunny_webhook_events(received_at);
+`
+
+const createVideoUploadsTable = `
+-- Resumable (tus) video uploads staged on disk until they are handed off to Bunny Stream
+CREATE TABLE IF NOT EXISTS video_uploads (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    category VARCHAR(100),
+    tags TEXT,
+    metadata TEXT,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'uploading',
+    video_id INTEGER REFERENCES videos(id) ON DELETE SET NULL,
+    error TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chunks are staged on the local disk of the instance that created the upload, which
+-- alone accepts its chunks and hands it off. handoff_attempts counts hand-offs started,
+-- so one interrupted by a restart is claimed again.
+DO $$ 
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'video_uploads' AND column_name = 'staging_host'
+    ) THEN
+        ALTER TABLE video_uploads ADD COLUMN staging_host VARCHAR(255);
+    END IF;
+
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns 
+        WHERE table_name = 'video_uploads' AND column_name = 'handoff_attempts'
+    ) THEN
+        ALTER TABLE video_uploads ADD COLUMN handoff_attempts INTEGER NOT NULL DEFAULT 0;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_video_uploads_user_id ON video_uploads(user_id);
+CREATE INDEX IF NOT EXISTS idx_video_uploads_status_expires_at ON video_uploads(status, expires_at);
+`
+
+const createBunnySyncJobsTable = `
+-- Bunny Stream library synchronization jobs, run by a background worker
+CREATE TABLE IF NOT EXISTS bunny_sync_jobs (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    phase VARCHAR(20),
+    total_items INTEGER NOT NULL DEFAULT 0,
+    processed_items INTEGER NOT NULL DEFAULT 0,
+    created_count INTEGER NOT NULL DEFAULT 0,
+    updated_count INTEGER NOT NULL DEFAULT 0,
+    deleted_count INTEGER NOT NULL DEFAULT 0,
+    unchanged_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    changes JSONB NOT NULL DEFAULT '[]',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Only one sync may be queued or running at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bunny_sync_jobs_active ON bunny_sync_jobs ((TRUE)) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS idx_bunny_sync_jobs_created_at ON bunny_sync_jobs(created_at);
+`
+
+const createPlaybackProgressTable = `
+-- Where each user is in each video, for resuming playback on any device. media_type
+-- is 'video' for Bunny Stream videos (media_id is videos.id) or 'youtube' (media_id is
+-- the YouTube video ID).
+CREATE TABLE IF NOT EXISTS playback_progress (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    media_type VARCHAR(20) NOT NULL,
+    media_id VARCHAR(64) NOT NULL,
+    position_seconds INTEGER NOT NULL DEFAULT 0,
+    duration_seconds INTEGER NOT NULL DEFAULT 0,
+    watched_seconds INTEGER NOT NULL DEFAULT 0,
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    completed_at TIMESTAMP,
+    last_watched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, media_type, media_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_playback_progress_continue ON playback_progress(user_id, last_watched_at DESC) WHERE NOT completed;
+
+-- RecordUserActivity stores the video and details of each activity
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'user_activity' AND column_name = 'video_id') THEN
+        ALTER TABLE user_activity ADD COLUMN video_id INTEGER REFERENCES videos(id) ON DELETE SET NULL;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'user_activity' AND column_name = 'metadata') THEN
+        ALTER TABLE user_activity ADD COLUMN metadata JSONB;
+    END IF;
+END $$;
+`
+
+const createVideoViewClaimsTable = `
+-- The last time each viewer's view of a video was counted, so a viewer counts once per
+-- window. viewer_key is 'user:<id>' or 'anon:<fingerprint>'.
+CREATE TABLE IF NOT EXISTS video_view_claims (
+    viewer_key VARCHAR(80) NOT NULL,
+    media_type VARCHAR(20) NOT NULL,
+    media_id VARCHAR(64) NOT NULL,
+    counted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (viewer_key, media_type, media_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_view_claims_counted_at ON video_view_claims(counted_at);
+
+-- Users can pause their watch history
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'watch_history_paused') THEN
+        ALTER TABLE users ADD COLUMN watch_history_paused BOOLEAN NOT NULL DEFAULT FALSE;
+    END IF;
+END $$;
+`
+
+const createSeriesTables = `
+-- Ordered series of videos, such as multi-part lectures, and curated playlists
+CREATE TABLE IF NOT EXISTS series (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(255) UNIQUE NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'series' CHECK (kind IN ('series', 'playlist')),
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    cover_image_url TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'published')),
+    bunny_collection_id VARCHAR(255) UNIQUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_series_status ON series(status, kind);
+
+-- Positions are checked at commit so items can be reordered in one transaction
+CREATE TABLE IF NOT EXISTS series_items (
+    series_id INTEGER NOT NULL REFERENCES series(id) ON DELETE CASCADE,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (series_id, video_id),
+    CONSTRAINT series_items_position_key UNIQUE (series_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS idx_series_items_video_id ON series_items(video_id);
+
+-- Library syncs can mirror Bunny collections into series
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'bunny_sync_jobs' AND column_name = 'mirror_collections') THEN
+        ALTER TABLE bunny_sync_jobs ADD COLUMN mirror_collections BOOLEAN NOT NULL DEFAULT FALSE;
+    END IF;
+END $$;
+`
+
+const createVideoSearchColumns = `
+-- Full-text search over videos and YouTube videos, weighted title, tags, description, transcript
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'videos' AND column_name = 'transcript') THEN
+        ALTER TABLE videos ADD COLUMN transcript TEXT;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'videos' AND column_name = 'search_vector') THEN
+        ALTER TABLE videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+            setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+            setweight(to_tsvector('english', COALESCE(tags, '')), 'B') ||
+            setweight(to_tsvector('english', COALESCE(description, '')), 'C') ||
+            setweight(to_tsvector('english', COALESCE(transcript, '')), 'D')
+        ) STORED;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'youtube_videos' AND column_name = 'transcript') THEN
+        ALTER TABLE youtube_videos ADD COLUMN transcript TEXT;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'youtube_videos' AND column_name = 'search_vector') THEN
+        ALTER TABLE youtube_videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+            setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+            setweight(to_tsvector('english', COALESCE(tags, '')), 'B') ||
+            setweight(to_tsvector('english', COALESCE(description, '')), 'C') ||
+            setweight(to_tsvector('english', COALESCE(transcript, '')), 'D')
+        ) STORED;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_videos_search_vector ON videos USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_youtube_videos_search_vector ON youtube_videos USING GIN (search_vector);
+`
+
+const createVideoRecommendationsTable = `
+-- The subscription tier needed to play each video
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'videos' AND column_name = 'required_tier') THEN
+        ALTER TABLE videos ADD COLUMN required_tier VARCHAR(20) NOT NULL DEFAULT 'free' CHECK (required_tier IN ('free', 'basic', 'premium'));
+    END IF;
+END $$;
+
+-- Precomputed related videos, with the signals behind each score so results can be explained
+CREATE TABLE IF NOT EXISTS video_recommendations (
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    related_video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    score DOUBLE PRECISION NOT NULL,
+    tag_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    category_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    co_watch_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    recency_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    shared_tags JSONB NOT NULL DEFAULT '[]',
+    co_watchers INTEGER NOT NULL DEFAULT 0,
+    computed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (video_id, related_video_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_recommendations_score ON video_recommendations(video_id, score DESC);
+CREATE INDEX IF NOT EXISTS idx_user_activity_video_id ON user_activity(video_id) WHERE video_id IS NOT NULL;
+`
+
+const createVideoCaptionsTable = `
+-- Caption and subtitle tracks, one per video and language, stored in Spaces as WebVTT.
+-- The text of each track is kept so the video's transcript can be searched.
+CREATE TABLE IF NOT EXISTS video_captions (
+    id SERIAL PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    language VARCHAR(35) NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'subtitles' CHECK (kind IN ('subtitles', 'captions')),
+    source_format VARCHAR(10) NOT NULL CHECK (source_format IN ('srt', 'vtt')),
+    storage_key TEXT NOT NULL,
+    url TEXT NOT NULL,
+    cue_count INTEGER NOT NULL DEFAULT 0,
+    transcript TEXT NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    bunny_synced_at TIMESTAMP,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (video_id, language)
+);
+`
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrEmailTaken is returned when confirming an email change to an address another account now uses
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// EmailChangeRequest is a user's pending change to a new email address
+type EmailChangeRequest struct {
+	UserID    int       `json:"user_id"`
+	NewEmail  string    `json:"new_email"`
+	TokenID   string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateEmailChangeRequest records a pending email change, replacing any earlier request
+// so only the latest verification link works
+func (db *DB) CreateEmailChangeRequest(userID int, newEmail, tokenID, requestedIP string, expiresAt time.Time) (*EmailChangeRequest, error) {
+	request := &EmailChangeRequest{}
+	err := db.QueryRow(`
+		INSERT INTO email_change_requests (user_id, new_email, token_id, requested_ip, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			new_email = EXCLUDED.new_email,
+			token_id = EXCLUDED.token_id,
+			requested_ip = EXCLUDED.requested_ip,
+			expires_at = EXCLUDED.expires_at,
+			created_at = NOW()
+		RETURNING user_id, new_email, token_id, expires_at, created_at
+	`, userID, newEmail, tokenID, requestedIP, expiresAt).Scan(&request.UserID, &request.NewEmail, &request.TokenID, &request.ExpiresAt, &request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetEmailChangeRequest returns the user's pending, unexpired email change
+func (db *DB) GetEmailChangeRequest(userID int) (*EmailChangeRequest, error) {
+	request := &EmailChangeRequest{}
+	err := db.QueryRow(`
+		SELECT user_id, new_email, token_id, expires_at, created_at
+		FROM email_change_requests
+		WHERE user_id = $1 AND expires_at > NOW()
+	`, userID).Scan(&request.UserID, &request.NewEmail, &request.TokenID, &request.ExpiresAt, &request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// CancelEmailChangeRequest removes the user's pending email change. It returns
+// sql.ErrNoRows if none is pending.
+func (db *DB) CancelEmailChangeRequest(userID int) error {
+	result, err := db.Exec(`DELETE FROM email_change_requests WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ConfirmEmailChange applies a pending email change and marks the new address as verified.
+// It returns the previous address, sql.ErrNoRows if the request is unknown, superseded or
+// expired, and ErrEmailTaken if another account has claimed the address in the meantime.
+func (db *DB) ConfirmEmailChange(userID int, tokenID string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var newEmail string
+	if err := tx.QueryRow(`
+		DELETE FROM email_change_requests
+		WHERE user_id = $1 AND token_id = $2 AND expires_at > NOW()
+		RETURNING new_email
+	`, userID, tokenID).Scan(&newEmail); err != nil {
+		return "", err
+	}
+
+	var taken bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)`, newEmail, userID).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrEmailTaken
+	}
+
+	var oldEmail string
+	if err := tx.QueryRow(`SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&oldEmail); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET email = $2, email_verified = TRUE, verification_token = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID, newEmail); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return oldEmail, nil
+}
+
+// CleanupExpiredEmailChangeRequests removes email changes that were never confirmed
+func (db *DB) CleanupExpiredEmailChangeRequests() error {
+	_, err := db.Exec(`DELETE FROM email_change_requests WHERE expires_at < NOW()`)
+	return err
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserIdentity represents an external OpenID Connect identity linked to a user
+type UserIdentity struct {
+	ID            int
+	UserID        int
+	Provider      string
+	Subject       string
+	Email         sql.NullString
+	EmailVerified bool
+	LastLoginAt   sql.NullTime
+	CreatedAt     time.Time
+}
+
+// OIDCAuthState represents a pending OpenID Connect authorization request
+type OIDCAuthState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       sql.NullInt64
+	ExpiresAt    time.Time
+}
+
+const userIdentityColumns = `id, user_id, provider, subject, email, COALESCE(email_verified, FALSE), last_login_at, created_at`
+
+// scanUserIdentity scans a row selected with userIdentityColumns
+func scanUserIdentity(scanner interface{ Scan(...interface{}) error }) (*UserIdentity, error) {
+	identity := &UserIdentity{}
+	err := scanner.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email,
+		&identity.EmailVerified, &identity.LastLoginAt, &identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// GetUserIdentity retrieves the identity a provider issued for a subject
+func (db *DB) GetUserIdentity(provider, subject string) (*UserIdentity, error) {
+	return scanUserIdentity(db.QueryRow(
+		`SELECT `+userIdentityColumns+` FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject,
+	))
+}
+
+// GetUserIdentities lists the identities linked to a user
+func (db *DB) GetUserIdentities(userID int) ([]*UserIdentity, error) {
+	rows, err := db.Query(
+		`SELECT `+userIdentityColumns+` FROM user_identities WHERE user_id = $1 ORDER BY provider`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// LinkUserIdentity links a provider identity to a user. It fails with a unique
+// violation if the identity belongs to someone else or the user already has one for the provider.
+func (db *DB) LinkUserIdentity(identity *UserIdentity) error {
+	return db.QueryRow(`
+		INSERT INTO user_identities (user_id, provider, subject, email, email_verified, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id, created_at
+	`, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.EmailVerified).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// UnlinkUserIdentity removes a user's identity for a provider.
+// It returns sql.ErrNoRows if the user has no identity for the provider.
+func (db *DB) UnlinkUserIdentity(userID int, provider string) error {
+	result, err := db.Exec(`DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`, userID, provider)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchUserIdentity records a sign-in through an identity and refreshes the email the provider reported
+func (db *DB) TouchUserIdentity(id int, email string, emailVerified bool) error {
+	_, err := db.Exec(
+		`UPDATE user_identities SET email = $1, email_verified = $2, last_login_at = NOW() WHERE id = $3`,
+		email, emailVerified, id,
+	)
+	return err
+}
+
+// CreateOIDCAuthState stores a pending authorization request
+func (db *DB) CreateOIDCAuthState(state *OIDCAuthState) error {
+	_, err := db.Exec(`
+		INSERT INTO oidc_auth_states (state, provider, nonce, code_verifier, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, state.State, state.Provider, state.Nonce, state.CodeVerifier, state.UserID, state.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCAuthState deletes and returns an unexpired authorization request for a provider,
+// so each state can be used once. It returns sql.ErrNoRows if there is none.
+func (db *DB) ConsumeOIDCAuthState(state, provider string) (*OIDCAuthState, error) {
+	authState := &OIDCAuthState{}
+	err := db.QueryRow(`
+		DELETE FROM oidc_auth_states
+		WHERE state = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING state, provider, nonce, code_verifier, user_id, expires_at
+	`, state, provider).Scan(&authState.State, &authState.Provider, &authState.Nonce, &authState.CodeVerifier, &authState.UserID, &authState.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return authState, nil
+}
+
+// CleanupExpiredOIDCAuthStates removes authorization requests that were never completed
+func (db *DB) CleanupExpiredOIDCAuthStates() error {
+	_, err := db.Exec(`DELETE FROM oidc_auth_states WHERE expires_at < NOW()`)
+	return err
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -124,7 +126,7 @@ func (db *DB) IsVideoFavorited(userID, videoID int) (bool, error) {
 // GetUserFavorites retrieves a user's favorite videos
 func (db *DB) GetUserFavorites(userID, limit, offset int) ([]*Video, error) {
 	rows, err := db.Query(
-		`SELECT v.id, v.title, v.description, v.bunny_video_id, v.thumbnail_url, v.duration, v.file_size, v.status, v.category, v.tags, v.view_count, v.like_count, v.created_by, v.created_at, v.updated_at 
+		`SELECT v.id, v.title, v.description, v.bunny_video_id, v.thumbnail_url, v.duration, v.file_size, v.status, v.category, v.tags, v.view_count, v.like_count, COALESCE(v.created_by, 0), v.created_at, v.updated_at 
 		 FROM videos v 
 		 JOIN favorites f ON v.id = f.video_id 
 		 WHERE f.user_id = $1 AND v.status = 'ready' 
@@ -152,9 +154,17 @@ func (db *DB) GetUserFavorites(userID, limit, offset int) ([]*Video, error) {
 
 // RecordUserActivity records user activity
 func (db *DB) RecordUserActivity(userID int, activityType string, videoID *int, metadata map[string]interface{}) error {
+	var metadataJSON []byte
+	if metadata != nil {
+		var err error
+		if metadataJSON, err = json.Marshal(metadata); err != nil {
+			return fmt.Errorf("failed to marshal metadata: %v", err)
+		}
+	}
+
 	_, err := db.Exec(
 		`INSERT INTO user_activity (user_id, activity_type, video_id, metadata, created_at) VALUES ($1, $2, $3, $4, NOW())`,
-		userID, activityType, videoID, metadata,
+		userID, activityType, videoID, metadataJSON,
 	)
 	return err
 }
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserInvitation is an invitation for an email address to create an account with a given role
+type UserInvitation struct {
+	ID             int           `json:"id"`
+	Email          string        `json:"email"`
+	Role           string        `json:"role"`
+	TokenID        string        `json:"-"`
+	InvitedBy      sql.NullInt64 `json:"-"`
+	InvitedByEmail string        `json:"invited_by_email,omitempty"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	AcceptedAt     sql.NullTime  `json:"-"`
+	AcceptedUserID sql.NullInt64 `json:"-"`
+	RevokedAt      sql.NullTime  `json:"-"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+const userInvitationColumns = `i.id, i.email, i.role, i.token_id, i.invited_by, COALESCE(u.email, ''), i.expires_at, i.accepted_at, i.accepted_user_id, i.revoked_at, i.created_at`
+
+func scanUserInvitation(row interface{ Scan(...interface{}) error }) (*UserInvitation, error) {
+	invitation := &UserInvitation{}
+	err := row.Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.TokenID, &invitation.InvitedBy, &invitation.InvitedByEmail, &invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.AcceptedUserID, &invitation.RevokedAt, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// CreateInvitation records a newly issued invitation. Earlier pending invitations for
+// the same email are revoked, so only the latest link works.
+func (db *DB) CreateInvitation(email, role, tokenID string, invitedBy int, expiresAt time.Time) (*UserInvitation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE user_invitations SET revoked_at = NOW()
+		WHERE LOWER(email) = LOWER($1) AND accepted_at IS NULL AND revoked_at IS NULL
+	`, email); err != nil {
+		return nil, err
+	}
+
+	var id int
+	if err := tx.QueryRow(`
+		INSERT INTO user_invitations (email, role, token_id, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id
+	`, email, role, tokenID, sql.NullInt64{Int64: int64(invitedBy), Valid: invitedBy != 0}, expiresAt).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.GetInvitation(id)
+}
+
+// GetInvitation retrieves an invitation by ID
+func (db *DB) GetInvitation(id int) (*UserInvitation, error) {
+	return scanUserInvitation(db.QueryRow(`
+		SELECT `+userInvitationColumns+`
+		FROM user_invitations i
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.id = $1
+	`, id))
+}
+
+// GetPendingInvitations returns invitations that have not been accepted, revoked or expired
+func (db *DB) GetPendingInvitations() ([]*UserInvitation, error) {
+	rows, err := db.Query(`
+		SELECT ` + userInvitationColumns + `
+		FROM user_invitations i
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > NOW()
+		ORDER BY i.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*UserInvitation
+	for rows.Next() {
+		invitation, err := scanUserInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation revokes a pending invitation. It returns sql.ErrNoRows if the
+// invitation does not exist or is no longer pending.
+func (db *DB) RevokeInvitation(id int) (*UserInvitation, error) {
+	var revokedID int
+	err := db.QueryRow(`
+		UPDATE user_invitations SET revoked_at = NOW()
+		WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id
+	`, id).Scan(&revokedID)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetInvitation(revokedID)
+}
+
+// AcceptInvitation marks a pending invitation as accepted and creates its account with
+// the invited role and a verified email, in one transaction. It returns sql.ErrNoRows
+// if the invitation is unknown, revoked, expired or already accepted.
+func (db *DB) AcceptInvitation(tokenID, passwordHash, firstName, lastName string) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invitationID int
+	var email, role string
+	if err := tx.QueryRow(`
+		UPDATE user_invitations SET accepted_at = NOW()
+		WHERE token_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id, email, role
+	`, tokenID).Scan(&invitationID, &email, &role); err != nil {
+		return nil, err
+	}
+
+	var userID int
+	if err := tx.QueryRow(`
+		INSERT INTO users (email, password_hash, first_name, last_name, role, email_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, TRUE, NOW(), NOW())
+		RETURNING id
+	`, email, passwordHash, firstName, lastName, role).Scan(&userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE user_invitations SET accepted_user_id = $2 WHERE id = $1`, invitationID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.GetUserByID(userID)
+}
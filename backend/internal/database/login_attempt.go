@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RecordLoginFailure counts a failed attempt for a key and returns the failures in the
+// current window. A new window ending at windowEnds starts when the previous one has expired.
+func (db *DB) RecordLoginFailure(key string, windowEnds time.Time) (int, error) {
+	var failures int
+	err := db.QueryRow(`
+		INSERT INTO login_attempts (key, failures, window_expires_at, updated_at)
+		VALUES ($1, 1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.window_expires_at < NOW() THEN 1 ELSE login_attempts.failures + 1 END,
+			window_expires_at = CASE WHEN login_attempts.window_expires_at < NOW() THEN EXCLUDED.window_expires_at ELSE login_attempts.window_expires_at END,
+			updated_at = NOW()
+		RETURNING failures
+	`, key, windowEnds).Scan(&failures)
+	return failures, err
+}
+
+// GetLoginFailures returns the failures for a key in its current window
+func (db *DB) GetLoginFailures(key string) (int, error) {
+	var failures int
+	err := db.QueryRow(`SELECT failures FROM login_attempts WHERE key = $1 AND window_expires_at > NOW()`, key).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return failures, err
+}
+
+// LockLoginKey locks a key until the given time
+func (db *DB) LockLoginKey(key string, until time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO login_attempts (key, failures, window_expires_at, locked_until, updated_at)
+		VALUES ($1, 0, NOW(), $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET locked_until = EXCLUDED.locked_until, updated_at = NOW()
+	`, key, until)
+	return err
+}
+
+// GetLoginLockedUntil returns when a key's lock ends, or the zero time if it is not locked
+func (db *DB) GetLoginLockedUntil(key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(`SELECT locked_until FROM login_attempts WHERE key = $1`, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// ResetLoginAttempts clears a key's failures and lock
+func (db *DB) ResetLoginAttempts(key string) error {
+	_, err := db.Exec(`DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+// RecordIPAccountFailure records a failed attempt against an account from an IP and returns
+// how many distinct accounts have failed from that IP since the given time
+func (db *DB) RecordIPAccountFailure(ipAddress, account string, since time.Time) (int, error) {
+	if _, err := db.Exec(`
+		INSERT INTO login_ip_failures (ip_address, account, failed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (ip_address, account) DO UPDATE SET failed_at = NOW()
+	`, ipAddress, account); err != nil {
+		return 0, err
+	}
+
+	var accounts int
+	err := db.QueryRow(`SELECT COUNT(*) FROM login_ip_failures WHERE ip_address = $1 AND failed_at > $2`, ipAddress, since).Scan(&accounts)
+	return accounts, err
+}
+
+// ResetIPAccountFailures forgets the accounts that failed from an IP
+func (db *DB) ResetIPAccountFailures(ipAddress string) error {
+	_, err := db.Exec(`DELETE FROM login_ip_failures WHERE ip_address = $1`, ipAddress)
+	return err
+}
+
+// CleanupLoginAttempts removes expired counters and locks, and IP failures older than the given time
+func (db *DB) CleanupLoginAttempts(ipFailuresBefore time.Time) error {
+	if _, err := db.Exec(`
+		DELETE FROM login_attempts
+		WHERE window_expires_at < NOW() AND (locked_until IS NULL OR locked_until < NOW())
+	`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM login_ip_failures WHERE failed_at < $1`, ipFailuresBefore)
+	return err
+}
+
+// RecordUserDevice records a sign-in from a device and reports whether the device is new
+// for the user and whether the user had signed in from any device before
+func (db *DB) RecordUserDevice(userID int, fingerprint, ipAddress, userAgent string) (isNew bool, hadDevices bool, err error) {
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_known_devices WHERE user_id = $1)`, userID).Scan(&hadDevices); err != nil {
+		return false, false, err
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO user_known_devices (user_id, device_fingerprint, user_agent, first_ip, last_ip, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $4, NOW(), NOW())
+		ON CONFLICT (user_id, device_fingerprint) DO UPDATE SET last_ip = EXCLUDED.last_ip, last_seen_at = NOW()
+		RETURNING (xmax = 0)
+	`, userID, fingerprint, userAgent, ipAddress).Scan(&isNew)
+	return isNew, hadDevices, err
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"time"
+)
+
+// CreateMagicLinkToken records a newly issued magic-link token. Any earlier links
+// the user has not used yet are invalidated, so only the latest email works.
+func (db *DB) CreateMagicLinkToken(tokenID string, userID int, requestedIP string, expiresAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE magic_link_tokens SET expires_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW()
+	`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO magic_link_tokens (token_id, user_id, requested_ip, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`, tokenID, userID, requestedIP, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeMagicLinkToken marks an unused, unexpired magic-link token as used.
+// It returns sql.ErrNoRows if the token is unknown, expired or already used.
+func (db *DB) ConsumeMagicLinkToken(tokenID string, userID int, usedIP string) error {
+	var consumed string
+	return db.QueryRow(`
+		UPDATE magic_link_tokens SET used_at = NOW(), used_ip = $3
+		WHERE token_id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING token_id
+	`, tokenID, userID, usedIP).Scan(&consumed)
+}
+
+// CleanupExpiredMagicLinkTokens removes magic-link tokens that expired more than a day ago
+func (db *DB) CleanupExpiredMagicLinkTokens() error {
+	_, err := db.Exec(`DELETE FROM magic_link_tokens WHERE expires_at < NOW() - INTERVAL '1 day'`)
+	return err
+}
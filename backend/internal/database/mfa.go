@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// UserMFA represents a user's two-factor authentication state
+type UserMFA struct {
+	UserID             int
+	Enabled            bool
+	Secret             sql.NullString
+	RecoveryCodeHashes []string
+	LastUsedStep       int64
+	EnabledAt          sql.NullTime
+}
+
+// GetUserMFA retrieves the two-factor authentication state for a user
+func (db *DB) GetUserMFA(userID int) (*UserMFA, error) {
+	mfa := &UserMFA{UserID: userID}
+	var codesJSON sql.NullString
+	err := db.QueryRow(
+		`SELECT mfa_enabled, mfa_secret, mfa_recovery_codes, mfa_last_used_step, mfa_enabled_at FROM users WHERE id = $1`,
+		userID,
+	).Scan(&mfa.Enabled, &mfa.Secret, &codesJSON, &mfa.LastUsedStep, &mfa.EnabledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if codesJSON.Valid && codesJSON.String != "" {
+		if err := json.Unmarshal([]byte(codesJSON.String), &mfa.RecoveryCodeHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	return mfa, nil
+}
+
+// SetPendingMFASecret stores a new TOTP secret that has not been confirmed yet
+func (db *DB) SetPendingMFASecret(userID int, secret string) error {
+	_, err := db.Exec(`UPDATE users SET mfa_secret = $1, mfa_enabled = FALSE, mfa_last_used_step = 0, updated_at = NOW() WHERE id = $2`, secret, userID)
+	return err
+}
+
+// EnableUserMFA marks two-factor authentication as enabled and stores hashed recovery codes
+func (db *DB) EnableUserMFA(userID int, lastUsedStep int64, recoveryCodeHashes []string) error {
+	codesJSON, err := json.Marshal(recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`UPDATE users SET mfa_enabled = TRUE, mfa_last_used_step = $1, mfa_recovery_codes = $2, mfa_enabled_at = $3, updated_at = NOW() WHERE id = $4`,
+		lastUsedStep, string(codesJSON), time.Now(), userID,
+	)
+	return err
+}
+
+// DisableUserMFA turns off two-factor authentication and clears the secret and recovery codes
+func (db *DB) DisableUserMFA(userID int) error {
+	_, err := db.Exec(`UPDATE users SET mfa_enabled = FALSE, mfa_secret = NULL, mfa_recovery_codes = '[]', mfa_last_used_step = 0, mfa_enabled_at = NULL, updated_at = NOW() WHERE id = $1`, userID)
+	return err
+}
+
+// AdvanceMFALastUsedStep records the TOTP time step that was just used.
+// It returns false if the step was already consumed by a concurrent request.
+func (db *DB) AdvanceMFALastUsedStep(userID int, step int64) (bool, error) {
+	result, err := db.Exec(`UPDATE users SET mfa_last_used_step = $1 WHERE id = $2 AND mfa_last_used_step < $1`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes for a user with new hashes
+func (db *DB) ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error {
+	codesJSON, err := json.Marshal(recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE users SET mfa_recovery_codes = $1, updated_at = NOW() WHERE id = $2`, string(codesJSON), userID)
+	return err
+}
+
+// ConsumeRecoveryCode removes a recovery code hash if present.
+// It returns true only if the code existed and was removed, so each code works once.
+func (db *DB) ConsumeRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE users SET mfa_recovery_codes = mfa_recovery_codes - $1::text, updated_at = NOW() WHERE id = $2 AND mfa_enabled = TRUE AND mfa_recovery_codes ? $1::text`,
+		codeHash, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
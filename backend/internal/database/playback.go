@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Media types tracked in playback_progress
+const (
+	PlaybackMediaVideo   = "video"
+	PlaybackMediaYouTube = "youtube"
+)
+
+// PlaybackProgress is how far a user has got through a video
+type PlaybackProgress struct {
+	UserID          int          `json:"-"`
+	MediaType       string       `json:"media_type"`
+	MediaID         string       `json:"media_id"`
+	PositionSeconds int          `json:"position_seconds"`
+	DurationSeconds int          `json:"duration_seconds"`
+	WatchedSeconds  int          `json:"watched_seconds"`
+	Completed       bool         `json:"completed"`
+	CompletedAt     sql.NullTime `json:"-"`
+	LastWatchedAt   time.Time    `json:"last_watched_at"`
+}
+
+// WatchHistoryItem is a video in a user's watch history or continue watching feed.
+// Title and thumbnail are only filled in for Bunny Stream videos.
+type WatchHistoryItem struct {
+	PlaybackProgress
+	Title        string `json:"title,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+const playbackProgressColumns = `user_id, media_type, media_id, position_seconds, duration_seconds, watched_seconds, completed, completed_at, last_watched_at`
+
+func scanPlaybackProgress(row interface{ Scan(...interface{}) error }) (*PlaybackProgress, error) {
+	progress := &PlaybackProgress{}
+	err := row.Scan(&progress.UserID, &progress.MediaType, &progress.MediaID, &progress.PositionSeconds, &progress.DurationSeconds, &progress.WatchedSeconds, &progress.Completed, &progress.CompletedAt, &progress.LastWatchedAt)
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// SavePlaybackProgress records a user's position in a video and adds watchedSeconds to
+// their total watch time. It reports whether this save is the one that completed the
+// video, so completion is only acted on once per viewing.
+func (db *DB) SavePlaybackProgress(progress *PlaybackProgress) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var wasCompleted bool
+	err = tx.QueryRow(`
+		SELECT completed FROM playback_progress
+		WHERE user_id = $1 AND media_type = $2 AND media_id = $3
+		FOR UPDATE
+	`, progress.UserID, progress.MediaType, progress.MediaID).Scan(&wasCompleted)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO playback_progress (user_id, media_type, media_id, position_seconds, duration_seconds, watched_seconds, completed, completed_at, last_watched_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $7 THEN NOW() END, $8, NOW(), NOW())
+		ON CONFLICT (user_id, media_type, media_id) DO UPDATE SET
+			position_seconds = EXCLUDED.position_seconds,
+			duration_seconds = GREATEST(playback_progress.duration_seconds, EXCLUDED.duration_seconds),
+			watched_seconds = playback_progress.watched_seconds + EXCLUDED.watched_seconds,
+			completed = EXCLUDED.completed,
+			completed_at = CASE WHEN EXCLUDED.completed THEN COALESCE(playback_progress.completed_at, NOW()) ELSE playback_progress.completed_at END,
+			last_watched_at = GREATEST(playback_progress.last_watched_at, EXCLUDED.last_watched_at),
+			updated_at = NOW()
+	`, progress.UserID, progress.MediaType, progress.MediaID, progress.PositionSeconds, progress.DurationSeconds, progress.WatchedSeconds, progress.Completed, progress.LastWatchedAt)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return progress.Completed && !wasCompleted, nil
+}
+
+// GetPlaybackProgress retrieves a user's progress through a video
+func (db *DB) GetPlaybackProgress(userID int, mediaType, mediaID string) (*PlaybackProgress, error) {
+	return scanPlaybackProgress(db.QueryRow(`
+		SELECT `+playbackProgressColumns+` FROM playback_progress
+		WHERE user_id = $1 AND media_type = $2 AND media_id = $3
+	`, userID, mediaType, mediaID))
+}
+
+// GetContinueWatching lists the videos a user has started but not finished, most
+// recently watched first. Bunny Stream videos that have since been deleted are left out.
+func (db *DB) GetContinueWatching(userID, limit, offset int) ([]*WatchHistoryItem, error) {
+	return db.getWatchHistoryItems(`AND NOT p.completed AND p.position_seconds > 0`, userID, limit, offset)
+}
+
+// GetWatchHistory lists every video a user has watched, finished or not, most recently
+// watched first
+func (db *DB) GetWatchHistory(userID, limit, offset int) ([]*WatchHistoryItem, error) {
+	return db.getWatchHistoryItems(``, userID, limit, offset)
+}
+
+func (db *DB) getWatchHistoryItems(filter string, userID, limit, offset int) ([]*WatchHistoryItem, error) {
+	rows, err := db.Query(`
+		SELECT p.user_id, p.media_type, p.media_id, p.position_seconds, p.duration_seconds, p.watched_seconds,
+			p.completed, p.completed_at, p.last_watched_at, COALESCE(v.title, ''), COALESCE(v.thumbnail_url, '')
+		FROM playback_progress p
+		LEFT JOIN videos v ON p.media_type = 'video' AND v.id::TEXT = p.media_id
+		WHERE p.user_id = $1 AND (p.media_type <> 'video' OR v.id IS NOT NULL) `+filter+`
+		ORDER BY p.last_watched_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*WatchHistoryItem
+	for rows.Next() {
+		item := &WatchHistoryItem{}
+		p := &item.PlaybackProgress
+		if err := rows.Scan(&p.UserID, &p.MediaType, &p.MediaID, &p.PositionSeconds, &p.DurationSeconds, &p.WatchedSeconds, &p.Completed, &p.CompletedAt, &p.LastWatchedAt, &item.Title, &item.ThumbnailURL); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ClearWatchHistory removes all of a user's watch history and playback positions
+func (db *DB) ClearWatchHistory(userID int) (int64, error) {
+	result, err := db.Exec(`DELETE FROM playback_progress WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteWatchHistoryItem removes one video from a user's watch history
+func (db *DB) DeleteWatchHistoryItem(userID int, mediaType, mediaID string) error {
+	result, err := db.Exec(`DELETE FROM playback_progress WHERE user_id = $1 AND media_type = $2 AND media_id = $3`, userID, mediaType, mediaID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsWatchHistoryPaused reports whether a user has paused their watch history
+func (db *DB) IsWatchHistoryPaused(userID int) (bool, error) {
+	var paused bool
+	err := db.QueryRow(`SELECT watch_history_paused FROM users WHERE id = $1`, userID).Scan(&paused)
+	return paused, err
+}
+
+// SetWatchHistoryPaused pauses or resumes a user's watch history
+func (db *DB) SetWatchHistoryPaused(userID int, paused bool) error {
+	result, err := db.Exec(`UPDATE users SET watch_history_paused = $2, updated_at = NOW() WHERE id = $1`, userID, paused)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
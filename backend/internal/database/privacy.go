@@ -0,0 +1,352 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DataExport is a request for an archive of everything stored about a user
+type DataExport struct {
+	ID          int          `json:"id"`
+	UserID      int          `json:"user_id"`
+	Status      string       `json:"status"` // pending, processing, completed, failed, expired
+	FileKey     string       `json:"-"`
+	Error       string       `json:"-"`
+	RequestedAt time.Time    `json:"requested_at"`
+	CompletedAt sql.NullTime `json:"-"`
+	ExpiresAt   sql.NullTime `json:"-"`
+}
+
+// AccountDeletion is a pending request to erase a user's account
+type AccountDeletion struct {
+	UserID       int       `json:"user_id"`
+	RequestedAt  time.Time `json:"requested_at"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+}
+
+// userExportQueries select each section of a personal data export as a JSON array.
+// Sessions leave out the token identifiers.
+var userExportQueries = map[string]string{
+	"activity":      `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM user_activity t WHERE t.user_id = $1`,
+	"comments":      `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM comments t WHERE t.user_id = $1`,
+	"likes":         `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM likes t WHERE t.user_id = $1`,
+	"favorites":     `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM favorites t WHERE t.user_id = $1`,
+	"playback":      `SELECT COALESCE(json_agg(t ORDER BY t.last_watched_at), '[]'::json) FROM playback_progress t WHERE t.user_id = $1`,
+	"subscriptions": `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM subscriptions t WHERE t.user_id = $1`,
+	"sessions": `
+		SELECT COALESCE(json_agg(json_build_object(
+			'device_info', device_info,
+			'ip_address', ip_address,
+			'user_agent', user_agent,
+			'is_active', is_active,
+			'created_at', created_at,
+			'last_activity', last_activity,
+			'expires_at', expires_at
+		) ORDER BY created_at), '[]'::json)
+		FROM user_sessions WHERE user_id = $1
+	`,
+	"audit_log": `SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]'::json) FROM audit_logs t WHERE t.user_id = $1`,
+}
+
+// ExportUserData gathers a user's personal data, keyed by section name, with each
+// section encoded as JSON
+func (db *DB) ExportUserData(userID int) (map[string]json.RawMessage, error) {
+	profile, err := db.GetUserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := map[string]json.RawMessage{"profile": profileJSON}
+	for name, query := range userExportQueries {
+		var section []byte
+		if err := db.QueryRow(query, userID).Scan(&section); err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", name, err)
+		}
+		sections[name] = section
+	}
+
+	return sections, nil
+}
+
+const dataExportColumns = `id, user_id, status, COALESCE(file_key, ''), COALESCE(error, ''), requested_at, completed_at, expires_at`
+
+func scanDataExport(row interface{ Scan(...interface{}) error }) (*DataExport, error) {
+	export := &DataExport{}
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.FileKey, &export.Error, &export.RequestedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// CreateDataExport queues a personal data export for a user
+func (db *DB) CreateDataExport(userID int) (*DataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		INSERT INTO data_exports (user_id, status, requested_at)
+		VALUES ($1, 'pending', NOW())
+		RETURNING `+dataExportColumns, userID))
+}
+
+// GetLatestDataExport returns the user's most recent export request
+func (db *DB) GetLatestDataExport(userID int) (*DataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE user_id = $1
+		ORDER BY requested_at DESC, id DESC
+		LIMIT 1
+	`, userID))
+}
+
+// ClaimPendingDataExport marks the oldest pending export as processing and returns it.
+// Concurrent workers never claim the same export. It returns sql.ErrNoRows if none is pending.
+func (db *DB) ClaimPendingDataExport() (*DataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		UPDATE data_exports SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending'
+			ORDER BY requested_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + dataExportColumns))
+}
+
+// RequeueStaleDataExports queues exports again whose worker stopped before finishing,
+// for example because of a restart. Exports already claimed maxAttempts times are
+// marked as failed instead.
+func (db *DB) RequeueStaleDataExports(staleAfter time.Duration, maxAttempts int) error {
+	_, err := db.Exec(`
+		UPDATE data_exports SET
+			status = CASE WHEN attempts >= $2 THEN 'failed' ELSE 'pending' END,
+			error = 'export was interrupted',
+			completed_at = CASE WHEN attempts >= $2 THEN NOW() END,
+			updated_at = NOW()
+		WHERE status = 'processing' AND updated_at < $1
+	`, time.Now().Add(-staleAfter), maxAttempts)
+	return err
+}
+
+// CompleteDataExport records where a finished export was stored and when its link expires
+func (db *DB) CompleteDataExport(id int, fileKey string, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE data_exports SET status = 'completed', file_key = $2, error = NULL, completed_at = NOW(), expires_at = $3, updated_at = NOW()
+		WHERE id = $1
+	`, id, fileKey, expiresAt)
+	return err
+}
+
+// FailDataExport marks an export as failed
+func (db *DB) FailDataExport(id int, reason string) error {
+	_, err := db.Exec(`UPDATE data_exports SET status = 'failed', error = $2, completed_at = NOW(), updated_at = NOW() WHERE id = $1`, id, reason)
+	return err
+}
+
+// GetExpiredDataExports returns completed exports whose download link has expired
+func (db *DB) GetExpiredDataExports() ([]*DataExport, error) {
+	rows, err := db.Query(`
+		SELECT ` + dataExportColumns + ` FROM data_exports
+		WHERE status = 'completed' AND expires_at < NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []*DataExport
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// GetUserDataExportFiles returns the storage keys of a user's export archives
+func (db *DB) GetUserDataExportFiles(userID int) ([]string, error) {
+	rows, err := db.Query(`SELECT file_key FROM data_exports WHERE user_id = $1 AND file_key IS NOT NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// ExpireDataExport marks an export as expired once its archive has been removed
+func (db *DB) ExpireDataExport(id int) error {
+	_, err := db.Exec(`UPDATE data_exports SET status = 'expired', file_key = NULL, updated_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// ScheduleAccountDeletion schedules a user's account for erasure. If a deletion is
+// already scheduled, the existing one is returned unchanged.
+func (db *DB) ScheduleAccountDeletion(userID int, scheduledFor time.Time) (*AccountDeletion, error) {
+	if _, err := db.Exec(`
+		INSERT INTO account_deletions (user_id, requested_at, scheduled_for)
+		VALUES ($1, NOW(), $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, scheduledFor); err != nil {
+		return nil, err
+	}
+	return db.GetAccountDeletion(userID)
+}
+
+// GetAccountDeletion returns the user's scheduled deletion, or sql.ErrNoRows if none
+func (db *DB) GetAccountDeletion(userID int) (*AccountDeletion, error) {
+	deletion := &AccountDeletion{}
+	err := db.QueryRow(`
+		SELECT user_id, requested_at, scheduled_for FROM account_deletions WHERE user_id = $1
+	`, userID).Scan(&deletion.UserID, &deletion.RequestedAt, &deletion.ScheduledFor)
+	if err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// CancelAccountDeletion cancels a scheduled deletion. It returns sql.ErrNoRows if none was scheduled.
+func (db *DB) CancelAccountDeletion(userID int) error {
+	result, err := db.Exec(`DELETE FROM account_deletions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetDueAccountDeletions returns the users whose grace period has ended
+func (db *DB) GetDueAccountDeletions() ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM account_deletions WHERE scheduled_for <= NOW() ORDER BY scheduled_for`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// userDetachStatements clear references to a user from records that are kept after
+// the user is deleted
+var userDetachStatements = []string{
+	`UPDATE videos SET created_by = NULL WHERE created_by = $1`,
+	`UPDATE youtube_videos SET created_by = NULL WHERE created_by = $1`,
+	`UPDATE audit_logs SET user_id = NULL, user_email = NULL WHERE user_id = $1`,
+	`UPDATE admin_logs SET admin_user_id = NULL WHERE admin_user_id = $1`,
+	`UPDATE ad_campaigns SET approved_by = NULL WHERE approved_by = $1`,
+	`UPDATE ad_clicks SET user_id = NULL WHERE user_id = $1`,
+	`UPDATE ad_impressions SET user_id = NULL WHERE user_id = $1`,
+	`UPDATE ad_audit_log SET actor_id = NULL WHERE actor_id = $1`,
+}
+
+// userPersonalDataStatements remove a user's personal data while keeping the user row
+var userPersonalDataStatements = []string{
+	`DELETE FROM user_activity WHERE user_id = $1`,
+	`DELETE FROM comments WHERE user_id = $1`,
+	`DELETE FROM likes WHERE user_id = $1`,
+	`DELETE FROM favorites WHERE user_id = $1`,
+	`DELETE FROM playback_progress WHERE user_id = $1`,
+	`DELETE FROM video_view_claims WHERE viewer_key = 'user:' || $1::TEXT`,
+	`DELETE FROM user_sessions WHERE user_id = $1`,
+	`DELETE FROM refresh_tokens WHERE user_id = $1`,
+	`DELETE FROM api_tokens WHERE user_id = $1`,
+	`DELETE FROM magic_link_tokens WHERE user_id = $1`,
+	`DELETE FROM user_identities WHERE user_id = $1`,
+	`DELETE FROM oidc_auth_states WHERE user_id = $1`,
+	`DELETE FROM user_known_devices WHERE user_id = $1`,
+	`DELETE FROM user_roles WHERE user_id = $1`,
+	`DELETE FROM user_permission_overrides WHERE user_id = $1`,
+	`DELETE FROM data_exports WHERE user_id = $1`,
+	`DELETE FROM account_deletions WHERE user_id = $1`,
+	`UPDATE audit_logs SET user_email = NULL WHERE user_id = $1`,
+	`UPDATE advertiser_accounts SET
+		contact_name = 'Deleted User',
+		contact_phone = NULL,
+		business_email = 'deleted-advertiser-' || id || '@deleted.invalid',
+		updated_at = NOW()
+	WHERE user_id = $1`,
+	`UPDATE users SET
+		email = 'deleted-user-' || id || '@deleted.invalid',
+		password_hash = '',
+		first_name = 'Deleted',
+		last_name = 'User',
+		role = 'user',
+		email_verified = FALSE,
+		reset_token = NULL,
+		reset_token_expiry = NULL,
+		verification_token = NULL,
+		bio = NULL,
+		location = NULL,
+		website = NULL,
+		phone = NULL,
+		avatar_url = NULL,
+		preferences = '{}',
+		mfa_enabled = FALSE,
+		mfa_secret = NULL,
+		mfa_recovery_codes = '[]',
+		mfa_enabled_at = NULL,
+		is_active = FALSE,
+		deleted_at = NOW(),
+		updated_at = NOW()
+	WHERE id = $1`,
+}
+
+// DeleteUser erases a user and their personal data. Users referenced by billing
+// records (subscriptions or advertiser accounts) are anonymized instead of deleted so
+// those records stay intact; anonymized reports which was done.
+func (db *DB) DeleteUser(userID int) (anonymized bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT TRUE FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1)
+			OR EXISTS (SELECT 1 FROM advertiser_accounts WHERE user_id = $1)
+	`, userID).Scan(&anonymized); err != nil {
+		return false, err
+	}
+
+	statements := append(append([]string{}, userDetachStatements...), `DELETE FROM users WHERE id = $1`)
+	if anonymized {
+		statements = userPersonalDataStatements
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return false, fmt.Errorf("failed to erase user %d: %w", userID, err)
+		}
+	}
+
+	return anonymized, tx.Commit()
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Subscription tiers, in ascending order of what they can play
+const (
+	TierFree    = "free"
+	TierBasic   = "basic"
+	TierPremium = "premium"
+)
+
+// RecommendationCandidate is a ready video considered when computing related videos
+type RecommendationCandidate struct {
+	ID        int
+	Title     string
+	Category  string
+	Tags      []string
+	CreatedAt time.Time
+}
+
+// VideoPair is an unordered pair of videos, with the lower ID first
+type VideoPair struct {
+	A int
+	B int
+}
+
+// CoWatchCounts counts engaged viewers per video and per pair of videos
+type CoWatchCounts struct {
+	Viewers map[int]int
+	Pairs   map[VideoPair]int
+}
+
+// VideoRecommendationScore is a related video and the signals behind its score
+type VideoRecommendationScore struct {
+	VideoID        int
+	RelatedVideoID int
+	Score          float64
+	TagScore       float64
+	CategoryScore  float64
+	CoWatchScore   float64
+	RecencyScore   float64
+	SharedTags     []string
+	CoWatchers     int
+}
+
+// RelatedVideo is a recommended video. SourceID and SourceTitle are the video it is
+// related to; Because and Reasons explain the recommendation and are filled in by the
+// recommendation service.
+type RelatedVideo struct {
+	VideoID       int       `json:"video_id"`
+	Title         string    `json:"title"`
+	ThumbnailURL  string    `json:"thumbnail_url"`
+	Category      string    `json:"category"`
+	Duration      int       `json:"duration"`
+	RequiredTier  string    `json:"required_tier"`
+	CreatedAt     time.Time `json:"created_at"`
+	Score         float64   `json:"score"`
+	TagScore      float64   `json:"-"`
+	CategoryScore float64   `json:"-"`
+	CoWatchScore  float64   `json:"-"`
+	RecencyScore  float64   `json:"-"`
+	SharedTags    []string  `json:"-"`
+	CoWatchers    int       `json:"-"`
+	SourceID      int       `json:"source_video_id"`
+	SourceTitle   string    `json:"-"`
+	Because       string    `json:"because,omitempty"`
+	Reasons       []string  `json:"reasons"`
+}
+
+const relatedVideoColumns = `v.id, v.title, COALESCE(v.thumbnail_url, ''), COALESCE(v.category, ''), COALESCE(v.duration, 0), v.required_tier, v.created_at,
+	r.tag_score, r.category_score, r.co_watch_score, r.recency_score, r.shared_tags, r.co_watchers, src.id, src.title`
+
+func scanRelatedVideo(row interface{ Scan(...interface{}) error }, score *float64) (*RelatedVideo, error) {
+	video := &RelatedVideo{}
+	var sharedTags []byte
+	err := row.Scan(&video.VideoID, &video.Title, &video.ThumbnailURL, &video.Category, &video.Duration, &video.RequiredTier, &video.CreatedAt,
+		&video.TagScore, &video.CategoryScore, &video.CoWatchScore, &video.RecencyScore, &sharedTags, &video.CoWatchers, &video.SourceID, &video.SourceTitle, score)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(sharedTags, &video.SharedTags); err != nil {
+		return nil, err
+	}
+	return video, nil
+}
+
+// GetRecommendationCandidates returns every ready video, with tags that cannot be
+// parsed treated as empty
+func (db *DB) GetRecommendationCandidates() ([]*RecommendationCandidate, error) {
+	rows, err := db.Query(`SELECT id, title, COALESCE(category, ''), COALESCE(tags, ''), created_at FROM videos WHERE status = 'ready'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*RecommendationCandidate
+	for rows.Next() {
+		candidate := &RecommendationCandidate{}
+		var tagsStr string
+		if err := rows.Scan(&candidate.ID, &candidate.Title, &candidate.Category, &tagsStr, &candidate.CreatedAt); err != nil {
+			return nil, err
+		}
+		if tagsStr != "" {
+			_ = json.Unmarshal([]byte(tagsStr), &candidate.Tags)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// engagedVideosSQL lists each user's engagement with a video once, from likes and
+// the activity types in $1 recorded since $2
+const engagedVideosSQL = `
+	WITH engaged AS (
+		SELECT user_id, video_id FROM user_activity
+		WHERE user_id IS NOT NULL AND video_id IS NOT NULL AND activity_type = ANY($1) AND created_at >= $2
+		UNION
+		SELECT user_id, video_id FROM likes
+		WHERE user_id IS NOT NULL AND video_id IS NOT NULL
+	)`
+
+// GetCoWatchCounts counts the users who engaged with each video, and with each pair of
+// videos, through likes or the given activity types since the given time
+func (db *DB) GetCoWatchCounts(activityTypes []string, since time.Time) (*CoWatchCounts, error) {
+	counts := &CoWatchCounts{Viewers: make(map[int]int), Pairs: make(map[VideoPair]int)}
+
+	rows, err := db.Query(engagedVideosSQL+`
+		SELECT video_id, COUNT(*) FROM engaged GROUP BY video_id
+	`, pq.Array(activityTypes), since)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var videoID, viewers int
+		if err := rows.Scan(&videoID, &viewers); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		counts.Viewers[videoID] = viewers
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(engagedVideosSQL+`
+		SELECT a.video_id, b.video_id, COUNT(*)
+		FROM engaged a
+		JOIN engaged b ON b.user_id = a.user_id AND b.video_id > a.video_id
+		GROUP BY a.video_id, b.video_id
+	`, pq.Array(activityTypes), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pair VideoPair
+		var viewers int
+		if err := rows.Scan(&pair.A, &pair.B, &viewers); err != nil {
+			return nil, err
+		}
+		counts.Pairs[pair] = viewers
+	}
+	return counts, rows.Err()
+}
+
+// ReplaceVideoRecommendations replaces every precomputed related video in one
+// transaction, so readers see either the old lists or the new ones
+func (db *DB) ReplaceVideoRecommendations(scores []VideoRecommendationScore) error {
+	videoIDs := make([]int64, len(scores))
+	relatedIDs := make([]int64, len(scores))
+	totals := make([]float64, len(scores))
+	tagScores := make([]float64, len(scores))
+	categoryScores := make([]float64, len(scores))
+	coWatchScores := make([]float64, len(scores))
+	recencyScores := make([]float64, len(scores))
+	sharedTags := make([]string, len(scores))
+	coWatchers := make([]int64, len(scores))
+	for i, score := range scores {
+		tags := score.SharedTags
+		if tags == nil {
+			tags = []string{}
+		}
+		tagsJSON, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		videoIDs[i] = int64(score.VideoID)
+		relatedIDs[i] = int64(score.RelatedVideoID)
+		totals[i] = score.Score
+		tagScores[i] = score.TagScore
+		categoryScores[i] = score.CategoryScore
+		coWatchScores[i] = score.CoWatchScore
+		recencyScores[i] = score.RecencyScore
+		sharedTags[i] = string(tagsJSON)
+		coWatchers[i] = int64(score.CoWatchers)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM video_recommendations`); err != nil {
+		return err
+	}
+
+	if len(scores) > 0 {
+		// Videos deleted since the candidates were loaded are skipped by the join
+		_, err = tx.Exec(`
+			INSERT INTO video_recommendations (video_id, related_video_id, score, tag_score, category_score, co_watch_score, recency_score, shared_tags, co_watchers, computed_at)
+			SELECT s.video_id, s.related_video_id, s.score, s.tag_score, s.category_score, s.co_watch_score, s.recency_score, s.shared_tags::JSONB, s.co_watchers, NOW()
+			FROM unnest($1::INTEGER[], $2::INTEGER[], $3::DOUBLE PRECISION[], $4::DOUBLE PRECISION[], $5::DOUBLE PRECISION[], $6::DOUBLE PRECISION[], $7::DOUBLE PRECISION[], $8::TEXT[], $9::INTEGER[])
+				AS s(video_id, related_video_id, score, tag_score, category_score, co_watch_score, recency_score, shared_tags, co_watchers)
+			JOIN videos a ON a.id = s.video_id
+			JOIN videos b ON b.id = s.related_video_id
+		`, pq.Array(videoIDs), pq.Array(relatedIDs), pq.Array(totals), pq.Array(tagScores), pq.Array(categoryScores),
+			pq.Array(coWatchScores), pq.Array(recencyScores), pq.Array(sharedTags), pq.Array(coWatchers))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRelatedVideos returns the videos related to a video that one of tiers can play,
+// best first. Videos the user has finished are left out; userID is zero for anonymous
+// viewers.
+func (db *DB) GetRelatedVideos(videoID, userID int, tiers []string, limit int) ([]*RelatedVideo, error) {
+	rows, err := db.Query(`
+		SELECT `+relatedVideoColumns+`, r.score
+		FROM video_recommendations r
+		JOIN videos v ON v.id = r.related_video_id
+		JOIN videos src ON src.id = r.video_id
+		WHERE r.video_id = $1 AND v.status = 'ready' AND v.required_tier = ANY($2)
+			AND NOT EXISTS (
+				SELECT 1 FROM playback_progress p
+				WHERE p.user_id = $3 AND p.media_type = 'video' AND p.media_id = v.id::TEXT AND p.completed
+			)
+		ORDER BY r.score DESC
+		LIMIT $4
+	`, videoID, pq.Array(tiers), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []*RelatedVideo{}
+	for rows.Next() {
+		var score float64
+		video, err := scanRelatedVideo(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		video.Score = score
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetRecommendedVideosForUser recommends videos related to what a user watched most
+// recently. Each video's score adds up its relatedness to every recently watched video,
+// weighted towards the most recent, and its source is the watched video it is most
+// related to. Videos the user has finished are left out.
+func (db *DB) GetRecommendedVideosForUser(userID int, tiers []string, seeds, limit int) ([]*RelatedVideo, error) {
+	rows, err := db.Query(`
+		WITH seeds AS (
+			SELECT p.media_id::INTEGER AS video_id, ROW_NUMBER() OVER (ORDER BY p.last_watched_at DESC) AS recency_rank
+			FROM playback_progress p
+			WHERE p.user_id = $1 AND p.media_type = 'video' AND p.media_id ~ '^[0-9]+$'
+			ORDER BY p.last_watched_at DESC
+			LIMIT $3
+		),
+		weighted AS (
+			SELECT r.video_id, r.related_video_id, r.score / s.recency_rank AS weight
+			FROM video_recommendations r
+			JOIN seeds s ON s.video_id = r.video_id
+		),
+		ranked AS (
+			SELECT related_video_id, SUM(weight) AS score, (ARRAY_AGG(video_id ORDER BY weight DESC))[1] AS source_id
+			FROM weighted
+			GROUP BY related_video_id
+		)
+		SELECT `+relatedVideoColumns+`, k.score
+		FROM ranked k
+		JOIN video_recommendations r ON r.video_id = k.source_id AND r.related_video_id = k.related_video_id
+		JOIN videos v ON v.id = k.related_video_id
+		JOIN videos src ON src.id = k.source_id
+		WHERE v.status = 'ready' AND v.required_tier = ANY($2)
+			AND NOT EXISTS (
+				SELECT 1 FROM playback_progress p
+				WHERE p.user_id = $1 AND p.media_type = 'video' AND p.media_id = v.id::TEXT AND p.completed
+			)
+		ORDER BY k.score DESC
+		LIMIT $4
+	`, userID, pq.Array(tiers), seeds, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []*RelatedVideo{}
+	for rows.Next() {
+		var score float64
+		video, err := scanRelatedVideo(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		video.Score = score
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetNextInSeries returns the video after videoID in a published series that one of
+// tiers can play, and the series' title. Series are preferred over playlists. It
+// returns sql.ErrNoRows if the video is last or in no published series.
+func (db *DB) GetNextInSeries(videoID int, tiers []string) (*RelatedVideo, string, error) {
+	video := &RelatedVideo{SharedTags: []string{}}
+	var seriesTitle string
+	err := db.QueryRow(`
+		SELECT v.id, v.title, COALESCE(v.thumbnail_url, ''), COALESCE(v.category, ''), COALESCE(v.duration, 0), v.required_tier, v.created_at, s.title
+		FROM series_items cur
+		JOIN series s ON s.id = cur.series_id AND s.status = 'published'
+		JOIN LATERAL (
+			SELECT i.video_id FROM series_items i
+			WHERE i.series_id = cur.series_id AND i.position > cur.position
+			ORDER BY i.position
+			LIMIT 1
+		) n ON TRUE
+		JOIN videos v ON v.id = n.video_id
+		WHERE cur.video_id = $1 AND v.status = 'ready' AND v.required_tier = ANY($2)
+		ORDER BY (s.kind = 'series') DESC, s.updated_at DESC
+		LIMIT 1
+	`, videoID, pq.Array(tiers)).Scan(&video.VideoID, &video.Title, &video.ThumbnailURL, &video.Category, &video.Duration, &video.RequiredTier, &video.CreatedAt, &seriesTitle)
+	if err != nil {
+		return nil, "", err
+	}
+	video.SourceID = videoID
+	return video, seriesTitle, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken represents one refresh token in a rotation family.
+// Every login starts a new family; each refresh rotates to a new token in the same family.
+type RefreshToken struct {
+	TokenID       string
+	FamilyID      string
+	UserID        int
+	SessionID     sql.NullString
+	AccessTokenID sql.NullString
+	ReplacedBy    sql.NullString
+	RotatedAt     sql.NullTime
+	RevokedAt     sql.NullTime
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// CreateRefreshToken stores the first refresh token of a family and links it to its session
+func (db *DB) CreateRefreshToken(token *RefreshToken) error {
+	_, err := db.Exec(`
+		INSERT INTO refresh_tokens (token_id, family_id, user_id, session_id, access_token_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, token.TokenID, token.FamilyID, token.UserID, token.SessionID, token.AccessTokenID, token.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	if token.SessionID.Valid {
+		_, err = db.Exec(`UPDATE user_sessions SET family_id = $1 WHERE session_id = $2`, token.FamilyID, token.SessionID.String)
+	}
+	return err
+}
+
+// GetRefreshToken retrieves a refresh token record by token ID
+func (db *DB) GetRefreshToken(tokenID string) (*RefreshToken, error) {
+	token := &RefreshToken{}
+	err := db.QueryRow(`
+		SELECT token_id, family_id, user_id, session_id, access_token_id, replaced_by, rotated_at, revoked_at, expires_at, created_at
+		FROM refresh_tokens WHERE token_id = $1
+	`, tokenID).Scan(&token.TokenID, &token.FamilyID, &token.UserID, &token.SessionID, &token.AccessTokenID, &token.ReplacedBy, &token.RotatedAt, &token.RevokedAt, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks the old token as rotated and stores its replacement in one transaction.
+// It returns false if the old token was already rotated or revoked, which indicates reuse.
+func (db *DB) RotateRefreshToken(oldTokenID string, next *RefreshToken) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens SET rotated_at = NOW(), replaced_by = $1
+		WHERE token_id = $2 AND rotated_at IS NULL AND revoked_at IS NULL
+	`, next.TokenID, oldTokenID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO refresh_tokens (token_id, family_id, user_id, session_id, access_token_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, next.TokenID, next.FamilyID, next.UserID, next.SessionID, next.AccessTokenID, next.ExpiresAt); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE user_sessions SET token_id = $1, last_activity = NOW(), expires_at = $2
+		WHERE family_id = $3 AND is_active = TRUE
+	`, next.TokenID, next.ExpiresAt, next.FamilyID); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in a family and returns the tokens that
+// were still live so their IDs can be added to the revocation store
+func (db *DB) RevokeRefreshTokenFamily(familyID string) ([]*RefreshToken, error) {
+	rows, err := db.Query(`
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING token_id, family_id, user_id, session_id, access_token_id, expires_at
+	`, familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*RefreshToken
+	for rows.Next() {
+		token := &RefreshToken{}
+		if err := rows.Scan(&token.TokenID, &token.FamilyID, &token.UserID, &token.SessionID, &token.AccessTokenID, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// CleanupExpiredRefreshTokens removes refresh token records that have expired
+func (db *DB) CleanupExpiredRefreshTokens() error {
+	_, err := db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < NOW()`)
+	return err
+}
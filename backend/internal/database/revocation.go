@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RevokeToken records a revoked token ID until the token would have expired
+func (db *DB) RevokeToken(tokenID string, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO revoked_tokens (token_id, expires_at, revoked_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (token_id) DO UPDATE SET expires_at = GREATEST(revoked_tokens.expires_at, EXCLUDED.expires_at)
+	`, tokenID, expiresAt)
+	return err
+}
+
+// IsTokenRevoked reports whether a token ID has been revoked and has not yet expired
+func (db *DB) IsTokenRevoked(tokenID string) (bool, error) {
+	var revoked bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = $1 AND expires_at > NOW())`, tokenID).Scan(&revoked)
+	return revoked, err
+}
+
+// SetUserTokenWatermark revokes every token issued to the user before the given time
+func (db *DB) SetUserTokenWatermark(userID int, revokedBefore time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO user_token_watermarks (user_id, revoked_before, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = GREATEST(user_token_watermarks.revoked_before, EXCLUDED.revoked_before), updated_at = NOW()
+	`, userID, revokedBefore)
+	return err
+}
+
+// GetUserTokenWatermark returns the revocation watermark for a user, or the zero time if none is set
+func (db *DB) GetUserTokenWatermark(userID int) (time.Time, error) {
+	var revokedBefore time.Time
+	err := db.QueryRow(`SELECT revoked_before FROM user_token_watermarks WHERE user_id = $1`, userID).Scan(&revokedBefore)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return revokedBefore, err
+}
+
+// CleanupRevokedTokens removes expired revocations and watermarks older than the longest token lifetime
+func (db *DB) CleanupRevokedTokens(maxTokenLifetime time.Duration) error {
+	if _, err := db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM user_token_watermarks WHERE revoked_before < $1`, time.Now().Add(-maxTokenLifetime))
+	return err
+}
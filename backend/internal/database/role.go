@@ -0,0 +1,513 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Role represents a role stored in the roles table together with its permissions
+type Role struct {
+	ID              string
+	Name            string
+	Slug            string
+	Description     string
+	Category        string
+	Level           int
+	IsSystemRole    bool
+	Color           string
+	Icon            string
+	SubsystemAccess []string
+	Permissions     []string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Permission represents a permission stored in the permissions table
+type Permission struct {
+	ID          string
+	Resource    string
+	Action      string
+	Description string
+	Category    string
+	Subsystem   string
+}
+
+// UserRoleAssignment represents an additional role assigned to a user on top of users.role
+type UserRoleAssignment struct {
+	UserID     int
+	RoleID     string
+	AssignedBy sql.NullInt64
+	ExpiresAt  sql.NullTime
+	CreatedAt  time.Time
+}
+
+// PermissionOverride grants or denies a single permission to a user, optionally until ExpiresAt
+type PermissionOverride struct {
+	UserID       int
+	PermissionID string
+	Effect       string
+	Reason       sql.NullString
+	GrantedBy    sql.NullInt64
+	ExpiresAt    sql.NullTime
+	CreatedAt    time.Time
+}
+
+// UserWithRoles represents a user with their primary role and active additional roles
+type UserWithRoles struct {
+	ID        int
+	Email     string
+	FirstName string
+	LastName  string
+	Role      string
+	IsActive  bool
+	RoleIDs   []string
+	LastLogin sql.NullTime
+	CreatedAt time.Time
+}
+
+// Permission override effects
+const (
+	PermissionEffectGrant = "grant"
+	PermissionEffectDeny  = "deny"
+)
+
+const roleColumns = `r.id, r.name, r.slug, COALESCE(r.description, ''), COALESCE(r.category, ''), COALESCE(r.level, 1),
+	COALESCE(r.is_system_role, FALSE), COALESCE(r.color, ''), COALESCE(r.icon, ''), COALESCE(r.subsystem_access, '{}'),
+	COALESCE(ARRAY(SELECT rp.permission_id FROM role_permissions rp WHERE rp.role_id = r.id ORDER BY rp.permission_id), '{}'),
+	r.created_at, r.updated_at`
+
+// scanRole scans a row selected with roleColumns
+func scanRole(scanner interface{ Scan(...interface{}) error }) (*Role, error) {
+	role := &Role{}
+	err := scanner.Scan(&role.ID, &role.Name, &role.Slug, &role.Description, &role.Category, &role.Level,
+		&role.IsSystemRole, &role.Color, &role.Icon, pq.Array(&role.SubsystemAccess),
+		pq.Array(&role.Permissions), &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// SeedRoles inserts any permissions and roles that do not exist yet.
+// Existing rows are left untouched so changes made through the API survive restarts.
+func (db *DB) SeedRoles(roles []*Role, permissions []*Permission) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, permission := range permissions {
+		if _, err := tx.Exec(`
+			INSERT INTO permissions (id, resource, action, description, category, subsystem)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (id) DO NOTHING
+		`, permission.ID, permission.Resource, permission.Action, permission.Description, permission.Category, permission.Subsystem); err != nil {
+			return fmt.Errorf("failed to seed permission %s: %w", permission.ID, err)
+		}
+	}
+
+	for _, role := range roles {
+		result, err := tx.Exec(`
+			INSERT INTO roles (id, name, slug, description, category, level, is_system_role, color, icon, subsystem_access, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+			ON CONFLICT (id) DO NOTHING
+		`, role.ID, role.Name, role.Slug, role.Description, role.Category, role.Level, role.IsSystemRole, role.Color, role.Icon, pq.Array(role.SubsystemAccess))
+		if err != nil {
+			return fmt.Errorf("failed to seed role %s: %w", role.ID, err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			continue
+		}
+		if err := setRolePermissions(tx, role.ID, role.Permissions); err != nil {
+			return fmt.Errorf("failed to seed permissions for role %s: %w", role.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// setRolePermissions replaces the permissions of a role
+func setRolePermissions(tx *sql.Tx, roleID string, permissions []string) error {
+	if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_id = $1`, roleID); err != nil {
+		return err
+	}
+	for _, permissionID := range permissions {
+		if _, err := tx.Exec(`
+			INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, roleID, permissionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRoles retrieves roles with optional search and category filtering
+func (db *DB) GetRoles(search, category string) ([]*Role, error) {
+	query := `SELECT ` + roleColumns + ` FROM roles r WHERE 1=1`
+	args := []interface{}{}
+
+	if search != "" {
+		args = append(args, "%"+search+"%")
+		query += fmt.Sprintf(` AND (r.name ILIKE $%d OR r.description ILIKE $%d)`, len(args), len(args))
+	}
+	if category != "" {
+		args = append(args, category)
+		query += fmt.Sprintf(` AND LOWER(r.category) = LOWER($%d)`, len(args))
+	}
+	query += ` ORDER BY r.level DESC, r.name`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRole retrieves a role by ID
+func (db *DB) GetRole(roleID string) (*Role, error) {
+	return scanRole(db.QueryRow(`SELECT `+roleColumns+` FROM roles r WHERE r.id = $1`, roleID))
+}
+
+// CreateRole inserts a custom role and its permissions
+func (db *DB) CreateRole(role *Role) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+		INSERT INTO roles (id, name, slug, description, category, level, is_system_role, color, icon, subsystem_access, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, FALSE, $7, $8, $9, NOW(), NOW())
+		RETURNING created_at, updated_at
+	`, role.ID, role.Name, role.Slug, role.Description, role.Category, role.Level, role.Color, role.Icon, pq.Array(role.SubsystemAccess)).Scan(&role.CreatedAt, &role.UpdatedAt); err != nil {
+		return err
+	}
+	if err := setRolePermissions(tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateRole updates a role's details and replaces its permissions
+func (db *DB) UpdateRole(role *Role) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE roles SET name = $1, slug = $2, description = $3, category = $4, level = $5, color = $6, icon = $7,
+			subsystem_access = $8, updated_at = NOW()
+		WHERE id = $9
+	`, role.Name, role.Slug, role.Description, role.Category, role.Level, role.Color, role.Icon, pq.Array(role.SubsystemAccess), role.ID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	if err := setRolePermissions(tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRole deletes a custom role. System roles cannot be deleted.
+func (db *DB) DeleteRole(roleID string) error {
+	result, err := db.Exec(`DELETE FROM roles WHERE id = $1 AND is_system_role = FALSE`, roleID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPermissions retrieves all permissions
+func (db *DB) GetPermissions() ([]*Permission, error) {
+	rows, err := db.Query(`
+		SELECT id, resource, action, COALESCE(description, ''), COALESCE(category, ''), COALESCE(subsystem, '')
+		FROM permissions ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*Permission
+	for rows.Next() {
+		permission := &Permission{}
+		if err := rows.Scan(&permission.ID, &permission.Resource, &permission.Action, &permission.Description, &permission.Category, &permission.Subsystem); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// GetUserPermissions resolves a user's effective permissions: the permissions of their primary
+// role and active additional roles plus active grant overrides, minus active deny overrides
+func (db *DB) GetUserPermissions(userID int, primaryRole string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT rp.permission_id, 'grant' FROM role_permissions rp
+		WHERE rp.role_id = $2
+		   OR rp.role_id IN (
+				SELECT role_id FROM user_roles
+				WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		   )
+		UNION ALL
+		SELECT permission_id, effect FROM user_permission_overrides
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`, userID, primaryRole)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var granted, denied []string
+	for rows.Next() {
+		var permission, effect string
+		if err := rows.Scan(&permission, &effect); err != nil {
+			return nil, err
+		}
+		if effect == "deny" {
+			denied = append(denied, permission)
+		} else {
+			granted = append(granted, permission)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ResolvePermissions(granted, denied), nil
+}
+
+// ResolvePermissions combines the permissions granted by roles and grant overrides with
+// deny overrides. A deny wins over any grant. The result is sorted and has no duplicates.
+func ResolvePermissions(granted, denied []string) []string {
+	deny := make(map[string]bool, len(denied))
+	for _, permission := range denied {
+		deny[permission] = true
+	}
+
+	seen := make(map[string]bool, len(granted))
+	var permissions []string
+	for _, permission := range granted {
+		if deny[permission] || seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// GetUserRoleAssignments retrieves a user's active additional roles
+func (db *DB) GetUserRoleAssignments(userID int) ([]*UserRoleAssignment, error) {
+	rows, err := db.Query(`
+		SELECT user_id, role_id, assigned_by, expires_at, created_at
+		FROM user_roles
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []*UserRoleAssignment
+	for rows.Next() {
+		assignment := &UserRoleAssignment{}
+		if err := rows.Scan(&assignment.UserID, &assignment.RoleID, &assignment.AssignedBy, &assignment.ExpiresAt, &assignment.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// AssignUserRole assigns an additional role to a user, replacing the expiry of an existing assignment
+func (db *DB) AssignUserRole(assignment *UserRoleAssignment) error {
+	return db.QueryRow(`
+		INSERT INTO user_roles (user_id, role_id, assigned_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id, role_id) DO UPDATE SET assigned_by = EXCLUDED.assigned_by, expires_at = EXCLUDED.expires_at
+		RETURNING created_at
+	`, assignment.UserID, assignment.RoleID, assignment.AssignedBy, assignment.ExpiresAt).Scan(&assignment.CreatedAt)
+}
+
+// RemoveUserRole removes an additional role from a user
+func (db *DB) RemoveUserRole(userID int, roleID string) error {
+	result, err := db.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2`, userID, roleID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUserPermissionOverrides retrieves a user's active permission overrides
+func (db *DB) GetUserPermissionOverrides(userID int) ([]*PermissionOverride, error) {
+	rows, err := db.Query(`
+		SELECT user_id, permission_id, effect, reason, granted_by, expires_at, created_at
+		FROM user_permission_overrides
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []*PermissionOverride
+	for rows.Next() {
+		override := &PermissionOverride{}
+		if err := rows.Scan(&override.UserID, &override.PermissionID, &override.Effect, &override.Reason, &override.GrantedBy, &override.ExpiresAt, &override.CreatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
+
+// SetUserPermissionOverride creates or replaces a user's override for a permission
+func (db *DB) SetUserPermissionOverride(override *PermissionOverride) error {
+	return db.QueryRow(`
+		INSERT INTO user_permission_overrides (user_id, permission_id, effect, reason, granted_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (user_id, permission_id) DO UPDATE SET
+			effect = EXCLUDED.effect, reason = EXCLUDED.reason, granted_by = EXCLUDED.granted_by,
+			expires_at = EXCLUDED.expires_at, created_at = NOW()
+		RETURNING created_at
+	`, override.UserID, override.PermissionID, override.Effect, override.Reason, override.GrantedBy, override.ExpiresAt).Scan(&override.CreatedAt)
+}
+
+// RemoveUserPermissionOverride removes a user's override for a permission
+func (db *DB) RemoveUserPermissionOverride(userID int, permissionID string) error {
+	result, err := db.Exec(`DELETE FROM user_permission_overrides WHERE user_id = $1 AND permission_id = $2`, userID, permissionID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUsersWithRoles retrieves users with their active additional roles and the total number of matching users
+func (db *DB) GetUsersWithRoles(limit, offset int, search string) ([]*UserWithRoles, int, error) {
+	where := ``
+	args := []interface{}{}
+	if search != "" {
+		args = append(args, "%"+search+"%")
+		where = ` WHERE u.email ILIKE $1 OR u.first_name ILIKE $1 OR u.last_name ILIKE $1`
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users u`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT u.id, u.email, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), COALESCE(u.role, 'user'),
+			COALESCE(u.is_active, TRUE), u.last_login, u.created_at,
+			COALESCE(ARRAY(
+				SELECT ur.role_id FROM user_roles ur
+				WHERE ur.user_id = u.id AND (ur.expires_at IS NULL OR ur.expires_at > NOW())
+				ORDER BY ur.created_at
+			), '{}')
+		FROM users u%s
+		ORDER BY u.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*UserWithRoles
+	for rows.Next() {
+		user := &UserWithRoles{}
+		if err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Role, &user.IsActive, &user.LastLogin, &user.CreatedAt, pq.Array(&user.RoleIDs)); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+// GetRoleUserCounts returns the number of users holding each role, either as their
+// primary role or as an active additional role
+func (db *DB) GetRoleUserCounts() (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT role_id, COUNT(DISTINCT user_id) FROM (
+			SELECT id AS user_id, role AS role_id FROM users WHERE role IS NOT NULL
+			UNION
+			SELECT user_id, role_id FROM user_roles WHERE expires_at IS NULL OR expires_at > NOW()
+		) assignments
+		GROUP BY role_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var roleID string
+		var count int
+		if err := rows.Scan(&roleID, &count); err != nil {
+			return nil, err
+		}
+		counts[roleID] = count
+	}
+	return counts, rows.Err()
+}
+
+// CountActivePermissionOverrides returns the number of unexpired permission overrides
+func (db *DB) CountActivePermissionOverrides() (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM user_permission_overrides WHERE expires_at IS NULL OR expires_at > NOW()
+	`).Scan(&count)
+	return count, err
+}
+
+// CleanupExpiredRoleGrants removes expired role assignments and permission overrides
+func (db *DB) CleanupExpiredRoleGrants() error {
+	if _, err := db.Exec(`DELETE FROM user_roles WHERE expires_at IS NOT NULL AND expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM user_permission_overrides WHERE expires_at IS NOT NULL AND expires_at < NOW()`)
+	return err
+}
+
+// CountUsersWithPrimaryRole returns the number of users whose users.role is the given role
+func (db *DB) CountUsersWithPrimaryRole(roleID string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = $1`, roleID).Scan(&count)
+	return count, err
+}
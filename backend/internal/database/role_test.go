@@ -0,0 +1,33 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolvePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		denied  []string
+		want    []string
+	}{
+		{name: "nothing granted", want: nil},
+		{name: "role permissions", granted: []string{"videos:read", "articles:read"}, want: []string{"articles:read", "videos:read"}},
+		{name: "granted by two roles", granted: []string{"videos:read", "videos:read", "users:read"}, want: []string{"users:read", "videos:read"}},
+		{name: "grant override adds a permission", granted: []string{"videos:read", "videos:publish"}, want: []string{"videos:publish", "videos:read"}},
+		{name: "deny override removes a role permission", granted: []string{"videos:read", "videos:delete"}, denied: []string{"videos:delete"}, want: []string{"videos:read"}},
+		{name: "deny wins over a grant override", granted: []string{"videos:read", "videos:publish", "videos:publish"}, denied: []string{"videos:publish"}, want: []string{"videos:read"}},
+		{name: "deny of a permission not held", granted: []string{"videos:read"}, denied: []string{"users:delete"}, want: []string{"videos:read"}},
+		{name: "everything denied", granted: []string{"videos:read"}, denied: []string{"videos:read"}, want: nil},
+		{name: "deny of full access", granted: []string{"system:full_access", "videos:read"}, denied: []string{"system:full_access"}, want: []string{"videos:read"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolvePermissions(tt.granted, tt.denied); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolvePermissions(%v, %v) = %v, want %v", tt.granted, tt.denied, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrEmptySearchQuery is returned when a search query has no words to search for
+var ErrEmptySearchQuery = errors.New("search query has no searchable words")
+
+// Duration buckets used to facet and filter search results
+const (
+	DurationUnder5Minutes = "under_5_minutes"
+	Duration5To20Minutes  = "5_to_20_minutes"
+	Duration20To60Minutes = "20_to_60_minutes"
+	DurationOver60Minutes = "over_60_minutes"
+	DurationUnknown       = "unknown"
+)
+
+// SearchDurationBuckets lists the duration buckets, shortest first
+var SearchDurationBuckets = []string{DurationUnder5Minutes, Duration5To20Minutes, Duration20To60Minutes, DurationOver60Minutes, DurationUnknown}
+
+// Highlighted terms are delimited with control characters by Postgres, so the text can
+// be HTML-escaped before the delimiters are turned into <mark> tags
+const (
+	searchHighlightStart    = "\x02"
+	searchHighlightStop     = "\x03"
+	searchTitleOptions      = "StartSel=" + searchHighlightStart + ", StopSel=" + searchHighlightStop + ", HighlightAll=true"
+	searchSnippetOptions    = "StartSel=" + searchHighlightStart + ", StopSel=" + searchHighlightStop + ", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" ... \""
+	maxSearchQueryTerms     = 12
+	searchDurationBucketSQL = `CASE
+		WHEN m.duration <= 0 THEN 'unknown'
+		WHEN m.duration < 300 THEN 'under_5_minutes'
+		WHEN m.duration < 1200 THEN '5_to_20_minutes'
+		WHEN m.duration < 3600 THEN '20_to_60_minutes'
+		ELSE 'over_60_minutes'
+	END`
+)
+
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// VideoSearchParams describes a catalog search. MediaTypes limits the search to
+// PlaybackMediaVideo or PlaybackMediaYouTube; empty searches both. Category, Duration
+// and Year filter the results but not the facets, so facet counts describe every match.
+type VideoSearchParams struct {
+	Query      string
+	MediaTypes []string
+	Category   string
+	Duration   string
+	Year       int
+	Limit      int
+	Offset     int
+}
+
+// VideoSearchResult is a video matching a search. TitleHighlight and Snippet are
+// HTML-escaped, with matching terms wrapped in <mark>.
+type VideoSearchResult struct {
+	MediaType      string    `json:"media_type"`
+	MediaID        string    `json:"media_id"`
+	Title          string    `json:"title"`
+	TitleHighlight string    `json:"title_highlight"`
+	Snippet        string    `json:"snippet"`
+	Description    string    `json:"-"`
+	Category       string    `json:"category"`
+	Duration       int       `json:"duration"`
+	DurationBucket string    `json:"duration_bucket"`
+	Year           int       `json:"year"`
+	ThumbnailURL   string    `json:"thumbnail_url"`
+	ViewCount      int64     `json:"view_count"`
+	Rank           float64   `json:"rank"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SearchFacetCount is how many matches share a facet value
+type SearchFacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchFacets counts matches by category, duration bucket and year
+type SearchFacets struct {
+	Categories []SearchFacetCount `json:"categories"`
+	Durations  []SearchFacetCount `json:"durations"`
+	Years      []SearchFacetCount `json:"years"`
+}
+
+// VideoSearchResults is a page of search results with the total number of filtered
+// matches and the facets of every match
+type VideoSearchResults struct {
+	Results []*VideoSearchResult `json:"results"`
+	Total   int                  `json:"total"`
+	Facets  SearchFacets         `json:"facets"`
+}
+
+// SearchVideos runs a full-text search over videos and YouTube videos. Matches are
+// ranked with titles weighted above tags, descriptions and transcripts, and the last
+// word of the query matches as a prefix so results can be shown while typing.
+func (db *DB) SearchVideos(params VideoSearchParams) (*VideoSearchResults, error) {
+	tsQuery := searchTSQuery(params.Query)
+	if tsQuery == "" {
+		return nil, ErrEmptySearchQuery
+	}
+
+	matches, err := searchMatchesSQL(params.MediaTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := db.searchResults(matches, tsQuery, params)
+	if err != nil {
+		return nil, err
+	}
+	facets, err := db.searchFacets(matches, tsQuery)
+	if err != nil {
+		return nil, err
+	}
+	results.Facets = *facets
+	return results, nil
+}
+
+// searchFilters appends the values of the category, duration and year filters to args
+// and returns the conditions that apply them to the faceted matches
+func searchFilters(params VideoSearchParams, args *[]interface{}) string {
+	filters := ""
+	if params.Category != "" {
+		*args = append(*args, params.Category)
+		filters += fmt.Sprintf(` AND m.category = $%d`, len(*args))
+	}
+	if params.Duration != "" {
+		*args = append(*args, params.Duration)
+		filters += fmt.Sprintf(` AND m.duration_bucket = $%d`, len(*args))
+	}
+	if params.Year > 0 {
+		*args = append(*args, params.Year)
+		filters += fmt.Sprintf(` AND m.year = $%d`, len(*args))
+	}
+	return filters
+}
+
+func (db *DB) searchResults(matches, tsQuery string, params VideoSearchParams) (*VideoSearchResults, error) {
+	results := &VideoSearchResults{Results: []*VideoSearchResult{}}
+
+	// The total is counted separately so it is still reported for pages past the last match
+	countArgs := []interface{}{tsQuery}
+	countFilters := searchFilters(params, &countArgs)
+	if err := db.QueryRow(matches+`
+		SELECT COUNT(*) FROM faceted m WHERE TRUE`+countFilters, countArgs...).Scan(&results.Total); err != nil {
+		return nil, err
+	}
+	if params.Offset >= results.Total {
+		return results, nil
+	}
+
+	args := []interface{}{tsQuery, searchTitleOptions, searchSnippetOptions}
+	filters := searchFilters(params, &args)
+	args = append(args, params.Limit, params.Offset)
+
+	// Highlighting is left until after paging, so it only runs for the rows returned
+	rows, err := db.Query(matches+`
+		SELECT p.media_type, p.media_id, p.title, p.description, p.category, p.duration, p.duration_bucket, p.year,
+			p.thumbnail_url, p.view_count, p.rank, p.created_at,
+			ts_headline('english', p.title, to_tsquery('english', $1), $2),
+			ts_headline('english',
+				CASE WHEN p.description = '' OR (p.transcript <> '' AND NOT to_tsvector('english', p.description) @@ to_tsquery('english', $1))
+					THEN p.transcript ELSE p.description END,
+				to_tsquery('english', $1), $3)
+		FROM (
+			SELECT m.*
+			FROM faceted m
+			WHERE TRUE`+filters+`
+			ORDER BY m.rank DESC, m.view_count DESC, m.created_at DESC
+			LIMIT $`+fmt.Sprint(len(args)-1)+` OFFSET $`+fmt.Sprint(len(args))+`
+		) p
+		ORDER BY p.rank DESC, p.view_count DESC, p.created_at DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result := &VideoSearchResult{}
+		if err := rows.Scan(&result.MediaType, &result.MediaID, &result.Title, &result.Description, &result.Category, &result.Duration,
+			&result.DurationBucket, &result.Year, &result.ThumbnailURL, &result.ViewCount, &result.Rank, &result.CreatedAt,
+			&result.TitleHighlight, &result.Snippet); err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = searchHighlightHTML(result.TitleHighlight)
+		result.Snippet = searchHighlightHTML(result.Snippet)
+		results.Results = append(results.Results, result)
+	}
+	return results, rows.Err()
+}
+
+func (db *DB) searchFacets(matches, tsQuery string) (*SearchFacets, error) {
+	rows, err := db.Query(matches+`
+		SELECT GROUPING(m.category), GROUPING(m.duration_bucket), m.category, m.duration_bucket, m.year, COUNT(*)
+		FROM faceted m
+		GROUP BY GROUPING SETS ((m.category), (m.duration_bucket), (m.year))
+		ORDER BY COUNT(*) DESC
+	`, tsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := &SearchFacets{
+		Categories: []SearchFacetCount{},
+		Durations:  []SearchFacetCount{},
+		Years:      []SearchFacetCount{},
+	}
+	for rows.Next() {
+		var categoryGrouped, durationGrouped int
+		var category, duration sql.NullString
+		var year sql.NullInt64
+		var count int
+		if err := rows.Scan(&categoryGrouped, &durationGrouped, &category, &duration, &year, &count); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case categoryGrouped == 0:
+			if category.String != "" {
+				facets.Categories = append(facets.Categories, SearchFacetCount{Value: category.String, Count: count})
+			}
+		case durationGrouped == 0:
+			facets.Durations = append(facets.Durations, SearchFacetCount{Value: duration.String, Count: count})
+		case year.Valid && year.Int64 > 0:
+			facets.Years = append(facets.Years, SearchFacetCount{Value: fmt.Sprint(year.Int64), Count: count})
+		}
+	}
+	return facets, rows.Err()
+}
+
+// searchMatchesSQL builds the common table expressions that find every match for the
+// tsquery in $1, as "faceted" with each match's duration bucket and year
+func searchMatchesSQL(mediaTypes []string) (string, error) {
+	var parts []string
+	for _, mediaType := range mediaTypes {
+		if mediaType != PlaybackMediaVideo && mediaType != PlaybackMediaYouTube {
+			return "", fmt.Errorf("unsupported media type %q", mediaType)
+		}
+	}
+	if len(mediaTypes) == 0 || containsString(mediaTypes, PlaybackMediaVideo) {
+		parts = append(parts, `
+			SELECT 'video' AS media_type, v.id::TEXT AS media_id, v.title, COALESCE(v.description, '') AS description,
+				COALESCE(v.transcript, '') AS transcript, COALESCE(v.category, '') AS category, COALESCE(v.duration, 0) AS duration,
+				COALESCE(v.thumbnail_url, '') AS thumbnail_url, COALESCE(v.view_count, 0)::BIGINT AS view_count, v.created_at,
+				ts_rank(v.search_vector, q.query) AS rank
+			FROM videos v, q
+			WHERE v.search_vector @@ q.query AND v.status = 'ready'`)
+	}
+	if len(mediaTypes) == 0 || containsString(mediaTypes, PlaybackMediaYouTube) {
+		parts = append(parts, `
+			SELECT 'youtube', y.video_id, y.title, COALESCE(y.description, ''),
+				COALESCE(y.transcript, ''), COALESCE(y.category, ''), COALESCE(y.duration, 0),
+				COALESCE(y.thumbnail_url, ''), COALESCE(y.view_count, 0)::BIGINT, y.created_at,
+				ts_rank(y.search_vector, q.query)
+			FROM youtube_videos y, q
+			WHERE y.search_vector @@ q.query`)
+	}
+
+	return `
+		WITH q AS (SELECT to_tsquery('english', $1) AS query),
+		matches AS (` + strings.Join(parts, `
+			UNION ALL`) + `
+		),
+		faceted AS (
+			SELECT m.*, ` + searchDurationBucketSQL + ` AS duration_bucket, COALESCE(EXTRACT(YEAR FROM m.created_at)::INTEGER, 0) AS year
+			FROM matches m
+		)`, nil
+}
+
+// searchTSQuery turns what a user typed into a tsquery that matches every word, with the
+// last word matched as a prefix. Anything other than letters and digits is dropped, so
+// the input cannot inject tsquery operators.
+func searchTSQuery(input string) string {
+	terms := searchTermPattern.FindAllString(strings.ToLower(input), maxSearchQueryTerms)
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += ":*"
+	return strings.Join(terms, " & ")
+}
+
+// searchHighlightHTML escapes highlighted text and marks the highlighted terms
+func searchHighlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, searchHighlightStart, "<mark>")
+	return strings.ReplaceAll(text, searchHighlightStop, "</mark>")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
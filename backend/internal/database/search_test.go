@@ -0,0 +1,70 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchTSQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "single word", input: "Moroni", want: "moroni:*"},
+		{name: "several words", input: "Book of Mormon", want: "book & of & mormon:*"},
+		{name: "extra whitespace", input: "  lehi \t nephi\n", want: "lehi & nephi:*"},
+		{name: "tsquery operators", input: "faith & !hope | (charity) <-> works:*", want: "faith & hope & charity & works:*"},
+		{name: "quotes and backslashes", input: `'a' "b" \c`, want: "a & b & c:*"},
+		{name: "sql injection attempt", input: "x'); DROP TABLE videos; --", want: "x & drop & table & videos:*"},
+		{name: "numbers", input: "1 Nephi 3:7", want: "1 & nephi & 3 & 7:*"},
+		{name: "non-latin letters", input: "Éter ñandú", want: "éter & ñandú:*"},
+		{name: "punctuation only", input: "!@#$%^&*()", want: ""},
+		{name: "empty", input: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchTSQuery(tt.input); got != tt.want {
+				t.Errorf("searchTSQuery(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchTSQueryLimitsTerms(t *testing.T) {
+	query := searchTSQuery(strings.Repeat("word ", maxSearchQueryTerms*2))
+	if terms := strings.Split(query, " & "); len(terms) != maxSearchQueryTerms {
+		t.Errorf("query has %d terms, want %d", len(terms), maxSearchQueryTerms)
+	}
+}
+
+func TestSearchHighlightHTML(t *testing.T) {
+	text := "<script>" + searchHighlightStart + "faith" + searchHighlightStop + " & works"
+	want := "&lt;script&gt;<mark>faith</mark> &amp; works"
+	if got := searchHighlightHTML(text); got != want {
+		t.Errorf("searchHighlightHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchFiltersNumbersPlaceholdersAfterExistingArgs(t *testing.T) {
+	params := VideoSearchParams{Category: "talks", Duration: DurationUnder5Minutes, Year: 2020}
+
+	countArgs := []interface{}{"query"}
+	if got, want := searchFilters(params, &countArgs), ` AND m.category = $2 AND m.duration_bucket = $3 AND m.year = $4`; got != want {
+		t.Errorf("count filters = %q, want %q", got, want)
+	}
+	if len(countArgs) != 4 {
+		t.Errorf("count args = %v, want 4 values", countArgs)
+	}
+
+	pageArgs := []interface{}{"query", searchTitleOptions, searchSnippetOptions}
+	if got, want := searchFilters(params, &pageArgs), ` AND m.category = $4 AND m.duration_bucket = $5 AND m.year = $6`; got != want {
+		t.Errorf("page filters = %q, want %q", got, want)
+	}
+
+	noArgs := []interface{}{"query"}
+	if got := searchFilters(VideoSearchParams{}, &noArgs); got != "" || len(noArgs) != 1 {
+		t.Errorf("searchFilters with no filters = %q, %v", got, noArgs)
+	}
+}
@@ -0,0 +1,463 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSeriesItemsMismatch is returned when a new order does not list exactly the
+// videos already in a series
+var ErrSeriesItemsMismatch = errors.New("order must list every video in the series exactly once")
+
+// Series is an ordered series of videos, such as a multi-part lecture, or a playlist.
+// Series mirrored from a Bunny Stream collection have BunnyCollectionID set.
+type Series struct {
+	ID                int            `json:"id"`
+	Slug              string         `json:"slug"`
+	Kind              string         `json:"kind"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
+	CoverImageURL     string         `json:"cover_image_url"`
+	Status            string         `json:"status"`
+	BunnyCollectionID sql.NullString `json:"-"`
+	CreatedBy         sql.NullInt64  `json:"-"`
+	ItemCount         int            `json:"item_count"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+}
+
+// SeriesItem is a video at a position in a series
+type SeriesItem struct {
+	VideoID      int       `json:"video_id"`
+	Position     int       `json:"position"`
+	Title        string    `json:"title"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Duration     int       `json:"duration"`
+	Status       string    `json:"status"`
+	AddedAt      time.Time `json:"added_at"`
+}
+
+// seriesColumnsFormat selects a series, counting the items that pass an item filter
+const seriesColumnsFormat = `s.id, s.slug, s.kind, s.title, s.description, s.cover_image_url, s.status, s.bunny_collection_id, s.created_by,
+	(SELECT COUNT(*) FROM series_items i JOIN videos v ON v.id = i.video_id WHERE i.series_id = s.id%s), s.created_at, s.updated_at`
+
+var (
+	seriesColumns          = fmt.Sprintf(seriesColumnsFormat, "")
+	publishedSeriesColumns = fmt.Sprintf(seriesColumnsFormat, publishedSeriesItemFilter)
+)
+
+func scanSeries(row interface{ Scan(...interface{}) error }) (*Series, error) {
+	series := &Series{}
+	err := row.Scan(&series.ID, &series.Slug, &series.Kind, &series.Title, &series.Description, &series.CoverImageURL, &series.Status,
+		&series.BunnyCollectionID, &series.CreatedBy, &series.ItemCount, &series.CreatedAt, &series.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+const seriesItemColumns = `i.video_id, i.position, v.title, COALESCE(v.thumbnail_url, ''), COALESCE(v.duration, 0), COALESCE(v.status, ''), i.added_at`
+
+func scanSeriesItem(row interface{ Scan(...interface{}) error }) (*SeriesItem, error) {
+	item := &SeriesItem{}
+	if err := row.Scan(&item.VideoID, &item.Position, &item.Title, &item.ThumbnailURL, &item.Duration, &item.Status, &item.AddedAt); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CreateSeries inserts a series. If the slug is taken, a numeric suffix is added.
+func (db *DB) CreateSeries(series *Series) error {
+	slug, err := db.uniqueSeriesSlug(series.Slug, 0)
+	if err != nil {
+		return err
+	}
+	series.Slug = slug
+
+	return db.QueryRow(`
+		INSERT INTO series (slug, kind, title, description, cover_image_url, status, bunny_collection_id, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		RETURNING id, created_at, updated_at
+	`, series.Slug, series.Kind, series.Title, series.Description, series.CoverImageURL, series.Status, series.BunnyCollectionID, series.CreatedBy,
+	).Scan(&series.ID, &series.CreatedAt, &series.UpdatedAt)
+}
+
+// uniqueSeriesSlug returns slug, or slug with the lowest free numeric suffix, ignoring
+// the series being updated
+func (db *DB) uniqueSeriesSlug(slug string, seriesID int) (string, error) {
+	rows, err := db.Query(`SELECT slug FROM series WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id <> $2`, slug, seriesID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", err
+		}
+		taken[existing] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	candidate := slug
+	for n := 2; taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return candidate, nil
+}
+
+// GetSeries retrieves a series by ID
+func (db *DB) GetSeries(id int) (*Series, error) {
+	return scanSeries(db.QueryRow(`SELECT `+seriesColumns+` FROM series s WHERE s.id = $1`, id))
+}
+
+// GetSeriesBySlug retrieves a series by slug
+func (db *DB) GetSeriesBySlug(slug string) (*Series, error) {
+	return scanSeries(db.QueryRow(`SELECT `+seriesColumns+` FROM series s WHERE s.slug = $1`, slug))
+}
+
+// GetPublishedSeriesBySlug retrieves a published series by slug, counting only its
+// ready videos
+func (db *DB) GetPublishedSeriesBySlug(slug string) (*Series, error) {
+	return scanSeries(db.QueryRow(`SELECT `+publishedSeriesColumns+` FROM series s WHERE s.slug = $1 AND s.status = 'published'`, slug))
+}
+
+// GetSeriesByBunnyCollection retrieves the series mirroring a Bunny Stream collection
+func (db *DB) GetSeriesByBunnyCollection(collectionID string) (*Series, error) {
+	return scanSeries(db.QueryRow(`SELECT `+seriesColumns+` FROM series s WHERE s.bunny_collection_id = $1`, collectionID))
+}
+
+// GetSeriesList lists series, most recently updated first, optionally filtered by kind
+// and status
+func (db *DB) GetSeriesList(kind, status string, limit, offset int) ([]*Series, error) {
+	return db.getSeriesList(seriesColumns, kind, status, limit, offset)
+}
+
+// GetPublishedSeriesList lists published series like GetSeriesList, counting only
+// their ready videos
+func (db *DB) GetPublishedSeriesList(kind string, limit, offset int) ([]*Series, error) {
+	return db.getSeriesList(publishedSeriesColumns, kind, "published", limit, offset)
+}
+
+func (db *DB) getSeriesList(columns, kind, status string, limit, offset int) ([]*Series, error) {
+	query := `SELECT ` + columns + ` FROM series s WHERE 1=1`
+	args := []interface{}{}
+
+	if kind != "" {
+		args = append(args, kind)
+		query += fmt.Sprintf(` AND s.kind = $%d`, len(args))
+	}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(` AND s.status = $%d`, len(args))
+	}
+
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(` ORDER BY s.updated_at DESC, s.id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Series
+	for rows.Next() {
+		series, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, series)
+	}
+	return list, rows.Err()
+}
+
+// UpdateSeries saves a series' details. If the slug changed and is taken, a numeric
+// suffix is added.
+func (db *DB) UpdateSeries(series *Series) error {
+	slug, err := db.uniqueSeriesSlug(series.Slug, series.ID)
+	if err != nil {
+		return err
+	}
+	series.Slug = slug
+
+	result, err := db.Exec(`
+		UPDATE series SET slug = $2, kind = $3, title = $4, description = $5, cover_image_url = $6, status = $7, updated_at = NOW()
+		WHERE id = $1
+	`, series.ID, series.Slug, series.Kind, series.Title, series.Description, series.CoverImageURL, series.Status)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteSeries deletes a series. Its videos are kept.
+func (db *DB) DeleteSeries(id int) error {
+	result, err := db.Exec(`DELETE FROM series WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// publishedSeriesItemFilter limits series items to videos viewers can watch
+const publishedSeriesItemFilter = ` AND v.status = 'ready'`
+
+// GetSeriesItems lists the videos in a series in order, whatever their status
+func (db *DB) GetSeriesItems(seriesID int) ([]*SeriesItem, error) {
+	return db.getSeriesItems(seriesID, "")
+}
+
+// GetPublishedSeriesItems lists the ready videos in a series in order
+func (db *DB) GetPublishedSeriesItems(seriesID int) ([]*SeriesItem, error) {
+	return db.getSeriesItems(seriesID, publishedSeriesItemFilter)
+}
+
+func (db *DB) getSeriesItems(seriesID int, filter string) ([]*SeriesItem, error) {
+	rows, err := db.Query(`
+		SELECT `+seriesItemColumns+`
+		FROM series_items i
+		JOIN videos v ON v.id = i.video_id
+		WHERE i.series_id = $1`+filter+`
+		ORDER BY i.position
+	`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*SeriesItem
+	for rows.Next() {
+		item, err := scanSeriesItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetSeriesNeighbours retrieves a video's place in a series along with the videos
+// before and after it, whatever their status. previous and next are nil at either
+// end of the series.
+func (db *DB) GetSeriesNeighbours(seriesID, videoID int) (current, previous, next *SeriesItem, err error) {
+	return db.getSeriesNeighbours(seriesID, videoID, "")
+}
+
+// GetPublishedSeriesNeighbours is GetSeriesNeighbours over the ready videos of a series.
+// Videos that are not ready are skipped, and asking for one returns sql.ErrNoRows.
+func (db *DB) GetPublishedSeriesNeighbours(seriesID, videoID int) (current, previous, next *SeriesItem, err error) {
+	return db.getSeriesNeighbours(seriesID, videoID, publishedSeriesItemFilter)
+}
+
+func (db *DB) getSeriesNeighbours(seriesID, videoID int, filter string) (current, previous, next *SeriesItem, err error) {
+	current, err = scanSeriesItem(db.QueryRow(`
+		SELECT `+seriesItemColumns+`
+		FROM series_items i
+		JOIN videos v ON v.id = i.video_id
+		WHERE i.series_id = $1 AND i.video_id = $2`+filter+`
+	`, seriesID, videoID))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	previous, err = scanSeriesItem(db.QueryRow(`
+		SELECT `+seriesItemColumns+`
+		FROM series_items i
+		JOIN videos v ON v.id = i.video_id
+		WHERE i.series_id = $1 AND i.position < $2`+filter+`
+		ORDER BY i.position DESC
+		LIMIT 1
+	`, seriesID, current.Position))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, nil, err
+	}
+
+	next, err = scanSeriesItem(db.QueryRow(`
+		SELECT `+seriesItemColumns+`
+		FROM series_items i
+		JOIN videos v ON v.id = i.video_id
+		WHERE i.series_id = $1 AND i.position > $2`+filter+`
+		ORDER BY i.position
+		LIMIT 1
+	`, seriesID, current.Position))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, nil, err
+	}
+
+	return current, previous, next, nil
+}
+
+// AddSeriesItem adds a video to a series at position, moving later videos down. A
+// position of zero or past the end appends the video. Adding a video already in the
+// series moves it.
+func (db *DB) AddSeriesItem(seriesID, videoID, position int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	videoIDs, err := lockSeriesItems(tx, seriesID)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, 0, len(videoIDs)+1)
+	for _, id := range videoIDs {
+		if id != videoID {
+			order = append(order, id)
+		}
+	}
+	if position <= 0 || position > len(order) {
+		order = append(order, videoID)
+	} else {
+		order = append(order[:position-1], append([]int{videoID}, order[position-1:]...)...)
+	}
+
+	if err := writeSeriesOrder(tx, seriesID, order); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveSeriesItem removes a video from a series, closing the gap it leaves
+func (db *DB) RemoveSeriesItem(seriesID, videoID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	videoIDs, err := lockSeriesItems(tx, seriesID)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, 0, len(videoIDs))
+	for _, id := range videoIDs {
+		if id != videoID {
+			order = append(order, id)
+		}
+	}
+	if len(order) == len(videoIDs) {
+		return sql.ErrNoRows
+	}
+
+	if err := writeSeriesOrder(tx, seriesID, order); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReorderSeriesItems puts the videos of a series in the given order, which must list
+// every video in the series exactly once
+func (db *DB) ReorderSeriesItems(seriesID int, videoIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockSeriesItems(tx, seriesID)
+	if err != nil {
+		return err
+	}
+	if len(current) != len(videoIDs) {
+		return ErrSeriesItemsMismatch
+	}
+	remaining := make(map[int]bool, len(current))
+	for _, id := range current {
+		remaining[id] = true
+	}
+	for _, id := range videoIDs {
+		if !remaining[id] {
+			return ErrSeriesItemsMismatch
+		}
+		delete(remaining, id)
+	}
+
+	if err := writeSeriesOrder(tx, seriesID, videoIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSeriesItems replaces the videos of a series with videoIDs, in that order
+func (db *DB) SetSeriesItems(seriesID int, videoIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockSeriesItems(tx, seriesID); err != nil {
+		return err
+	}
+	if err := writeSeriesOrder(tx, seriesID, videoIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockSeriesItems locks a series against concurrent changes to its items and returns
+// its videos in order
+func lockSeriesItems(tx *sql.Tx, seriesID int) ([]int, error) {
+	var id int
+	if err := tx.QueryRow(`SELECT id FROM series WHERE id = $1 FOR UPDATE`, seriesID).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT video_id FROM series_items WHERE series_id = $1 ORDER BY position`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var videoIDs []int
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		videoIDs = append(videoIDs, id)
+	}
+	return videoIDs, rows.Err()
+}
+
+// writeSeriesOrder makes videoIDs the items of a series, numbered from one
+func writeSeriesOrder(tx *sql.Tx, seriesID int, videoIDs []int) error {
+	ids := make([]int64, len(videoIDs))
+	for i, id := range videoIDs {
+		ids[i] = int64(id)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM series_items WHERE series_id = $1 AND NOT (video_id = ANY($2::INTEGER[]))`, seriesID, pq.Array(ids)); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO series_items (series_id, video_id, position, added_at)
+			SELECT $1, o.video_id, o.position, NOW()
+			FROM unnest($2::INTEGER[]) WITH ORDINALITY AS o(video_id, position)
+			ON CONFLICT (series_id, video_id) DO UPDATE SET position = EXCLUDED.position
+		`, seriesID, pq.Array(ids)); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE series SET updated_at = NOW() WHERE id = $1`, seriesID)
+	return err
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// signingKeyRotationLock is the advisory lock that serializes key rotation across replicas
+const signingKeyRotationLock = 727001
+
+// SigningKeyRecord represents a stored JWT signing key
+type SigningKeyRecord struct {
+	KID         string
+	Algorithm   string
+	PrivateKey  string
+	PublicKey   sql.NullString
+	CreatedAt   time.Time
+	ActivatesAt sql.NullTime
+	RetiredAt   sql.NullTime
+	VerifyUntil sql.NullTime
+}
+
+// GetSigningKeys retrieves the active key, the next key if one has been published and all
+// retired keys that are still valid for verification
+func (db *DB) GetSigningKeys() ([]*SigningKeyRecord, error) {
+	rows, err := db.Query(`
+		SELECT kid, algorithm, private_key, public_key, created_at, activates_at, retired_at, verify_until
+		FROM jwt_signing_keys
+		WHERE verify_until IS NULL OR verify_until > NOW()
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*SigningKeyRecord
+	for rows.Next() {
+		key := &SigningKeyRecord{}
+		if err := rows.Scan(&key.KID, &key.Algorithm, &key.PrivateKey, &key.PublicKey, &key.CreatedAt, &key.ActivatesAt, &key.RetiredAt, &key.VerifyUntil); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey stores a new key that becomes active at activatesAt and retires the
+// keys in use at that time, keeping them valid for verification until verifyUntil. A
+// next key published earlier that has not become active yet is retired without ever
+// signing. It returns false without changes if the newest unretired key is no longer
+// previousKID, which means another replica rotated first.
+func (db *DB) RotateSigningKey(key *SigningKeyRecord, previousKID string, activatesAt, verifyUntil time.Time) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, signingKeyRotationLock); err != nil {
+		return false, err
+	}
+
+	var activeKID string
+	err = tx.QueryRow(`
+		SELECT kid FROM jwt_signing_keys WHERE retired_at IS NULL ORDER BY created_at DESC LIMIT 1
+	`).Scan(&activeKID)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if activeKID != previousKID {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE jwt_signing_keys SET retired_at = $1, verify_until = $2 WHERE retired_at IS NULL OR retired_at > $1
+	`, activatesAt, verifyUntil); err != nil {
+		return false, err
+	}
+
+	if err := tx.QueryRow(`
+		INSERT INTO jwt_signing_keys (kid, algorithm, private_key, public_key, created_at, activates_at)
+		VALUES ($1, $2, $3, $4, NOW(), $5)
+		RETURNING created_at, activates_at
+	`, key.KID, key.Algorithm, key.PrivateKey, key.PublicKey, activatesAt).Scan(&key.CreatedAt, &key.ActivatesAt); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// CleanupExpiredSigningKeys removes retired keys that can no longer verify any valid token
+func (db *DB) CleanupExpiredSigningKeys() error {
+	_, err := db.Exec(`DELETE FROM jwt_signing_keys WHERE verify_until IS NOT NULL AND verify_until < NOW()`)
+	return err
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -30,6 +32,7 @@ type User struct {
 	LastLogin   sql.NullTime
 	LastLogout  sql.NullTime
 	MaxSessions int
+	MFAEnabled  bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
@@ -42,6 +45,7 @@ type UserProfile struct {
 	LastName      string                 `json:"last_name"`
 	Role          string                 `json:"role"`
 	EmailVerified bool                   `json:"email_verified"`
+	MFAEnabled    bool                   `json:"mfa_enabled"`
 	Bio           *string                `json:"bio,omitempty"`
 	Location      *string                `json:"location,omitempty"`
 	Website       *string                `json:"website,omitempty"`
@@ -65,6 +69,7 @@ type Session struct {
 	IsActive     bool      `json:"is_active"`
 	CreatedAt    time.Time `json:"created_at"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	FamilyID     string    `json:"-"`
 }
 
 // CreateUser inserts a new user into the database
@@ -84,9 +89,9 @@ func (db *DB) CreateUser(email, passwordHash, firstName, lastName, role string)
 func (db *DB) GetUserByID(id int) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(
-		`SELECT id, email, password_hash, first_name, last_name, role, email_verified, stripe_customer_id, reset_token, reset_token_expiry, verification_token, bio, location, website, phone, avatar_url, preferences, last_login, last_logout, max_sessions, created_at, updated_at FROM users WHERE id = $1`,
+		`SELECT id, email, password_hash, first_name, last_name, role, email_verified, stripe_customer_id, reset_token, reset_token_expiry, verification_token, bio, location, website, phone, avatar_url, preferences, last_login, last_logout, max_sessions, mfa_enabled, created_at, updated_at FROM users WHERE id = $1`,
 		id,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified, &user.StripeCustomerID, &user.ResetToken, &user.ResetTokenExpiry, &user.VerificationToken, &user.Bio, &user.Location, &user.Website, &user.Phone, &user.AvatarURL, &user.Preferences, &user.LastLogin, &user.LastLogout, &user.MaxSessions, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified, &user.StripeCustomerID, &user.ResetToken, &user.ResetTokenExpiry, &user.VerificationToken, &user.Bio, &user.Location, &user.Website, &user.Phone, &user.AvatarURL, &user.Preferences, &user.LastLogin, &user.LastLogout, &user.MaxSessions, &user.MFAEnabled, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -97,9 +102,9 @@ func (db *DB) GetUserByID(id int) (*User, error) {
 func (db *DB) GetUserByEmail(email string) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(
-		`SELECT id, email, password_hash, first_name, last_name, role, email_verified, stripe_customer_id, reset_token, reset_token_expiry, verification_token, bio, location, website, phone, avatar_url, preferences, last_login, last_logout, max_sessions, created_at, updated_at FROM users WHERE email = $1`,
+		`SELECT id, email, password_hash, first_name, last_name, role, email_verified, stripe_customer_id, reset_token, reset_token_expiry, verification_token, bio, location, website, phone, avatar_url, preferences, last_login, last_logout, max_sessions, mfa_enabled, created_at, updated_at FROM users WHERE email = $1`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified, &user.StripeCustomerID, &user.ResetToken, &user.ResetTokenExpiry, &user.VerificationToken, &user.Bio, &user.Location, &user.Website, &user.Phone, &user.AvatarURL, &user.Preferences, &user.LastLogin, &user.LastLogout, &user.MaxSessions, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified, &user.StripeCustomerID, &user.ResetToken, &user.ResetTokenExpiry, &user.VerificationToken, &user.Bio, &user.Location, &user.Website, &user.Phone, &user.AvatarURL, &user.Preferences, &user.LastLogin, &user.LastLogout, &user.MaxSessions, &user.MFAEnabled, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +125,7 @@ func (db *DB) GetUserProfile(userID int) (*UserProfile, error) {
 		LastName:      user.LastName,
 		Role:          user.Role,
 		EmailVerified: user.EmailVerified,
+		MFAEnabled:    user.MFAEnabled,
 		CreatedAt:     user.CreatedAt,
 		UpdatedAt:     user.UpdatedAt,
 	}
@@ -291,7 +297,12 @@ func (db *DB) UpdateUserStripeCustomerID(userID int, stripeCustomerID string) er
 
 // CreateSession creates a new user session
 func (db *DB) CreateSession(userID int, tokenID, deviceInfo, ipAddress, userAgent string, expiresAt time.Time) (*Session, error) {
-	sessionID := fmt.Sprintf("sess_%d_%s", userID, time.Now().Format("20060102150405"))
+	// Random suffix keeps IDs unique for logins within the same second and unguessable
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	sessionID := fmt.Sprintf("sess_%d_%s_%s", userID, time.Now().Format("20060102150405"), hex.EncodeToString(suffix))
 
 	_, err := db.Exec(`
 		INSERT INTO user_sessions (session_id, user_id, token_id, device_info, ip_address, user_agent, last_activity, is_active, created_at, expires_at)
@@ -319,7 +330,7 @@ func (db *DB) CreateSession(userID int, tokenID, deviceInfo, ipAddress, userAgen
 // GetActiveSessions retrieves all active sessions for a user
 func (db *DB) GetActiveSessions(userID int) ([]*Session, error) {
 	rows, err := db.Query(`
-		SELECT session_id, user_id, token_id, device_info, ip_address, user_agent, last_activity, is_active, created_at, expires_at
+		SELECT session_id, user_id, token_id, device_info, ip_address, user_agent, last_activity, is_active, created_at, expires_at, COALESCE(family_id, '')
 		FROM user_sessions 
 		WHERE user_id = $1 AND is_active = TRUE AND expires_at > NOW()
 		ORDER BY last_activity DESC
@@ -332,7 +343,7 @@ func (db *DB) GetActiveSessions(userID int) ([]*Session, error) {
 	var sessions []*Session
 	for rows.Next() {
 		session := &Session{}
-		err := rows.Scan(&session.ID, &session.UserID, &session.TokenID, &session.DeviceInfo, &session.IPAddress, &session.UserAgent, &session.LastActivity, &session.IsActive, &session.CreatedAt, &session.ExpiresAt)
+		err := rows.Scan(&session.ID, &session.UserID, &session.TokenID, &session.DeviceInfo, &session.IPAddress, &session.UserAgent, &session.LastActivity, &session.IsActive, &session.CreatedAt, &session.ExpiresAt, &session.FamilyID)
 		if err != nil {
 			return nil, err
 		}
@@ -342,6 +353,20 @@ func (db *DB) GetActiveSessions(userID int) ([]*Session, error) {
 	return sessions, nil
 }
 
+// GetUserSession retrieves an active session belonging to a user
+func (db *DB) GetUserSession(userID int, sessionID string) (*Session, error) {
+	session := &Session{}
+	err := db.QueryRow(`
+		SELECT session_id, user_id, token_id, device_info, ip_address, user_agent, last_activity, is_active, created_at, expires_at, COALESCE(family_id, '')
+		FROM user_sessions 
+		WHERE session_id = $1 AND user_id = $2 AND is_active = TRUE AND expires_at > NOW()
+	`, sessionID, userID).Scan(&session.ID, &session.UserID, &session.TokenID, &session.DeviceInfo, &session.IPAddress, &session.UserAgent, &session.LastActivity, &session.IsActive, &session.CreatedAt, &session.ExpiresAt, &session.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
 // DeactivateSession deactivates a specific session
 func (db *DB) DeactivateSession(sessionID string) error {
 	_, err := db.Exec(`UPDATE user_sessions SET is_active = FALSE WHERE session_id = $1`, sessionID)
@@ -366,6 +391,23 @@ func (db *DB) UpdateSessionActivityByTokenID(tokenID string) error {
 	return err
 }
 
+// UpdateSessionActivityByFamilyID updates the last activity time for the session owning a token family
+func (db *DB) UpdateSessionActivityByFamilyID(familyID string) error {
+	_, err := db.Exec(`UPDATE user_sessions SET last_activity = NOW() WHERE family_id = $1 AND is_active = TRUE AND expires_at > NOW()`, familyID)
+	return err
+}
+
+// GetSessionStartedAt returns when the active session owning a token family signed in
+func (db *DB) GetSessionStartedAt(familyID string) (time.Time, error) {
+	var startedAt time.Time
+	err := db.QueryRow(`
+		SELECT created_at FROM user_sessions
+		WHERE family_id = $1 AND is_active = TRUE AND expires_at > NOW()
+		ORDER BY created_at LIMIT 1
+	`, familyID).Scan(&startedAt)
+	return startedAt, err
+}
+
 // CleanupExpiredSessions removes expired sessions
 func (db *DB) CleanupExpiredSessions() error {
 	_, err := db.Exec(`DELETE FROM user_sessions WHERE expires_at < NOW()`)
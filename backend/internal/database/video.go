@@ -33,9 +33,13 @@ type Video struct {
 	DirectPlayURL string                  `json:"direct_play_url,omitempty"`
 	PlaybackURL   string                  `json:"playback_url,omitempty"`
 	Resolutions   []string                `json:"resolutions,omitempty"`
+
+	// Caption and subtitle tracks the player can offer
+	Captions []*VideoCaption `json:"captions,omitempty"`
 }
 
-// CreateVideo inserts a new video into the database
+// CreateVideo inserts a new video into the database. A createdBy of 0 leaves the
+// video without a creator.
 func (db *DB) CreateVideo(title, description, bunnyVideoID, thumbnailURL, category string, duration int, fileSize int64, tags []string, createdBy int) (*Video, error) {
 	// Convert tags to JSON string
 	tagsJSON, err := json.Marshal(tags)
@@ -45,7 +49,7 @@ func (db *DB) CreateVideo(title, description, bunnyVideoID, thumbnailURL, catego
 
 	var id int
 	err = db.QueryRow(
-		`INSERT INTO videos (title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, created_by, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW()) RETURNING id`,
+		`INSERT INTO videos (title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, created_by, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), NOW(), NOW()) RETURNING id`,
 		title, description, bunnyVideoID, thumbnailURL, duration, fileSize, "processing", category, string(tagsJSON), createdBy,
 	).Scan(&id)
 	if err != nil {
@@ -59,7 +63,7 @@ func (db *DB) GetVideoByID(id int) (*Video, error) {
 	video := &Video{}
 	var tagsStr string
 	err := db.QueryRow(
-		`SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, created_by, created_at, updated_at FROM videos WHERE id = $1`,
+		`SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, COALESCE(created_by, 0), created_at, updated_at FROM videos WHERE id = $1`,
 		id,
 	).Scan(&video.ID, &video.Title, &video.Description, &video.BunnyVideoID, &video.ThumbnailURL, &video.Duration, &video.FileSize, &video.Status, &video.Category, &tagsStr, &video.ViewCount, &video.LikeCount, &video.CreatedBy, &video.CreatedAt, &video.UpdatedAt)
 	if err != nil {
@@ -81,7 +85,7 @@ func (db *DB) GetVideoByBunnyID(bunnyVideoID string) (*Video, error) {
 	video := &Video{}
 	var tagsStr string
 	err := db.QueryRow(
-		`SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, created_by, created_at, updated_at FROM videos WHERE bunny_video_id = $1`,
+		`SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, COALESCE(created_by, 0), created_at, updated_at FROM videos WHERE bunny_video_id = $1`,
 		bunnyVideoID,
 	).Scan(&video.ID, &video.Title, &video.Description, &video.BunnyVideoID, &video.ThumbnailURL, &video.Duration, &video.FileSize, &video.Status, &video.Category, &tagsStr, &video.ViewCount, &video.LikeCount, &video.CreatedBy, &video.CreatedAt, &video.UpdatedAt)
 	if err != nil {
@@ -100,7 +104,7 @@ func (db *DB) GetVideoByBunnyID(bunnyVideoID string) (*Video, error) {
 
 // GetVideos retrieves videos with pagination and filtering
 func (db *DB) GetVideos(limit, offset int, category, status string) ([]*Video, error) {
-	query := `SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, created_by, created_at, updated_at FROM videos WHERE 1=1`
+	query := `SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, COALESCE(created_by, 0), created_at, updated_at FROM videos WHERE 1=1`
 	args := []interface{}{}
 	argCount := 0
 
@@ -156,15 +160,25 @@ func (db *DB) UpdateVideoStatus(videoID int, status string) error {
 	return err
 }
 
-// UpdateVideoViews updates a video's view count
-func (db *DB) UpdateVideoViews(videoID int, views int) error {
-	_, err := db.Exec(`UPDATE videos SET view_count = $1, updated_at = NOW() WHERE id = $2`, views, videoID)
+// UpdateVideoFromBunny stores the status and encoding details reported by Bunny Stream
+func (db *DB) UpdateVideoFromBunny(videoID int, status string, duration int, fileSize int64, thumbnailURL, resolution, availableResolutions string) error {
+	_, err := db.Exec(`
+		UPDATE videos SET
+			status = $2,
+			duration = CASE WHEN $3::INTEGER > 0 THEN $3::INTEGER ELSE duration END,
+			file_size = CASE WHEN $4::BIGINT > 0 THEN $4::BIGINT ELSE file_size END,
+			thumbnail_url = COALESCE(NULLIF($5, ''), thumbnail_url),
+			resolution = COALESCE(NULLIF($6, ''), resolution),
+			available_resolutions = COALESCE(NULLIF($7, ''), available_resolutions),
+			updated_at = NOW()
+		WHERE id = $1
+	`, videoID, status, duration, fileSize, thumbnailURL, resolution, availableResolutions)
 	return err
 }
 
-// IncrementViewCount increments a video's view count
-func (db *DB) IncrementViewCount(videoID int) error {
-	_, err := db.Exec(`UPDATE videos SET view_count = view_count + 1, updated_at = NOW() WHERE id = $1`, videoID)
+// UpdateVideoViews updates a video's view count
+func (db *DB) UpdateVideoViews(videoID int, views int) error {
+	_, err := db.Exec(`UPDATE videos SET view_count = $1, updated_at = NOW() WHERE id = $2`, views, videoID)
 	return err
 }
 
@@ -188,31 +202,6 @@ func (db *DB) GetVideoCategories() ([]string, error) {
 	return categories, nil
 }
 
-// SearchVideos searches videos by title and description
-func (db *DB) SearchVideos(query string, limit, offset int) ([]*Video, error) {
-	searchQuery := `%` + query + `%`
-	rows, err := db.Query(
-		`SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, created_by, created_at, updated_at FROM videos WHERE (title ILIKE $1 OR description ILIKE $1) AND status = 'ready' ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
-		searchQuery, limit, offset,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var videos []*Video
-	for rows.Next() {
-		video := &Video{}
-		err := rows.Scan(&video.ID, &video.Title, &video.Description, &video.BunnyVideoID, &video.ThumbnailURL, &video.Duration, &video.FileSize, &video.Status, &video.Category, &video.Tags, &video.ViewCount, &video.LikeCount, &video.CreatedBy, &video.CreatedAt, &video.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		videos = append(videos, video)
-	}
-
-	return videos, nil
-}
-
 // UpdateVideo updates video details
 func (db *DB) UpdateVideo(videoID int, updateData map[string]interface{}) error {
 	// Build dynamic update query
@@ -230,6 +219,13 @@ func (db *DB) UpdateVideo(videoID int, updateData map[string]interface{}) error
 			argCount++
 			setParts = append(setParts, fmt.Sprintf("tags = $%d", argCount))
 			args = append(args, value)
+		case "required_tier":
+			if value != TierFree && value != TierBasic && value != TierPremium {
+				return fmt.Errorf("invalid required tier: %v", value)
+			}
+			argCount++
+			setParts = append(setParts, fmt.Sprintf("required_tier = $%d", argCount))
+			args = append(args, value)
 		}
 	}
 
@@ -261,7 +257,7 @@ func (db *DB) ScheduleVideo(videoID int, publishDate time.Time) error {
 
 // GetScheduledVideos retrieves videos scheduled to be published before the given time
 func (db *DB) GetScheduledVideos(beforeTime time.Time) ([]*Video, error) {
-	query := `SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, created_by, scheduled_publish_date, created_at, updated_at FROM videos WHERE status = 'scheduled' AND scheduled_publish_date <= $1`
+	query := `SELECT id, title, description, bunny_video_id, thumbnail_url, duration, file_size, status, category, tags, view_count, like_count, COALESCE(created_by, 0), scheduled_publish_date, created_at, updated_at FROM videos WHERE status = 'scheduled' AND scheduled_publish_date <= $1`
 
 	rows, err := db.Query(query, beforeTime)
 	if err != nil {
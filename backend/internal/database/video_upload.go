@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// VideoUpload is a resumable video upload. Status is uploading, processing, completed,
+// failed or expired. Its chunks are staged on the disk of StagingHost.
+type VideoUpload struct {
+	ID           string        `json:"id"`
+	UserID       int           `json:"user_id"`
+	Filename     string        `json:"filename"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	Category     string        `json:"category"`
+	Tags         []string      `json:"tags"`
+	Metadata     string        `json:"-"`
+	UploadLength int64         `json:"upload_length"`
+	UploadOffset int64         `json:"upload_offset"`
+	Status       string        `json:"status"`
+	VideoID      sql.NullInt64 `json:"-"`
+	Error        string        `json:"error,omitempty"`
+	StagingHost  string        `json:"-"`
+	HandOffs     int           `json:"-"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+const videoUploadColumns = `id, user_id, filename, title, COALESCE(description, ''), COALESCE(category, ''), COALESCE(tags, ''), COALESCE(metadata, ''), upload_length, upload_offset, status, video_id, COALESCE(error, ''), COALESCE(staging_host, ''), handoff_attempts, expires_at, created_at, updated_at`
+
+func scanVideoUpload(row interface{ Scan(...interface{}) error }) (*VideoUpload, error) {
+	upload := &VideoUpload{}
+	var tagsStr string
+	err := row.Scan(&upload.ID, &upload.UserID, &upload.Filename, &upload.Title, &upload.Description, &upload.Category, &tagsStr, &upload.Metadata, &upload.UploadLength, &upload.UploadOffset, &upload.Status, &upload.VideoID, &upload.Error, &upload.StagingHost, &upload.HandOffs, &upload.ExpiresAt, &upload.CreatedAt, &upload.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if tagsStr != "" {
+		if err := json.Unmarshal([]byte(tagsStr), &upload.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags: %v", err)
+		}
+	}
+	return upload, nil
+}
+
+// CreateVideoUpload records a new resumable upload
+func (db *DB) CreateVideoUpload(upload *VideoUpload) (*VideoUpload, error) {
+	tagsJSON, err := json.Marshal(upload.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tags: %v", err)
+	}
+
+	return scanVideoUpload(db.QueryRow(`
+		INSERT INTO video_uploads (id, user_id, filename, title, description, category, tags, metadata, upload_length, upload_offset, status, staging_host, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, 'uploading', NULLIF($10, ''), $11, NOW(), NOW())
+		RETURNING `+videoUploadColumns,
+		upload.ID, upload.UserID, upload.Filename, upload.Title, upload.Description, upload.Category, string(tagsJSON), upload.Metadata, upload.UploadLength, upload.StagingHost, upload.ExpiresAt))
+}
+
+// GetVideoUpload retrieves a resumable upload by ID
+func (db *DB) GetVideoUpload(id string) (*VideoUpload, error) {
+	return scanVideoUpload(db.QueryRow(`SELECT `+videoUploadColumns+` FROM video_uploads WHERE id = $1`, id))
+}
+
+// AdvanceVideoUpload moves an upload's offset forward and extends its expiry. It returns
+// sql.ErrNoRows if the upload is no longer at the expected offset or is not uploading.
+func (db *DB) AdvanceVideoUpload(id string, fromOffset, toOffset int64, expiresAt time.Time) error {
+	var updated string
+	return db.QueryRow(`
+		UPDATE video_uploads SET upload_offset = $3, expires_at = $4, updated_at = NOW()
+		WHERE id = $1 AND upload_offset = $2 AND status = 'uploading'
+		RETURNING id
+	`, id, fromOffset, toOffset, expiresAt).Scan(&updated)
+}
+
+// SetVideoUploadStatus changes an upload's status and records any error
+func (db *DB) SetVideoUploadStatus(id, status, reason string) error {
+	_, err := db.Exec(`
+		UPDATE video_uploads SET status = $2, error = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1
+	`, id, status, reason)
+	return err
+}
+
+// StartVideoUploadHandOff marks a fully received upload as processing and counts the
+// hand-off. It returns sql.ErrNoRows if the upload is no longer uploading.
+func (db *DB) StartVideoUploadHandOff(id string, expiresAt time.Time) error {
+	var started string
+	return db.QueryRow(`
+		UPDATE video_uploads SET status = 'processing', handoff_attempts = handoff_attempts + 1, expires_at = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'uploading'
+		RETURNING id
+	`, id, expiresAt).Scan(&started)
+}
+
+// TouchVideoUploadHandOff records that a hand-off is still running, so it is not
+// claimed again as interrupted
+func (db *DB) TouchVideoUploadHandOff(id string, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE video_uploads SET expires_at = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'processing'
+	`, id, expiresAt)
+	return err
+}
+
+// ClaimStaleVideoUploadHandOffs claims the uploads staged on stagingHost whose
+// hand-off stopped reporting progress before staleAfter, counting a new hand-off for
+// each. Uploads from before staging hosts were recorded are claimed by any instance.
+func (db *DB) ClaimStaleVideoUploadHandOffs(stagingHost string, staleAfter time.Duration, expiresAt time.Time) ([]*VideoUpload, error) {
+	rows, err := db.Query(`
+		UPDATE video_uploads SET handoff_attempts = handoff_attempts + 1, expires_at = $3, updated_at = NOW()
+		WHERE status = 'processing' AND (staging_host = $1 OR staging_host IS NULL) AND updated_at < $2
+		RETURNING `+videoUploadColumns,
+		stagingHost, time.Now().Add(-staleAfter), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*VideoUpload
+	for rows.Next() {
+		upload, err := scanVideoUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// CompleteVideoUpload links a finished upload to the video it created
+func (db *DB) CompleteVideoUpload(id string, videoID int) error {
+	_, err := db.Exec(`
+		UPDATE video_uploads SET status = 'completed', video_id = $2, error = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, id, videoID)
+	return err
+}
+
+// DeleteVideoUpload removes an upload record
+func (db *DB) DeleteVideoUpload(id string) error {
+	_, err := db.Exec(`DELETE FROM video_uploads WHERE id = $1`, id)
+	return err
+}
+
+// GetExpiredVideoUploads returns the unfinished uploads staged on stagingHost that have
+// not received data, or whose hand-off has not made progress, before their expiry
+func (db *DB) GetExpiredVideoUploads(stagingHost string) ([]*VideoUpload, error) {
+	rows, err := db.Query(`
+		SELECT `+videoUploadColumns+` FROM video_uploads
+		WHERE status IN ('uploading', 'processing') AND (staging_host = $1 OR staging_host IS NULL) AND expires_at < NOW()
+	`, stagingHost)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*VideoUpload
+	for rows.Next() {
+		upload, err := scanVideoUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// CleanupFinishedVideoUploads removes records of uploads that finished, failed or
+// expired more than a week ago
+func (db *DB) CleanupFinishedVideoUploads() error {
+	_, err := db.Exec(`
+		DELETE FROM video_uploads
+		WHERE status IN ('completed', 'failed', 'expired') AND updated_at < NOW() - INTERVAL '7 days'
+	`)
+	return err
+}
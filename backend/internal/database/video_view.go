@@ -0,0 +1,118 @@
+package database
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// VideoViewClaim is a qualified view waiting to be counted
+type VideoViewClaim struct {
+	ViewerKey string
+	MediaType string
+	MediaID   string
+}
+
+// RecordVideoViews counts a batch of qualified views. A viewer's view of a video is only
+// counted if it was not already counted within window. Claims must be unique within the
+// batch. It returns how many views were counted.
+func (db *DB) RecordVideoViews(claims []VideoViewClaim, window time.Duration) (int, error) {
+	if len(claims) == 0 {
+		return 0, nil
+	}
+
+	viewerKeys := make([]string, len(claims))
+	mediaTypes := make([]string, len(claims))
+	mediaIDs := make([]string, len(claims))
+	for i, claim := range claims {
+		viewerKeys[i] = claim.ViewerKey
+		mediaTypes[i] = claim.MediaType
+		mediaIDs[i] = claim.MediaID
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Only claims that are new, or whose previous count has fallen out of the window, come back
+	rows, err := tx.Query(`
+		INSERT INTO video_view_claims (viewer_key, media_type, media_id, counted_at)
+		SELECT c.viewer_key, c.media_type, c.media_id, NOW()
+		FROM unnest($1::TEXT[], $2::TEXT[], $3::TEXT[]) AS c(viewer_key, media_type, media_id)
+		ON CONFLICT (viewer_key, media_type, media_id) DO UPDATE SET counted_at = NOW()
+		WHERE video_view_claims.counted_at < $4
+		RETURNING media_type, media_id
+	`, pq.Array(viewerKeys), pq.Array(mediaTypes), pq.Array(mediaIDs), time.Now().Add(-window))
+	if err != nil {
+		return 0, err
+	}
+
+	counted := 0
+	videoViews := make(map[string]int)
+	youtubeViews := make(map[string]int)
+	for rows.Next() {
+		var mediaType, mediaID string
+		if err := rows.Scan(&mediaType, &mediaID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		switch mediaType {
+		case PlaybackMediaVideo:
+			videoViews[mediaID]++
+		case PlaybackMediaYouTube:
+			youtubeViews[mediaID]++
+		}
+		counted++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(videoViews) > 0 {
+		ids, views := viewCountArrays(videoViews)
+		if _, err := tx.Exec(`
+			UPDATE videos SET view_count = view_count + v.views
+			FROM unnest($1::TEXT[], $2::INTEGER[]) AS v(id, views)
+			WHERE videos.id::TEXT = v.id
+		`, pq.Array(ids), pq.Array(views)); err != nil {
+			return 0, err
+		}
+	}
+	if len(youtubeViews) > 0 {
+		ids, views := viewCountArrays(youtubeViews)
+		if _, err := tx.Exec(`
+			UPDATE youtube_videos SET view_count = view_count + v.views
+			FROM unnest($1::TEXT[], $2::INTEGER[]) AS v(video_id, views)
+			WHERE youtube_videos.video_id = v.video_id
+		`, pq.Array(ids), pq.Array(views)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return counted, nil
+}
+
+func viewCountArrays(counts map[string]int) ([]string, []int64) {
+	ids := make([]string, 0, len(counts))
+	views := make([]int64, 0, len(counts))
+	for id, n := range counts {
+		ids = append(ids, id)
+		views = append(views, int64(n))
+	}
+	return ids, views
+}
+
+// CleanupVideoViewClaims removes claims older than window, which no longer affect counting
+func (db *DB) CleanupVideoViewClaims(window time.Duration) (int64, error) {
+	result, err := db.Exec(`DELETE FROM video_view_claims WHERE counted_at < $1`, time.Now().Add(-window))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -2,10 +2,13 @@ package middleware
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
+	"reflect"
+	"runtime"
 	"strings"
 	"time"
 
@@ -112,8 +115,9 @@ func CORS() gin.HandlerFunc {
 			c.Header("Access-Control-Allow-Origin", origin)
 		}
 
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
+		c.Header("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-CSRF-Token, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum")
+		c.Header("Access-Control-Expose-Headers", "X-Impersonation, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Offset, Upload-Length, Upload-Expires")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Max-Age", "86400") // 24 hours
 
@@ -160,32 +164,45 @@ func RateLimiting() gin.HandlerFunc {
 // AuthRequired middleware that requires a valid JWT token
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Extract token from Authorization header
+		// Extract token from Authorization header, falling back to the browser session cookie
 		authHeader := c.GetHeader("Authorization")
+		authMethod := "bearer"
+		token := ""
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header required",
-			})
-			c.Abort()
-			return
+			cookie, err := c.Cookie(services.AccessTokenCookie)
+			if err != nil || cookie == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Authorization header required",
+				})
+				c.Abort()
+				return
+			}
+			authMethod = "cookie"
+			token = cookie
+		} else {
+			// Check Bearer token format
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid authorization header format. Use 'Bearer <token>'",
+				})
+				c.Abort()
+				return
+			}
+			token = parts[1]
 		}
 
-		// Check Bearer token format
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format. Use 'Bearer <token>'",
+				"error": "Token is required",
 			})
 			c.Abort()
 			return
 		}
 
-		token := parts[1]
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Token is required",
-			})
-			c.Abort()
+		// Personal access tokens and API keys authenticate as their owner
+		if authMethod == "bearer" && services.IsAPIToken(token) {
+			authenticateAPIToken(c, token)
 			return
 		}
 
@@ -215,7 +232,14 @@ func AuthRequired() gin.HandlerFunc {
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
 		c.Set("email_verified", claims.EmailVerified)
-		c.Set("token_id", claims.TokenID) // Store token ID for session tracking
+		c.Set("token_id", claims.TokenID)           // Store token ID for session tracking
+		c.Set("session_family_id", claims.FamilyID) // Identifies the session the token was issued for
+		c.Set("auth_method", authMethod)
+
+		if claims.ImpersonatorID != 0 {
+			handleImpersonatedRequest(c, claims)
+			return
+		}
 
 		// Log successful authentication
 		log.Printf("Authenticated user: %s (ID: %d, Role: %s)", claims.Email, claims.UserID, claims.Role)
@@ -224,6 +248,143 @@ func AuthRequired() gin.HandlerFunc {
 	}
 }
 
+// ImpersonationAuditor records a write made, or attempted, with an impersonation token
+type ImpersonationAuditor func(c *gin.Context, blocked bool)
+
+// impersonationAuditor is registered by the routes package when a database is available
+var impersonationAuditor ImpersonationAuditor
+
+// SetImpersonationAuditor sets how AuthRequired records writes made while impersonating
+func SetImpersonationAuditor(auditor ImpersonationAuditor) {
+	impersonationAuditor = auditor
+}
+
+// handleImpersonatedRequest runs a request made by staff impersonating a user. Every
+// response carries a banner header, financial writes are refused and every write is audited.
+func handleImpersonatedRequest(c *gin.Context, claims *services.Claims) {
+	c.Set("auth_method", "impersonation")
+	c.Set("impersonator_id", claims.ImpersonatorID)
+	c.Set("impersonator_email", claims.ImpersonatorEmail)
+	c.Header("X-Impersonation", "true")
+
+	log.Printf("Authenticated user: %s (ID: %d, Role: %s) impersonated by %s (ID: %d)", claims.Email, claims.UserID, claims.Role, claims.ImpersonatorEmail, claims.ImpersonatorID)
+
+	isWrite := c.Request.Method != "GET" && c.Request.Method != "HEAD" && c.Request.Method != "OPTIONS"
+	if !services.ImpersonationAllowed(c.Request.Method, c.Request.URL.Path) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "Billing and payment changes are not allowed while impersonating a user",
+			"impersonating": true,
+		})
+		c.Abort()
+		if impersonationAuditor != nil {
+			impersonationAuditor(c, true)
+		}
+		return
+	}
+
+	c.Next()
+
+	if isWrite && impersonationAuditor != nil {
+		impersonationAuditor(c, false)
+	}
+}
+
+// APITokenAuthenticator verifies a personal access token or API key
+type APITokenAuthenticator func(token, ipAddress string) (*services.APITokenPrincipal, error)
+
+// apiTokenAuthenticator is registered by the routes package when a database is available
+var apiTokenAuthenticator APITokenAuthenticator
+
+// SetAPITokenAuthenticator sets how AuthRequired verifies personal access tokens and API keys
+func SetAPITokenAuthenticator(authenticator APITokenAuthenticator) {
+	apiTokenAuthenticator = authenticator
+}
+
+// authenticateAPIToken authenticates a request made with an API token. The token's scopes
+// are stored in the context so RequirePermission can restrict the owner's permissions to
+// them, and routes that do not require a permission refuse the token.
+func authenticateAPIToken(c *gin.Context, token string) {
+	if apiTokenAuthenticator == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "API tokens are not supported",
+		})
+		c.Abort()
+		return
+	}
+
+	clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+	principal, err := apiTokenAuthenticator(token, clientIP)
+	if err != nil {
+		log.Printf("API token validation failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired token",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", principal.UserID)
+	c.Set("user_email", principal.Email)
+	c.Set("user_role", principal.Role)
+	c.Set("email_verified", principal.EmailVerified)
+	c.Set("auth_method", "api_token")
+	c.Set("api_token_id", principal.TokenID)
+	c.Set("api_token_scopes", principal.Scopes)
+
+	// Scopes are only enforced by RequirePermission, so a route without one would give the
+	// token every permission its owner has
+	if !routeRequiresPermission(c) {
+		log.Printf("API token %d rejected on %s %s: route does not declare a permission", principal.TokenID, c.Request.Method, c.FullPath())
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This endpoint cannot be used with an API token",
+		})
+		c.Abort()
+		return
+	}
+
+	log.Printf("Authenticated user: %s (ID: %d, Role: %s) with API token %d", principal.Email, principal.UserID, principal.Role, principal.TokenID)
+
+	c.Next()
+}
+
+// permissionHandlerName is the name gin reports for handlers built by permissionMiddleware
+var permissionHandlerName = runtime.FuncForPC(reflect.ValueOf(permissionMiddleware(nil, nil, true)).Pointer()).Name()
+
+// routeRequiresPermission reports whether the matched route checks a permission with
+// RequirePermission or RequireAnyPermission, which is where API token scopes are applied
+func routeRequiresPermission(c *gin.Context) bool {
+	for _, name := range c.HandlerNames() {
+		if name == permissionHandlerName {
+			return true
+		}
+	}
+	return false
+}
+
+// InteractiveSessionRequired middleware that rejects requests authenticated with an API token
+// or an impersonation token. Account security settings and token management require the
+// signed-in user themselves.
+func InteractiveSessionRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.GetString("auth_method") {
+		case "api_token":
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "This action requires signing in. API tokens cannot be used.",
+			})
+			c.Abort()
+			return
+		case "impersonation":
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":         "This action is not available while impersonating a user",
+				"impersonating": true,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // AdminRequired middleware that requires admin role
 func AdminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -237,45 +398,172 @@ func AdminRequired() gin.HandlerFunc {
 			return
 		}
 
-		// Admin roles include all roles with level 7+ (subsystem managers and above)
-		adminRoles := []string{
-			"super_admin",           // Level 10: Super Administrator
-			"system_admin",          // Level 9: System Administrator
-			"content_manager",       // Level 8: Content Manager
-			"articles_manager",      // Level 7: Articles Manager
-			"youtube_manager",       // Level 7: YouTube Manager
-			"streaming_manager",     // Level 7: Video Streaming Manager
-			"events_manager",        // Level 7: Events Manager
-			"advertisement_manager", // Level 7: Advertisement Manager
-			"user_manager",          // Level 7: User Account Manager
-			"analytics_manager",     // Level 7: Analytics Manager
-			"financial_admin",       // Level 7: Financial Administrator
-			"admin",                 // Legacy admin role
+		// Admin roles include all roles with level 7+ (see services.AdminRoles)
+		roleStr := role.(string)
+		if !services.IsAdminRole(roleStr) {
+			userEmail, _ := c.Get("user_email")
+			log.Printf("Admin access denied for user: %v (role: %s)", userEmail, roleStr)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Admin access required",
+			})
+			c.Abort()
+			return
 		}
 
+		c.Next()
+	}
+}
+
+// PermissionResolver returns the permissions granted to a user with the given role
+type PermissionResolver func(userID int, role string) ([]string, error)
+
+// permissionResolver is registered by the routes package, which owns the role definitions
+var permissionResolver PermissionResolver
+
+// SetPermissionResolver sets how RequirePermission and RequireAnyPermission resolve a caller's permissions
+func SetPermissionResolver(resolver PermissionResolver) {
+	permissionResolver = resolver
+}
+
+// RequirePermission middleware that requires the caller to hold every listed permission
+func RequirePermission(db *database.DB, permissions ...string) gin.HandlerFunc {
+	return permissionMiddleware(db, permissions, true)
+}
+
+// RequireAnyPermission middleware that requires the caller to hold at least one listed permission
+func RequireAnyPermission(db *database.DB, permissions ...string) gin.HandlerFunc {
+	return permissionMiddleware(db, permissions, false)
+}
+
+// permissionMiddleware checks the caller's resolved permissions and audits denials
+func permissionMiddleware(db *database.DB, required []string, requireAll bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get user role from context (set by AuthRequired)
+		role, exists := c.Get("user_role")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Authentication required",
+			})
+			c.Abort()
+			return
+		}
 		roleStr := role.(string)
-		isAdmin := false
-		for _, adminRole := range adminRoles {
-			if roleStr == adminRole {
-				isAdmin = true
-				break
-			}
+		userID := c.GetInt("user_id")
+
+		if permissionResolver == nil {
+			log.Printf("Permission check failed: no permission resolver registered")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			c.Abort()
+			return
 		}
 
-		if !isAdmin {
-			userEmail, _ := c.Get("user_email")
-			log.Printf("Admin access denied for user: %v (role: %s)", userEmail, roleStr)
+		granted, err := permissionResolver(userID, roleStr)
+		if err != nil {
+			log.Printf("Failed to resolve permissions for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			c.Abort()
+			return
+		}
+
+		// API tokens only carry the permissions they were scoped to
+		if scopes, ok := c.Get("api_token_scopes"); ok {
+			granted = scopePermissions(granted, scopes.([]string))
+		}
+
+		if !hasPermissions(granted, required, requireAll) {
+			userEmail := c.GetString("user_email")
+			log.Printf("Permission denied for user: %s (role: %s) on %s %s, required: %v", userEmail, roleStr, c.Request.Method, c.FullPath(), required)
+			recordPermissionDenied(c, db, userID, userEmail, roleStr, required, requireAll)
 			c.JSON(http.StatusForbidden, gin.H{
-				"error": "Admin access required",
+				"error":                "Insufficient permissions",
+				"required_permissions": required,
 			})
 			c.Abort()
 			return
 		}
 
+		c.Set("user_permissions", granted)
 		c.Next()
 	}
 }
 
+// hasPermissions reports whether the granted set satisfies the required permissions.
+// system:full_access satisfies any requirement.
+func hasPermissions(granted, required []string, requireAll bool) bool {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, permission := range granted {
+		grantedSet[permission] = true
+	}
+	if grantedSet["system:full_access"] {
+		return true
+	}
+
+	for _, permission := range required {
+		if grantedSet[permission] && !requireAll {
+			return true
+		}
+		if !grantedSet[permission] && requireAll {
+			return false
+		}
+	}
+	return requireAll
+}
+
+// scopePermissions restricts granted permissions to a token's scopes
+func scopePermissions(granted, scopes []string) []string {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, permission := range granted {
+		grantedSet[permission] = true
+	}
+
+	scoped := []string{}
+	for _, scope := range scopes {
+		if grantedSet[scope] || grantedSet["system:full_access"] {
+			scoped = append(scoped, scope)
+		}
+	}
+	return scoped
+}
+
+// recordPermissionDenied writes a failed permission check to the audit log
+func recordPermissionDenied(c *gin.Context, db *database.DB, userID int, userEmail, role string, required []string, requireAll bool) {
+	if db == nil {
+		return
+	}
+
+	details := fmt.Sprintf("Permission denied for %s %s", c.Request.Method, c.FullPath())
+	auditLog := &database.AuditLog{
+		Action:    "permission_denied",
+		Resource:  "authorization",
+		IPAddress: services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent: c.GetHeader("User-Agent"),
+		Status:    "failed",
+		Details:   &details,
+		Severity:  "medium",
+		Metadata: map[string]interface{}{
+			"role":                 role,
+			"required_permissions": required,
+			"require_all":          requireAll,
+			"method":               c.Request.Method,
+			"path":                 c.Request.URL.Path,
+		},
+	}
+	if userID != 0 {
+		auditLog.UserID = &userID
+	}
+	if userEmail != "" {
+		auditLog.UserEmail = &userEmail
+	}
+
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write permission denied audit log: %v", err)
+	}
+}
+
 // EmailVerificationRequired middleware that requires verified email
 func EmailVerificationRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -302,6 +590,21 @@ func EmailVerificationRequired() gin.HandlerFunc {
 	}
 }
 
+// AuthIfPresent authenticates requests that carry credentials exactly like
+// AuthRequired, rejecting invalid ones, and lets requests without any through anonymously
+func AuthIfPresent() gin.HandlerFunc {
+	required := AuthRequired()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if cookie, err := c.Cookie(services.AccessTokenCookie); err != nil || cookie == "" {
+				c.Next()
+				return
+			}
+		}
+		required(c)
+	}
+}
+
 // OptionalAuth middleware that extracts user info if token is present but doesn't require it
 func OptionalAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -334,6 +637,11 @@ func OptionalAuth() gin.HandlerFunc {
 		c.Set("user_role", claims.Role)
 		c.Set("email_verified", claims.EmailVerified)
 
+		if claims.ImpersonatorID != 0 {
+			handleImpersonatedRequest(c, claims)
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -360,31 +668,10 @@ func UserOwnershipRequired() gin.HandlerFunc {
 		// Allow if user is accessing their own resource or is admin
 		userRole, _ := c.Get("user_role")
 
-		// Admin roles include all roles with level 7+ (subsystem managers and above)
-		adminRoles := []string{
-			"super_admin",           // Level 10: Super Administrator
-			"system_admin",          // Level 9: System Administrator
-			"content_manager",       // Level 8: Content Manager
-			"articles_manager",      // Level 7: Articles Manager
-			"youtube_manager",       // Level 7: YouTube Manager
-			"streaming_manager",     // Level 7: Video Streaming Manager
-			"events_manager",        // Level 7: Events Manager
-			"advertisement_manager", // Level 7: Advertisement Manager
-			"user_manager",          // Level 7: User Account Manager
-			"analytics_manager",     // Level 7: Analytics Manager
-			"financial_admin",       // Level 7: Financial Administrator
-			"admin",                 // Legacy admin role
-		}
-
 		// Check if user has admin role
 		isAdmin := false
 		if userRoleStr, ok := userRole.(string); ok {
-			for _, adminRole := range adminRoles {
-				if userRoleStr == adminRole {
-					isAdmin = true
-					break
-				}
-			}
+			isAdmin = services.IsAdminRole(userRoleStr)
 		}
 
 		if isAdmin || fmt.Sprintf("%d", userID) == requestedUserID {
@@ -422,8 +709,15 @@ func RecoveryWithLogging() gin.HandlerFunc {
 // SessionActivityTracker updates session activity for authenticated requests
 func SessionActivityTracker(db *database.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Only track activity for authenticated requests
-		if tokenID, exists := c.Get("token_id"); exists && db != nil {
+		// Only track activity for authenticated requests. Access tokens carry their
+		// session's token family; older tokens fall back to the token ID.
+		if familyID := c.GetString("session_family_id"); familyID != "" && db != nil {
+			go func() {
+				if err := db.UpdateSessionActivityByFamilyID(familyID); err != nil {
+					log.Printf("Failed to update session activity: %v", err)
+				}
+			}()
+		} else if tokenID, exists := c.Get("token_id"); exists && db != nil {
 			if tokenIDStr, ok := tokenID.(string); ok && tokenIDStr != "" {
 				// Update session activity asynchronously to not block the request
 				go func() {
@@ -443,22 +737,41 @@ type ClientInfo struct {
 	LastSeen time.Time
 }
 
-// CSRFProtection middleware that validates CSRF tokens
+// csrfExemptRoutes are the routes that create a session. A cookie from an earlier session
+// may still be sent to them, but there is no session yet to bind a CSRF token to.
+var csrfExemptRoutes = map[string]bool{
+	"/api/v1/auth/login":                   true,
+	"/api/v1/auth/refresh":                 true,
+	"/api/v1/auth/mfa/verify":              true,
+	"/api/v1/auth/magic-link/verify":       true,
+	"/api/v1/auth/oidc/:provider/callback": true,
+}
+
+// CSRFProtection middleware that requires a session-bound CSRF token on state-changing
+// requests authenticated by the access token cookie. Requests that send an Authorization
+// header carry no ambient browser credentials and are not checked.
 func CSRFProtection() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Skip CSRF check for GET requests and OPTIONS
-		if c.Request.Method == "GET" || c.Request.Method == "OPTIONS" {
+		// Skip CSRF check for safe methods
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" {
 			c.Next()
 			return
 		}
 
-		// Skip CSRF check for API endpoints that use token-based auth
-		if strings.HasPrefix(c.Request.URL.Path, "/api/v1/auth/") {
+		// Skip CSRF check for requests that are not cookie-authenticated
+		accessToken, err := c.Cookie(services.AccessTokenCookie)
+		if c.GetHeader("Authorization") != "" || err != nil || accessToken == "" {
 			c.Next()
 			return
 		}
 
-		token := c.GetHeader("X-CSRF-Token")
+		// Skip CSRF check for endpoints that start a session rather than act within one
+		if csrfExemptRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(services.CSRFHeader)
 		if token == "" {
 			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token required"})
 			c.Abort()
@@ -466,7 +779,7 @@ func CSRFProtection() gin.HandlerFunc {
 		}
 
 		// Validate token against session
-		if !validateCSRFToken(c, token) {
+		if !validateCSRFToken(c, accessToken, token) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
 			c.Abort()
 			return
@@ -476,76 +789,23 @@ func CSRFProtection() gin.HandlerFunc {
 	}
 }
 
-// validateCSRFToken validates the CSRF token
-func validateCSRFToken(c *gin.Context, token string) bool {
-	// For now, implement basic validation
-	// In production, this should validate against a session-based token
-
-	// Check if token is at least 32 characters (basic security)
-	if len(token) < 32 {
-		return false
-	}
-
-	// Check if token contains only valid characters
-	validChars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	for _, char := range token {
-		if !strings.ContainsRune(validChars, char) {
+// validateCSRFToken checks that the token matches the CSRF cookie, when one was sent,
+// and that it was issued for the session of the access token cookie
+func validateCSRFToken(c *gin.Context, accessToken, token string) bool {
+	if cookieToken, err := c.Cookie(services.CSRFCookie); err == nil && cookieToken != "" {
+		if subtle.ConstantTimeCompare([]byte(cookieToken), []byte(token)) != 1 {
 			return false
 		}
 	}
 
-	// TODO: Implement proper session-based CSRF validation
-	// This would involve:
-	// 1. Storing CSRF tokens in user sessions
-	// 2. Validating tokens against stored session tokens
-	// 3. Regenerating tokens on login/logout
-
-	return true
+	claims, err := services.ParseToken(accessToken)
+	if err != nil {
+		return false
+	}
+	return services.ValidateCSRFToken(services.CSRFBinding(claims.FamilyID, claims.TokenID), token)
 }
 
 // VideoUploadRequired middleware that requires video upload permissions
-func VideoUploadRequired() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user role from context (set by AuthRequired)
-		role, exists := c.Get("user_role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authentication required",
-			})
-			c.Abort()
-			return
-		}
-
-		// Video upload roles include admin and content management roles
-		uploadRoles := []string{
-			"super_admin",       // Level 10: Super Administrator
-			"system_admin",      // Level 9: System Administrator
-			"content_manager",   // Level 8: Content Manager
-			"youtube_manager",   // Level 7: YouTube Manager
-			"streaming_manager", // Level 7: Video Streaming Manager
-		}
-
-		roleStr := role.(string)
-		canUpload := false
-		for _, uploadRole := range uploadRoles {
-			if roleStr == uploadRole {
-				canUpload = true
-				break
-			}
-		}
-
-		if !canUpload {
-			userEmail, _ := c.Get("user_email")
-			log.Printf("Video upload denied for user: %v (role: %s)", userEmail, roleStr)
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":          "Video upload access required. Only administrators and content managers can upload videos.",
-				"required_roles": uploadRoles,
-				"user_role":      roleStr,
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
+func VideoUploadRequired(db *database.DB) gin.HandlerFunc {
+	return RequirePermission(db, "videos:create")
 }
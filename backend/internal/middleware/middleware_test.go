@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		granted    []string
+		required   []string
+		requireAll bool
+		want       bool
+	}{
+		{name: "all held", granted: []string{"videos:read", "videos:create"}, required: []string{"videos:read", "videos:create"}, requireAll: true, want: true},
+		{name: "one of all missing", granted: []string{"videos:read"}, required: []string{"videos:read", "videos:create"}, requireAll: true},
+		{name: "any held", granted: []string{"videos:create"}, required: []string{"videos:read", "videos:create"}, want: true},
+		{name: "none of any held", granted: []string{"users:read"}, required: []string{"videos:read", "videos:create"}},
+		{name: "nothing granted", required: []string{"videos:read"}, requireAll: true},
+		{name: "full access satisfies all", granted: []string{"system:full_access"}, required: []string{"users:delete", "roles:update"}, requireAll: true, want: true},
+		{name: "full access satisfies any", granted: []string{"system:full_access"}, required: []string{"users:delete"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasPermissions(tt.granted, tt.required, tt.requireAll); got != tt.want {
+				t.Errorf("hasPermissions(%v, %v, %v) = %v, want %v", tt.granted, tt.required, tt.requireAll, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		scopes  []string
+		want    []string
+	}{
+		{name: "scopes within the owner's permissions", granted: []string{"videos:read", "videos:create", "users:read"}, scopes: []string{"videos:read"}, want: []string{"videos:read"}},
+		{name: "scope the owner no longer holds", granted: []string{"videos:read"}, scopes: []string{"videos:read", "videos:delete"}, want: []string{"videos:read"}},
+		{name: "no scopes", granted: []string{"videos:read"}, want: []string{}},
+		{name: "owner with full access", granted: []string{"system:full_access"}, scopes: []string{"videos:read"}, want: []string{"videos:read"}},
+		{name: "full access is only carried when scoped", granted: []string{"system:full_access"}, scopes: []string{"users:read"}, want: []string{"users:read"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scopePermissions(tt.granted, tt.scopes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopePermissions(%v, %v) = %v, want %v", tt.granted, tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPITokenRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetAPITokenAuthenticator(func(token, ipAddress string) (*services.APITokenPrincipal, error) {
+		return &services.APITokenPrincipal{TokenID: 1, UserID: 7, Role: "user", Scopes: []string{"videos:read"}}, nil
+	})
+	SetPermissionResolver(func(userID int, role string) ([]string, error) {
+		return []string{"videos:read", "videos:create"}, nil
+	})
+	t.Cleanup(func() {
+		SetAPITokenAuthenticator(nil)
+		SetPermissionResolver(nil)
+	})
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.GET("/videos", AuthRequired(), RequirePermission(nil, "videos:read"), ok)
+	router.POST("/videos", AuthRequired(), RequirePermission(nil, "videos:create"), ok)
+	router.GET("/any", AuthRequired(), RequireAnyPermission(nil, "videos:read", "users:read"), ok)
+	router.POST("/subscriptions", AuthRequired(), ok)
+	router.GET("/profile", AuthRequired(), ok)
+	router.GET("/optional", AuthIfPresent(), ok)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodGet, path: "/videos", want: http.StatusOK},
+		{method: http.MethodPost, path: "/videos", want: http.StatusForbidden},
+		{method: http.MethodGet, path: "/any", want: http.StatusOK},
+		{method: http.MethodPost, path: "/subscriptions", want: http.StatusForbidden},
+		{method: http.MethodGet, path: "/profile", want: http.StatusForbidden},
+		{method: http.MethodGet, path: "/optional", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer bome_pat_test")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-jwt-secret-for-unit-tests-only")
+	t.Setenv("CSRF_SECRET", "test-csrf-secret")
+
+	session, err := services.GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+	otherSession, err := services.GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+	csrfToken, err := services.GenerateCSRFToken(services.CSRFBinding(session.FamilyID, session.AccessTokenID))
+	if err != nil {
+		t.Fatalf("GenerateCSRFToken failed: %v", err)
+	}
+	otherCSRFToken, _ := services.GenerateCSRFToken(services.CSRFBinding(otherSession.FamilyID, otherSession.AccessTokenID))
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.Use(CSRFProtection())
+	router.GET("/api/v1/videos", ok)
+	router.POST("/api/v1/videos", ok)
+	router.POST("/api/v1/auth/login", ok)
+	router.POST("/api/v1/auth/refresh", ok)
+	router.POST("/api/v1/auth/oidc/:provider/callback", ok)
+	router.POST("/api/v1/auth/login-history", ok)
+	router.POST("/api/v1/auth/oidc/:provider/link", ok)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		cookie        string
+		csrfCookie    string
+		header        string
+		authorization string
+		want          int
+	}{
+		{name: "safe method", method: http.MethodGet, path: "/api/v1/videos", cookie: session.AccessToken, want: http.StatusOK},
+		{name: "no session cookie", method: http.MethodPost, path: "/api/v1/videos", want: http.StatusOK},
+		{name: "bearer token", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, authorization: "Bearer " + session.AccessToken, want: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, want: http.StatusForbidden},
+		{name: "valid token", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, header: csrfToken, want: http.StatusOK},
+		{name: "valid token matching cookie", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, csrfCookie: csrfToken, header: csrfToken, want: http.StatusOK},
+		{name: "header differs from cookie", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, csrfCookie: otherCSRFToken, header: csrfToken, want: http.StatusForbidden},
+		{name: "token from another session", method: http.MethodPost, path: "/api/v1/videos", cookie: session.AccessToken, header: otherCSRFToken, want: http.StatusForbidden},
+		{name: "invalid session cookie", method: http.MethodPost, path: "/api/v1/videos", cookie: "not-a-jwt", header: csrfToken, want: http.StatusForbidden},
+		{name: "login is exempt", method: http.MethodPost, path: "/api/v1/auth/login", cookie: session.AccessToken, want: http.StatusOK},
+		{name: "refresh is exempt", method: http.MethodPost, path: "/api/v1/auth/refresh", cookie: session.AccessToken, want: http.StatusOK},
+		{name: "OIDC callback is exempt", method: http.MethodPost, path: "/api/v1/auth/oidc/google/callback", cookie: session.AccessToken, want: http.StatusOK},
+		{name: "route sharing the login prefix", method: http.MethodPost, path: "/api/v1/auth/login-history", cookie: session.AccessToken, want: http.StatusForbidden},
+		{name: "other OIDC route", method: http.MethodPost, path: "/api/v1/auth/oidc/google/link", cookie: session.AccessToken, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: services.AccessTokenCookie, Value: tt.cookie})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: services.CSRFCookie, Value: tt.csrfCookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(services.CSRFHeader, tt.header)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
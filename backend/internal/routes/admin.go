@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	"bome-backend/internal/database"
 	"bome-backend/internal/middleware"
+	"bome-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -182,8 +184,9 @@ func UpdateUserHandler(db *database.DB) gin.HandlerFunc {
 	}
 }
 
-// DeleteUserHandler handles deleting a user for admin
-func DeleteUserHandler(db *database.DB) gin.HandlerFunc {
+// DeleteUserHandler handles erasing a user for admin. Users referenced by billing
+// records are anonymized instead of deleted.
+func DeleteUserHandler(db *database.DB, privacy *services.PrivacyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -192,15 +195,24 @@ func DeleteUserHandler(db *database.DB) gin.HandlerFunc {
 		}
 
 		adminID := c.GetInt("user_id")
-		if err := db.DeleteUser(userID); err != nil {
+		anonymized, err := privacy.EraseAccount(userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to erase user %d: %v", userID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 			return
 		}
 
 		// Log admin action
 		go db.CreateAdminLog(&adminID, "user_deleted", "user", &userID, nil, c.ClientIP(), c.GetHeader("User-Agent"))
+		recordAdminAudit(c, db, "user_erased", "user", strconv.Itoa(userID), "User account erased", map[string]interface{}{
+			"anonymized": anonymized,
+		})
 
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "anonymized": anonymized})
 	}
 }
 
@@ -1431,30 +1443,30 @@ func UpdateAdPlacementHandler(db *database.DB) gin.HandlerFunc {
 }
 
 // SetupAdminRoutes configures admin-related routes
-func SetupAdminRoutes(router *gin.RouterGroup, db *database.DB) {
+func SetupAdminRoutes(router *gin.RouterGroup, db *database.DB, privacy *services.PrivacyService) {
 	// Users
-	router.GET("/users", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetUsersHandler(db))
-	router.GET("/users/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetUserHandler(db))
-	router.PUT("/users/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), UpdateUserHandler(db))
-	router.DELETE("/users/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), DeleteUserHandler(db))
+	router.GET("/users", middleware.AuthRequired(), middleware.RequirePermission(db, "users:read"), middleware.SessionActivityTracker(db), GetUsersHandler(db))
+	router.GET("/users/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "users:read"), middleware.SessionActivityTracker(db), GetUserHandler(db))
+	router.PUT("/users/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "users:update"), middleware.SessionActivityTracker(db), UpdateUserHandler(db))
+	router.DELETE("/users/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "users:delete"), middleware.SessionActivityTracker(db), DeleteUserHandler(db, privacy))
 
 	// Videos
-	router.GET("/videos", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetAdminVideosHandler(db))
-	router.GET("/videos/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetAdminVideoHandler(db))
-	router.PUT("/videos/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), UpdateVideoHandler(db))
-	router.DELETE("/videos/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), DeleteVideoHandler(db))
-	router.POST("/videos/bulk", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), BulkVideoOperationHandler(db))
-	router.GET("/videos/:id/stats", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetVideoStatsHandler(db))
-	router.GET("/videos/categories", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetVideoCategoriesHandler(db))
-	router.POST("/videos/:id/schedule", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), ScheduleVideoHandler(db))
-	router.POST("/videos/:id/unschedule", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), UnscheduleVideoHandler(db))
-	router.GET("/videos/scheduled", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetScheduledVideosHandler(db))
+	router.GET("/videos", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:read"), middleware.SessionActivityTracker(db), GetAdminVideosHandler(db))
+	router.GET("/videos/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:read"), middleware.SessionActivityTracker(db), GetAdminVideoHandler(db))
+	router.PUT("/videos/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:update"), middleware.SessionActivityTracker(db), UpdateVideoHandler(db))
+	router.DELETE("/videos/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:delete"), middleware.SessionActivityTracker(db), DeleteVideoHandler(db))
+	router.POST("/videos/bulk", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), BulkVideoOperationHandler(db))
+	router.GET("/videos/:id/stats", middleware.AuthRequired(), middleware.RequireAnyPermission(db, "videos:read", "analytics:read"), middleware.SessionActivityTracker(db), GetVideoStatsHandler(db))
+	router.GET("/videos/categories", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:read"), middleware.SessionActivityTracker(db), GetVideoCategoriesHandler(db))
+	router.POST("/videos/:id/schedule", middleware.AuthRequired(), middleware.RequireAnyPermission(db, "videos:update", "content:publish"), middleware.SessionActivityTracker(db), ScheduleVideoHandler(db))
+	router.POST("/videos/:id/unschedule", middleware.AuthRequired(), middleware.RequireAnyPermission(db, "videos:update", "content:publish"), middleware.SessionActivityTracker(db), UnscheduleVideoHandler(db))
+	router.GET("/videos/scheduled", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:read"), middleware.SessionActivityTracker(db), GetScheduledVideosHandler(db))
 
 	// Ad Placements
-	router.GET("/placements", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetAdPlacementsHandler(db))
-	router.GET("/placements/performance", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), GetAdPlacementsPerformanceHandler(db))
-	router.POST("/placements", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), CreateAdPlacementHandler(db))
-	router.PUT("/placements/:id", middleware.AuthRequired(), middleware.AdminRequired(), middleware.SessionActivityTracker(db), UpdateAdPlacementHandler(db))
+	router.GET("/placements", middleware.AuthRequired(), middleware.RequirePermission(db, "advertisements:read"), middleware.SessionActivityTracker(db), GetAdPlacementsHandler(db))
+	router.GET("/placements/performance", middleware.AuthRequired(), middleware.RequireAnyPermission(db, "advertisements:read", "analytics:read"), middleware.SessionActivityTracker(db), GetAdPlacementsPerformanceHandler(db))
+	router.POST("/placements", middleware.AuthRequired(), middleware.RequirePermission(db, "advertisements:manage"), middleware.SessionActivityTracker(db), CreateAdPlacementHandler(db))
+	router.PUT("/placements/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "advertisements:manage"), middleware.SessionActivityTracker(db), UpdateAdPlacementHandler(db))
 
 	// Design System Routes
 	// Temporarily disabled for debugging
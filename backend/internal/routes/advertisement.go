@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"bome-backend/internal/database"
 	"bome-backend/internal/middleware"
 	"bome-backend/internal/services"
 
@@ -14,65 +15,51 @@ import (
 // SetupAdvertisementRoutes configures advertisement-related routes
 func SetupAdvertisementRoutes(
 	router *gin.RouterGroup,
+	db *database.DB,
 	adService *services.AdvertisementService,
 ) {
-	// Advertiser routes (require advertiser role)
+	// Advertiser routes (require advertisement permissions)
 	advertiser := router.Group("/advertiser")
-	advertiser.Use(middleware.AuthRequired(), RoleRequired(
-		"advertiser",            // Level 3: Advertiser
-		"marketing_specialist",  // Level 4: Marketing Specialist
-		"advertisement_manager", // Level 7: Advertisement Manager
-		"super_admin",           // Level 10: Super Administrator
-		"system_admin",          // Level 9: System Administrator
-		"content_manager",       // Level 8: Content Manager
-		"articles_manager",      // Level 7: Articles Manager
-		"youtube_manager",       // Level 7: YouTube Manager
-		"streaming_manager",     // Level 7: Video Streaming Manager
-		"events_manager",        // Level 7: Events Manager
-		"user_manager",          // Level 7: User Account Manager
-		"analytics_manager",     // Level 7: Analytics Manager
-		"financial_admin",       // Level 7: Financial Administrator
-		"admin",                 // Legacy admin role
-	))
+	advertiser.Use(middleware.AuthRequired())
 	{
 		// Advertiser account management
-		advertiser.POST("/account", createAdvertiserAccountHandler(adService))
-		advertiser.GET("/account", getAdvertiserAccountHandler(adService))
+		advertiser.POST("/account", middleware.RequirePermission(db, "advertisements:create"), createAdvertiserAccountHandler(adService))
+		advertiser.GET("/account", middleware.RequirePermission(db, "advertisements:read"), getAdvertiserAccountHandler(adService))
 
 		// Campaign management
-		advertiser.POST("/campaigns", createCampaignHandler(adService))
-		advertiser.GET("/campaigns", getCampaignsHandler(adService))
-		advertiser.GET("/campaigns/:id", getCampaignHandler(adService))
+		advertiser.POST("/campaigns", middleware.RequirePermission(db, "advertisements:create"), createCampaignHandler(adService))
+		advertiser.GET("/campaigns", middleware.RequirePermission(db, "advertisements:read"), getCampaignsHandler(adService))
+		advertiser.GET("/campaigns/:id", middleware.RequirePermission(db, "advertisements:read"), getCampaignHandler(adService))
 
 		// Advertisement management
-		advertiser.POST("/campaigns/:campaignId/ads", createAdvertisementHandler(adService))
-		advertiser.GET("/ads/:id", getAdvertisementHandler(adService))
+		advertiser.POST("/campaigns/:campaignId/ads", middleware.RequirePermission(db, "advertisements:create"), createAdvertisementHandler(adService))
+		advertiser.GET("/ads/:id", middleware.RequirePermission(db, "advertisements:read"), getAdvertisementHandler(adService))
 
 		// Analytics
-		advertiser.GET("/ads/:id/analytics", getAdAnalyticsHandler(adService))
-		advertiser.GET("/campaigns/:id/analytics", getCampaignAnalyticsHandler(adService))
+		advertiser.GET("/ads/:id/analytics", middleware.RequirePermission(db, "advertisements:read"), getAdAnalyticsHandler(adService))
+		advertiser.GET("/campaigns/:id/analytics", middleware.RequirePermission(db, "advertisements:read"), getCampaignAnalyticsHandler(adService))
 	}
 
-	// Admin routes (require admin authentication)
+	// Admin routes (require advertisement management permissions)
 	admin := router.Group("/admin/ads")
-	admin.Use(middleware.AuthRequired(), middleware.AdminRequired())
+	admin.Use(middleware.AuthRequired())
 	{
 		// Advertiser account management
-		admin.GET("/advertisers", getAdvertisersHandler(adService))
-		admin.GET("/advertisers/:id", getAdvertiserHandler(adService))
-		admin.POST("/advertisers/:id/approve", approveAdvertiserHandler(adService))
-		admin.POST("/advertisers/:id/reject", rejectAdvertiserHandler(adService))
+		admin.GET("/advertisers", middleware.RequirePermission(db, "advertisements:manage"), getAdvertisersHandler(adService))
+		admin.GET("/advertisers/:id", middleware.RequirePermission(db, "advertisements:manage"), getAdvertiserHandler(adService))
+		admin.POST("/advertisers/:id/approve", middleware.RequirePermission(db, "advertisements:approve"), approveAdvertiserHandler(adService))
+		admin.POST("/advertisers/:id/reject", middleware.RequirePermission(db, "advertisements:approve"), rejectAdvertiserHandler(adService))
 
 		// Campaign management
-		admin.GET("/campaigns", getAllCampaignsHandler(adService))
-		admin.GET("/campaigns/:id", getAdminCampaignHandler(adService))
-		admin.POST("/campaigns/:id/approve", approveCampaignHandler(adService))
-		admin.POST("/campaigns/:id/reject", rejectCampaignHandler(adService))
+		admin.GET("/campaigns", middleware.RequirePermission(db, "advertisements:manage"), getAllCampaignsHandler(adService))
+		admin.GET("/campaigns/:id", middleware.RequirePermission(db, "advertisements:manage"), getAdminCampaignHandler(adService))
+		admin.POST("/campaigns/:id/approve", middleware.RequirePermission(db, "advertisements:approve"), approveCampaignHandler(adService))
+		admin.POST("/campaigns/:id/reject", middleware.RequirePermission(db, "advertisements:approve"), rejectCampaignHandler(adService))
 
 		// Ad placement management
-		admin.GET("/placements", getPlacementsHandler(adService))
-		admin.POST("/placements", createPlacementHandler(adService))
-		admin.PUT("/placements/:id", updatePlacementHandler(adService))
+		admin.GET("/placements", middleware.RequirePermission(db, "advertisements:manage"), getPlacementsHandler(adService))
+		admin.POST("/placements", middleware.RequirePermission(db, "advertisements:manage"), createPlacementHandler(adService))
+		admin.PUT("/placements/:id", middleware.RequirePermission(db, "advertisements:manage"), updatePlacementHandler(adService))
 	}
 
 	// Public routes (no authentication required)
@@ -5,18 +5,19 @@ import (
 	"net/http"
 	"time"
 
+	"bome-backend/internal/database"
 	"bome-backend/internal/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupAnalyticsRoutes configures analytics-related routes
-func SetupAnalyticsRoutes(router *gin.RouterGroup) {
+func SetupAnalyticsRoutes(router *gin.RouterGroup, db *database.DB) {
 	fmt.Printf("🔥 ANALYTICS: Starting SetupAnalyticsRoutes function\n")
 
 	// Create analytics group with authentication
 	analytics := router.Group("/dashboard/analytics")
-	analytics.Use(middleware.AuthRequired())
+	analytics.Use(middleware.AuthRequired(), middleware.RequirePermission(db, "analytics:read"))
 
 	// Main analytics dashboard endpoint
 	analytics.GET("", func(c *gin.Context) {
@@ -67,7 +68,7 @@ func SetupAnalyticsRoutes(router *gin.RouterGroup) {
 	})
 
 	// Export endpoint
-	analytics.GET("/export", func(c *gin.Context) {
+	analytics.GET("/export", middleware.RequirePermission(db, "analytics:export"), func(c *gin.Context) {
 		format := c.DefaultQuery("format", "csv")
 		period := c.DefaultQuery("period", "7d")
 
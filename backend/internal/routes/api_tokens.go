@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenRequest is the body accepted when creating a personal access token or API key
+type apiTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// apiTokenResponse builds the public view of an API token. The secret is never included.
+func apiTokenResponse(token *database.APIToken) gin.H {
+	status := "active"
+	if token.RevokedAt.Valid {
+		status = "revoked"
+	} else if time.Now().After(token.ExpiresAt) {
+		status = "expired"
+	}
+
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	response := gin.H{
+		"id":         token.ID,
+		"user_id":    token.UserID,
+		"name":       token.Name,
+		"prefix":     token.Prefix,
+		"scopes":     scopes,
+		"status":     status,
+		"expires_at": token.ExpiresAt,
+		"created_at": token.CreatedAt,
+	}
+	if token.LastUsedAt.Valid {
+		response["last_used_at"] = token.LastUsedAt.Time
+	}
+	if token.LastUsedIP.Valid {
+		response["last_used_ip"] = token.LastUsedIP.String
+	}
+	if token.RevokedAt.Valid {
+		response["revoked_at"] = token.RevokedAt.Time
+	}
+	return response
+}
+
+// listAPITokens lists tokens of a kind for one owner, or for all owners when userID is 0
+func listAPITokens(db *database.DB, kind string, userID int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		tokens, err := db.GetAPITokens(kind, userID)
+		if err != nil {
+			log.Printf("Failed to get API tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tokens"})
+			return
+		}
+
+		result := make([]gin.H, 0, len(tokens))
+		for _, token := range tokens {
+			result = append(result, apiTokenResponse(token))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"tokens": result,
+			"total":  len(result),
+		})
+	}
+}
+
+// createAPIToken creates a token of a kind owned by the authenticated user.
+// Scopes must be standardized permission IDs that the user holds.
+func createAPIToken(c *gin.Context, db *database.DB, kind string) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	if db == nil {
+		serviceUnavailable(c)
+		return
+	}
+
+	var req apiTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be between 1 and 100 characters"})
+		return
+	}
+
+	maxDays := int(services.MaxAPITokenLifetime / (24 * time.Hour))
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be between 1 and 365 days"})
+		return
+	}
+	lifetime := services.DefaultAPITokenLifetime
+	if req.ExpiresInDays != 0 {
+		lifetime = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	// The caller's permissions are resolved here because these routes are not permission-gated
+	scopes := uniqueStrings(req.Scopes)
+	if len(scopes) > 0 {
+		permissions, err := db.GetUserPermissions(userID, canonicalRoleID(c.GetString("user_role")))
+		if err != nil {
+			log.Printf("Failed to resolve permissions for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+		c.Set("user_permissions", permissions)
+		if !checkGrantablePermissions(c, db, scopes, scopes) {
+			return
+		}
+	}
+
+	secret, prefix, hash, err := services.GenerateAPIToken(kind)
+	if err != nil {
+		log.Printf("Failed to generate API token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	token := &database.APIToken{
+		UserID:    userID,
+		Kind:      kind,
+		Name:      name,
+		Prefix:    prefix,
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(lifetime),
+		CreatedBy: sql.NullInt64{Int64: int64(userID), Valid: true},
+	}
+	if err := db.CreateAPIToken(token); err != nil {
+		log.Printf("Failed to create API token for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	recordAdminAudit(c, db, kind+"_created", "api_token", strconv.Itoa(token.ID), "API token created", map[string]interface{}{
+		"kind":       kind,
+		"prefix":     token.Prefix,
+		"scopes":     token.Scopes,
+		"expires_at": token.ExpiresAt,
+	})
+
+	response := apiTokenResponse(token)
+	response["token"] = secret
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Token created. Copy it now, it will not be shown again.",
+		"token":   response,
+	})
+}
+
+// revokeAPIToken revokes a token of a kind, limited to the caller's own tokens when ownOnly is set
+func revokeAPIToken(c *gin.Context, db *database.DB, kind string, ownOnly bool) {
+	if db == nil {
+		serviceUnavailable(c)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	ownerID := 0
+	if ownOnly {
+		ownerID = c.GetInt("user_id")
+	}
+
+	token, err := db.RevokeAPIToken(tokenID, kind, ownerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke API token %d: %v", tokenID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	recordAdminAudit(c, db, kind+"_revoked", "api_token", strconv.Itoa(token.ID), "API token revoked", map[string]interface{}{
+		"kind":     kind,
+		"prefix":   token.Prefix,
+		"owner_id": token.UserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token revoked successfully",
+		"token":   apiTokenResponse(token),
+	})
+}
+
+// ListPersonalAccessTokensHandler lists the authenticated user's personal access tokens
+func ListPersonalAccessTokensHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		listAPITokens(db, database.APITokenKindPersonal, userID)(c)
+	}
+}
+
+// CreatePersonalAccessTokenHandler creates a personal access token for the authenticated user
+func CreatePersonalAccessTokenHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		createAPIToken(c, db, database.APITokenKindPersonal)
+	}
+}
+
+// RevokePersonalAccessTokenHandler revokes one of the authenticated user's personal access tokens
+func RevokePersonalAccessTokenHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revokeAPIToken(c, db, database.APITokenKindPersonal, true)
+	}
+}
+
+// ListAPIKeysHandler lists all API keys
+func ListAPIKeysHandler(db *database.DB) gin.HandlerFunc {
+	return listAPITokens(db, database.APITokenKindAPIKey, 0)
+}
+
+// CreateAPIKeyHandler creates an API key for an integration, owned by the creating administrator
+func CreateAPIKeyHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		createAPIToken(c, db, database.APITokenKindAPIKey)
+	}
+}
+
+// RevokeAPIKeyHandler revokes an API key
+func RevokeAPIKeyHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revokeAPIToken(c, db, database.APITokenKindAPIKey, false)
+	}
+}
@@ -2,7 +2,7 @@ package routes
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -196,12 +196,7 @@ func LoginHandler(db *database.DB) gin.HandlerFunc {
 		}
 
 		// Check enhanced rate limiting
-		if !services.EnhancedLoginRateLimiter.CheckLoginAttempt(req.Email, clientIP) {
-			remainingTime := services.EnhancedLoginRateLimiter.GetRemainingLockoutTime(req.Email)
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":             fmt.Sprintf("Account temporarily locked. Please try again in %v", remainingTime),
-				"lockout_remaining": remainingTime.String(),
-			})
+		if !checkLoginAllowed(c, req.Email, clientIP) {
 			return
 		}
 
@@ -272,119 +267,239 @@ func LoginHandler(db *database.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Record successful attempt
-		services.EnhancedLoginRateLimiter.RecordSuccessfulAttempt(req.Email)
-
-		// Check session limit
-		maxSessions := 5 // Default max sessions
-		if user.MaxSessions > 0 {
-			maxSessions = user.MaxSessions
+		// Require a second factor when enabled, or when the role makes it mandatory
+		if challengeSecondFactor(c, db, user, "Password verified") {
+			return
 		}
 
-		canCreateSession, err := db.CheckSessionLimit(user.ID, maxSessions)
-		if err != nil {
-			log.Printf("Error checking session limit: %v", err)
-			// Continue anyway - don't block login for session limit errors
-		} else if !canCreateSession {
-			// Log session limit exceeded
-			if db != nil {
-				auditLog := &database.AuditLog{
-					UserID:    &user.ID,
-					UserEmail: &user.Email,
-					Action:    "login",
-					Resource:  "authentication",
-					IPAddress: clientIP,
-					UserAgent: c.GetHeader("User-Agent"),
-					Status:    "warning",
-					Details:   &[]string{"Session limit exceeded"}[0],
-					Severity:  "medium",
-				}
-				db.CreateAuditLog(auditLog)
-			}
-		}
+		completeLogin(c, db, user, clientIP, "password", nil)
+	}
+}
 
-		// Generate token pair
-		tokenPair, err := services.GenerateTokenPair(user.ID, user.Email, user.Role, user.EmailVerified)
+// challengeSecondFactor responds with an MFA pending token when the user must complete
+// two-factor authentication before logging in. It returns true if a response was written.
+func challengeSecondFactor(c *gin.Context, db *database.DB, user *database.User, firstFactor string) bool {
+	if !user.MFAEnabled {
+		required, err := mfaRequiredForUser(db, user)
 		if err != nil {
-			log.Printf("Failed to generate tokens: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Service temporarily unavailable. Please try again later.",
-			})
-			return
+			log.Printf("Failed to resolve roles of user %d: %v", user.ID, err)
+			serviceUnavailable(c)
+			return true
+		}
+		if !required {
+			return false
 		}
+	}
 
-		// Create session record
-		var session *database.Session
-		if db != nil {
-			deviceInfo := services.GenerateDeviceFingerprint(c.Request)
-			// Extract token ID from refresh token for session tracking
-			refreshClaims, _ := services.ParseRefreshToken(tokenPair.RefreshToken)
-			tokenID := ""
-			if refreshClaims != nil {
-				tokenID = refreshClaims.TokenID
-			}
+	mfaToken, err := services.GenerateMFAPendingToken(user.ID, user.Email, user.Role, user.EmailVerified)
+	if err != nil {
+		log.Printf("Failed to generate MFA token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Service temporarily unavailable. Please try again later.",
+		})
+		return true
+	}
 
-			session, err = db.CreateSession(
-				user.ID,
-				tokenID,
-				deviceInfo,
-				clientIP,
-				c.GetHeader("User-Agent"),
-				time.Now().Add(7*24*time.Hour), // Session expires with refresh token
-			)
-			if err != nil {
-				log.Printf("Failed to create session: %v", err)
-				// Continue anyway - don't block login for session creation errors
-			}
-		}
+	details := firstFactor + ", awaiting second factor"
+	if !user.MFAEnabled {
+		details = firstFactor + ", two-factor enrollment required"
+	}
+	recordAuthAudit(c, db, user, "login_mfa_challenge", "success", details, "low", nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required":            user.MFAEnabled,
+		"mfa_enrollment_required": !user.MFAEnabled,
+		"mfa_token":               mfaToken,
+		"expires_in":              int64((5 * time.Minute).Seconds()),
+	})
+	return true
+}
 
-		// Update last login timestamp
-		if err := db.UpdateLastLogin(user.ID); err != nil {
-			log.Printf("Failed to update last login: %v", err)
-			// Continue anyway - this is not critical
-		}
-
-		// Log successful login
-		if db != nil {
-			auditLog := &database.AuditLog{
-				UserID:    &user.ID,
-				UserEmail: &user.Email,
-				Action:    "login",
-				Resource:  "authentication",
-				IPAddress: clientIP,
-				UserAgent: c.GetHeader("User-Agent"),
-				Status:    "success",
-				Details:   &[]string{"Login successful"}[0],
-				Severity:  "low",
-				Metadata: map[string]interface{}{
-					"session_id":  session.ID,
-					"device_info": session.DeviceInfo,
-				},
-			}
-			db.CreateAuditLog(auditLog)
-		}
+// completeLogin finishes a login for a fully authenticated user: it issues the token
+// pair, records the session and audit trail, and writes the login response.
+// Any extra fields are merged into the response body.
+func completeLogin(c *gin.Context, db *database.DB, user *database.User, clientIP, method string, extra gin.H) {
+	// Record successful attempt
+	services.EnhancedLoginRateLimiter.RecordSuccessfulAttempt(user.Email)
+
+	// Enforce session limit (per-user override, otherwise per-role limit)
+	maxSessions := services.MaxSessionsForRole(user.Role)
+	if user.MaxSessions > 0 {
+		maxSessions = user.MaxSessions
+	}
+	if !enforceSessionLimit(c, db, user, maxSessions) {
+		return
+	}
 
-		log.Printf("User logged in successfully: %s (ID: %d) from %s", user.Email, user.ID, clientIP)
+	// Generate token pair
+	tokenPair, err := services.GenerateTokenPair(user.ID, user.Email, user.Role, user.EmailVerified)
+	if err != nil {
+		log.Printf("Failed to generate tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Service temporarily unavailable. Please try again later.",
+		})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"access_token":  tokenPair.AccessToken,
-			"refresh_token": tokenPair.RefreshToken,
-			"expires_in":    tokenPair.ExpiresIn,
-			"token_type":    tokenPair.TokenType,
-			"session_id":    session.ID,
-			"user": gin.H{
-				"id":             user.ID,
-				"email":          user.Email,
-				"role":           user.Role,
-				"first_name":     user.FirstName,
-				"last_name":      user.LastName,
-				"email_verified": user.EmailVerified,
-			},
+	// Create session record, tracked by the refresh token ID
+	deviceInfo := services.GenerateDeviceFingerprint(c.Request)
+	sessionID := ""
+	session, err := db.CreateSession(
+		user.ID,
+		tokenPair.RefreshTokenID,
+		deviceInfo,
+		clientIP,
+		c.GetHeader("User-Agent"),
+		tokenPair.RefreshExpiresAt, // Session expires with refresh token
+	)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		// Continue anyway - don't block login for session creation errors
+	} else {
+		sessionID = session.ID
+	}
+
+	// Alert the user when they sign in from a device they have not used before
+	checkLoginDevice(c, db, user, deviceInfo, clientIP)
+
+	// Start the refresh token rotation family for this session
+	if err := services.StartRefreshTokenFamily(db, user.ID, sessionID, tokenPair); err != nil {
+		log.Printf("Failed to record refresh token family: %v", err)
+	}
+
+	// Update last login timestamp
+	if err := db.UpdateLastLogin(user.ID); err != nil {
+		log.Printf("Failed to update last login: %v", err)
+		// Continue anyway - this is not critical
+	}
+
+	// Log successful login
+	recordAuthAudit(c, db, user, "login", "success", "Login successful", "low", map[string]interface{}{
+		"session_id":  sessionID,
+		"device_info": deviceInfo,
+		"method":      method,
+	})
+
+	log.Printf("User logged in successfully: %s (ID: %d) from %s", user.Email, user.ID, clientIP)
+
+	// Each login starts a new session, so the CSRF token is rotated with it
+	csrfToken, err := issueCSRFToken(c, tokenPair.FamilyID, tokenPair.AccessTokenID)
+	if err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+	}
+	setAccessTokenCookie(c, tokenPair)
+
+	response := gin.H{
+		"access_token":  tokenPair.AccessToken,
+		"refresh_token": tokenPair.RefreshToken,
+		"expires_in":    tokenPair.ExpiresIn,
+		"token_type":    tokenPair.TokenType,
+		"session_id":    sessionID,
+		"csrf_token":    csrfToken,
+		"user": gin.H{
+			"id":             user.ID,
+			"email":          user.Email,
+			"role":           user.Role,
+			"first_name":     user.FirstName,
+			"last_name":      user.LastName,
+			"email_verified": user.EmailVerified,
+			"mfa_enabled":    user.MFAEnabled,
+		},
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// enforceSessionLimit makes room for a new session when the user is at their limit,
+// either by revoking the least recently active sessions or by rejecting the login.
+// It returns false if a response has already been written.
+func enforceSessionLimit(c *gin.Context, db *database.DB, user *database.User, maxSessions int) bool {
+	canCreateSession, err := db.CheckSessionLimit(user.ID, maxSessions)
+	if err != nil {
+		log.Printf("Error checking session limit: %v", err)
+		// Continue anyway - don't block login for session limit errors
+		return true
+	}
+	if canCreateSession {
+		return true
+	}
+
+	if services.SessionLimitPolicy() == services.SessionLimitReject {
+		recordAuthAudit(c, db, user, "login", "failed", "Session limit exceeded", "medium", map[string]interface{}{
+			"max_sessions": maxSessions,
+		})
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":        "Maximum number of active sessions reached. Please log out of another device and try again.",
+			"max_sessions": maxSessions,
 		})
+		return false
+	}
+
+	// Sessions are ordered by most recent activity, so evict from the end
+	sessions, err := db.GetActiveSessions(user.ID)
+	if err != nil {
+		log.Printf("Error loading sessions for session limit: %v", err)
+		return true
 	}
+
+	evicted := []string{}
+	for i := len(sessions) - 1; i >= maxSessions-1 && i >= 0; i-- {
+		if err := services.RevokeSessionTokens(db, sessions[i]); err != nil {
+			log.Printf("Failed to evict session %s: %v", sessions[i].ID, err)
+			continue
+		}
+		evicted = append(evicted, sessions[i].ID)
+	}
+
+	recordAuthAudit(c, db, user, "session_evicted", "warning", "Session limit exceeded; oldest sessions revoked", "medium", map[string]interface{}{
+		"max_sessions":     maxSessions,
+		"evicted_sessions": evicted,
+	})
+	return true
 }
 
-// RefreshTokenHandler handles token refresh
+// recordAuthAudit writes an authentication-related audit log entry for a user
+func recordAuthAudit(c *gin.Context, db *database.DB, user *database.User, action, status, details, severity string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+
+	auditLog := &database.AuditLog{
+		Action:    action,
+		Resource:  "authentication",
+		IPAddress: services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent: c.GetHeader("User-Agent"),
+		Status:    status,
+		Details:   &details,
+		Severity:  severity,
+		Metadata:  metadata,
+	}
+	if user != nil {
+		auditLog.UserID = &user.ID
+		auditLog.UserEmail = &user.Email
+	}
+
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
+
+// revokeAllUserSessions revokes every token issued to the user so far and
+// deactivates their sessions, e.g. after the password changes
+func revokeAllUserSessions(db *database.DB, userID int) {
+	if err := services.RevokeAllUserTokens(userID); err != nil {
+		log.Printf("Failed to revoke tokens for user %d: %v", userID, err)
+	}
+	if err := db.DeactivateAllUserSessions(userID); err != nil {
+		log.Printf("Failed to deactivate sessions for user %d: %v", userID, err)
+	}
+}
+
+// RefreshTokenHandler handles token refresh with rotation. Each refresh token can be
+// used once; presenting a rotated token again revokes its whole family and session.
 func RefreshTokenHandler(db *database.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req RefreshTokenRequest
@@ -393,27 +508,33 @@ func RefreshTokenHandler(db *database.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Parse and validate refresh token
-		tokenPair, err := services.RefreshTokenPair(req.RefreshToken)
+		// Check if database is available
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		tokenPair, claims, err := services.RotateRefreshToken(db, req.RefreshToken)
 		if err != nil {
+			var reuseErr *services.RefreshTokenReuseError
+			if errors.As(err, &reuseErr) {
+				handleRefreshTokenReuse(c, db, reuseErr)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has already been used. Please log in again."})
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 			return
 		}
 
 		// Verify user still exists and is active
-		claims, err := services.ParseRefreshToken(req.RefreshToken)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		if _, err := db.GetUserByID(claims.UserID); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
 			return
 		}
 
-		if db != nil {
-			_, err = db.GetUserByID(claims.UserID)
-			if err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
-				return
-			}
-		}
+		setAccessTokenCookie(c, tokenPair)
 
 		c.JSON(http.StatusOK, gin.H{
 			"access_token":  tokenPair.AccessToken,
@@ -424,6 +545,29 @@ func RefreshTokenHandler(db *database.DB) gin.HandlerFunc {
 	}
 }
 
+// handleRefreshTokenReuse deactivates the session of a compromised token family
+// and records the incident in the audit log
+func handleRefreshTokenReuse(c *gin.Context, db *database.DB, reuseErr *services.RefreshTokenReuseError) {
+	if reuseErr.SessionID != "" {
+		if err := db.DeactivateSession(reuseErr.SessionID); err != nil {
+			log.Printf("Failed to deactivate session %s after refresh token reuse: %v", reuseErr.SessionID, err)
+		}
+	}
+
+	user, err := db.GetUserByID(reuseErr.UserID)
+	if err != nil {
+		user = &database.User{ID: reuseErr.UserID}
+	}
+
+	log.Printf("Refresh token reuse detected for user %d (family %s)", reuseErr.UserID, reuseErr.FamilyID)
+	recordAuthAudit(c, db, user, "refresh_token_reuse", "failed", "Rotated refresh token was presented again; token family revoked", "high", map[string]interface{}{
+		"family_id":      reuseErr.FamilyID,
+		"token_id":       reuseErr.TokenID,
+		"session_id":     reuseErr.SessionID,
+		"tokens_revoked": reuseErr.Revoked,
+	})
+}
+
 // ForgotPasswordHandler handles password reset requests
 func ForgotPasswordHandler(db *database.DB, emailService *services.EmailService) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -542,6 +686,9 @@ func ResetPasswordHandler(db *database.DB) gin.HandlerFunc {
 			log.Printf("Failed to clear reset token: %v", err)
 		}
 
+		// Invalidate every token and session issued with the old password
+		revokeAllUserSessions(db, user.ID)
+
 		log.Printf("Password reset completed for: %s (ID: %d)", user.Email, user.ID)
 		c.JSON(http.StatusOK, gin.H{"message": "Password reset successful"})
 	}
@@ -654,8 +801,19 @@ func ChangePasswordHandler(db *database.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Invalidate every token and session issued with the old password
+		revokeAllUserSessions(db, user.ID)
+
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+		recordAuthAudit(c, db, user, "password_changed", "success", "Password changed; all sessions revoked", "medium", map[string]interface{}{
+			"ip_address": clientIP,
+		})
+
 		log.Printf("Password changed for: %s (ID: %d)", user.Email, user.ID)
-		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+		c.JSON(http.StatusOK, gin.H{
+			"message":          "Password changed successfully. Please log in again.",
+			"sessions_revoked": true,
+		})
 	}
 }
 
@@ -673,12 +831,20 @@ func LogoutHandler(db *database.DB) gin.HandlerFunc {
 		userEmail, emailExists := c.Get("user_email")
 		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
 
+		// Identify the session before the refresh token is revoked
+		refreshClaims, _ := services.ParseRefreshToken(req.RefreshToken)
+
 		// Blacklist the refresh token
 		if err := services.BlacklistToken(req.RefreshToken); err != nil {
 			log.Printf("Failed to blacklist token: %v", err)
 			// Continue with logout even if blacklisting fails
 		}
 
+		// End the session the refresh token belongs to
+		if db != nil && refreshClaims != nil && refreshClaims.FamilyID != "" {
+			revokeFamilySession(db, refreshClaims.UserID, refreshClaims.FamilyID)
+		}
+
 		// If user is authenticated, log the logout and manage sessions
 		if userExists && emailExists {
 			userIDInt := userID.(int)
@@ -708,6 +874,9 @@ func LogoutHandler(db *database.DB) gin.HandlerFunc {
 
 				// If all_devices is true, deactivate all user's sessions
 				if req.AllDevices {
+					if err := services.RevokeAllUserTokens(userIDInt); err != nil {
+						log.Printf("Failed to revoke all user tokens: %v", err)
+					}
 					if err := db.DeactivateAllUserSessions(userIDInt); err != nil {
 						log.Printf("Failed to deactivate all user sessions: %v", err)
 					} else {
@@ -725,10 +894,6 @@ func LogoutHandler(db *database.DB) gin.HandlerFunc {
 						}
 						db.CreateAuditLog(auditLog)
 					}
-				} else {
-					// Deactivate specific session if we have the session ID
-					// This would require storing session ID in the token or request
-					// For now, we'll just log the single device logout
 				}
 			}
 		} else {
@@ -749,6 +914,9 @@ func LogoutHandler(db *database.DB) gin.HandlerFunc {
 			}
 		}
 
+		// The session's CSRF token dies with it
+		clearSessionCookies(c)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message":                "Logout successful",
 			"all_devices_logged_out": req.AllDevices,
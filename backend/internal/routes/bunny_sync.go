@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartBunnySyncHandler queues a Bunny library sync. With "dry_run", the sync only
+// reports the changes it would make; with "mirror_collections", it also mirrors Bunny
+// collections into series. Only one sync runs at a time; if one is already queued or
+// running, it is returned with 409.
+func StartBunnySyncHandler(db *database.DB, bunnySync *services.BunnySyncService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req struct {
+			DryRun            bool `json:"dry_run"`
+			MirrorCollections bool `json:"mirror_collections"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+		if c.Query("dry_run") == "true" {
+			req.DryRun = true
+		}
+		if c.Query("mirror_collections") == "true" {
+			req.MirrorCollections = true
+		}
+
+		userID := c.GetInt("user_id")
+		job, created, err := bunnySync.Enqueue(req.DryRun, req.MirrorCollections, &userID)
+		if err != nil {
+			log.Printf("Failed to queue Bunny sync: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sync"})
+			return
+		}
+		if !created {
+			c.JSON(http.StatusConflict, gin.H{"error": "A sync is already in progress", "job": bunnySyncJobResponse(job)})
+			return
+		}
+
+		recordAdminAudit(c, db, "bunny_sync_started", "bunny_sync_job", strconv.Itoa(job.ID), "Bunny library sync queued", map[string]interface{}{
+			"dry_run":            job.DryRun,
+			"mirror_collections": job.MirrorCollections,
+		})
+
+		c.Header("Location", "/api/v1/admin/bunny-sync/jobs/"+strconv.Itoa(job.ID))
+		c.JSON(http.StatusAccepted, gin.H{"message": "Sync queued", "job": bunnySyncJobResponse(job)})
+	}
+}
+
+// ListBunnySyncJobsHandler lists recent Bunny library syncs
+func ListBunnySyncJobsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+		if offset < 0 {
+			offset = 0
+		}
+
+		jobs, err := db.GetBunnySyncJobs(limit, offset)
+		if err != nil {
+			log.Printf("Failed to list Bunny sync jobs: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sync jobs"})
+			return
+		}
+
+		response := make([]gin.H, 0, len(jobs))
+		for _, job := range jobs {
+			summary := bunnySyncJobResponse(job)
+			delete(summary, "changes")
+			response = append(response, summary)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"jobs": response, "limit": limit, "offset": offset})
+	}
+}
+
+// GetBunnySyncJobHandler reports a sync's progress and, once finished, its changes
+func GetBunnySyncJobHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || jobID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		job, err := db.GetBunnySyncJob(jobID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sync job not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load Bunny sync job %d: %v", jobID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sync job"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"job": bunnySyncJobResponse(job)})
+	}
+}
+
+// bunnySyncJobResponse builds the admin view of a sync job
+func bunnySyncJobResponse(job *database.BunnySyncJob) gin.H {
+	progress := 0
+	if job.Status == "completed" {
+		progress = 100
+	} else if job.TotalItems > 0 {
+		progress = job.ProcessedItems * 100 / job.TotalItems
+	}
+
+	response := gin.H{
+		"id":                 job.ID,
+		"status":             job.Status,
+		"dry_run":            job.DryRun,
+		"mirror_collections": job.MirrorCollections,
+		"phase":              job.Phase,
+		"progress":           progress,
+		"total_items":        job.TotalItems,
+		"processed_items":    job.ProcessedItems,
+		"created":            job.Created,
+		"updated":            job.Updated,
+		"deleted":            job.Deleted,
+		"unchanged":          job.Unchanged,
+		"failed":             job.Failed,
+		"changes":            job.Changes,
+		"attempts":           job.Attempts,
+		"created_at":         job.CreatedAt,
+		"updated_at":         job.UpdatedAt,
+	}
+	if job.RequestedBy.Valid {
+		response["requested_by"] = job.RequestedBy.Int64
+	}
+	if job.Status == "queued" && job.Attempts > 0 {
+		response["next_attempt_at"] = job.NextAttemptAt
+	}
+	if job.StartedAt.Valid {
+		response["started_at"] = job.StartedAt.Time
+	}
+	if job.FinishedAt.Valid {
+		response["finished_at"] = job.FinishedAt.Time
+	}
+	if job.LastError != "" {
+		response["last_error"] = job.LastError
+	}
+	return response
+}
@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bunnyWebhookSignatureHeader carries the HMAC-SHA256 of the webhook body
+const bunnyWebhookSignatureHeader = "X-BunnyStream-Signature"
+
+// maxBunnyWebhookSize bounds the webhook body read before verifying its signature
+const maxBunnyWebhookSize = 64 << 10
+
+// BunnyWebhookHandler verifies, stores and applies Bunny Stream video status webhooks.
+// Deliveries that were already processed are acknowledged without being applied again.
+func BunnyWebhookHandler(db *database.DB, bunnyService *services.BunnyService, webhooks *services.BunnyWebhookProcessor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil || !bunnyService.WebhooksConfigured() {
+			serviceUnavailable(c)
+			return
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBunnyWebhookSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook body"})
+			return
+		}
+
+		if !bunnyService.ValidateWebhookSignature(payload, c.GetHeader(bunnyWebhookSignatureHeader)) {
+			log.Printf("Rejected Bunny webhook with invalid signature from %s", c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			return
+		}
+
+		event, duplicate, err := webhooks.Receive(payload)
+		if err == services.ErrInvalidBunnyWebhook {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to process Bunny webhook: %v", err)
+			if event == nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store webhook"})
+				return
+			}
+			// The event is stored, so Bunny retries and admin replays can apply it later
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook", "event_id": event.ID})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"event_id":  event.ID,
+			"duplicate": duplicate,
+		})
+	}
+}
+
+// ListBunnyWebhookEventsHandler lists received Bunny webhooks, optionally only those
+// that still need processing
+func ListBunnyWebhookEventsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		unprocessed := c.Query("unprocessed") == "true"
+
+		events, err := db.GetBunnyWebhookEvents(unprocessed, limit, offset)
+		if err != nil {
+			log.Printf("Failed to list Bunny webhook events: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhook events"})
+			return
+		}
+
+		response := make([]gin.H, 0, len(events))
+		for _, event := range events {
+			response = append(response, bunnyWebhookEventResponse(event))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"events": response, "limit": limit, "offset": offset})
+	}
+}
+
+// ReplayBunnyWebhookEventHandler applies a stored Bunny webhook again
+func ReplayBunnyWebhookEventHandler(db *database.DB, webhooks *services.BunnyWebhookProcessor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || eventID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		event, err := webhooks.Replay(eventID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+			return
+		}
+		if event == nil {
+			log.Printf("Failed to replay Bunny webhook %d: %v", eventID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay webhook event"})
+			return
+		}
+
+		recordAdminAudit(c, db, "bunny_webhook_replayed", "bunny_webhook_event", strconv.Itoa(eventID), "Bunny webhook replayed", map[string]interface{}{
+			"video_guid": event.VideoGUID,
+			"succeeded":  err == nil,
+		})
+
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Webhook event could not be applied",
+				"event": bunnyWebhookEventResponse(event),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook event replayed", "event": bunnyWebhookEventResponse(event)})
+	}
+}
+
+// bunnyWebhookEventResponse builds the admin view of a stored webhook
+func bunnyWebhookEventResponse(event *database.BunnyWebhookEvent) gin.H {
+	response := gin.H{
+		"id":          event.ID,
+		"video_guid":  event.VideoGUID,
+		"status_code": event.StatusCode,
+		"payload":     event.Payload,
+		"attempts":    event.Attempts,
+		"received_at": event.ReceivedAt,
+		"processed":   event.ProcessedAt.Valid,
+	}
+	if event.ProcessedAt.Valid {
+		response["processed_at"] = event.ProcessedAt.Time
+	}
+	if event.LastError != "" {
+		response["last_error"] = event.LastError
+	}
+	return response
+}
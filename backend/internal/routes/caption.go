@@ -0,0 +1,355 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readCaptionFile reads and parses the caption file uploaded as "file", writing the
+// error response if it is missing or invalid. Parse errors are returned with their
+// line numbers so they can be fixed in the original file.
+func readCaptionFile(c *gin.Context) (*services.CaptionTrack, bool) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An SRT or WebVTT file is required"})
+		return nil, false
+	}
+	if file.Size > services.MaxCaptionFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Caption files must be 2MB or smaller"})
+		return nil, false
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read caption file"})
+		return nil, false
+	}
+	defer src.Close()
+
+	content, err := io.ReadAll(io.LimitReader(src, services.MaxCaptionFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read caption file"})
+		return nil, false
+	}
+	if len(content) > services.MaxCaptionFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Caption files must be 2MB or smaller"})
+		return nil, false
+	}
+
+	track, err := services.ParseCaptions(content)
+	var parseErr *services.CaptionParseError
+	if errors.As(err, &parseErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Caption file is invalid", "errors": parseErr.Errors})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read caption file"})
+		return nil, false
+	}
+	return track, true
+}
+
+func captionResponse(caption *database.VideoCaption) gin.H {
+	response := gin.H{
+		"id":              caption.ID,
+		"video_id":        caption.VideoID,
+		"language":        caption.Language,
+		"label":           caption.Label,
+		"kind":            caption.Kind,
+		"source_format":   caption.SourceFormat,
+		"url":             caption.URL,
+		"cue_count":       caption.CueCount,
+		"default":         caption.IsDefault,
+		"bunny_synced_at": nil,
+		"created_at":      caption.CreatedAt,
+		"updated_at":      caption.UpdatedAt,
+	}
+	if caption.BunnySyncedAt.Valid {
+		response["bunny_synced_at"] = caption.BunnySyncedAt.Time
+	}
+	return response
+}
+
+// ValidateCaptionsHandler checks an SRT or WebVTT file without saving it and returns
+// the normalized WebVTT it would be stored as
+func ValidateCaptionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		track, ok := readCaptionFile(c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":            true,
+			"source_format":    track.Format,
+			"cue_count":        len(track.Cues),
+			"duration_seconds": track.Duration().Seconds(),
+			"webvtt":           string(track.WebVTT()),
+		})
+	}
+}
+
+// ListVideoCaptionsHandler lists a video's caption tracks for admins, including whether
+// each was sent to Bunny Stream
+func ListVideoCaptionsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+
+		captions, err := db.GetVideoCaptions(videoID)
+		if err != nil {
+			log.Printf("Failed to list captions for video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captions"})
+			return
+		}
+
+		response := make([]gin.H, 0, len(captions))
+		for _, caption := range captions {
+			response = append(response, captionResponse(caption))
+		}
+		c.JSON(http.StatusOK, gin.H{"captions": response})
+	}
+}
+
+// UploadVideoCaptionHandler adds or replaces a video's caption track in a language.
+// The uploaded SRT or WebVTT file is validated, normalized to WebVTT and stored in
+// Spaces. With push_to_bunny, or if the replaced track had been, the track is also
+// sent to Bunny Stream; a failed push is reported but does not fail the upload.
+func UploadVideoCaptionHandler(db *database.DB, spacesService *services.SpacesService, bunnyService *services.BunnyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil || spacesService == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+		language, ok := services.NormalizeCaptionLanguage(c.Param("language"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Language must be a language tag such as en or pt-BR"})
+			return
+		}
+		kind := c.DefaultPostForm("kind", services.CaptionKindSubtitles)
+		if kind != services.CaptionKindSubtitles && kind != services.CaptionKindCaptions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be subtitles or captions"})
+			return
+		}
+		label := strings.TrimSpace(c.PostForm("label"))
+		if label == "" {
+			label = language
+		}
+		if len(label) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Label must be 100 characters or fewer"})
+			return
+		}
+
+		video, err := db.GetVideoByID(videoID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload captions"})
+			return
+		}
+
+		track, ok := readCaptionFile(c)
+		if !ok {
+			return
+		}
+		content := track.WebVTT()
+
+		key := fmt.Sprintf("captions/%d/%s-%d.vtt", videoID, language, time.Now().Unix())
+		result, err := spacesService.UploadPublicObject(key, content, "text/vtt; charset=utf-8")
+		if err != nil {
+			log.Printf("Failed to upload captions for video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload captions"})
+			return
+		}
+
+		userID := c.GetInt("user_id")
+		caption := &database.VideoCaption{
+			VideoID:      videoID,
+			Language:     language,
+			Label:        label,
+			Kind:         kind,
+			SourceFormat: track.Format,
+			StorageKey:   key,
+			URL:          result.URL,
+			CueCount:     len(track.Cues),
+			IsDefault:    c.PostForm("default") == "true",
+			CreatedBy:    sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		}
+		if strings.HasPrefix(result.CDNURL, "http") {
+			caption.URL = result.CDNURL
+		}
+
+		previous, err := db.SaveVideoCaption(caption, track.Text())
+		if err != nil {
+			log.Printf("Failed to save captions for video %d: %v", videoID, err)
+			if err := spacesService.DeleteFile(key); err != nil {
+				log.Printf("Failed to remove unsaved caption file %s: %v", key, err)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save captions"})
+			return
+		}
+		if previous != nil && previous.StorageKey != key {
+			if err := spacesService.DeleteFile(previous.StorageKey); err != nil {
+				log.Printf("Failed to remove replaced caption file %s: %v", previous.StorageKey, err)
+			}
+		}
+
+		response := gin.H{}
+		if c.PostForm("push_to_bunny") == "true" || (previous != nil && previous.BunnySyncedAt.Valid) {
+			if bunnyErr := pushCaptionToBunny(db, bunnyService, video, caption, content); bunnyErr != "" {
+				response["bunny_error"] = bunnyErr
+			}
+		}
+
+		recordAdminAudit(c, db, "video_caption_uploaded", "video", strconv.Itoa(videoID), "Caption track uploaded", map[string]interface{}{
+			"language":      language,
+			"kind":          kind,
+			"source_format": track.Format,
+			"cue_count":     len(track.Cues),
+			"replaced":      previous != nil,
+			"bunny_synced":  caption.BunnySyncedAt.Valid,
+		})
+
+		response["caption"] = captionResponse(caption)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// pushCaptionToBunny sends a caption track to the video in Bunny Stream, returning why
+// it could not be sent if it failed
+func pushCaptionToBunny(db *database.DB, bunnyService *services.BunnyService, video *database.Video, caption *database.VideoCaption, content []byte) string {
+	if video.BunnyVideoID == "" {
+		return "Video is not in Bunny Stream"
+	}
+	if err := bunnyService.UploadCaption(video.BunnyVideoID, caption.Language, caption.Label, content); err != nil {
+		log.Printf("Failed to send %s captions for video %d to Bunny Stream: %v", caption.Language, video.ID, err)
+		return "Failed to send captions to Bunny Stream"
+	}
+	if err := db.MarkVideoCaptionBunnySynced(caption.ID); err != nil {
+		log.Printf("Failed to record Bunny caption sync for video %d: %v", video.ID, err)
+		return ""
+	}
+	caption.BunnySyncedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return ""
+}
+
+// DeleteVideoCaptionHandler removes a video's caption track in a language, along with
+// its file and, if it was sent there, the track in Bunny Stream
+func DeleteVideoCaptionHandler(db *database.DB, spacesService *services.SpacesService, bunnyService *services.BunnyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+		language, ok := services.NormalizeCaptionLanguage(c.Param("language"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Language must be a language tag such as en or pt-BR"})
+			return
+		}
+
+		caption, err := db.DeleteVideoCaption(videoID, language)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Caption track not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete %s captions for video %d: %v", language, videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete captions"})
+			return
+		}
+
+		if spacesService != nil {
+			if err := spacesService.DeleteFile(caption.StorageKey); err != nil {
+				log.Printf("Failed to remove caption file %s: %v", caption.StorageKey, err)
+			}
+		}
+		if caption.BunnySyncedAt.Valid {
+			if video, err := db.GetVideoByID(videoID); err == nil && video.BunnyVideoID != "" {
+				if err := bunnyService.DeleteCaption(video.BunnyVideoID, language); err != nil {
+					log.Printf("Failed to remove %s captions for video %d from Bunny Stream: %v", language, videoID, err)
+				}
+			}
+		}
+
+		recordAdminAudit(c, db, "video_caption_deleted", "video", strconv.Itoa(videoID), "Caption track deleted", map[string]interface{}{
+			"language": language,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Caption track deleted"})
+	}
+}
+
+// playableVideoCaptions returns a video's caption tracks if it is ready and the
+// caller's tier can play it. It returns sql.ErrNoRows for any other video.
+func playableVideoCaptions(c *gin.Context, db *database.DB, videoID int) ([]*database.VideoCaption, error) {
+	userID := c.GetInt("user_id")
+	tier, err := services.SubscriptionTier(db, userID, c.GetString("user_role"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve subscription tier for user %d: %w", userID, err)
+	}
+	return db.GetPlayableVideoCaptions(videoID, services.PlayableTiers(tier))
+}
+
+// GetVideoCaptionsHandler lists the caption tracks of a ready video the viewer's tier can play
+func GetVideoCaptionsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+
+		captions, err := playableVideoCaptions(c, db, videoID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to list captions for video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"captions": captions})
+	}
+}
@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// issueCSRFToken creates a CSRF token bound to a session and sets it as the CSRF cookie
+// the SPA echoes back in the X-CSRF-Token header
+func issueCSRFToken(c *gin.Context, familyID, tokenID string) (string, error) {
+	token, err := services.GenerateCSRFToken(services.CSRFBinding(familyID, tokenID))
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(c.Writer, services.SessionCookie(services.CSRFCookie, token, 0, false))
+	return token, nil
+}
+
+// setAccessTokenCookie stores the access token in an HttpOnly cookie when cookie authentication is enabled
+func setAccessTokenCookie(c *gin.Context, tokenPair *services.TokenPair) {
+	if !services.AuthCookiesEnabled() {
+		return
+	}
+	http.SetCookie(c.Writer, services.SessionCookie(services.AccessTokenCookie, tokenPair.AccessToken, int(tokenPair.ExpiresIn), true))
+}
+
+// clearSessionCookies removes the access token and CSRF cookies
+func clearSessionCookies(c *gin.Context) {
+	http.SetCookie(c.Writer, services.SessionCookie(services.AccessTokenCookie, "", -1, true))
+	http.SetCookie(c.Writer, services.SessionCookie(services.CSRFCookie, "", -1, false))
+}
+
+// CSRFTokenHandler issues a fresh CSRF token for the authenticated session
+func CSRFTokenHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		token, err := issueCSRFToken(c, c.GetString("session_family_id"), c.GetString("token_id"))
+		if err != nil {
+			log.Printf("Failed to generate CSRF token for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"csrf_token": token,
+			"header":     services.CSRFHeader,
+		})
+	}
+}
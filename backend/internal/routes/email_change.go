@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailChangeRequest asks to move the caller's account to a new email address
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" binding:"required"`
+	Password string `json:"password"`
+}
+
+// ConfirmEmailChangeRequest confirms the new address with the emailed token
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestEmailChangeHandler stores a pending email change, sends a confirmation link to
+// the new address and tells the current address about it. Users with a password must
+// confirm it.
+func RequestEmailChangeHandler(db *database.DB, emailService *services.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req EmailChangeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		req.NewEmail = strings.ToLower(services.SanitizeString(req.NewEmail))
+		if err := services.ValidateEmail(req.NewEmail); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if db == nil || emailService == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if user.PasswordHash != "" {
+			if err := services.CheckPassword(user.PasswordHash, req.Password); err != nil {
+				recordAuthAudit(c, db, user, "email_change_requested", "failed", "Incorrect password", "medium", nil)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+				return
+			}
+		}
+
+		if strings.EqualFold(req.NewEmail, user.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The new email address is the same as your current one"})
+			return
+		}
+
+		exists, err := db.CheckUserExists(req.NewEmail)
+		if err != nil {
+			log.Printf("Failed to check user existence for email change: %v", err)
+			serviceUnavailable(c)
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+
+		token, tokenID, expiresAt, err := services.GenerateEmailChangeToken(user.ID, req.NewEmail, user.Role)
+		if err != nil {
+			log.Printf("Failed to generate email change token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request email change"})
+			return
+		}
+
+		pending, err := db.CreateEmailChangeRequest(user.ID, req.NewEmail, tokenID, clientIP, expiresAt)
+		if err != nil {
+			log.Printf("Failed to record email change for user %d: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request email change"})
+			return
+		}
+
+		if err := emailService.SendEmailChangeVerification(user.FirstName, req.NewEmail, token, expiresAt); err != nil {
+			log.Printf("Failed to send email change verification to user %d: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+			return
+		}
+		if err := emailService.SendEmailChangeNotice(user.FirstName, user.Email, req.NewEmail); err != nil {
+			log.Printf("Failed to send email change notice to user %d: %v", user.ID, err)
+		}
+
+		recordAuthAudit(c, db, user, "email_change_requested", "success", "Email change requested", "medium", map[string]interface{}{
+			"new_email":  req.NewEmail,
+			"expires_at": expiresAt,
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "We sent a confirmation link to your new email address. Your email will change once you confirm it.",
+			"pending": pending,
+		})
+	}
+}
+
+// GetEmailChangeHandler returns the caller's pending email change, if any
+func GetEmailChangeHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		pending, err := db.GetEmailChangeRequest(userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, gin.H{"pending": false})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get email change for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"pending": true, "request": pending})
+	}
+}
+
+// CancelEmailChangeHandler cancels the caller's pending email change
+func CancelEmailChangeHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		if err := db.CancelEmailChangeRequest(userID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "No email change is pending"})
+				return
+			}
+			log.Printf("Failed to cancel email change for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel email change"})
+			return
+		}
+
+		recordPrivacyAudit(c, db, "email_change_cancelled", "Pending email change cancelled", "low", nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled"})
+	}
+}
+
+// ConfirmEmailChangeHandler applies a pending email change once the user follows the
+// link sent to the new address. Every session is revoked because tokens carry the old
+// address, and the current one is replaced with a fresh session. The Stripe customer
+// is updated so receipts go to the new address.
+func ConfirmEmailChangeHandler(db *database.DB, stripeService *services.StripeService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req ConfirmEmailChangeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		claims, err := services.ParseEmailChangeToken(req.Token)
+		if err != nil || claims.UserID != userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+			return
+		}
+
+		oldEmail, err := db.ConfirmEmailChange(userID, claims.TokenID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This confirmation link has expired or been replaced by a newer one"})
+			return
+		}
+		if err == database.ErrEmailTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to confirm email change for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email address"})
+			return
+		}
+		services.RevokeClaims(claims)
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			log.Printf("Failed to reload user %d after email change: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		// Tokens issued so far carry the old address
+		revokeAllUserSessions(db, user.ID)
+
+		if stripeService != nil && user.StripeCustomerID.Valid && user.StripeCustomerID.String != "" {
+			if err := stripeService.UpdateCustomerEmail(user.StripeCustomerID.String, user.Email); err != nil {
+				log.Printf("Failed to update Stripe customer email for user %d: %v", user.ID, err)
+			}
+		}
+
+		recordAuthAudit(c, db, user, "email_changed", "success", "Email address changed; all sessions revoked", "high", map[string]interface{}{
+			"old_email": oldEmail,
+			"new_email": user.Email,
+		})
+
+		completeLogin(c, db, user, clientIP, "email_change", gin.H{
+			"message":          "Your email address has been changed",
+			"sessions_revoked": true,
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/middleware"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartImpersonationRequest explains why a staff member needs to act as a user
+type StartImpersonationRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// setupImpersonationAudit records every write made with an impersonation token
+func setupImpersonationAudit(db *database.DB) {
+	if db == nil {
+		return
+	}
+
+	middleware.SetImpersonationAuditor(func(c *gin.Context, blocked bool) {
+		status, severity := "success", "medium"
+		if blocked {
+			status, severity = "failed", "high"
+		} else if c.Writer.Status() >= http.StatusBadRequest {
+			status = "failed"
+		}
+
+		details := fmt.Sprintf("%s %s while impersonating %s", c.Request.Method, c.Request.URL.Path, c.GetString("user_email"))
+		recordImpersonationAudit(c, db, "impersonated_request", status, details, severity, map[string]interface{}{
+			"method":          c.Request.Method,
+			"path":            c.Request.URL.Path,
+			"response_status": c.Writer.Status(),
+			"blocked":         blocked,
+		})
+	})
+}
+
+// recordImpersonationAudit writes an audit entry for a request made with an impersonation
+// token. The entry is attributed to the staff member, with the impersonated user as resource.
+func recordImpersonationAudit(c *gin.Context, db *database.DB, action, status, details, severity string, metadata map[string]interface{}) {
+	actorID := c.GetInt("impersonator_id")
+	actorEmail := c.GetString("impersonator_email")
+	targetID := strconv.Itoa(c.GetInt("user_id"))
+	metadata["token_id"] = c.GetString("token_id")
+
+	auditLog := &database.AuditLog{
+		UserID:     &actorID,
+		UserEmail:  &actorEmail,
+		Action:     action,
+		Resource:   "user",
+		ResourceID: &targetID,
+		IPAddress:  services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Status:     status,
+		Details:    &details,
+		Severity:   severity,
+		Metadata:   metadata,
+	}
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
+
+// impersonationInfo describes an impersonation for the frontend, which shows a banner while it is active
+func impersonationInfo(actorID int, actorEmail string, userID int, userEmail string) gin.H {
+	return gin.H{
+		"active":       true,
+		"show_banner":  true,
+		"actor_id":     actorID,
+		"actor_email":  actorEmail,
+		"user_id":      userID,
+		"user_email":   userEmail,
+		"read_only_on": "billing and payments",
+	}
+}
+
+// impersonationBanner returns the banner details to show while a staff member is
+// impersonating the user, or nil for a normal session
+func impersonationBanner(c *gin.Context) gin.H {
+	if c.GetString("auth_method") != "impersonation" {
+		return nil
+	}
+	return impersonationInfo(c.GetInt("impersonator_id"), c.GetString("impersonator_email"), c.GetInt("user_id"), c.GetString("user_email"))
+}
+
+// StartImpersonationHandler issues a short-lived token that lets a support staff member
+// see the application as the given user. Users holding any permission the caller lacks
+// cannot be impersonated.
+func StartImpersonationHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID := c.GetInt("user_id")
+		targetID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req StartImpersonationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason for impersonating the user is required"})
+			return
+		}
+		req.Reason = strings.TrimSpace(services.SanitizeString(req.Reason))
+		if req.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason for impersonating the user is required"})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		if targetID == actorID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot impersonate yourself"})
+			return
+		}
+
+		target, err := db.GetUserByID(targetID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load user %d for impersonation: %v", targetID, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		targetPermissions, err := db.GetUserPermissions(target.ID, canonicalRoleID(target.Role))
+		if err != nil {
+			log.Printf("Failed to resolve permissions for user %d: %v", target.ID, err)
+			serviceUnavailable(c)
+			return
+		}
+		if missing := callerMissingPermissions(c, targetPermissions); len(missing) > 0 {
+			recordAdminAudit(c, db, "impersonation_denied", "user", strconv.Itoa(target.ID), "Impersonation of a more privileged user refused", map[string]interface{}{
+				"reason":              req.Reason,
+				"missing_permissions": missing,
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot impersonate a user with permissions you do not hold"})
+			return
+		}
+
+		token, tokenID, expiresAt, err := services.GenerateImpersonationToken(actorID, c.GetString("user_email"), target.ID, target.Email, target.Role, target.EmailVerified)
+		if err != nil {
+			log.Printf("Failed to generate impersonation token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+			return
+		}
+
+		recordAdminAudit(c, db, "impersonation_started", "user", strconv.Itoa(target.ID), "Impersonation started", map[string]interface{}{
+			"reason":      req.Reason,
+			"token_id":    tokenID,
+			"target":      target.Email,
+			"expires_at":  expiresAt,
+			"target_role": target.Role,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"access_token":  token,
+			"token_type":    "Bearer",
+			"expires_in":    int64(time.Until(expiresAt).Seconds()),
+			"expires_at":    expiresAt,
+			"impersonation": impersonationInfo(actorID, c.GetString("user_email"), target.ID, target.Email),
+		})
+	}
+}
+
+// StopImpersonationHandler ends impersonation by revoking the impersonation token
+// used to call it
+func StopImpersonationHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != "impersonation" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You are not impersonating a user"})
+			return
+		}
+
+		tokenID := c.GetString("token_id")
+		if err := services.RevokeTokenID(tokenID, time.Now().Add(services.MaxImpersonationLifetime)); err != nil {
+			log.Printf("Failed to revoke impersonation token %s: %v", tokenID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop impersonation"})
+			return
+		}
+
+		if db != nil {
+			recordImpersonationAudit(c, db, "impersonation_stopped", "success", "Impersonation stopped", "high", map[string]interface{}{})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended", "impersonation": gin.H{"active": false}})
+	}
+}
+
+// ImpersonationStatusHandler tells the frontend whether to show the impersonation banner
+func ImpersonationStatusHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if banner := impersonationBanner(c); banner != nil {
+			c.JSON(http.StatusOK, gin.H{"impersonation": banner})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"impersonation": gin.H{"active": false}})
+	}
+}
@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateInvitationRequest invites an email address to create an account with a role
+type CreateInvitationRequest struct {
+	Email  string `json:"email" binding:"required"`
+	RoleID string `json:"roleId" binding:"required"`
+}
+
+// AcceptInvitationRequest sets up the account for an invitation
+type AcceptInvitationRequest struct {
+	Token     string `json:"token" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name" binding:"required"`
+	LastName  string `json:"last_name" binding:"required"`
+}
+
+// CreateInvitationHandler emails a signed invitation for an address to create an account
+// with a pre-assigned role. The caller must hold every permission of the role.
+func CreateInvitationHandler(db *database.DB, emailService *services.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		req.Email = strings.ToLower(services.SanitizeString(req.Email))
+		if err := services.ValidateEmail(req.Email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if db == nil || emailService == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		exists, err := db.CheckUserExists(req.Email)
+		if err != nil {
+			log.Printf("Failed to check user existence for invitation: %v", err)
+			serviceUnavailable(c)
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+
+		role, err := db.GetRole(req.RoleID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role %s: %v", req.RoleID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+			return
+		}
+		if missing := callerMissingPermissions(c, role.Permissions); len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":               "You cannot invite users to a role with permissions you do not hold",
+				"missing_permissions": missing,
+			})
+			return
+		}
+
+		token, tokenID, expiresAt, err := services.GenerateInvitationToken(req.Email, role.ID)
+		if err != nil {
+			log.Printf("Failed to generate invitation token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+			return
+		}
+
+		invitation, err := db.CreateInvitation(req.Email, role.ID, tokenID, c.GetInt("user_id"), expiresAt)
+		if err != nil {
+			log.Printf("Failed to record invitation for %s: %v", req.Email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+			return
+		}
+
+		inviterName := c.GetString("user_email")
+		if inviter, err := db.GetUserByID(c.GetInt("user_id")); err == nil {
+			if name := strings.TrimSpace(inviter.FirstName + " " + inviter.LastName); name != "" {
+				inviterName = name
+			}
+		}
+		if err := emailService.SendInvitationEmail(inviterName, req.Email, role.Name, token, expiresAt); err != nil {
+			log.Printf("Failed to send invitation email to %s: %v", req.Email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation email"})
+			return
+		}
+
+		recordAdminAudit(c, db, "user_invited", "invitation", strconv.Itoa(invitation.ID), "Invitation sent", map[string]interface{}{
+			"email":      req.Email,
+			"role_id":    role.ID,
+			"expires_at": expiresAt,
+		})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":    "Invitation sent",
+			"invitation": invitation,
+		})
+	}
+}
+
+// ListInvitationsHandler lists invitations that are still waiting to be accepted
+func ListInvitationsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		invitations, err := db.GetPendingInvitations()
+		if err != nil {
+			log.Printf("Failed to list invitations: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+			return
+		}
+		if invitations == nil {
+			invitations = []*database.UserInvitation{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+	}
+}
+
+// RevokeInvitationHandler revokes a pending invitation so its link no longer works. As
+// when inviting, the caller must hold every permission of the invited role.
+func RevokeInvitationHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || invitationID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		invitation, err := db.GetInvitation(invitationID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pending invitation not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get invitation %d: %v", invitationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+
+		// A role deleted since the invitation was sent grants nothing, so anyone may revoke it
+		role, err := db.GetRole(invitation.Role)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to get role %s: %v", invitation.Role, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+		if err == nil {
+			if missing := callerMissingPermissions(c, role.Permissions); len(missing) > 0 {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":               "You cannot revoke invitations to a role with permissions you do not hold",
+					"missing_permissions": missing,
+				})
+				return
+			}
+		}
+
+		invitation, err = db.RevokeInvitation(invitationID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pending invitation not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to revoke invitation %d: %v", invitationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+
+		recordAdminAudit(c, db, "invitation_revoked", "invitation", strconv.Itoa(invitation.ID), "Invitation revoked", map[string]interface{}{
+			"email":   invitation.Email,
+			"role_id": invitation.Role,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	}
+}
+
+// AcceptInvitationHandler creates the invited account with its pre-assigned role,
+// sets the password and verifies the email in one step, then signs the user in
+func AcceptInvitationHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req AcceptInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		req.FirstName = services.SanitizeString(req.FirstName)
+		req.LastName = services.SanitizeString(req.LastName)
+		if err := services.ValidatePassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := services.ValidateName(req.FirstName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid first name: " + err.Error()})
+			return
+		}
+		if err := services.ValidateName(req.LastName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last name: " + err.Error()})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		claims, err := services.ParseInvitationToken(req.Token)
+		if err != nil {
+			recordAuthAudit(c, db, nil, "invitation_accepted", "failed", "Invalid or expired invitation", "medium", nil)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired invitation"})
+			return
+		}
+
+		exists, err := db.CheckUserExists(claims.Email)
+		if err != nil {
+			log.Printf("Failed to check user existence for invitation: %v", err)
+			serviceUnavailable(c)
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+
+		passwordHash, err := services.HashPassword(req.Password)
+		if err != nil {
+			log.Printf("Failed to hash password: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+			return
+		}
+
+		user, err := db.AcceptInvitation(claims.TokenID, passwordHash, req.FirstName, req.LastName)
+		if err == sql.ErrNoRows {
+			recordAuthAudit(c, db, nil, "invitation_accepted", "failed", "Invitation revoked, expired or already accepted", "medium", map[string]interface{}{
+				"email":    claims.Email,
+				"token_id": claims.TokenID,
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This invitation has been revoked, has expired or has already been used"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to accept invitation for %s: %v", claims.Email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+			return
+		}
+		services.RevokeClaims(claims)
+
+		recordAuthAudit(c, db, user, "invitation_accepted", "success", "Account created from invitation", "medium", map[string]interface{}{
+			"role":     user.Role,
+			"token_id": claims.TokenID,
+		})
+		log.Printf("Invited user created: %s (ID: %d, role: %s)", user.Email, user.ID, user.Role)
+
+		if challengeSecondFactor(c, db, user, "Invitation accepted") {
+			return
+		}
+
+		completeLogin(c, db, user, clientIP, "invitation", nil)
+	}
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler serves the public keys that verify issued tokens
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := services.PublicJWKS()
+		if err != nil {
+			log.Printf("Failed to load signing keys: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		// Scheduled rotations publish the next key for longer than this before it signs
+		// anything. After an immediate rotation verifiers must refetch on an unknown kid.
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(services.JWKSCacheMaxAge.Seconds())))
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+// RotateSigningKeyHandler rotates the JWT signing key immediately, e.g. after a suspected key compromise.
+// Tokens signed with the previous key stay valid until they expire. The new key is not
+// published in advance, so verifiers that cache the JWKS must refetch it on an unknown kid.
+func RotateSigningKeyHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, err := services.RotateSigningKey()
+		if err != nil {
+			log.Printf("Failed to rotate signing key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key"})
+			return
+		}
+
+		if db != nil {
+			recordAdminAudit(c, db, "signing_key_rotated", "signing_key", key.ID, "JWT signing key rotated", map[string]interface{}{
+				"algorithm": key.Algorithm,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Signing key rotated successfully",
+			"kid":       key.ID,
+			"algorithm": key.Algorithm,
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginAlertRevokeRequest carries the token from a "this wasn't me" link
+type LoginAlertRevokeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// loginAlertEmailService sends new-device sign-in alerts; nil disables them
+var loginAlertEmailService *services.EmailService
+
+// setupLoginProtection registers the new-device alert mailer and records IP blocks in the audit log
+func setupLoginProtection(db *database.DB, emailService *services.EmailService) {
+	loginAlertEmailService = emailService
+
+	services.SetIPBlockListener(func(ip string, accounts int, until time.Time) {
+		if db == nil {
+			return
+		}
+		details := fmt.Sprintf("Failed sign-ins against %d accounts; IP blocked", accounts)
+		auditLog := &database.AuditLog{
+			Action:    "ip_blocked",
+			Resource:  "authentication",
+			IPAddress: ip,
+			Status:    "warning",
+			Details:   &details,
+			Severity:  "high",
+			Metadata: map[string]interface{}{
+				"accounts":      accounts,
+				"blocked_until": until,
+			},
+		}
+		if err := db.CreateAuditLog(auditLog); err != nil {
+			log.Printf("Failed to write audit log for IP block of %s: %v", ip, err)
+		}
+	})
+}
+
+// checkLoginAllowed rejects a sign-in attempt while the account is locked out or the
+// client IP is blocked. It returns false if a response has already been written.
+func checkLoginAllowed(c *gin.Context, email, clientIP string) bool {
+	if services.EnhancedLoginRateLimiter.CheckLoginAttempt(email, clientIP) {
+		return true
+	}
+
+	if remainingTime := services.RemainingIPBlockTime(clientIP); remainingTime > 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":             "Too many failed sign-in attempts from your network. Please try again later.",
+			"lockout_remaining": remainingTime.String(),
+		})
+		return false
+	}
+
+	remainingTime := services.EnhancedLoginRateLimiter.GetRemainingLockoutTime(email)
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":             fmt.Sprintf("Account temporarily locked. Please try again in %v", remainingTime),
+		"lockout_remaining": remainingTime.String(),
+	})
+	return false
+}
+
+// checkLoginDevice records the device a user signed in from and, when the user has signed
+// in before but never from this device, emails them an alert with a "this wasn't me" link
+func checkLoginDevice(c *gin.Context, db *database.DB, user *database.User, deviceInfo, clientIP string) {
+	userAgent := c.GetHeader("User-Agent")
+	isNew, hadDevices, err := db.RecordUserDevice(user.ID, deviceInfo, clientIP, userAgent)
+	if err != nil {
+		log.Printf("Failed to record device for user %d: %v", user.ID, err)
+		return
+	}
+	if !isNew || !hadDevices {
+		return
+	}
+
+	recordAuthAudit(c, db, user, "new_device_login", "warning", "Sign-in from a new device", "medium", map[string]interface{}{
+		"device_info": deviceInfo,
+	})
+
+	if loginAlertEmailService == nil {
+		return
+	}
+	revokeToken, err := services.GenerateLoginAlertToken(user.ID, user.Email, user.Role, user.EmailVerified)
+	if err != nil {
+		log.Printf("Failed to generate login alert token: %v", err)
+		return
+	}
+
+	// Send in the background so the alert does not slow down the login
+	name, email, loginAt := user.FirstName, user.Email, time.Now()
+	go func() {
+		if err := loginAlertEmailService.SendNewDeviceLoginAlert(name, email, clientIP, userAgent, loginAt, revokeToken); err != nil {
+			log.Printf("Failed to send new device alert to %s: %v", email, err)
+		}
+	}()
+}
+
+// LoginAlertRevokeHandler handles the "this wasn't me" link from a new-device alert by
+// revoking every session and token the user has
+func LoginAlertRevokeHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LoginAlertRevokeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		claims, err := services.ParseLoginAlertToken(req.Token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This link is invalid, has expired or has already been used"})
+			return
+		}
+
+		user, err := db.GetUserByID(claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return
+		}
+
+		// Revokes the alert token too, so the link works once
+		revokeAllUserSessions(db, user.ID)
+
+		recordAuthAudit(c, db, user, "sessions_revoked_by_alert", "success", "User reported an unrecognized sign-in; all sessions revoked", "high", nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "You have been signed out of every device. Please reset your password.",
+		})
+	}
+}
+
+// UnblockIPHandler lifts an IP-level sign-in block
+func UnblockIPHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.Param("ip")
+		if net.ParseIP(ip) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+			return
+		}
+
+		remaining := services.RemainingIPBlockTime(ip)
+		if err := services.UnblockIP(ip); err != nil {
+			log.Printf("Failed to unblock %s: %v", ip, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock IP address"})
+			return
+		}
+
+		recordAdminAudit(c, db, "ip_unblocked", "authentication", ip, "IP sign-in block lifted", map[string]interface{}{
+			"was_blocked":       remaining > 0,
+			"remaining_seconds": int64(remaining.Seconds()),
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "IP address unblocked", "ip": ip})
+	}
+}
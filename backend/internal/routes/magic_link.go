@@ -0,0 +1,184 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MagicLinkRequest represents a request for a passwordless sign-in link
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// MagicLinkVerifyRequest represents the payload exchanging a magic link for a session
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestMagicLinkHandler emails a single-use sign-in link to the account owner.
+// The response is the same whether or not the account exists or may use magic links.
+func RequestMagicLinkHandler(db *database.DB, emailService *services.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+		if !services.MagicLinkRateLimiter.Allow(clientIP) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many sign-in link requests. Please try again later.",
+			})
+			return
+		}
+
+		var req MagicLinkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		req.Email = strings.ToLower(services.SanitizeString(req.Email))
+		if err := services.ValidateEmail(req.Email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+			return
+		}
+
+		// Locked accounts cannot bypass the lockout with a magic link
+		if !checkLoginAllowed(c, req.Email, clientIP) {
+			return
+		}
+
+		if db == nil {
+			log.Printf("Database not available for magic link request from %s", clientIP)
+			serviceUnavailable(c)
+			return
+		}
+
+		response := gin.H{"message": "If an account with this email exists, a sign-in link has been sent."}
+
+		user, err := db.GetUserByEmail(req.Email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Database error during magic link request: %v", err)
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		roles, permissions, err := userAccess(db, user)
+		if err != nil {
+			log.Printf("Failed to resolve roles of user %d for magic link: %v", user.ID, err)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if !services.MagicLinkAllowed(roles, permissions) {
+			recordAuthAudit(c, db, user, "magic_link_requested", "failed", "Magic link sign-in is disabled for this role", "medium", map[string]interface{}{
+				"roles": roles,
+			})
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		token, tokenID, expiresAt, err := services.GenerateMagicLinkToken(user.ID, user.Email, user.Role, user.EmailVerified)
+		if err != nil {
+			log.Printf("Failed to generate magic link token: %v", err)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		if err := db.CreateMagicLinkToken(tokenID, user.ID, clientIP, expiresAt); err != nil {
+			log.Printf("Failed to store magic link token: %v", err)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		if emailService != nil {
+			if err := emailService.SendMagicLinkEmail(user.FirstName, user.Email, token, expiresAt); err != nil {
+				log.Printf("Failed to send magic link email: %v", err)
+			}
+		}
+
+		recordAuthAudit(c, db, user, "magic_link_requested", "success", "Magic link sent", "low", map[string]interface{}{
+			"expires_at": expiresAt,
+		})
+
+		log.Printf("Magic link requested for: %s from %s", user.Email, clientIP)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// VerifyMagicLinkHandler exchanges a magic-link token for a token pair and session.
+// Each link works once; users with two-factor authentication still get an MFA challenge.
+func VerifyMagicLinkHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req MagicLinkVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		claims, err := services.ParseMagicLinkToken(req.Token)
+		if err != nil {
+			recordAuthAudit(c, db, nil, "magic_link_login", "failed", "Invalid or expired magic link", "medium", nil)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		if !checkLoginAllowed(c, claims.Email, clientIP) {
+			return
+		}
+
+		user, err := db.GetUserByID(claims.UserID)
+		if err != nil || user.Email != claims.Email {
+			services.EnhancedLoginRateLimiter.RecordFailedAttempt(claims.Email, clientIP)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		// The user's roles may have changed since the link was sent
+		roles, permissions, err := userAccess(db, user)
+		if err != nil {
+			log.Printf("Failed to resolve roles of user %d for magic link: %v", user.ID, err)
+			serviceUnavailable(c)
+			return
+		}
+		if !services.MagicLinkAllowed(roles, permissions) {
+			recordAuthAudit(c, db, user, "magic_link_login", "failed", "Magic link sign-in is disabled for this role", "medium", map[string]interface{}{
+				"roles": roles,
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sign-in links are not available for this account. Please log in with your password."})
+			return
+		}
+
+		if err := db.ConsumeMagicLinkToken(claims.TokenID, user.ID, clientIP); err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Failed to consume magic link token: %v", err)
+				serviceUnavailable(c)
+				return
+			}
+			services.EnhancedLoginRateLimiter.RecordFailedAttempt(user.Email, clientIP)
+			recordAuthAudit(c, db, user, "magic_link_login", "failed", "Magic link already used or superseded", "medium", map[string]interface{}{
+				"token_id": claims.TokenID,
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This sign-in link has already been used or has expired"})
+			return
+		}
+		services.RevokeClaims(claims)
+
+		if challengeSecondFactor(c, db, user, "Magic link verified") {
+			return
+		}
+
+		completeLogin(c, db, user, clientIP, "magic_link", nil)
+	}
+}
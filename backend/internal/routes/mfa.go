@@ -0,0 +1,487 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userAccess returns the roles a user holds, their primary role followed by any active
+// additional roles, and their effective permissions
+func userAccess(db *database.DB, user *database.User) ([]string, []string, error) {
+	assignments, err := db.GetUserRoleAssignments(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	roles := []string{user.Role}
+	for _, assignment := range assignments {
+		roles = append(roles, assignment.RoleID)
+	}
+
+	permissions, err := db.GetUserPermissions(user.ID, canonicalRoleID(user.Role))
+	if err != nil {
+		return nil, nil, err
+	}
+	return roles, permissions, nil
+}
+
+// mfaRequiredForUser reports whether two-factor authentication is mandatory for the
+// user through any role or permission they hold
+func mfaRequiredForUser(db *database.DB, user *database.User) (bool, error) {
+	roles, permissions, err := userAccess(db, user)
+	if err != nil {
+		return false, err
+	}
+	return services.MFARequired(roles, permissions), nil
+}
+
+// MFAVerifyRequest represents the second login step payload
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAEnrollRequest represents an enrollment request. The MFA token is only needed
+// when enrolling during login, before the user holds an access token.
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// MFAConfirmRequest represents the enrollment confirmation payload
+type MFAConfirmRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFADisableRequest represents the payload for turning two-factor authentication off
+type MFADisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFACodeRequest represents a payload carrying a single TOTP code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// resolveMFAUser loads the user an MFA request applies to, either from the
+// authenticated context or from an MFA pending token issued by LoginHandler.
+func resolveMFAUser(c *gin.Context, db *database.DB, mfaToken string) (*database.User, *services.Claims, bool) {
+	if userID := c.GetInt("user_id"); userID != 0 {
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return nil, nil, false
+		}
+		return user, nil, true
+	}
+
+	claims, err := services.ParseMFAPendingToken(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, nil, false
+	}
+
+	user, err := db.GetUserByID(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+		return nil, nil, false
+	}
+	return user, claims, true
+}
+
+// verifyUserTOTP checks a TOTP code for an MFA-enabled user and consumes its time step
+func verifyUserTOTP(db *database.DB, userID int, code string) (bool, error) {
+	mfa, err := db.GetUserMFA(userID)
+	if err != nil {
+		return false, err
+	}
+	if !mfa.Enabled || !mfa.Secret.Valid {
+		return false, nil
+	}
+
+	step, ok := services.ValidateTOTPCode(mfa.Secret.String, code, mfa.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	return db.AdvanceMFALastUsedStep(userID, step)
+}
+
+// VerifyMFAHandler exchanges an MFA pending token and a second factor for a token pair
+func VerifyMFAHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req MFAVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A verification code or recovery code is required"})
+			return
+		}
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		claims, err := services.ParseMFAPendingToken(req.MFAToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		// Second-factor failures count towards the same lockout as password failures
+		if !services.EnhancedLoginRateLimiter.CheckLoginAttempt(claims.Email, clientIP) {
+			remainingTime := services.EnhancedLoginRateLimiter.GetRemainingLockoutTime(claims.Email)
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":             "Account temporarily locked. Please try again later.",
+				"lockout_remaining": remainingTime.String(),
+			})
+			return
+		}
+
+		user, err := db.GetUserByID(claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Two-factor authentication is not set up for this account",
+				"code":  "MFA_ENROLLMENT_REQUIRED",
+			})
+			return
+		}
+
+		method := "password+totp"
+		var verified bool
+		if req.Code != "" {
+			verified, err = verifyUserTOTP(db, user.ID, req.Code)
+		} else {
+			method = "password+recovery_code"
+			verified, err = db.ConsumeRecoveryCode(user.ID, services.HashRecoveryCode(req.RecoveryCode))
+		}
+		if err != nil {
+			log.Printf("Failed to verify second factor: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		if !verified {
+			services.EnhancedLoginRateLimiter.RecordFailedAttempt(user.Email, clientIP)
+			recordAuthAudit(c, db, user, "login_mfa", "failed", "Invalid second factor", "medium", map[string]interface{}{
+				"method": method,
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		// The pending token is single use
+		services.RevokeClaims(claims)
+
+		extra := gin.H{}
+		if req.RecoveryCode != "" {
+			remaining := 0
+			if mfa, err := db.GetUserMFA(user.ID); err == nil {
+				remaining = len(mfa.RecoveryCodeHashes)
+			}
+			recordAuthAudit(c, db, user, "mfa_recovery_code_used", "success", "Recovery code used to log in", "high", map[string]interface{}{
+				"remaining_recovery_codes": remaining,
+			})
+			extra["remaining_recovery_codes"] = remaining
+		}
+
+		completeLogin(c, db, user, clientIP, method, extra)
+	}
+}
+
+// GetMFAStatusHandler returns the caller's two-factor authentication status
+func GetMFAStatusHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		mfa, err := db.GetUserMFA(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get two-factor status"})
+			return
+		}
+
+		required, err := mfaRequiredForUser(db, user)
+		if err != nil {
+			log.Printf("Failed to resolve roles of user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get two-factor status"})
+			return
+		}
+
+		response := gin.H{
+			"enabled":                  mfa.Enabled,
+			"required":                 required,
+			"remaining_recovery_codes": len(mfa.RecoveryCodeHashes),
+		}
+		if mfa.EnabledAt.Valid {
+			response["enabled_at"] = mfa.EnabledAt.Time
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// BeginMFAEnrollmentHandler generates a new TOTP secret and returns its provisioning URI
+func BeginMFAEnrollmentHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MFAEnrollRequest
+		// The body is optional for authenticated callers
+		_ = c.ShouldBindJSON(&req)
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		user, _, ok := resolveMFAUser(c, db, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		if user.MFAEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := services.GenerateTOTPSecret()
+		if err != nil {
+			log.Printf("Failed to generate TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor enrollment"})
+			return
+		}
+
+		if err := db.SetPendingMFASecret(user.ID, secret); err != nil {
+			log.Printf("Failed to store TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor enrollment"})
+			return
+		}
+
+		recordAuthAudit(c, db, user, "mfa_enroll_started", "success", "Two-factor enrollment started", "low", nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           secret,
+			"provisioning_uri": services.TOTPProvisioningURI(secret, user.Email),
+		})
+	}
+}
+
+// ConfirmMFAEnrollmentHandler verifies the first code from the authenticator app,
+// enables two-factor authentication and returns one-time recovery codes. When called
+// with an MFA pending token it also completes the login.
+func ConfirmMFAEnrollmentHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		var req MFAConfirmRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		user, claims, ok := resolveMFAUser(c, db, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		if !services.EnhancedLoginRateLimiter.CheckLoginAttempt(user.Email, clientIP) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts. Please try again later."})
+			return
+		}
+
+		mfa, err := db.GetUserMFA(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm two-factor enrollment"})
+			return
+		}
+		if mfa.Enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		if !mfa.Secret.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor enrollment has not been started"})
+			return
+		}
+
+		step, valid := services.ValidateTOTPCode(mfa.Secret.String, req.Code, mfa.LastUsedStep)
+		if !valid {
+			services.EnhancedLoginRateLimiter.RecordFailedAttempt(user.Email, clientIP)
+			recordAuthAudit(c, db, user, "mfa_enroll_confirm", "failed", "Invalid verification code", "medium", nil)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		codes, hashes, err := services.GenerateRecoveryCodes()
+		if err != nil {
+			log.Printf("Failed to generate recovery codes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm two-factor enrollment"})
+			return
+		}
+
+		if err := db.EnableUserMFA(user.ID, step, hashes); err != nil {
+			log.Printf("Failed to enable MFA: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm two-factor enrollment"})
+			return
+		}
+		user.MFAEnabled = true
+
+		recordAuthAudit(c, db, user, "mfa_enabled", "success", "Two-factor authentication enabled", "medium", nil)
+
+		// Enrollment during login finishes the login in the same request
+		if claims != nil {
+			services.RevokeClaims(claims)
+			completeLogin(c, db, user, clientIP, "password+totp", gin.H{"recovery_codes": codes})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// DisableMFAHandler turns off two-factor authentication for the caller
+func DisableMFAHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var req MFADisableRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		required, err := mfaRequiredForUser(db, user)
+		if err != nil {
+			log.Printf("Failed to resolve roles of user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role and cannot be disabled"})
+			return
+		}
+
+		if err := services.CheckPassword(user.PasswordHash, req.Password); err != nil {
+			recordAuthAudit(c, db, user, "mfa_disable", "failed", "Invalid password", "medium", nil)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		verified, err := verifyUserTOTP(db, user.ID, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		if !verified {
+			recordAuthAudit(c, db, user, "mfa_disable", "failed", "Invalid verification code", "medium", nil)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		if err := db.DisableUserMFA(user.ID); err != nil {
+			log.Printf("Failed to disable MFA: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+
+		recordAuthAudit(c, db, user, "mfa_disabled", "success", "Two-factor authentication disabled", "high", nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces the caller's recovery codes with a fresh set
+func RegenerateRecoveryCodesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var req MFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		verified, err := verifyUserTOTP(db, user.ID, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		codes, hashes, err := services.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+
+		if err := db.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+			log.Printf("Failed to store recovery codes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+
+		recordAuthAudit(c, db, user, "mfa_recovery_codes_regenerated", "success", "Recovery codes regenerated", "medium", nil)
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
@@ -0,0 +1,443 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCCallbackRequest carries the authorization response the provider sent to the frontend
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// identityResponse builds the public view of a linked identity
+func identityResponse(identity *database.UserIdentity) gin.H {
+	response := gin.H{
+		"provider":       identity.Provider,
+		"email":          identity.Email.String,
+		"email_verified": identity.EmailVerified,
+		"linked_at":      identity.CreatedAt,
+	}
+	if identity.LastLoginAt.Valid {
+		response["last_login_at"] = identity.LastLoginAt.Time
+	}
+	return response
+}
+
+// lookupOIDCProvider resolves the :provider path parameter, writing a 404 if it is not configured
+func lookupOIDCProvider(c *gin.Context) (*services.OIDCProvider, bool) {
+	provider, ok := services.GetOIDCProvider(strings.ToLower(c.Param("provider")))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sign-in provider not found"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// beginOIDCAuthorization stores a pending authorization request and responds with the
+// provider URL to redirect to. A non-zero userID marks the request as linking that user.
+func beginOIDCAuthorization(c *gin.Context, db *database.DB, userID int) {
+	if db == nil {
+		serviceUnavailable(c)
+		return
+	}
+
+	provider, ok := lookupOIDCProvider(c)
+	if !ok {
+		return
+	}
+
+	state, nonce, codeVerifier := services.NewOIDCAuthRequest()
+	authorizationURL, err := provider.AuthorizationURL(c.Request.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("Failed to build %s authorization URL: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Sign-in provider is unavailable. Please try again later."})
+		return
+	}
+
+	authState := &database.OIDCAuthState{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(services.OIDCAuthStateLifetime),
+	}
+	if userID != 0 {
+		authState.UserID = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	if err := db.CreateOIDCAuthState(authState); err != nil {
+		log.Printf("Failed to store OIDC state: %v", err)
+		serviceUnavailable(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": authorizationURL,
+		"state":             state,
+		"expires_in":        int64(services.OIDCAuthStateLifetime.Seconds()),
+	})
+}
+
+// finishOIDCAuthorization consumes the state of an authorization response and exchanges the
+// code for a verified identity. The state must have been created for linkingUserID (0 for sign-in).
+// It returns false if a response has already been written.
+func finishOIDCAuthorization(c *gin.Context, db *database.DB, linkingUserID int) (*services.OIDCProvider, *services.OIDCIdentity, bool) {
+	if db == nil {
+		serviceUnavailable(c)
+		return nil, nil, false
+	}
+
+	provider, ok := lookupOIDCProvider(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return nil, nil, false
+	}
+
+	authState, err := db.ConsumeOIDCAuthState(req.State, provider.Name)
+	if err != nil || int(authState.UserID.Int64) != linkingUserID {
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to load OIDC state: %v", err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in request is invalid or has expired. Please try again."})
+		return nil, nil, false
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), req.Code, authState.CodeVerifier, authState.Nonce)
+	if err != nil {
+		log.Printf("OIDC exchange with %s failed: %v", provider.Name, err)
+		recordAuthAudit(c, db, nil, "oidc_login", "failed", "Identity provider exchange failed", "medium", map[string]interface{}{
+			"provider": provider.Name,
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Could not verify your sign-in with the provider"})
+		return nil, nil, false
+	}
+
+	return provider, identity, true
+}
+
+// ListOIDCProvidersHandler lists the configured sign-in providers
+func ListOIDCProvidersHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": services.OIDCProviderNames()})
+	}
+}
+
+// OIDCAuthorizeHandler starts sign-in with an OpenID Connect provider
+func OIDCAuthorizeHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		beginOIDCAuthorization(c, db, 0)
+	}
+}
+
+// OIDCCallbackHandler completes sign-in with an OpenID Connect provider. The identity is
+// matched to a linked user, then to an existing user by verified email, and otherwise a
+// new account is created. Login ends in the same token pair and session as LoginHandler.
+func OIDCCallbackHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+
+		provider, identity, ok := finishOIDCAuthorization(c, db, 0)
+		if !ok {
+			return
+		}
+
+		if identity.Email != "" && !checkLoginAllowed(c, identity.Email, clientIP) {
+			return
+		}
+
+		user, ok := resolveOIDCUser(c, db, provider, identity)
+		if !ok {
+			return
+		}
+
+		if challengeSecondFactor(c, db, user, "Signed in with "+provider.Name) {
+			return
+		}
+
+		completeLogin(c, db, user, clientIP, "oidc:"+provider.Name, nil)
+	}
+}
+
+// resolveOIDCUser finds or creates the user for a verified provider identity.
+// It returns false if a response has already been written.
+func resolveOIDCUser(c *gin.Context, db *database.DB, provider *services.OIDCProvider, identity *services.OIDCIdentity) (*database.User, bool) {
+	linked, err := db.GetUserIdentity(provider.Name, identity.Subject)
+	if err == nil {
+		user, err := db.GetUserByID(linked.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return nil, false
+		}
+		if err := db.TouchUserIdentity(linked.ID, identity.Email, identity.EmailVerified); err != nil {
+			log.Printf("Failed to update identity %d: %v", linked.ID, err)
+		}
+		return user, true
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Failed to look up %s identity: %v", provider.Name, err)
+		serviceUnavailable(c)
+		return nil, false
+	}
+
+	// Unlinked identities are only matched or registered by an email the provider has verified
+	if identity.Email == "" || !identity.EmailVerified {
+		recordAuthAudit(c, db, nil, "oidc_login", "failed", "Provider email missing or unverified", "medium", map[string]interface{}{
+			"provider": provider.Name,
+			"email":    identity.Email,
+		})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account with this provider does not have a verified email address"})
+		return nil, false
+	}
+	if err := services.ValidateEmail(identity.Email); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account with this provider does not have a valid email address"})
+		return nil, false
+	}
+
+	user, err := db.GetUserByEmail(identity.Email)
+	switch {
+	case err == nil:
+		// Linking to an account whose own email was never verified would let whoever
+		// registered it first keep access alongside the real owner
+		if !user.EmailVerified {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": fmt.Sprintf("An account with this email already exists. Log in with your password and link %s from your profile.", provider.Name),
+			})
+			return nil, false
+		}
+	case err == sql.ErrNoRows:
+		user, err = createOIDCUser(db, identity)
+		if err != nil {
+			log.Printf("Failed to create user for %s identity: %v", provider.Name, err)
+			serviceUnavailable(c)
+			return nil, false
+		}
+		recordAuthAudit(c, db, user, "register", "success", "Account created through "+provider.Name, "low", map[string]interface{}{
+			"provider": provider.Name,
+		})
+	default:
+		log.Printf("Database error during OIDC login: %v", err)
+		serviceUnavailable(c)
+		return nil, false
+	}
+
+	if !linkOIDCIdentity(c, db, user, identity) {
+		return nil, false
+	}
+	return user, true
+}
+
+// createOIDCUser registers a new account for a provider identity. The account has no
+// password until the user sets one through the password reset flow.
+func createOIDCUser(db *database.DB, identity *services.OIDCIdentity) (*database.User, error) {
+	firstName := services.SanitizeString(identity.GivenName)
+	lastName := services.SanitizeString(identity.FamilyName)
+	if firstName == "" && lastName == "" {
+		if parts := strings.Fields(services.SanitizeString(identity.Name)); len(parts) > 0 {
+			firstName = parts[0]
+			lastName = strings.Join(parts[1:], " ")
+		}
+	}
+	if len(firstName) > 100 {
+		firstName = firstName[:100]
+	}
+	if len(lastName) > 100 {
+		lastName = lastName[:100]
+	}
+
+	user, err := db.CreateUser(identity.Email, "", firstName, lastName, "user")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.SetUserEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	return user, nil
+}
+
+// linkOIDCIdentity links a verified provider identity to a user and records it in the audit log.
+// It returns false if a response has already been written.
+func linkOIDCIdentity(c *gin.Context, db *database.DB, user *database.User, identity *services.OIDCIdentity) bool {
+	linked := &database.UserIdentity{
+		UserID:        user.ID,
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         sql.NullString{String: identity.Email, Valid: identity.Email != ""},
+		EmailVerified: identity.EmailVerified,
+	}
+	if err := db.LinkUserIdentity(linked); err != nil {
+		log.Printf("Failed to link %s identity to user %d: %v", identity.Provider, user.ID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "This sign-in provider could not be linked to your account"})
+		return false
+	}
+	if err := db.TouchUserIdentity(linked.ID, identity.Email, identity.EmailVerified); err != nil {
+		log.Printf("Failed to update identity %d: %v", linked.ID, err)
+	}
+
+	recordAuthAudit(c, db, user, "identity_linked", "success", "Linked "+identity.Provider+" sign-in", "medium", map[string]interface{}{
+		"provider": identity.Provider,
+		"email":    identity.Email,
+	})
+	return true
+}
+
+// ListIdentitiesHandler lists the authenticated user's linked identities and the available providers
+func ListIdentitiesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		identities, err := db.GetUserIdentities(userID)
+		if err != nil {
+			log.Printf("Failed to get identities for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get linked accounts"})
+			return
+		}
+
+		result := make([]gin.H, 0, len(identities))
+		for _, identity := range identities {
+			result = append(result, identityResponse(identity))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"identities": result,
+			"providers":  services.OIDCProviderNames(),
+		})
+	}
+}
+
+// LinkIdentityHandler starts linking an OpenID Connect provider to the authenticated user
+func LinkIdentityHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		beginOIDCAuthorization(c, db, userID)
+	}
+}
+
+// ConfirmIdentityLinkHandler completes linking a provider identity to the authenticated user.
+// The provider email does not have to match the account email.
+func ConfirmIdentityLinkHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		provider, identity, ok := finishOIDCAuthorization(c, db, userID)
+		if !ok {
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return
+		}
+
+		existing, err := db.GetUserIdentity(provider.Name, identity.Subject)
+		if err == nil {
+			if existing.UserID == userID {
+				c.JSON(http.StatusOK, gin.H{"message": "Account already linked", "identity": identityResponse(existing)})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "This account is already linked to another user"})
+			return
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to look up %s identity: %v", provider.Name, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		if !linkOIDCIdentity(c, db, user, identity) {
+			return
+		}
+
+		linked, err := db.GetUserIdentity(provider.Name, identity.Subject)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Account linked successfully"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Account linked successfully",
+			"identity": identityResponse(linked),
+		})
+	}
+}
+
+// UnlinkIdentityHandler removes a linked provider from the authenticated user. The last
+// sign-in method cannot be removed from an account without a password.
+func UnlinkIdentityHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		providerName := strings.ToLower(c.Param("provider"))
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account not found"})
+			return
+		}
+
+		identities, err := db.GetUserIdentities(userID)
+		if err != nil {
+			log.Printf("Failed to get identities for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+		if user.PasswordHash == "" && len(identities) <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Set a password before unlinking your only sign-in method"})
+			return
+		}
+
+		if err := db.UnlinkUserIdentity(userID, providerName); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "No linked account for this provider"})
+				return
+			}
+			log.Printf("Failed to unlink %s for user %d: %v", providerName, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+			return
+		}
+
+		recordAuthAudit(c, db, user, "identity_unlinked", "success", "Unlinked "+providerName+" sign-in", "medium", map[string]interface{}{
+			"provider": providerName,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account unlinked successfully"})
+	}
+}
@@ -0,0 +1,268 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeSQL is a database that answers queries containing a registered fragment with one
+// row and every other query with no rows, recording each statement it is sent
+type fakeSQL struct {
+	mu         sync.Mutex
+	rows       map[string][]driver.Value
+	statements []string
+}
+
+func newFakeDB(t *testing.T, rows map[string][]driver.Value) (*database.DB, *fakeSQL) {
+	t.Helper()
+	fake := &fakeSQL{rows: rows}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return &database.DB{DB: db}, fake
+}
+
+// ran reports whether a statement containing the fragment was sent
+func (f *fakeSQL) ran(fragment string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, statement := range f.statements {
+		if strings.Contains(statement, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) { return &fakeSQLConn{fake: f}, nil }
+func (f *fakeSQL) Driver() driver.Driver                        { return nil }
+
+type fakeSQLConn struct{ fake *fakeSQL }
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{fake: c.fake, query: query}, nil
+}
+func (c *fakeSQLConn) Close() error              { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) { return fakeSQLTx{}, nil }
+
+type fakeSQLTx struct{}
+
+func (fakeSQLTx) Commit() error   { return nil }
+func (fakeSQLTx) Rollback() error { return nil }
+
+type fakeSQLStmt struct {
+	fake  *fakeSQL
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.fake.mu.Lock()
+	s.fake.statements = append(s.fake.statements, s.query)
+	s.fake.mu.Unlock()
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeSQLStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.fake.mu.Lock()
+	defer s.fake.mu.Unlock()
+	s.fake.statements = append(s.fake.statements, s.query)
+	for fragment, row := range s.fake.rows {
+		if strings.Contains(s.query, fragment) {
+			return &fakeSQLRows{row: row}, nil
+		}
+	}
+	return &fakeSQLRows{}, nil
+}
+
+type fakeSQLRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeSQLRows) Columns() []string { return make([]string, len(r.row)) }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+// newTestOIDCIssuer starts an OpenID Connect provider whose token endpoint answers with
+// an ID token built from the claims the test returns
+func newTestOIDCIssuer(t *testing.T, claims func(issuer string) jwt.MapClaims) *httptest.Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]services.JSONWebKey{"keys": {{
+			KeyType: "RSA",
+			KeyID:   "test-key",
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims(server.URL))
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCCallbackHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		provider   string
+		state      bool
+		nonce      string
+		email      string
+		verified   interface{}
+		wantStatus int
+	}{
+		{name: "unknown provider", provider: "unknown", state: true, wantStatus: http.StatusNotFound},
+		{name: "unknown or expired state", provider: "stub", state: false, wantStatus: http.StatusBadRequest},
+		{name: "nonce mismatch", provider: "stub", state: true, nonce: "replayed", email: "person@example.com", verified: true, wantStatus: http.StatusUnauthorized},
+		{name: "unverified email", provider: "stub", state: true, email: "person@example.com", verified: false, wantStatus: http.StatusForbidden},
+		{name: "unverified email as string", provider: "stub", state: true, email: "person@example.com", verified: "false", wantStatus: http.StatusForbidden},
+		{name: "missing email", provider: "stub", state: true, verified: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestOIDCIssuer(t, func(issuer string) jwt.MapClaims {
+				nonce := tt.nonce
+				if nonce == "" {
+					nonce = "expected-nonce"
+				}
+				claims := jwt.MapClaims{
+					"iss":            issuer,
+					"aud":            "client",
+					"sub":            "subject-1",
+					"exp":            time.Now().Add(5 * time.Minute).Unix(),
+					"nonce":          nonce,
+					"email_verified": tt.verified,
+				}
+				if tt.email != "" {
+					claims["email"] = tt.email
+				}
+				return claims
+			})
+			services.SetOIDCProviders([]*services.OIDCProvider{
+				services.NewOIDCProvider("stub", issuer.URL, "client", "secret", []string{"openid", "email"}, "https://bome.test/callback"),
+			})
+			t.Cleanup(func() { services.SetOIDCProviders(nil) })
+
+			rows := map[string][]driver.Value{}
+			if tt.state {
+				rows["DELETE FROM oidc_auth_states"] = []driver.Value{"state-1", "stub", "expected-nonce", "verifier", nil, time.Now().Add(time.Minute)}
+			}
+			db, fake := newFakeDB(t, rows)
+
+			router := gin.New()
+			router.POST("/auth/oidc/:provider/callback", OIDCCallbackHandler(db))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/auth/oidc/"+tt.provider+"/callback", strings.NewReader(`{"code":"code-1","state":"state-1"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if fake.ran("INSERT INTO users") || fake.ran("INSERT INTO user_identities") {
+				t.Error("a rejected sign-in created an account or linked an identity")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackHandlerWithoutDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/auth/oidc/:provider/callback", OIDCCallbackHandler(nil))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/oidc/stub/callback", strings.NewReader(`{"code":"code-1","state":"state-1"}`))
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestListOIDCProvidersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	services.SetOIDCProviders([]*services.OIDCProvider{
+		services.NewOIDCProvider("microsoft", "https://login.example.com", "client", "secret", nil, ""),
+		services.NewOIDCProvider("google", "https://accounts.example.com", "client", "secret", nil, ""),
+	})
+	t.Cleanup(func() { services.SetOIDCProviders(nil) })
+
+	router := gin.New()
+	router.GET("/auth/oidc/providers", ListOIDCProvidersHandler())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/providers", nil))
+
+	var response struct {
+		Providers []string `json:"providers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if strings.Join(response.Providers, ",") != "google,microsoft" {
+		t.Errorf("providers = %v, want [google microsoft]", response.Providers)
+	}
+}
@@ -0,0 +1,273 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaybackHeartbeatHandler records where the viewer is in a video. Players send it
+// every few seconds while playing and once more when playback ends. media_type is
+// "video" (media_id is the video ID) or "youtube" (media_id is the YouTube video ID).
+// Anonymous viewers may send heartbeats too, rate limited by IP address; they count
+// towards views but no position is kept.
+func PlaybackHeartbeatHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req struct {
+			MediaType       string `json:"media_type" binding:"required"`
+			MediaID         string `json:"media_id" binding:"required"`
+			PositionSeconds int    `json:"position_seconds"`
+			DurationSeconds int    `json:"duration_seconds"`
+			WatchedSeconds  int    `json:"watched_seconds"`
+			Ended           bool   `json:"ended"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "media_type and media_id are required"})
+			return
+		}
+
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			clientIP := services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP"))
+			if !services.HeartbeatRateLimiter.Allow(clientIP) {
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error": "Too many playback updates. Please try again later.",
+				})
+				return
+			}
+		}
+
+		viewerKey := ""
+		if userID != 0 {
+			viewerKey = services.UserViewerKey(userID)
+		} else if !isLikelyBot(c.Request.UserAgent()) {
+			viewerKey = services.AnonymousViewerKey(c.ClientIP(), c.Request.UserAgent())
+		}
+
+		progress, err := playback.Heartbeat(userID, viewerKey, services.PlaybackHeartbeat{
+			MediaType:       req.MediaType,
+			MediaID:         req.MediaID,
+			PositionSeconds: req.PositionSeconds,
+			DurationSeconds: req.DurationSeconds,
+			WatchedSeconds:  req.WatchedSeconds,
+			Ended:           req.Ended,
+		})
+		switch {
+		case errors.Is(err, services.ErrUnsupportedMediaType):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "media_type must be video or youtube"})
+			return
+		case errors.Is(err, services.ErrMediaNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		case err != nil:
+			log.Printf("Failed to record playback heartbeat: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record playback position"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"position_seconds": progress.PositionSeconds,
+			"duration_seconds": progress.DurationSeconds,
+			"completed":        progress.Completed,
+		})
+	}
+}
+
+// GetPlaybackProgressHandler returns where the user left off in a video so the player
+// can resume there. Finished videos resume from the start.
+func GetPlaybackProgressHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		progress, err := playback.Progress(c.GetInt("user_id"), c.Param("type"), c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, gin.H{"progress": nil, "resume_position": 0})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load playback progress: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load playback position"})
+			return
+		}
+
+		resumePosition := progress.PositionSeconds
+		if progress.Completed {
+			resumePosition = 0
+		}
+
+		c.JSON(http.StatusOK, gin.H{"progress": progress, "resume_position": resumePosition})
+	}
+}
+
+// ContinueWatchingHandler lists the videos the user started but has not finished,
+// most recently watched first
+func ContinueWatchingHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+		if offset < 0 {
+			offset = 0
+		}
+
+		items, err := playback.ContinueWatching(c.GetInt("user_id"), limit, offset)
+		if err != nil {
+			log.Printf("Failed to load continue watching: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load continue watching"})
+			return
+		}
+		if items == nil {
+			items = []*database.WatchHistoryItem{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"items": items, "limit": limit, "offset": offset})
+	}
+}
+
+// WatchHistoryHandler lists every video the user has watched, most recently watched
+// first, and whether their watch history is paused
+func WatchHistoryHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+		if offset < 0 {
+			offset = 0
+		}
+
+		userID := c.GetInt("user_id")
+		items, err := playback.History(userID, limit, offset)
+		if err != nil {
+			log.Printf("Failed to load watch history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load watch history"})
+			return
+		}
+		if items == nil {
+			items = []*database.WatchHistoryItem{}
+		}
+
+		paused, err := playback.HistoryPaused(userID)
+		if err != nil {
+			log.Printf("Failed to load watch history setting: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load watch history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"items": items, "paused": paused, "limit": limit, "offset": offset})
+	}
+}
+
+// ClearWatchHistoryHandler removes all of the user's watch history, including their
+// saved playback positions
+func ClearWatchHistoryHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		removed, err := playback.ClearHistory(c.GetInt("user_id"))
+		if err != nil {
+			log.Printf("Failed to clear watch history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear watch history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Watch history cleared", "removed": removed})
+	}
+}
+
+// DeleteWatchHistoryItemHandler removes one video from the user's watch history
+func DeleteWatchHistoryItemHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		err := playback.DeleteHistoryItem(c.GetInt("user_id"), c.Param("type"), c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found in watch history"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to remove watch history item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove video from watch history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Video removed from watch history"})
+	}
+}
+
+// UpdateWatchHistorySettingsHandler pauses or resumes the user's watch history. While
+// paused, playback positions are not saved, though views are still counted.
+func UpdateWatchHistorySettingsHandler(db *database.DB, playback *services.PlaybackService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req struct {
+			Paused *bool `json:"paused" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "paused is required"})
+			return
+		}
+
+		if err := playback.SetHistoryPaused(c.GetInt("user_id"), *req.Paused); err != nil {
+			log.Printf("Failed to update watch history setting: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update watch history setting"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"paused": *req.Paused})
+	}
+}
+
+// isLikelyBot reports whether a user agent looks like a crawler, whose heartbeats are
+// not counted as views
+func isLikelyBot(userAgent string) bool {
+	if userAgent == "" {
+		return true
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, marker := range []string{"bot", "crawler", "spider", "slurp", "headless", "curl/", "wget/", "python-requests"} {
+		if strings.Contains(userAgent, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dataExportCooldown is how often a user may request a new data export
+const dataExportCooldown = 24 * time.Hour
+
+// AccountDeletionRequest confirms a request to delete the caller's account
+type AccountDeletionRequest struct {
+	Password string `json:"password"`
+	MFACode  string `json:"mfa_code"`
+}
+
+// accountDeletionReauthWindow is how recently a user without a password must have
+// signed in to schedule deletion without an MFA code
+const accountDeletionReauthWindow = 10 * time.Minute
+
+// dataExportResponse builds the public view of a data export request
+func dataExportResponse(privacy *services.PrivacyService, export *database.DataExport) gin.H {
+	response := gin.H{
+		"id":           export.ID,
+		"status":       export.Status,
+		"requested_at": export.RequestedAt,
+	}
+	if export.CompletedAt.Valid {
+		response["completed_at"] = export.CompletedAt.Time
+	}
+	if export.Status == "completed" {
+		response["expires_at"] = export.ExpiresAt.Time
+		if downloadURL, err := privacy.ExportDownloadURL(export); err == nil {
+			response["download_url"] = downloadURL
+		}
+	}
+	return response
+}
+
+// RequestDataExportHandler queues an export of everything stored about the caller.
+// The archive is built in the background and a download link is emailed when it is ready.
+func RequestDataExportHandler(db *database.DB, privacy *services.PrivacyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil || !privacy.ExportsAvailable() {
+			serviceUnavailable(c)
+			return
+		}
+
+		latest, err := db.GetLatestDataExport(userID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to get data export for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+		if latest != nil {
+			switch {
+			case latest.Status == "pending" || latest.Status == "processing":
+				c.JSON(http.StatusAccepted, gin.H{"export": dataExportResponse(privacy, latest)})
+				return
+			case latest.Status != "failed" && time.Since(latest.RequestedAt) < dataExportCooldown:
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":  "You can request one data export per day",
+					"export": dataExportResponse(privacy, latest),
+				})
+				return
+			}
+		}
+
+		export, err := db.CreateDataExport(userID)
+		if err != nil {
+			log.Printf("Failed to create data export for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request data export"})
+			return
+		}
+
+		recordPrivacyAudit(c, db, "data_export_requested", "Personal data export requested", "medium", map[string]interface{}{
+			"export_id": export.ID,
+		})
+
+		// Start right away rather than waiting for the next scheduled run
+		go privacy.ProcessPendingExports()
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Your data export has been requested. We will email you a download link when it is ready.",
+			"export":  dataExportResponse(privacy, export),
+		})
+	}
+}
+
+// GetDataExportHandler returns the status of the caller's latest data export
+func GetDataExportHandler(db *database.DB, privacy *services.PrivacyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		export, err := db.GetLatestDataExport(userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No data export has been requested"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get data export for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"export": dataExportResponse(privacy, export)})
+	}
+}
+
+// ScheduleAccountDeletionHandler schedules the caller's account for erasure after the
+// grace period. Users with a password must confirm it; users without one must give an
+// MFA code or have signed in within accountDeletionReauthWindow.
+func ScheduleAccountDeletionHandler(db *database.DB, emailService *services.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		var req AccountDeletionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if user.PasswordHash != "" {
+			if err := services.CheckPassword(user.PasswordHash, req.Password); err != nil {
+				recordAuthAudit(c, db, user, "account_deletion_requested", "failed", "Incorrect password", "medium", nil)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+				return
+			}
+		} else if ok, reason := passwordlessReauthenticated(c, db, user, req.MFACode); !ok {
+			recordAuthAudit(c, db, user, "account_deletion_requested", "failed", reason, "medium", nil)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                     "Sign in again or enter an MFA code to delete your account",
+				"reauthentication_required": true,
+			})
+			return
+		}
+
+		deletion, err := db.ScheduleAccountDeletion(userID, time.Now().Add(services.AccountDeletionGracePeriod()))
+		if err != nil {
+			log.Printf("Failed to schedule deletion for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+			return
+		}
+
+		if emailService != nil {
+			if err := emailService.SendAccountDeletionScheduledEmail(user.FirstName, user.Email, deletion.ScheduledFor); err != nil {
+				log.Printf("Failed to send account deletion email: %v", err)
+			}
+		}
+
+		recordAuthAudit(c, db, user, "account_deletion_requested", "success", "Account scheduled for deletion", "high", map[string]interface{}{
+			"scheduled_for": deletion.ScheduledFor,
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  "Your account is scheduled for deletion. You can cancel until the scheduled date.",
+			"deletion": deletion,
+		})
+	}
+}
+
+// passwordlessReauthenticated reports whether a user without a password has recently
+// proven who they are, either with an MFA code or a fresh sign-in, and why not if not
+func passwordlessReauthenticated(c *gin.Context, db *database.DB, user *database.User, mfaCode string) (bool, string) {
+	if mfaCode != "" {
+		valid, err := verifyUserTOTP(db, user.ID, mfaCode)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to verify MFA code for user %d: %v", user.ID, err)
+		}
+		if !valid {
+			return false, "Invalid MFA code"
+		}
+		return true, ""
+	}
+
+	familyID := c.GetString("session_family_id")
+	if familyID == "" {
+		return false, "Re-authentication required"
+	}
+	startedAt, err := db.GetSessionStartedAt(familyID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to get session start for user %d: %v", user.ID, err)
+		}
+		return false, "Re-authentication required"
+	}
+	if time.Since(startedAt) > accountDeletionReauthWindow {
+		return false, "Sign-in is too old"
+	}
+	return true, ""
+}
+
+// GetAccountDeletionHandler returns the caller's scheduled account deletion, if any
+func GetAccountDeletionHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		deletion, err := db.GetAccountDeletion(userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, gin.H{"scheduled": false})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get account deletion for user %d: %v", userID, err)
+			serviceUnavailable(c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"scheduled": true, "deletion": deletion})
+	}
+}
+
+// CancelAccountDeletionHandler cancels the caller's scheduled account deletion
+func CancelAccountDeletionHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		if err := db.CancelAccountDeletion(userID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "No account deletion is scheduled"})
+				return
+			}
+			log.Printf("Failed to cancel account deletion for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+			return
+		}
+
+		recordPrivacyAudit(c, db, "account_deletion_cancelled", "Scheduled account deletion cancelled", "medium", nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+	}
+}
+
+// recordPrivacyAudit writes an audit entry for a privacy request made by the current user
+func recordPrivacyAudit(c *gin.Context, db *database.DB, action, details, severity string, metadata map[string]interface{}) {
+	userID := c.GetInt("user_id")
+	email := c.GetString("user_email")
+	resourceID := strconv.Itoa(userID)
+
+	auditLog := &database.AuditLog{
+		UserID:     &userID,
+		UserEmail:  &email,
+		Action:     action,
+		Resource:   "user",
+		ResourceID: &resourceID,
+		IPAddress:  services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Status:     "success",
+		Details:    &details,
+		Severity:   severity,
+		Metadata:   metadata,
+	}
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RelatedVideosHandler returns the videos related to a video and the video to play up
+// next, limited to what the viewer's subscription tier can play. Signed-in viewers do
+// not see videos they have already finished. Each video lists the reasons it was
+// recommended.
+func RelatedVideosHandler(db *database.DB, recommendations *services.RecommendationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+		if _, err := db.GetVideoByID(videoID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		} else if err != nil {
+			log.Printf("Failed to load video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related videos"})
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if limit < 1 || limit > 20 {
+			limit = 10
+		}
+
+		userID := c.GetInt("user_id")
+		tier, err := services.SubscriptionTier(db, userID, c.GetString("user_role"))
+		if err != nil {
+			log.Printf("Failed to resolve subscription tier for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related videos"})
+			return
+		}
+
+		upNext, related, err := recommendations.Related(videoID, userID, tier, limit)
+		if err != nil {
+			log.Printf("Failed to load related videos for video %d: %v", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load related videos"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"up_next": upNext, "videos": related, "tier": tier})
+	}
+}
+
+// RecommendedVideosHandler recommends videos based on what the user watched recently,
+// each explained by the video that led to it
+func RecommendedVideosHandler(db *database.DB, recommendations *services.RecommendationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if limit < 1 || limit > 50 {
+			limit = 20
+		}
+
+		userID := c.GetInt("user_id")
+		tier, err := services.SubscriptionTier(db, userID, c.GetString("user_role"))
+		if err != nil {
+			log.Printf("Failed to resolve subscription tier for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recommendations"})
+			return
+		}
+
+		videos, err := recommendations.ForUser(userID, tier, limit)
+		if err != nil {
+			log.Printf("Failed to load recommendations for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recommendations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"videos": videos, "tier": tier})
+	}
+}
+
+// RefreshRecommendationsHandler recomputes related videos in the background, for use
+// after large catalog changes rather than waiting for the next scheduled run
+func RefreshRecommendationsHandler(db *database.DB, recommendations *services.RecommendationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		go func() {
+			if err := recommendations.Refresh(); err != nil {
+				log.Printf("Failed to compute related videos: %v", err)
+			}
+		}()
+
+		recordAdminAudit(c, db, "recommendations_refreshed", "recommendations", "", "Related videos recomputation started", nil)
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Related videos are being recomputed"})
+	}
+}
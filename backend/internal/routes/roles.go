@@ -1,27 +1,37 @@
 package routes
 
 import (
+	"database/sql"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/middleware"
+	"bome-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Role represents a user role
 type Role struct {
-	ID           string   `json:"id"`
-	Name         string   `json:"name"`
-	Slug         string   `json:"slug"`
-	Description  string   `json:"description"`
-	Category     string   `json:"category"`
-	Level        int      `json:"level"`
-	Permissions  []string `json:"permissions"`
-	IsSystemRole bool     `json:"isSystemRole"`
-	Color        string   `json:"color"`
-	Icon         string   `json:"icon"`
-	CreatedAt    string   `json:"createdAt"`
-	UpdatedAt    string   `json:"updatedAt"`
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Slug            string   `json:"slug"`
+	Description     string   `json:"description"`
+	Category        string   `json:"category"`
+	Level           int      `json:"level"`
+	Permissions     []string `json:"permissions"`
+	IsSystemRole    bool     `json:"isSystemRole"`
+	Color           string   `json:"color"`
+	Icon            string   `json:"icon"`
+	SubsystemAccess []string `json:"subsystemAccess"`
+	UserCount       int      `json:"userCount"`
+	CreatedAt       string   `json:"createdAt"`
+	UpdatedAt       string   `json:"updatedAt"`
 }
 
 // Permission represents a system permission
@@ -31,297 +41,1118 @@ type Permission struct {
 	Action      string `json:"action"`
 	Description string `json:"description"`
 	Category    string `json:"category"`
+	Subsystem   string `json:"subsystem"`
 }
 
 // UserWithRoles represents a user with role assignments
 type UserWithRoles struct {
-	ID        int      `json:"id"`
-	Email     string   `json:"email"`
-	FirstName string   `json:"firstName"`
-	LastName  string   `json:"lastName"`
-	Status    string   `json:"status"`
-	Roles     []Role   `json:"roles"`
-	RoleNames []string `json:"roleNames"`
-	LastLogin string   `json:"lastLogin"`
-	CreatedAt string   `json:"createdAt"`
-}
-
-// MOCK PERMISSIONS DATA
-var MOCK_PERMISSIONS_DATA = []Permission{
-	// User Management Permissions
-	{ID: "users:create", Resource: "users", Action: "create", Description: "Create new user accounts", Category: "core"},
-	{ID: "users:read", Resource: "users", Action: "read", Description: "View user account information", Category: "core"},
-	{ID: "users:update", Resource: "users", Action: "update", Description: "Edit user account details", Category: "core"},
-	{ID: "users:delete", Resource: "users", Action: "delete", Description: "Delete user accounts", Category: "core"},
-	{ID: "users:manage", Resource: "users", Action: "manage", Description: "Full user account management", Category: "core"},
-
-	// Content Management Permissions
-	{ID: "content:create", Resource: "content", Action: "create", Description: "Create new content", Category: "content"},
-	{ID: "content:read", Resource: "content", Action: "read", Description: "View content", Category: "content"},
-	{ID: "content:update", Resource: "content", Action: "update", Description: "Edit existing content", Category: "content"},
-	{ID: "content:delete", Resource: "content", Action: "delete", Description: "Delete content", Category: "content"},
-	{ID: "content:publish", Resource: "content", Action: "publish", Description: "Publish content to live site", Category: "content"},
-	{ID: "content:moderate", Resource: "content", Action: "moderate", Description: "Moderate user-generated content", Category: "content"},
-
-	// Video Management Permissions
-	{ID: "videos:create", Resource: "videos", Action: "create", Description: "Upload new videos", Category: "content"},
-	{ID: "videos:read", Resource: "videos", Action: "read", Description: "View video content and metadata", Category: "content"},
-	{ID: "videos:update", Resource: "videos", Action: "update", Description: "Edit video metadata and settings", Category: "content"},
-	{ID: "videos:delete", Resource: "videos", Action: "delete", Description: "Delete video content", Category: "content"},
-	{ID: "videos:manage", Resource: "videos", Action: "manage", Description: "Full video management capabilities", Category: "content"},
-
-	// Analytics Permissions
-	{ID: "analytics:read", Resource: "analytics", Action: "read", Description: "View analytics and reports", Category: "core"},
-	{ID: "analytics:export", Resource: "analytics", Action: "export", Description: "Export analytics data", Category: "core"},
-	{ID: "analytics:manage", Resource: "analytics", Action: "manage", Description: "Configure analytics settings", Category: "core"},
-
-	// System Administration Permissions
-	{ID: "system:read", Resource: "system", Action: "read", Description: "View system information", Category: "technical"},
-	{ID: "system:update", Resource: "system", Action: "update", Description: "Modify system settings", Category: "technical"},
-	{ID: "system:manage", Resource: "system", Action: "manage", Description: "Full system administration", Category: "technical"},
-
-	// Role & Permission Management
-	{ID: "roles:create", Resource: "roles", Action: "create", Description: "Create new roles", Category: "core"},
-	{ID: "roles:read", Resource: "roles", Action: "read", Description: "View role information", Category: "core"},
-	{ID: "roles:update", Resource: "roles", Action: "update", Description: "Edit existing roles", Category: "core"},
-	{ID: "roles:delete", Resource: "roles", Action: "delete", Description: "Delete roles", Category: "core"},
-	{ID: "permissions:manage", Resource: "permissions", Action: "manage", Description: "Manage user permissions", Category: "core"},
-}
-
-// MOCK ROLES DATA
-var MOCK_ROLES_DATA = []Role{
-	{
-		ID:           "super-administrator",
-		Name:         "Super Administrator",
-		Slug:         "super-administrator",
-		Description:  "Full system access and role management capabilities",
-		Category:     "core",
-		Level:        10,
-		Permissions:  getAllPermissionIDs(),
-		IsSystemRole: true,
-		Color:        "#dc2626",
-		Icon:         "crown",
-		CreatedAt:    "2024-01-01T00:00:00Z",
-		UpdatedAt:    "2024-01-01T00:00:00Z",
-	},
-	{
-		ID:           "system-administrator",
-		Name:         "System Administrator",
-		Slug:         "system-administrator",
-		Description:  "Technical system management without role changes",
-		Category:     "core",
-		Level:        9,
-		Permissions:  []string{"system:read", "system:update", "system:manage", "analytics:read", "analytics:export"},
-		IsSystemRole: true,
-		Color:        "#7c3aed",
-		Icon:         "server",
-		CreatedAt:    "2024-01-01T00:00:00Z",
-		UpdatedAt:    "2024-01-01T00:00:00Z",
-	},
-	{
-		ID:           "content-manager",
-		Name:         "Content Manager",
-		Slug:         "content-manager",
-		Description:  "Overall content strategy and oversight",
-		Category:     "core",
-		Level:        8,
-		Permissions:  []string{"content:create", "content:read", "content:update", "content:delete", "content:publish", "content:moderate", "videos:create", "videos:read", "videos:update", "videos:delete", "videos:manage", "analytics:read", "analytics:export"},
-		IsSystemRole: true,
-		Color:        "#059669",
-		Icon:         "document-text",
-		CreatedAt:    "2024-01-01T00:00:00Z",
-		UpdatedAt:    "2024-01-01T00:00:00Z",
-	},
-	{
-		ID:           "user-account-manager",
-		Name:         "User Account Manager",
-		Slug:         "user-account-manager",
-		Description:  "User management and support operations",
-		Category:     "core",
-		Level:        7,
-		Permissions:  []string{"users:create", "users:read", "users:update", "users:delete", "users:manage", "analytics:read"},
-		IsSystemRole: true,
-		Color:        "#2563eb",
-		Icon:         "users",
-		CreatedAt:    "2024-01-01T00:00:00Z",
-		UpdatedAt:    "2024-01-01T00:00:00Z",
-	},
-}
-
-// MOCK USERS WITH ROLES DATA
-var MOCK_USERS_WITH_ROLES_DATA = []UserWithRoles{
-	{
-		ID:        1,
-		Email:     "admin@bome.com",
-		FirstName: "Super",
-		LastName:  "Administrator",
-		Status:    "active",
-		Roles:     []Role{MOCK_ROLES_DATA[0]},
-		RoleNames: []string{"Super Administrator"},
-		LastLogin: "2024-04-01T12:00:00Z",
-		CreatedAt: "2024-01-01T00:00:00Z",
-	},
-	{
-		ID:        2,
-		Email:     "system@bome.com",
-		FirstName: "System",
-		LastName:  "Administrator",
-		Status:    "active",
-		Roles:     []Role{MOCK_ROLES_DATA[1]},
-		RoleNames: []string{"System Administrator"},
-		LastLogin: "2024-03-30T14:22:00Z",
-		CreatedAt: "2024-01-05T00:00:00Z",
-	},
-	{
-		ID:        3,
-		Email:     "content@bome.com",
-		FirstName: "Content",
-		LastName:  "Manager",
-		Status:    "active",
-		Roles:     []Role{MOCK_ROLES_DATA[2]},
-		RoleNames: []string{"Content Manager"},
-		LastLogin: "2024-03-29T08:15:00Z",
-		CreatedAt: "2024-01-10T00:00:00Z",
-	},
+	ID          int      `json:"id"`
+	Email       string   `json:"email"`
+	FirstName   string   `json:"firstName"`
+	LastName    string   `json:"lastName"`
+	Status      string   `json:"status"`
+	PrimaryRole string   `json:"primaryRole"`
+	Roles       []Role   `json:"roles"`
+	RoleNames   []string `json:"roleNames"`
+	LastLogin   string   `json:"lastLogin"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+// roleRequest is the body accepted when creating or updating a role
+type roleRequest struct {
+	Name            string   `json:"name" binding:"required"`
+	Description     string   `json:"description"`
+	Category        string   `json:"category"`
+	Level           int      `json:"level"`
+	Permissions     []string `json:"permissions"`
+	Color           string   `json:"color"`
+	Icon            string   `json:"icon"`
+	SubsystemAccess []string `json:"subsystemAccess"`
+}
+
+var nonSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// SeedRoles stores the standardized roles and permissions in the database.
+// Only missing rows are inserted, so roles edited through the API are preserved.
+func SeedRoles(db *database.DB) error {
+	permissions := make([]*database.Permission, 0, len(STANDARDIZED_PERMISSIONS))
+	for _, perm := range STANDARDIZED_PERMISSIONS {
+		permissions = append(permissions, &database.Permission{
+			ID:          perm.ID,
+			Resource:    perm.Resource,
+			Action:      perm.Action,
+			Description: perm.Description,
+			Category:    perm.Category,
+			Subsystem:   perm.Subsystem,
+		})
+	}
+
+	roles := make([]*database.Role, 0, len(STANDARDIZED_ROLES))
+	for _, role := range STANDARDIZED_ROLES {
+		roles = append(roles, &database.Role{
+			ID:              role.ID,
+			Name:            role.Name,
+			Slug:            role.Slug,
+			Description:     role.Description,
+			Category:        role.Category,
+			Level:           role.Level,
+			IsSystemRole:    role.IsSystemRole,
+			Color:           role.Color,
+			Icon:            role.Icon,
+			SubsystemAccess: role.SubsystemAccess,
+			Permissions:     role.Permissions,
+		})
+	}
+
+	return db.SeedRoles(roles, permissions)
+}
+
+// DatabasePermissionResolver resolves a caller's permissions from their stored roles and overrides
+func DatabasePermissionResolver(db *database.DB) middleware.PermissionResolver {
+	return func(userID int, role string) ([]string, error) {
+		return db.GetUserPermissions(userID, canonicalRoleID(role))
+	}
+}
+
+// setupPermissionResolver seeds the role tables and resolves permissions from them,
+// falling back to the compiled-in role definitions when the database is unavailable
+func setupPermissionResolver(db *database.DB) {
+	if db == nil {
+		middleware.SetPermissionResolver(StandardizedPermissionResolver)
+		return
+	}
+
+	if err := SeedRoles(db); err != nil {
+		log.Printf("Failed to seed roles, using built-in role definitions: %v", err)
+		middleware.SetPermissionResolver(StandardizedPermissionResolver)
+		return
+	}
+
+	middleware.SetPermissionResolver(DatabasePermissionResolver(db))
+}
+
+// roleResponse converts a stored role to its API representation
+func roleResponse(role *database.Role, userCount int) Role {
+	permissions := role.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	subsystemAccess := role.SubsystemAccess
+	if subsystemAccess == nil {
+		subsystemAccess = []string{}
+	}
+	return Role{
+		ID:              role.ID,
+		Name:            role.Name,
+		Slug:            role.Slug,
+		Description:     role.Description,
+		Category:        role.Category,
+		Level:           role.Level,
+		Permissions:     permissions,
+		IsSystemRole:    role.IsSystemRole,
+		Color:           role.Color,
+		Icon:            role.Icon,
+		SubsystemAccess: subsystemAccess,
+		UserCount:       userCount,
+		CreatedAt:       role.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       role.UpdatedAt.Format(time.RFC3339),
+	}
 }
 
-// Helper function to get all permission IDs
-func getAllPermissionIDs() []string {
-	var ids []string
-	for _, perm := range MOCK_PERMISSIONS_DATA {
-		ids = append(ids, perm.ID)
+// roleUserCounts returns user counts per role with legacy role names folded into their equivalents
+func roleUserCounts(db *database.DB) (map[string]int, error) {
+	counts, err := db.GetRoleUserCounts()
+	if err != nil {
+		return nil, err
 	}
-	return ids
+	for legacy, roleID := range legacyRoleAliases {
+		if count, ok := counts[legacy]; ok {
+			counts[roleID] += count
+			delete(counts, legacy)
+		}
+	}
+	return counts, nil
+}
+
+// validatePermissionIDs returns the permission IDs that do not exist
+func validatePermissionIDs(db *database.DB, permissionIDs []string) ([]string, error) {
+	permissions, err := db.GetPermissions()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		known[permission.ID] = true
+	}
+
+	var unknown []string
+	for _, permissionID := range permissionIDs {
+		if !known[permissionID] {
+			unknown = append(unknown, permissionID)
+		}
+	}
+	return unknown, nil
+}
+
+// callerMissingPermissions returns the permissions the caller would grant without holding them.
+// Callers may only hand out permissions they hold themselves.
+func callerMissingPermissions(c *gin.Context, permissionIDs []string) []string {
+	held := make(map[string]bool)
+	for _, permission := range c.GetStringSlice("user_permissions") {
+		held[permission] = true
+	}
+	if held["system:full_access"] {
+		return nil
+	}
+
+	var missing []string
+	for _, permissionID := range permissionIDs {
+		if !held[permissionID] {
+			missing = append(missing, permissionID)
+		}
+	}
+	return missing
+}
+
+// addedPermissions returns the permissions in next that are not in current. With the
+// arguments swapped it returns the permissions that were removed.
+func addedPermissions(current, next []string) []string {
+	existing := make(map[string]bool, len(current))
+	for _, permission := range current {
+		existing[permission] = true
+	}
+	var added []string
+	for _, permission := range next {
+		if !existing[permission] {
+			added = append(added, permission)
+		}
+	}
+	return added
+}
+
+// uniqueStrings removes empty and duplicate values while preserving order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := []string{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	return result
+}
+
+// checkGrantablePermissions validates permission IDs and ensures the caller holds them.
+// It writes the error response and returns false if the permissions cannot be granted.
+func checkGrantablePermissions(c *gin.Context, db *database.DB, permissionIDs, grantable []string) bool {
+	unknown, err := validatePermissionIDs(db, permissionIDs)
+	if err != nil {
+		log.Printf("Failed to load permissions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Service temporarily unavailable. Please try again later.",
+		})
+		return false
+	}
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":               "Unknown permissions",
+			"unknown_permissions": unknown,
+		})
+		return false
+	}
+	if missing := callerMissingPermissions(c, grantable); len(missing) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":               "You cannot grant permissions you do not hold",
+			"missing_permissions": missing,
+		})
+		return false
+	}
+	return true
+}
+
+// checkCanManageUser writes a 403 response unless the caller may change the target
+// user's roles and permissions. Nobody may change their own account, and the caller
+// must hold every permission the target currently has, so permissions cannot be used
+// against someone with more access.
+func checkCanManageUser(c *gin.Context, db *database.DB, userID int) bool {
+	if userID == c.GetInt("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own roles or permissions"})
+		return false
+	}
+
+	user, err := db.GetUserByID(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service temporarily unavailable. Please try again later."})
+		return false
+	}
+
+	permissions, err := db.GetUserPermissions(user.ID, canonicalRoleID(user.Role))
+	if err != nil {
+		log.Printf("Failed to load permissions for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service temporarily unavailable. Please try again later."})
+		return false
+	}
+	if missing := callerMissingPermissions(c, permissions); len(missing) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":               "You cannot change the access of a user with permissions you do not hold",
+			"missing_permissions": missing,
+		})
+		return false
+	}
+	return true
+}
+
+// parseUserIDParam reads the :id path parameter, writing a 400 response if it is invalid
+func parseUserIDParam(c *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// recordAdminAudit writes a high-severity audit log entry for an administrative action by the authenticated user
+func recordAdminAudit(c *gin.Context, db *database.DB, action, resource, resourceID, details string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	userEmail := c.GetString("user_email")
+	auditLog := &database.AuditLog{
+		UserID:     &userID,
+		UserEmail:  &userEmail,
+		Action:     action,
+		Resource:   resource,
+		ResourceID: &resourceID,
+		IPAddress:  services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Status:     "success",
+		Details:    &details,
+		Metadata:   metadata,
+		Severity:   "high",
+	}
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
+
+// serviceUnavailable writes the standard response for a missing or failing database
+func serviceUnavailable(c *gin.Context) {
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": "Service temporarily unavailable. Please try again later.",
+	})
 }
 
 // ROLES ENDPOINTS
 
 // GetRolesHandler returns all roles with optional filtering
-func GetRolesHandler(c *gin.Context) {
-	search := c.Query("search")
-	category := c.Query("category")
-
-	filteredRoles := MOCK_ROLES_DATA
-
-	// Apply search filter
-	if search != "" {
-		searchLower := strings.ToLower(search)
-		filtered := []Role{}
-		for _, role := range filteredRoles {
-			if strings.Contains(strings.ToLower(role.Name), searchLower) ||
-				strings.Contains(strings.ToLower(role.Description), searchLower) {
-				filtered = append(filtered, role)
-			}
+func GetRolesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		roles, err := db.GetRoles(c.Query("search"), c.Query("category"))
+		if err != nil {
+			log.Printf("Failed to get roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+			return
 		}
-		filteredRoles = filtered
+		counts, err := roleUserCounts(db)
+		if err != nil {
+			log.Printf("Failed to get role user counts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+			return
+		}
+
+		result := make([]Role, 0, len(roles))
+		for _, role := range roles {
+			result = append(result, roleResponse(role, counts[role.ID]))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"roles": result})
 	}
+}
 
-	// Apply category filter
-	if category != "" {
-		filtered := []Role{}
-		for _, role := range filteredRoles {
-			if strings.EqualFold(role.Category, category) {
-				filtered = append(filtered, role)
-			}
+// GetRoleHandler returns a single role
+func GetRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		role, err := db.GetRole(c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role"})
+			return
+		}
+		counts, err := roleUserCounts(db)
+		if err != nil {
+			log.Printf("Failed to get role user counts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"role": roleResponse(role, counts[role.ID])})
+	}
+}
+
+// CreateRoleHandler creates a custom role
+func CreateRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req roleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		slug := strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(name), "-"), "-")
+		if slug == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role name must contain letters or numbers"})
+			return
+		}
+		if req.Level < 1 || req.Level > 10 {
+			req.Level = 1
+		}
+
+		permissions := uniqueStrings(req.Permissions)
+		if !checkGrantablePermissions(c, db, permissions, permissions) {
+			return
+		}
+
+		role := &database.Role{
+			ID:              strings.ReplaceAll(slug, "-", "_"),
+			Name:            name,
+			Slug:            slug,
+			Description:     req.Description,
+			Category:        req.Category,
+			Level:           req.Level,
+			Color:           req.Color,
+			Icon:            req.Icon,
+			SubsystemAccess: uniqueStrings(req.SubsystemAccess),
+			Permissions:     permissions,
+		}
+
+		if _, err := db.GetRole(role.ID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+			return
+		} else if err != sql.ErrNoRows {
+			log.Printf("Failed to check role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
 		}
-		filteredRoles = filtered
+
+		if err := db.CreateRole(role); err != nil {
+			log.Printf("Failed to create role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
+		}
+
+		recordAdminAudit(c, db, "role_created", "role", role.ID, "Role created", map[string]interface{}{
+			"permissions": role.Permissions,
+		})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Role created successfully",
+			"role":    roleResponse(role, 0),
+		})
 	}
+}
+
+// UpdateRoleHandler updates a role's details and permissions. The caller must hold
+// every permission they add or remove.
+func UpdateRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		role, err := db.GetRole(c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+		if role.ID == "super_admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The Super Administrator role cannot be modified"})
+			return
+		}
+
+		var req roleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
 
-	c.JSON(http.StatusOK, gin.H{"roles": filteredRoles})
+		permissions := uniqueStrings(req.Permissions)
+		if !checkGrantablePermissions(c, db, permissions, addedPermissions(role.Permissions, permissions)) {
+			return
+		}
+		// Removing a permission affects every holder of the role, so it needs the same
+		// standing as removing the role from each of them
+		if missing := callerMissingPermissions(c, addedPermissions(permissions, role.Permissions)); len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":               "You cannot remove permissions you do not hold",
+				"missing_permissions": missing,
+			})
+			return
+		}
+
+		previous := role.Permissions
+		role.Name = strings.TrimSpace(req.Name)
+		role.Description = req.Description
+		role.Category = req.Category
+		if req.Level >= 1 && req.Level <= 10 {
+			role.Level = req.Level
+		}
+		role.Color = req.Color
+		role.Icon = req.Icon
+		role.SubsystemAccess = uniqueStrings(req.SubsystemAccess)
+		role.Permissions = permissions
+
+		if err := db.UpdateRole(role); err != nil {
+			log.Printf("Failed to update role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+
+		recordAdminAudit(c, db, "role_updated", "role", role.ID, "Role updated", map[string]interface{}{
+			"previous_permissions": previous,
+			"permissions":          role.Permissions,
+		})
+
+		updated, err := db.GetRole(role.ID)
+		if err != nil {
+			updated = role
+		}
+		counts, _ := roleUserCounts(db)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Role updated successfully",
+			"role":    roleResponse(updated, counts[role.ID]),
+		})
+	}
+}
+
+// DeleteRoleHandler deletes a custom role
+func DeleteRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		role, err := db.GetRole(c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+		if role.IsSystemRole {
+			c.JSON(http.StatusForbidden, gin.H{"error": "System roles cannot be deleted"})
+			return
+		}
+
+		primaryUsers, err := db.CountUsersWithPrimaryRole(role.ID)
+		if err != nil {
+			log.Printf("Failed to count users with role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+		if primaryUsers > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "Role is the primary role of existing users. Reassign them before deleting it.",
+				"user_count": primaryUsers,
+			})
+			return
+		}
+
+		if err := db.DeleteRole(role.ID); err != nil {
+			log.Printf("Failed to delete role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+
+		recordAdminAudit(c, db, "role_deleted", "role", role.ID, "Role deleted", map[string]interface{}{
+			"permissions": role.Permissions,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+	}
 }
 
 // GetPermissionsHandler returns all permissions
-func GetPermissionsHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"permissions": MOCK_PERMISSIONS_DATA})
+func GetPermissionsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		permissions, err := db.GetPermissions()
+		if err != nil {
+			log.Printf("Failed to get permissions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions"})
+			return
+		}
+
+		result := make([]Permission, 0, len(permissions))
+		for _, permission := range permissions {
+			result = append(result, Permission{
+				ID:          permission.ID,
+				Resource:    permission.Resource,
+				Action:      permission.Action,
+				Description: permission.Description,
+				Category:    permission.Category,
+				Subsystem:   permission.Subsystem,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"permissions": result})
+	}
 }
 
 // GetUsersWithRolesHandler returns users with their role assignments
-func GetUsersWithRolesHandler(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	search := c.Query("search")
-
-	filteredUsers := MOCK_USERS_WITH_ROLES_DATA
-
-	// Apply search filter
-	if search != "" {
-		searchLower := strings.ToLower(search)
-		filtered := []UserWithRoles{}
-		for _, user := range filteredUsers {
-			if strings.Contains(strings.ToLower(user.Email), searchLower) ||
-				strings.Contains(strings.ToLower(user.FirstName), searchLower) ||
-				strings.Contains(strings.ToLower(user.LastName), searchLower) {
-				filtered = append(filtered, user)
+func GetUsersWithRolesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		users, total, err := db.GetUsersWithRoles(limit, (page-1)*limit, c.Query("search"))
+		if err != nil {
+			log.Printf("Failed to get users with roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+			return
+		}
+		roles, err := db.GetRoles("", "")
+		if err != nil {
+			log.Printf("Failed to get roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+			return
+		}
+		rolesByID := make(map[string]*database.Role, len(roles))
+		for _, role := range roles {
+			rolesByID[role.ID] = role
+		}
+
+		result := make([]UserWithRoles, 0, len(users))
+		for _, user := range users {
+			entry := UserWithRoles{
+				ID:          user.ID,
+				Email:       user.Email,
+				FirstName:   user.FirstName,
+				LastName:    user.LastName,
+				Status:      "active",
+				PrimaryRole: user.Role,
+				Roles:       []Role{},
+				RoleNames:   []string{},
+				CreatedAt:   user.CreatedAt.Format(time.RFC3339),
+			}
+			if !user.IsActive {
+				entry.Status = "inactive"
+			}
+			if user.LastLogin.Valid {
+				entry.LastLogin = user.LastLogin.Time.Format(time.RFC3339)
 			}
+
+			for _, roleID := range uniqueStrings(append([]string{canonicalRoleID(user.Role)}, user.RoleIDs...)) {
+				if role, ok := rolesByID[roleID]; ok {
+					entry.Roles = append(entry.Roles, roleResponse(role, 0))
+					entry.RoleNames = append(entry.RoleNames, role.Name)
+				}
+			}
+			result = append(result, entry)
 		}
-		filteredUsers = filtered
-	}
 
-	// Apply pagination
-	startIndex := (page - 1) * limit
-	endIndex := startIndex + limit
-	if startIndex >= len(filteredUsers) {
-		filteredUsers = []UserWithRoles{}
-	} else if endIndex > len(filteredUsers) {
-		filteredUsers = filteredUsers[startIndex:]
-	} else {
-		filteredUsers = filteredUsers[startIndex:endIndex]
+		c.JSON(http.StatusOK, gin.H{
+			"users": result,
+			"pagination": gin.H{
+				"page":       page,
+				"limit":      limit,
+				"total":      total,
+				"totalPages": (total + limit - 1) / limit,
+			},
+		})
 	}
+}
 
-	totalPages := (len(MOCK_USERS_WITH_ROLES_DATA) + limit - 1) / limit
+// GetRoleAnalyticsHandler returns role usage analytics
+func GetRoleAnalyticsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
 
-	c.JSON(http.StatusOK, gin.H{
-		"users": filteredUsers,
-		"pagination": gin.H{
-			"page":       page,
-			"limit":      limit,
-			"total":      len(MOCK_USERS_WITH_ROLES_DATA),
-			"totalPages": totalPages,
-		},
-	})
+		roles, err := db.GetRoles("", "")
+		if err != nil {
+			log.Printf("Failed to get roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role analytics"})
+			return
+		}
+		counts, err := roleUserCounts(db)
+		if err != nil {
+			log.Printf("Failed to get role user counts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role analytics"})
+			return
+		}
+		totalUsers, err := db.GetUserCount()
+		if err != nil {
+			log.Printf("Failed to get user count: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role analytics"})
+			return
+		}
+		activeOverrides, err := db.CountActivePermissionOverrides()
+		if err != nil {
+			log.Printf("Failed to count permission overrides: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role analytics"})
+			return
+		}
+
+		systemRoles := 0
+		distribution := []gin.H{}
+		for _, role := range roles {
+			if role.IsSystemRole {
+				systemRoles++
+			}
+			userCount := counts[role.ID]
+			if userCount == 0 {
+				continue
+			}
+			percentage := 0.0
+			if totalUsers > 0 {
+				percentage = float64(int(float64(userCount)/float64(totalUsers)*1000)) / 10
+			}
+			distribution = append(distribution, gin.H{
+				"roleId":     role.ID,
+				"roleName":   role.Name,
+				"userCount":  userCount,
+				"percentage": percentage,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"analytics": gin.H{
+				"totalRoles":       len(roles),
+				"systemRoles":      systemRoles,
+				"customRoles":      len(roles) - systemRoles,
+				"totalUsers":       totalUsers,
+				"activeOverrides":  activeOverrides,
+				"roleDistribution": distribution,
+			},
+		})
+	}
 }
 
-// GetRoleAnalyticsHandler returns role usage analytics
-func GetRoleAnalyticsHandler(c *gin.Context) {
-	analytics := gin.H{
-		"totalRoles": len(MOCK_ROLES_DATA),
-		"systemRoles": func() int {
-			count := 0
-			for _, role := range MOCK_ROLES_DATA {
-				if role.IsSystemRole {
-					count++
-				}
+// USER ROLE ASSIGNMENT ENDPOINTS
+
+// GetUserRolesHandler returns a user's roles, permission overrides and effective permissions
+func GetUserRolesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
+			return
+		}
+
+		assignments, err := db.GetUserRoleAssignments(userID)
+		if err != nil {
+			log.Printf("Failed to get role assignments for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
+			return
+		}
+		overrides, err := db.GetUserPermissionOverrides(userID)
+		if err != nil {
+			log.Printf("Failed to get permission overrides for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
+			return
+		}
+		permissions, err := db.GetUserPermissions(userID, canonicalRoleID(user.Role))
+		if err != nil {
+			log.Printf("Failed to resolve permissions for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
+			return
+		}
+		if permissions == nil {
+			permissions = []string{}
+		}
+
+		roles := make([]gin.H, 0, len(assignments))
+		for _, assignment := range assignments {
+			entry := gin.H{
+				"roleId":     assignment.RoleID,
+				"assignedAt": assignment.CreatedAt,
 			}
-			return count
-		}(),
-		"customRoles": func() int {
-			count := 0
-			for _, role := range MOCK_ROLES_DATA {
-				if !role.IsSystemRole {
-					count++
-				}
+			if assignment.AssignedBy.Valid {
+				entry["assignedBy"] = assignment.AssignedBy.Int64
+			}
+			if assignment.ExpiresAt.Valid {
+				entry["expiresAt"] = assignment.ExpiresAt.Time
 			}
-			return count
-		}(),
-		"totalUsers": len(MOCK_USERS_WITH_ROLES_DATA),
-		"roleDistribution": []gin.H{
-			{"roleName": "Super Administrator", "userCount": 1, "percentage": 33.3},
-			{"roleName": "System Administrator", "userCount": 1, "percentage": 33.3},
-			{"roleName": "Content Manager", "userCount": 1, "percentage": 33.3},
-		},
+			roles = append(roles, entry)
+		}
+
+		permissionOverrides := make([]gin.H, 0, len(overrides))
+		for _, override := range overrides {
+			entry := gin.H{
+				"permissionId": override.PermissionID,
+				"effect":       override.Effect,
+				"createdAt":    override.CreatedAt,
+			}
+			if override.Reason.Valid {
+				entry["reason"] = override.Reason.String
+			}
+			if override.GrantedBy.Valid {
+				entry["grantedBy"] = override.GrantedBy.Int64
+			}
+			if override.ExpiresAt.Valid {
+				entry["expiresAt"] = override.ExpiresAt.Time
+			}
+			permissionOverrides = append(permissionOverrides, entry)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"userId":               userID,
+			"primaryRole":          user.Role,
+			"roles":                roles,
+			"permissionOverrides":  permissionOverrides,
+			"effectivePermissions": permissions,
+		})
 	}
+}
+
+// AssignUserRoleHandler assigns an additional role to a user, optionally until an expiry time
+func AssignUserRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req struct {
+			RoleID    string     `json:"roleId" binding:"required"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+			return
+		}
+		if userID == c.GetInt("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own roles or permissions"})
+			return
+		}
+
+		if _, err := db.GetUserByID(userID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		} else if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+			return
+		}
+
+		role, err := db.GetRole(req.RoleID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role %s: %v", req.RoleID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+			return
+		}
+		if missing := callerMissingPermissions(c, role.Permissions); len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":               "You cannot assign a role with permissions you do not hold",
+				"missing_permissions": missing,
+			})
+			return
+		}
+
+		assignment := &database.UserRoleAssignment{
+			UserID:     userID,
+			RoleID:     role.ID,
+			AssignedBy: sql.NullInt64{Int64: int64(c.GetInt("user_id")), Valid: c.GetInt("user_id") != 0},
+		}
+		if req.ExpiresAt != nil {
+			assignment.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+		}
+		if err := db.AssignUserRole(assignment); err != nil {
+			log.Printf("Failed to assign role %s to user %d: %v", role.ID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+			return
+		}
+
+		recordAdminAudit(c, db, "user_role_assigned", "user", strconv.Itoa(userID), "Role assigned to user", map[string]interface{}{
+			"role_id":    role.ID,
+			"expires_at": req.ExpiresAt,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Role assigned successfully",
+			"userId":    userID,
+			"roleId":    role.ID,
+			"expiresAt": req.ExpiresAt,
+		})
+	}
+}
+
+// RemoveUserRoleHandler removes an additional role from a user
+func RemoveUserRoleHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		if !checkCanManageUser(c, db, userID) {
+			return
+		}
+
+		roleID := c.Param("roleId")
+		role, err := db.GetRole(roleID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get role %s: %v", roleID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove role"})
+			return
+		}
+		if missing := callerMissingPermissions(c, role.Permissions); len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":               "You cannot remove a role with permissions you do not hold",
+				"missing_permissions": missing,
+			})
+			return
+		}
+
+		if err := db.RemoveUserRole(userID, roleID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role assignment not found"})
+			return
+		} else if err != nil {
+			log.Printf("Failed to remove role %s from user %d: %v", roleID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove role"})
+			return
+		}
+
+		recordAdminAudit(c, db, "user_role_removed", "user", strconv.Itoa(userID), "Role removed from user", map[string]interface{}{
+			"role_id": roleID,
+		})
 
-	c.JSON(http.StatusOK, gin.H{"analytics": analytics})
+		c.JSON(http.StatusOK, gin.H{"message": "Role removed successfully"})
+	}
+}
+
+// SetUserPermissionOverrideHandler grants or denies a single permission to a user, optionally until an expiry time
+func SetUserPermissionOverrideHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req struct {
+			PermissionID string     `json:"permissionId" binding:"required"`
+			Effect       string     `json:"effect"`
+			Reason       string     `json:"reason"`
+			ExpiresAt    *time.Time `json:"expiresAt"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Effect == "" {
+			req.Effect = database.PermissionEffectGrant
+		}
+		if req.Effect != database.PermissionEffectGrant && req.Effect != database.PermissionEffectDeny {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Effect must be 'grant' or 'deny'"})
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+			return
+		}
+		if !checkCanManageUser(c, db, userID) {
+			return
+		}
+
+		// A deny can only take away a permission the caller could have granted
+		if !checkGrantablePermissions(c, db, []string{req.PermissionID}, []string{req.PermissionID}) {
+			return
+		}
+
+		override := &database.PermissionOverride{
+			UserID:       userID,
+			PermissionID: req.PermissionID,
+			Effect:       req.Effect,
+			Reason:       sql.NullString{String: req.Reason, Valid: req.Reason != ""},
+			GrantedBy:    sql.NullInt64{Int64: int64(c.GetInt("user_id")), Valid: c.GetInt("user_id") != 0},
+		}
+		if req.ExpiresAt != nil {
+			override.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+		}
+		if err := db.SetUserPermissionOverride(override); err != nil {
+			log.Printf("Failed to set permission override for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set permission override"})
+			return
+		}
+
+		recordAdminAudit(c, db, "user_permission_override_set", "user", strconv.Itoa(userID), "Permission override set for user", map[string]interface{}{
+			"permission_id": req.PermissionID,
+			"effect":        req.Effect,
+			"reason":        req.Reason,
+			"expires_at":    req.ExpiresAt,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Permission override saved successfully",
+			"userId":       userID,
+			"permissionId": req.PermissionID,
+			"effect":       req.Effect,
+			"expiresAt":    req.ExpiresAt,
+		})
+	}
+}
+
+// RemoveUserPermissionOverrideHandler removes a user's override for a permission
+func RemoveUserPermissionOverrideHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := parseUserIDParam(c)
+		if !ok {
+			return
+		}
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		if !checkCanManageUser(c, db, userID) {
+			return
+		}
+
+		// Removing a grant takes the permission away and removing a deny gives it back,
+		// so either way the caller must hold it
+		permissionID := c.Param("permissionId")
+		if missing := callerMissingPermissions(c, []string{permissionID}); len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":               "You cannot change a permission you do not hold",
+				"missing_permissions": missing,
+			})
+			return
+		}
+
+		if err := db.RemoveUserPermissionOverride(userID, permissionID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Permission override not found"})
+			return
+		} else if err != nil {
+			log.Printf("Failed to remove permission override %s from user %d: %v", permissionID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove permission override"})
+			return
+		}
+
+		recordAdminAudit(c, db, "user_permission_override_removed", "user", strconv.Itoa(userID), "Permission override removed from user", map[string]interface{}{
+			"permission_id": permissionID,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Permission override removed successfully"})
+	}
 }
 
 // SetupRolesRoutes sets up all roles management routes
-func SetupRolesRoutes(router *gin.RouterGroup) {
+func SetupRolesRoutes(router *gin.RouterGroup, db *database.DB) {
 	api := router.Group("/api/v1")
+	api.Use(middleware.AuthRequired(), middleware.SessionActivityTracker(db))
 	{
 		// Roles endpoints
-		api.GET("/roles", GetRolesHandler)
-		api.GET("/permissions", GetPermissionsHandler)
-		api.GET("/users/roles", GetUsersWithRolesHandler)
-		api.GET("/roles/analytics", GetRoleAnalyticsHandler)
+		api.GET("/roles", middleware.RequirePermission(db, "roles:read"), GetRolesHandler(db))
+		api.GET("/roles/analytics", middleware.RequirePermission(db, "roles:read"), GetRoleAnalyticsHandler(db))
+		api.GET("/roles/:id", middleware.RequirePermission(db, "roles:read"), GetRoleHandler(db))
+		api.POST("/roles", middleware.RequirePermission(db, "roles:create"), CreateRoleHandler(db))
+		api.PUT("/roles/:id", middleware.RequirePermission(db, "roles:update"), UpdateRoleHandler(db))
+		api.DELETE("/roles/:id", middleware.RequirePermission(db, "roles:delete"), DeleteRoleHandler(db))
+		api.GET("/permissions", middleware.RequirePermission(db, "roles:read"), GetPermissionsHandler(db))
+
+		// User role assignments and permission overrides
+		api.GET("/users/roles", middleware.RequirePermission(db, "roles:read", "users:read"), GetUsersWithRolesHandler(db))
+		api.GET("/users/:id/roles", middleware.RequirePermission(db, "roles:read", "users:read"), GetUserRolesHandler(db))
+		api.POST("/users/:id/roles", middleware.RequirePermission(db, "permissions:manage"), AssignUserRoleHandler(db))
+		api.DELETE("/users/:id/roles/:roleId", middleware.RequirePermission(db, "permissions:manage"), RemoveUserRoleHandler(db))
+		api.POST("/users/:id/permissions", middleware.RequirePermission(db, "permissions:manage"), SetUserPermissionOverrideHandler(db))
+		api.DELETE("/users/:id/permissions/:permissionId", middleware.RequirePermission(db, "permissions:manage"), RemoveUserPermissionOverrideHandler(db))
 	}
 }
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStandardizedPermissionResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		contains string
+		want     bool
+	}{
+		{name: "user reads content", role: "user", contains: "content:read", want: true},
+		{name: "user cannot delete users", role: "user", contains: "users:delete"},
+		{name: "super admin has full access", role: "super_admin", contains: "system:full_access", want: true},
+		{name: "legacy admin resolves as super admin", role: "admin", contains: "system:full_access", want: true},
+		{name: "unknown role has nothing", role: "nobody", contains: "content:read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions, err := StandardizedPermissionResolver(1, tt.role)
+			if err != nil {
+				t.Fatalf("StandardizedPermissionResolver failed: %v", err)
+			}
+			found := false
+			for _, permission := range permissions {
+				found = found || permission == tt.contains
+			}
+			if found != tt.want {
+				t.Errorf("role %s has %s = %v, want %v", tt.role, tt.contains, found, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallerMissingPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		held        []string
+		permissions []string
+		want        []string
+	}{
+		{name: "holds everything", held: []string{"videos:read", "videos:create"}, permissions: []string{"videos:read"}},
+		{name: "missing one", held: []string{"videos:read"}, permissions: []string{"videos:read", "users:delete"}, want: []string{"users:delete"}},
+		{name: "holds nothing", permissions: []string{"videos:read", "users:delete"}, want: []string{"videos:read", "users:delete"}},
+		{name: "full access holds everything", held: []string{"system:full_access"}, permissions: []string{"users:delete", "roles:update"}},
+		{name: "nothing requested", held: []string{"videos:read"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.held != nil {
+				c.Set("user_permissions", tt.held)
+			}
+			if got := callerMissingPermissions(c, tt.permissions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("callerMissingPermissions(%v) = %v, want %v", tt.permissions, got, tt.want)
+			}
+		})
+	}
+}
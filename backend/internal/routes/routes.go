@@ -1,12 +1,11 @@
 package routes
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
-	"mime/multipart"
+	"log"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -77,6 +76,11 @@ func SetupRoutes(
 	stripeService *services.StripeService,
 	spacesService *services.SpacesService,
 	emailService *services.EmailService,
+	privacyService *services.PrivacyService,
+	videoUploads *services.VideoUploadService,
+	bunnySync *services.BunnySyncService,
+	playback *services.PlaybackService,
+	recommendations *services.RecommendationService,
 ) {
 	// Debug logging
 	fmt.Printf("Setting up routes...\n")
@@ -90,21 +94,71 @@ func SetupRoutes(
 	})
 	fmt.Printf("Registered health check endpoint\n")
 
+	// Public keys for verifying issued tokens
+	router.GET("/.well-known/jwks.json", JWKSHandler())
+
+	// Resolve permissions for RequirePermission from the stored roles
+	setupPermissionResolver(db)
+
+	// Audit IP blocks and alert users about sign-ins from new devices
+	setupLoginProtection(db, emailService)
+
+	// Audit every write made while staff impersonate a user
+	setupImpersonationAudit(db)
+
+	// Applies Bunny Stream encoding webhooks to videos
+	bunnyWebhooks := services.NewBunnyWebhookProcessor(db, bunnyService, emailService)
+
+	// Accept personal access tokens and API keys in AuthRequired
+	if db != nil {
+		middleware.SetAPITokenAuthenticator(func(token, ipAddress string) (*services.APITokenPrincipal, error) {
+			return services.AuthenticateAPIToken(db, token, ipAddress)
+		})
+	}
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	fmt.Printf("Created v1 route group with base path: %s\n", v1.BasePath())
 
 	// Admin routes
 	admin := v1.Group("/admin")
-	SetupAdminRoutes(admin, db)
-	SetupAnalyticsRoutes(admin)
+	SetupAdminRoutes(admin, db, privacyService)
+	SetupAnalyticsRoutes(admin, db)
+	admin.GET("/api-keys", middleware.AuthRequired(), middleware.RequirePermission(db, "system:manage"), middleware.SessionActivityTracker(db), ListAPIKeysHandler(db))
+	admin.POST("/api-keys", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.RequirePermission(db, "system:manage"), middleware.SessionActivityTracker(db), CreateAPIKeyHandler(db))
+	admin.DELETE("/api-keys/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "system:manage"), middleware.SessionActivityTracker(db), RevokeAPIKeyHandler(db))
+	admin.POST("/security/signing-keys/rotate", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.RequirePermission(db, "security:manage"), middleware.SessionActivityTracker(db), RotateSigningKeyHandler(db))
+	admin.POST("/users/:id/impersonate", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.RequirePermission(db, "technical:support"), middleware.SessionActivityTracker(db), StartImpersonationHandler(db))
+	admin.GET("/invitations", middleware.AuthRequired(), middleware.RequirePermission(db, "users:create"), middleware.SessionActivityTracker(db), ListInvitationsHandler(db))
+	admin.POST("/invitations", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.RequirePermission(db, "users:create"), middleware.SessionActivityTracker(db), CreateInvitationHandler(db, emailService))
+	admin.DELETE("/invitations/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "users:create"), middleware.SessionActivityTracker(db), RevokeInvitationHandler(db))
+	admin.GET("/webhooks/bunny/events", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), ListBunnyWebhookEventsHandler(db))
+	admin.POST("/webhooks/bunny/events/:id/replay", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), ReplayBunnyWebhookEventHandler(db, bunnyWebhooks))
+	admin.GET("/bunny-sync/jobs", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), ListBunnySyncJobsHandler(db))
+	admin.POST("/bunny-sync/jobs", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), StartBunnySyncHandler(db, bunnySync))
+	admin.GET("/bunny-sync/jobs/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), GetBunnySyncJobHandler(db))
+	admin.GET("/series", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), ListSeriesHandler(db))
+	admin.POST("/series", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), CreateSeriesHandler(db))
+	admin.GET("/series/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), GetSeriesHandler(db))
+	admin.PUT("/series/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), UpdateSeriesHandler(db))
+	admin.DELETE("/series/:id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), DeleteSeriesHandler(db))
+	admin.POST("/series/:id/cover", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), UploadSeriesCoverHandler(db, spacesService))
+	admin.POST("/series/:id/items", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), AddSeriesItemHandler(db))
+	admin.PUT("/series/:id/items/order", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), ReorderSeriesItemsHandler(db))
+	admin.DELETE("/series/:id/items/:video_id", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), RemoveSeriesItemHandler(db))
+	admin.POST("/captions/validate", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:update"), middleware.SessionActivityTracker(db), ValidateCaptionsHandler())
+	admin.GET("/videos/:id/captions", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:read"), middleware.SessionActivityTracker(db), ListVideoCaptionsHandler(db))
+	admin.PUT("/videos/:id/captions/:language", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:update"), middleware.SessionActivityTracker(db), UploadVideoCaptionHandler(db, spacesService, bunnyService))
+	admin.DELETE("/videos/:id/captions/:language", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:update"), middleware.SessionActivityTracker(db), DeleteVideoCaptionHandler(db, spacesService, bunnyService))
+	admin.POST("/recommendations/refresh", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), RefreshRecommendationsHandler(db, recommendations))
+	admin.DELETE("/security/ip-blocks/:ip", middleware.AuthRequired(), middleware.RequirePermission(db, "security:manage"), middleware.SessionActivityTracker(db), UnblockIPHandler(db))
 	fmt.Printf("Admin routes setup complete\n")
 
 	// Setup all mock data routes for development/testing
 	fmt.Printf("Setting up mock data routes...\n")
 	SetupMockDataRoutes(v1)
 	SetupArticlesRoutes(v1)
-	SetupRolesRoutes(v1)
+	SetupRolesRoutes(v1, db)
 	SetupStandardizedRolesRoutes(v1)
 	SetupYouTubeRoutes(v1, db)
 	fmt.Printf("Mock data routes setup complete\n")
@@ -114,7 +168,29 @@ func SetupRoutes(
 	{
 		auth.POST("/login", LoginHandler(db))
 		auth.POST("/register", RegisterHandler(db, emailService))
+		auth.POST("/refresh", RefreshTokenHandler(db))
 		auth.POST("/logout", LogoutHandler(db))
+		auth.GET("/csrf-token", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), CSRFTokenHandler())
+		auth.POST("/login-alert/revoke", LoginAlertRevokeHandler(db))
+		auth.POST("/invitations/accept", AcceptInvitationHandler(db))
+
+		// Support staff viewing the application as a user
+		auth.GET("/impersonation", middleware.AuthRequired(), ImpersonationStatusHandler())
+		auth.POST("/impersonation/stop", middleware.AuthRequired(), StopImpersonationHandler(db))
+
+		// Two-factor authentication login step and enrollment during login
+		auth.POST("/mfa/verify", VerifyMFAHandler(db))
+		auth.POST("/mfa/enroll", BeginMFAEnrollmentHandler(db))
+		auth.POST("/mfa/enroll/confirm", ConfirmMFAEnrollmentHandler(db))
+
+		// Passwordless sign-in with single-use email links
+		auth.POST("/magic-link", RequestMagicLinkHandler(db, emailService))
+		auth.POST("/magic-link/verify", VerifyMagicLinkHandler(db))
+
+		// OpenID Connect social sign-in
+		auth.GET("/oidc/providers", ListOIDCProvidersHandler())
+		auth.POST("/oidc/:provider/authorize", OIDCAuthorizeHandler(db))
+		auth.POST("/oidc/:provider/callback", OIDCCallbackHandler(db))
 	}
 
 	// Video routes using database handlers with bunny.net integration
@@ -132,7 +208,7 @@ func SetupRoutes(
 		})
 
 		videos.GET("/categories", GetMockCategoriesHandler) // Must come before /:id
-		videos.GET("/:id", func(c *gin.Context) {
+		videos.GET("/:id", middleware.AuthIfPresent(), func(c *gin.Context) {
 			videoID := c.Param("id")
 			if videoID == "" {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Video ID is required"})
@@ -175,6 +251,18 @@ func SetupRoutes(
 					"library_id":    bunnyVideo.LibraryID,
 				}
 
+				// Captions are kept for videos imported into the library, and only listed
+				// like GET /videos/:id/captions would list them to this viewer
+				if db != nil {
+					if dbVideo, err := db.GetVideoByBunnyID(videoID); err == nil {
+						if captions, err := playableVideoCaptions(c, db, dbVideo.ID); err == nil {
+							response["captions"] = captions
+						} else if err != sql.ErrNoRows {
+							log.Printf("Failed to get captions: %v", err)
+						}
+					}
+				}
+
 				if playData != nil {
 					response["play_data"] = playData
 					response["iframe_src"] = playData.IframeSrc
@@ -223,18 +311,57 @@ func SetupRoutes(
 				}
 			}
 
+			// Captions are only listed like GET /videos/:id/captions would list them to this viewer
+			captions, err := playableVideoCaptions(c, db, video.ID)
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Failed to get captions: %v", err)
+				// Continue without captions
+			}
+			video.Captions = captions
+
 			c.JSON(http.StatusOK, video)
 		})
 
 		videos.GET("/:id/comments", GetMockCommentsHandler)
 
+		// Caption and subtitle tracks for the player
+		videos.GET("/:id/captions", middleware.AuthIfPresent(), GetVideoCaptionsHandler(db))
+
+		// Related videos and up next, limited to what the viewer's tier can play
+		videos.GET("/:id/related", middleware.AuthIfPresent(), RelatedVideosHandler(db, recommendations))
+
 		// Add secure video upload endpoint - RESTRICTED TO ADMINS AND CONTENT MANAGERS
 		videos.POST("/upload",
 			middleware.AuthRequired(),
 			middleware.SessionActivityTracker(db),
-			middleware.VideoUploadRequired(),
+			middleware.VideoUploadRequired(db),
 			UploadVideoHandler(db, bunnyService))
 
+		// Resumable (tus) uploads for large recordings
+		videos.POST("/uploads",
+			middleware.AuthRequired(),
+			middleware.SessionActivityTracker(db),
+			middleware.VideoUploadRequired(db),
+			CreateVideoUploadHandler(db, videoUploads))
+		videos.HEAD("/uploads/:id",
+			middleware.AuthRequired(),
+			middleware.VideoUploadRequired(db),
+			VideoUploadStatusHandler(db))
+		videos.GET("/uploads/:id",
+			middleware.AuthRequired(),
+			middleware.VideoUploadRequired(db),
+			VideoUploadStatusHandler(db))
+		videos.PATCH("/uploads/:id",
+			middleware.AuthRequired(),
+			middleware.SessionActivityTracker(db),
+			middleware.VideoUploadRequired(db),
+			PatchVideoUploadHandler(db, videoUploads))
+		videos.DELETE("/uploads/:id",
+			middleware.AuthRequired(),
+			middleware.SessionActivityTracker(db),
+			middleware.VideoUploadRequired(db),
+			TerminateVideoUploadHandler(db, videoUploads))
+
 		// Add streaming endpoint for frontend
 		videos.GET("/:id/stream", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "Video streaming endpoint"})
@@ -382,22 +509,92 @@ func SetupRoutes(
 		subscriptions.POST("/checkout", CreateCheckoutSessionHandler(stripeService))
 	}
 
+	// Refund routes (issuing refunds is a financial operation)
+	refunds := v1.Group("/refunds")
+	{
+		refunds.GET("", middleware.AuthRequired(), middleware.SessionActivityTracker(db), handleGetRefunds(db, stripeService))
+		refunds.GET("/:id", middleware.AuthRequired(), middleware.SessionActivityTracker(db), handleGetRefund(db, stripeService))
+		refunds.POST("", middleware.AuthRequired(), middleware.RequirePermission(db, "financial:refund"), middleware.SessionActivityTracker(db), handleCreateRefund(db, stripeService))
+	}
+
 	// User profile routes
 	users := v1.Group("/users")
 	{
 		users.GET("/profile", middleware.AuthRequired(), middleware.SessionActivityTracker(db), GetProfileHandler(db))
 		users.PUT("/profile", middleware.AuthRequired(), middleware.SessionActivityTracker(db), UpdateProfileHandler(db))
+		users.POST("/change-password", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ChangePasswordHandler(db))
+
+		// Changing the account email, applied once the new address is confirmed
+		users.GET("/email", middleware.AuthRequired(), middleware.SessionActivityTracker(db), GetEmailChangeHandler(db))
+		users.POST("/email", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), RequestEmailChangeHandler(db, emailService))
+		users.DELETE("/email", middleware.AuthRequired(), middleware.SessionActivityTracker(db), CancelEmailChangeHandler(db))
+		users.POST("/email/confirm", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ConfirmEmailChangeHandler(db, stripeService))
+
+		// Session management
+		users.GET("/sessions", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ListSessionsHandler(db))
+		users.DELETE("/sessions", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), RevokeOtherSessionsHandler(db))
+		users.DELETE("/sessions/:id", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), RevokeSessionHandler(db))
+
+		// Personal access tokens
+		users.GET("/tokens", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ListPersonalAccessTokensHandler(db))
+		users.POST("/tokens", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), CreatePersonalAccessTokenHandler(db))
+		users.DELETE("/tokens/:id", middleware.AuthRequired(), middleware.SessionActivityTracker(db), RevokePersonalAccessTokenHandler(db))
+
+		// Two-factor authentication management
+		users.GET("/mfa", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), GetMFAStatusHandler(db))
+		users.POST("/mfa/enroll", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), BeginMFAEnrollmentHandler(db))
+		users.POST("/mfa/enroll/confirm", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ConfirmMFAEnrollmentHandler(db))
+		users.POST("/mfa/disable", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), DisableMFAHandler(db))
+		users.POST("/mfa/recovery-codes", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), RegenerateRecoveryCodesHandler(db))
+
+		// Linked sign-in providers
+		users.GET("/identities", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ListIdentitiesHandler(db))
+		users.POST("/identities/:provider", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), LinkIdentityHandler(db))
+		users.POST("/identities/:provider/callback", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ConfirmIdentityLinkHandler(db))
+		users.DELETE("/identities/:provider", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), UnlinkIdentityHandler(db))
+
+		// Personal data export and account erasure
+		users.GET("/data-export", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), GetDataExportHandler(db, privacyService))
+		users.POST("/data-export", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), RequestDataExportHandler(db, privacyService))
+		users.GET("/account/deletion", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), GetAccountDeletionHandler(db))
+		users.POST("/account/deletion", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), ScheduleAccountDeletionHandler(db, emailService))
+		users.DELETE("/account/deletion", middleware.AuthRequired(), middleware.InteractiveSessionRequired(), middleware.SessionActivityTracker(db), CancelAccountDeletionHandler(db))
+		users.GET("/watch-history", middleware.AuthRequired(), middleware.SessionActivityTracker(db), WatchHistoryHandler(db, playback))
+		users.DELETE("/watch-history", middleware.AuthRequired(), middleware.SessionActivityTracker(db), ClearWatchHistoryHandler(db, playback))
+		users.DELETE("/watch-history/:type/:id", middleware.AuthRequired(), middleware.SessionActivityTracker(db), DeleteWatchHistoryItemHandler(db, playback))
+		users.PUT("/watch-history/settings", middleware.AuthRequired(), middleware.SessionActivityTracker(db), UpdateWatchHistorySettingsHandler(db, playback))
+	}
+
+	// Full-text search across videos and YouTube videos
+	v1.GET("/search", SearchVideosHandler(db))
+
+	// Personal recommendations from the user's recent viewing
+	v1.GET("/recommendations", middleware.AuthRequired(), middleware.SessionActivityTracker(db), RecommendedVideosHandler(db, recommendations))
+
+	// Published series and playlists
+	seriesRoutes := v1.Group("/series")
+	{
+		seriesRoutes.GET("", ListPublishedSeriesHandler(db))
+		seriesRoutes.GET("/:slug", GetPublishedSeriesHandler(db))
+		seriesRoutes.GET("/:slug/videos/:video_id", SeriesNavigationHandler(db))
+	}
+
+	// Playback position tracking for resuming videos on any device
+	playbackRoutes := v1.Group("/playback")
+	{
+		// Heartbeats arrive every few seconds, so they skip session activity tracking.
+		// Anonymous heartbeats only count views.
+		playbackRoutes.POST("/heartbeat", middleware.AuthIfPresent(), PlaybackHeartbeatHandler(db, playback))
+		playbackRoutes.GET("/progress/:type/:id", middleware.AuthRequired(), middleware.SessionActivityTracker(db), GetPlaybackProgressHandler(db, playback))
+		playbackRoutes.GET("/continue-watching", middleware.AuthRequired(), middleware.SessionActivityTracker(db), ContinueWatchingHandler(db, playback))
 	}
 
 	// User dashboard
 	v1.GET("/dashboard", GetDashboardDataHandler)
 
-	// Advertisement routes for public ad serving
-	ads := v1.Group("/ads")
-	{
-		ads.GET("/serve/:placement", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{"message": "Ad serving endpoint (mock)"})
-		})
+	// Advertiser, advertisement review and public ad serving routes
+	if db != nil {
+		SetupAdvertisementRoutes(v1, db, services.NewAdvertisementService(db))
 	}
 
 	// Bunny.net test endpoint
@@ -599,250 +796,11 @@ func SetupRoutes(
 		}
 	})
 
-	// Manual sync endpoint for Bunny.net videos
-	v1.POST("/admin/sync-bunny-videos", middleware.AuthRequired(), func(c *gin.Context) {
-		// Check if user is admin
-		userRole := c.GetString("user_role")
-		if userRole != "admin" && userRole != "super_admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			return
-		}
-
-		// Fetch videos from Bunny.net
-		videos, err := fetchBunnyVideos(cfg.BunnyStreamLibrary, cfg.BunnyStreamAPIKey)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to fetch videos from Bunny.net",
-				"details": err.Error(),
-			})
-			return
-		}
-
-		// Sync videos to database
-		syncedCount := 0
-		skippedCount := 0
-		errorCount := 0
-		var errors []string
-
-		for _, bunnyVideo := range videos {
-			err := syncVideoToDatabase(db, bunnyService, bunnyVideo)
-			if err != nil {
-				if strings.Contains(err.Error(), "already exists") {
-					skippedCount++
-				} else {
-					errorCount++
-					errors = append(errors, fmt.Sprintf("%s: %v", bunnyVideo.Title, err))
-				}
-				continue
-			}
-			syncedCount++
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"success":       true,
-			"message":       "Sync completed",
-			"total_videos":  len(videos),
-			"synced":        syncedCount,
-			"skipped":       skippedCount,
-			"errors":        errorCount,
-			"error_details": errors,
-		})
-	})
-
-	// Test sync endpoint (no auth required for testing)
-	v1.POST("/test/sync-bunny-videos", func(c *gin.Context) {
-		// Fetch videos from Bunny.net
-		videos, err := fetchBunnyVideos(cfg.BunnyStreamLibrary, cfg.BunnyStreamAPIKey)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to fetch videos from Bunny.net",
-				"details": err.Error(),
-			})
-			return
-		}
-
-		// Sync videos to database
-		syncedCount := 0
-		skippedCount := 0
-		errorCount := 0
-		var errors []string
-
-		for _, bunnyVideo := range videos {
-			err := syncVideoToDatabase(db, bunnyService, bunnyVideo)
-			if err != nil {
-				if strings.Contains(err.Error(), "already exists") {
-					skippedCount++
-				} else {
-					errorCount++
-					errors = append(errors, fmt.Sprintf("%s: %v", bunnyVideo.Title, err))
-				}
-				continue
-			}
-			syncedCount++
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"success":       true,
-			"message":       "Test sync completed",
-			"total_videos":  len(videos),
-			"synced":        syncedCount,
-			"skipped":       skippedCount,
-			"errors":        errorCount,
-			"error_details": errors,
-		})
-	})
-
-	// Simple sync endpoint (no auth or webhook secret required for testing)
-	v1.POST("/sync-bunny-videos", func(c *gin.Context) {
-		// Validate configuration
-		if cfg.BunnyStreamLibrary == "" || cfg.BunnyStreamAPIKey == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Bunny.net configuration missing",
-				"details": gin.H{
-					"library_id_set": cfg.BunnyStreamLibrary != "",
-					"api_key_set":    cfg.BunnyStreamAPIKey != "",
-				},
-			})
-			return
-		}
-
-		// Fetch videos from Bunny.net
-		videos, err := fetchBunnyVideos(cfg.BunnyStreamLibrary, cfg.BunnyStreamAPIKey)
-		if err != nil {
-			// Categorize errors for better client handling
-			var statusCode int
-			var errorType string
-
-			switch {
-			case strings.Contains(err.Error(), "unauthorized"):
-				statusCode = http.StatusUnauthorized
-				errorType = "authentication_error"
-			case strings.Contains(err.Error(), "forbidden"):
-				statusCode = http.StatusForbidden
-				errorType = "permission_error"
-			case strings.Contains(err.Error(), "not found"):
-				statusCode = http.StatusNotFound
-				errorType = "resource_not_found"
-			case strings.Contains(err.Error(), "rate limited"):
-				statusCode = http.StatusTooManyRequests
-				errorType = "rate_limit_error"
-			case strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "connection"):
-				statusCode = http.StatusServiceUnavailable
-				errorType = "network_error"
-			default:
-				statusCode = http.StatusInternalServerError
-				errorType = "api_error"
-			}
-
-			c.JSON(statusCode, gin.H{
-				"error":      "Failed to fetch videos from Bunny.net",
-				"error_type": errorType,
-				"details":    err.Error(),
-				"timestamp":  time.Now().Format(time.RFC3339),
-			})
-			return
-		}
-
-		// Sync videos to database with detailed tracking
-		syncedCount := 0
-		skippedCount := 0
-		errorCount := 0
-		var errors []gin.H
-		var skipped []gin.H
-
-		for i, bunnyVideo := range videos {
-			err := syncVideoToDatabase(db, bunnyService, bunnyVideo)
-			if err != nil {
-				if strings.Contains(err.Error(), "already exists") {
-					skippedCount++
-					skipped = append(skipped, gin.H{
-						"title":  bunnyVideo.Title,
-						"guid":   bunnyVideo.GUID,
-						"reason": "already_exists",
-					})
-				} else {
-					errorCount++
-					errors = append(errors, gin.H{
-						"title": bunnyVideo.Title,
-						"guid":  bunnyVideo.GUID,
-						"error": err.Error(),
-						"index": i,
-					})
-				}
-				continue
-			}
-			syncedCount++
-		}
-
-		// Determine overall success status
-		overallSuccess := errorCount == 0 || syncedCount > 0
-		statusCode := http.StatusOK
-		if errorCount > 0 && syncedCount == 0 {
-			statusCode = http.StatusPartialContent
-		}
-
-		c.JSON(statusCode, gin.H{
-			"success":         overallSuccess,
-			"message":         fmt.Sprintf("Sync completed: %d synced, %d skipped, %d errors", syncedCount, skippedCount, errorCount),
-			"total_videos":    len(videos),
-			"synced":          syncedCount,
-			"skipped":         skippedCount,
-			"errors":          errorCount,
-			"error_details":   errors,
-			"skipped_details": skipped,
-			"timestamp":       time.Now().Format(time.RFC3339),
-		})
-	})
-
-	// Webhook endpoint for Bunny.net sync (no auth required)
-	v1.POST("/webhook/bunny-sync", func(c *gin.Context) {
-		// Simple webhook secret validation - allow if no secret is configured
-		webhookSecret := c.GetHeader("X-Webhook-Secret")
-		if cfg.BunnyWebhookSecret != "" && webhookSecret != cfg.BunnyWebhookSecret {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook secret"})
-			return
-		}
+	// Legacy sync endpoint, now queues a background library sync
+	v1.POST("/admin/sync-bunny-videos", middleware.AuthRequired(), middleware.RequirePermission(db, "videos:manage"), middleware.SessionActivityTracker(db), StartBunnySyncHandler(db, bunnySync))
 
-		// Fetch videos from Bunny.net
-		videos, err := fetchBunnyVideos(cfg.BunnyStreamLibrary, cfg.BunnyStreamAPIKey)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to fetch videos from Bunny.net",
-				"details": err.Error(),
-			})
-			return
-		}
-
-		// Sync videos to database
-		syncedCount := 0
-		skippedCount := 0
-		errorCount := 0
-		var errors []string
-
-		for _, bunnyVideo := range videos {
-			err := syncVideoToDatabase(db, bunnyService, bunnyVideo)
-			if err != nil {
-				if strings.Contains(err.Error(), "already exists") {
-					skippedCount++
-				} else {
-					errorCount++
-					errors = append(errors, fmt.Sprintf("%s: %v", bunnyVideo.Title, err))
-				}
-				continue
-			}
-			syncedCount++
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"success":       true,
-			"message":       "Webhook sync completed",
-			"total_videos":  len(videos),
-			"synced":        syncedCount,
-			"skipped":       skippedCount,
-			"errors":        errorCount,
-			"error_details": errors,
-		})
-	})
+	// Bunny Stream video status webhooks, verified with the webhook secret (no auth required)
+	v1.POST("/webhook/bunny-sync", BunnyWebhookHandler(db, bunnyService, bunnyWebhooks))
 }
 
 // Placeholder handler functions - these will be implemented in separate files
@@ -912,12 +870,6 @@ func handleGetCategories(db *database.DB) gin.HandlerFunc {
 	}
 }
 
-func handleSearchVideos(db *database.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "Search videos endpoint - TODO"})
-	}
-}
-
 func handleGetSubscriptionPlans(stripeService *services.StripeService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Get subscription plans endpoint - TODO"})
@@ -951,7 +903,11 @@ func GetProfileHandler(db *database.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"user": profile})
+		response := gin.H{"user": profile}
+		if banner := impersonationBanner(c); banner != nil {
+			response["impersonation"] = banner
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -979,10 +935,17 @@ func UpdateProfileHandler(db *database.DB) gin.HandlerFunc {
 		}
 
 		if email, exists := updates["email"]; exists {
-			if emailStr, ok := email.(string); !ok || emailStr == "" {
+			emailStr, ok := email.(string)
+			if !ok || emailStr == "" {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
 				return
 			}
+
+			// The email can only change once the new address is verified
+			if user, err := db.GetUserByID(userID); err == nil && !strings.EqualFold(strings.TrimSpace(emailStr), user.Email) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Use the change email request to update your email address"})
+				return
+			}
 		}
 
 		// Update profile
@@ -1265,6 +1228,13 @@ func handleGetRefunds(db *database.DB, stripeService *services.StripeService) gi
 			return
 		}
 
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
 		// Get pagination parameters
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 		if limit < 1 || limit > 100 {
@@ -1309,6 +1279,13 @@ func handleGetRefund(db *database.DB, stripeService *services.StripeService) gin
 			return
 		}
 
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
 		refundID := c.Param("id")
 		if refundID == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Refund ID is required"})
@@ -1346,6 +1323,13 @@ func handleCreateRefund(db *database.DB, stripeService *services.StripeService)
 			return
 		}
 
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
 		var refundReq struct {
 			PaymentIntentID string `json:"payment_intent_id" binding:"required"`
 			Amount          int64  `json:"amount"`
@@ -1486,32 +1470,8 @@ func UploadVideoHandler(db *database.DB, bunnyService *services.BunnyService) gi
 			}
 		}
 
-		// Create a temporary file to pass to Bunny service
-		tempFile, err := os.CreateTemp("", "upload-*.tmp")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temporary file"})
-			return
-		}
-		defer os.Remove(tempFile.Name())
-		defer tempFile.Close()
-
-		// Copy uploaded file to temp file
-		if _, err := io.Copy(tempFile, file); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save uploaded file"})
-			return
-		}
-
-		// Reset file pointer for reading
-		tempFile.Seek(0, 0)
-
-		// Create multipart file header for Bunny service
-		fileHeader := &multipart.FileHeader{
-			Filename: header.Filename,
-			Header:   header.Header,
-		}
-
-		// Upload to Bunny.net
-		uploadResp, err := bunnyService.UploadVideo(fileHeader, title, description)
+		// Stream the file to Bunny.net
+		uploadResp, err := bunnyService.UploadVideo(file, header.Size, title)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload video: " + err.Error()})
 			return
@@ -1702,120 +1662,3 @@ func fetchBunnyVideos(libraryID, apiKey string) ([]BunnyVideo, error) {
 
 	return response.Items, nil
 }
-
-// syncVideoToDatabase syncs a Bunny video to the database with improved error handling
-func syncVideoToDatabase(db *database.DB, bunnyService *services.BunnyService, bunnyVideo BunnyVideo) error {
-	// Validate required fields
-	if bunnyVideo.GUID == "" {
-		return fmt.Errorf("video GUID is required")
-	}
-	if bunnyVideo.Title == "" {
-		return fmt.Errorf("video title is required")
-	}
-
-	// Check if video already exists
-	existingVideo, err := db.GetVideoByBunnyID(bunnyVideo.GUID)
-	if err == nil && existingVideo != nil {
-		return fmt.Errorf("video %s already exists in database", bunnyVideo.GUID)
-	}
-
-	// Generate thumbnail URL
-	thumbnailURL := bunnyService.GetThumbnailURL(bunnyVideo.GUID)
-
-	// Determine video status based on Bunny status
-	var status string
-	switch bunnyVideo.Status {
-	case 0:
-		status = "queued"
-	case 1:
-		status = "processing"
-	case 2:
-		status = "encoding"
-	case 3:
-		status = "ready"
-	case 4:
-		status = "error"
-	default:
-		status = "unknown"
-	}
-
-	// Parse category or set default
-	category := bunnyVideo.Category
-	if category == "" {
-		category = "General"
-	}
-
-	// Create tags from meta tags
-	var tags []string
-	for _, metaTag := range bunnyVideo.MetaTags {
-		if metaTag.Property == "tag" {
-			tags = append(tags, metaTag.Value)
-		}
-	}
-
-	// If no tags from meta, create some based on the video properties
-	if len(tags) == 0 {
-		tags = []string{"bunny", "streaming"}
-		if bunnyVideo.HasMP4Fallback {
-			tags = append(tags, "mp4")
-		}
-		if bunnyVideo.IsPublic {
-			tags = append(tags, "public")
-		}
-	}
-
-	// Create video description
-	description := fmt.Sprintf("Video from Bunny.net library. Duration: %d seconds, Resolution: %dx%d",
-		bunnyVideo.Length, bunnyVideo.Width, bunnyVideo.Height)
-
-	if len(bunnyVideo.TranscodingMessages) > 0 {
-		description += "\n\nTranscoding notes:"
-		for _, msg := range bunnyVideo.TranscodingMessages {
-			description += fmt.Sprintf("\n- %s", msg.Message)
-		}
-	}
-
-	// Create video in database with retry logic
-	var video *database.Video
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		video, err = db.CreateVideo(
-			bunnyVideo.Title,
-			description,
-			bunnyVideo.GUID,
-			thumbnailURL,
-			category,
-			bunnyVideo.Length,
-			bunnyVideo.StorageSize,
-			tags,
-			1, // Default to admin user ID
-		)
-		if err == nil {
-			break
-		}
-
-		if attempt < maxRetries {
-			time.Sleep(time.Duration(attempt) * time.Second)
-		}
-	}
-
-	if err != nil {
-		return fmt.Errorf("failed to create video in database after %d attempts: %w", maxRetries, err)
-	}
-
-	// Update video status
-	err = db.UpdateVideoStatus(video.ID, status)
-	if err != nil {
-		return fmt.Errorf("failed to update video status: %w", err)
-	}
-
-	// Update view count if available
-	if bunnyVideo.Views > 0 {
-		err = db.UpdateVideoViews(video.ID, bunnyVideo.Views)
-		if err != nil {
-			return fmt.Errorf("failed to update video views: %w", err)
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"bome-backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchVideosHandler searches videos and YouTube videos. q is required; type limits the
+// search to "video" or "youtube", and category, duration and year filter the results.
+// Titles and snippets come back HTML-escaped with matching words wrapped in <mark>.
+func SearchVideosHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		params := database.VideoSearchParams{
+			Query:    c.Query("q"),
+			Category: c.Query("category"),
+			Duration: c.Query("duration"),
+		}
+		if params.Query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query parameter 'q' is required"})
+			return
+		}
+
+		switch mediaType := c.DefaultQuery("type", "all"); mediaType {
+		case "all":
+		case database.PlaybackMediaVideo, database.PlaybackMediaYouTube:
+			params.MediaTypes = []string{mediaType}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be all, video or youtube"})
+			return
+		}
+
+		if params.Duration != "" && !containsValue(database.SearchDurationBuckets, params.Duration) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown duration", "durations": database.SearchDurationBuckets})
+			return
+		}
+		if year := c.Query("year"); year != "" {
+			parsed, err := strconv.Atoi(year)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+				return
+			}
+			params.Year = parsed
+		}
+
+		params.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+		params.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if params.Limit < 1 || params.Limit > 50 {
+			params.Limit = 20
+		}
+		if params.Offset < 0 {
+			params.Offset = 0
+		}
+
+		results, err := db.SearchVideos(params)
+		if errors.Is(err, database.ErrEmptySearchQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must contain letters or numbers"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to search videos for %q: %v", params.Query, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search videos"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"query":   params.Query,
+			"results": results.Results,
+			"total":   results.Total,
+			"facets":  results.Facets,
+			"limit":   params.Limit,
+			"offset":  params.Offset,
+		})
+	}
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
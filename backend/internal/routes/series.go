@@ -0,0 +1,558 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSeriesCoverSize is the largest cover image accepted, in bytes
+const maxSeriesCoverSize = 5 << 20
+
+// seriesCoverTypes maps the accepted cover image types to their file extensions
+var seriesCoverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+type seriesRequest struct {
+	Title         string `json:"title" binding:"required"`
+	Slug          string `json:"slug"`
+	Kind          string `json:"kind"`
+	Description   string `json:"description"`
+	CoverImageURL string `json:"cover_image_url"`
+	Status        string `json:"status"`
+}
+
+// apply validates the request and copies it onto series, reporting the problem if
+// it is invalid
+func (req *seriesRequest) apply(series *database.Series) string {
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return "Title is required"
+	}
+
+	slug := services.Slugify(req.Slug)
+	if req.Slug == "" {
+		slug = services.Slugify(title)
+	}
+	if slug == "" {
+		return "Slug must contain letters or numbers"
+	}
+
+	kind := req.Kind
+	if kind == "" {
+		kind = "series"
+	}
+	if kind != "series" && kind != "playlist" {
+		return "kind must be series or playlist"
+	}
+
+	status := req.Status
+	if status == "" {
+		status = "draft"
+	}
+	if status != "draft" && status != "published" {
+		return "status must be draft or published"
+	}
+
+	series.Title = title
+	series.Slug = slug
+	series.Kind = kind
+	series.Description = strings.TrimSpace(req.Description)
+	series.CoverImageURL = strings.TrimSpace(req.CoverImageURL)
+	series.Status = status
+	return ""
+}
+
+// ListSeriesHandler lists series and playlists for admins, including drafts
+func ListSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, offset := seriesPage(c)
+		list, err := db.GetSeriesList(c.Query("kind"), c.Query("status"), limit, offset)
+		if err != nil {
+			log.Printf("Failed to list series: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list series"})
+			return
+		}
+
+		response := make([]gin.H, 0, len(list))
+		for _, series := range list {
+			response = append(response, seriesResponse(series))
+		}
+		c.JSON(http.StatusOK, gin.H{"series": response, "limit": limit, "offset": offset})
+	}
+}
+
+// CreateSeriesHandler creates a series or playlist
+func CreateSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		var req seriesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+			return
+		}
+
+		series := &database.Series{}
+		if userID := c.GetInt("user_id"); userID != 0 {
+			series.CreatedBy = sql.NullInt64{Int64: int64(userID), Valid: true}
+		}
+		if problem := req.apply(series); problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+
+		if err := db.CreateSeries(series); err != nil {
+			log.Printf("Failed to create series: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_created", "series", strconv.Itoa(series.ID), "Series created", map[string]interface{}{
+			"title": series.Title,
+			"kind":  series.Kind,
+		})
+
+		c.JSON(http.StatusCreated, gin.H{"series": seriesResponse(series), "items": []*database.SeriesItem{}})
+	}
+}
+
+// GetSeriesHandler returns a series and its videos for admins
+func GetSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		items, err := db.GetSeriesItems(series.ID)
+		if err != nil {
+			log.Printf("Failed to load items of series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+			return
+		}
+		if items == nil {
+			items = []*database.SeriesItem{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"series": seriesResponse(series), "items": items})
+	}
+}
+
+// UpdateSeriesHandler replaces a series' details
+func UpdateSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		var req seriesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+			return
+		}
+		previousStatus := series.Status
+		if problem := req.apply(series); problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+
+		if err := db.UpdateSeries(series); err != nil {
+			log.Printf("Failed to update series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_updated", "series", strconv.Itoa(series.ID), "Series updated", map[string]interface{}{
+			"previous_status": previousStatus,
+			"status":          series.Status,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"series": seriesResponse(series)})
+	}
+}
+
+// DeleteSeriesHandler deletes a series. The videos in it are kept.
+func DeleteSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		if err := db.DeleteSeries(series.ID); err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to delete series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_deleted", "series", strconv.Itoa(series.ID), "Series deleted", map[string]interface{}{
+			"title": series.Title,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Series deleted"})
+	}
+}
+
+// AddSeriesItemHandler adds a video to a series. Without a position the video is added
+// at the end; adding a video that is already in the series moves it.
+func AddSeriesItemHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			VideoID  int `json:"video_id" binding:"required"`
+			Position int `json:"position"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.VideoID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "video_id is required"})
+			return
+		}
+
+		if _, err := db.GetVideoByID(req.VideoID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		} else if err != nil {
+			log.Printf("Failed to load video %d: %v", req.VideoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add video to series"})
+			return
+		}
+
+		if err := db.AddSeriesItem(series.ID, req.VideoID, req.Position); err != nil {
+			log.Printf("Failed to add video %d to series %d: %v", req.VideoID, series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add video to series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_item_added", "series", strconv.Itoa(series.ID), "Video added to series", map[string]interface{}{
+			"video_id": req.VideoID,
+			"position": req.Position,
+		})
+
+		respondWithSeriesItems(c, db, series.ID)
+	}
+}
+
+// RemoveSeriesItemHandler removes a video from a series
+func RemoveSeriesItemHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		videoID, err := strconv.Atoi(c.Param("video_id"))
+		if err != nil || videoID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+
+		err = db.RemoveSeriesItem(series.ID, videoID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video is not in this series"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to remove video %d from series %d: %v", videoID, series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove video from series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_item_removed", "series", strconv.Itoa(series.ID), "Video removed from series", map[string]interface{}{
+			"video_id": videoID,
+		})
+
+		respondWithSeriesItems(c, db, series.ID)
+	}
+}
+
+// ReorderSeriesItemsHandler puts the videos of a series in a new order. video_ids must
+// list every video in the series exactly once.
+func ReorderSeriesItemsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			VideoIDs []int `json:"video_ids" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "video_ids is required"})
+			return
+		}
+
+		err := db.ReorderSeriesItems(series.ID, req.VideoIDs)
+		if errors.Is(err, database.ErrSeriesItemsMismatch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "video_ids must list every video in the series exactly once"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to reorder series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_reordered", "series", strconv.Itoa(series.ID), "Series reordered", map[string]interface{}{
+			"video_ids": req.VideoIDs,
+		})
+
+		respondWithSeriesItems(c, db, series.ID)
+	}
+}
+
+// UploadSeriesCoverHandler uploads cover art for a series to Spaces and sets it as
+// the series' cover
+func UploadSeriesCoverHandler(db *database.DB, spacesService *services.SpacesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if spacesService == nil {
+			serviceUnavailable(c)
+			return
+		}
+		series, ok := loadSeries(c, db)
+		if !ok {
+			return
+		}
+
+		file, err := c.FormFile("cover")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A cover image is required"})
+			return
+		}
+		if file.Size > maxSeriesCoverSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Cover images must be 5MB or smaller"})
+			return
+		}
+		extension, ok := seriesCoverTypes[file.Header.Get("Content-Type")]
+		if !ok {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Cover images must be JPEG, PNG or WebP"})
+			return
+		}
+
+		key := fmt.Sprintf("series/%d/cover-%d%s", series.ID, time.Now().Unix(), extension)
+		result, err := spacesService.UploadFile(file, key)
+		if err != nil {
+			log.Printf("Failed to upload cover for series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload cover image"})
+			return
+		}
+
+		previousCover := series.CoverImageURL
+		series.CoverImageURL = result.URL
+		if strings.HasPrefix(result.CDNURL, "http") {
+			series.CoverImageURL = result.CDNURL
+		}
+		if err := db.UpdateSeries(series); err != nil {
+			log.Printf("Failed to set cover for series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update series"})
+			return
+		}
+
+		recordAdminAudit(c, db, "series_cover_uploaded", "series", strconv.Itoa(series.ID), "Series cover uploaded", map[string]interface{}{
+			"previous_cover": previousCover,
+			"key":            key,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"series": seriesResponse(series)})
+	}
+}
+
+// ListPublishedSeriesHandler lists published series and playlists
+func ListPublishedSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		limit, offset := seriesPage(c)
+		list, err := db.GetPublishedSeriesList(c.Query("kind"), limit, offset)
+		if err != nil {
+			log.Printf("Failed to list series: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list series"})
+			return
+		}
+
+		response := make([]gin.H, 0, len(list))
+		for _, series := range list {
+			response = append(response, seriesResponse(series))
+		}
+		c.JSON(http.StatusOK, gin.H{"series": response, "limit": limit, "offset": offset})
+	}
+}
+
+// GetPublishedSeriesHandler returns a published series and its ready videos in order
+func GetPublishedSeriesHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		series, ok := loadPublishedSeries(c, db)
+		if !ok {
+			return
+		}
+
+		items, err := db.GetPublishedSeriesItems(series.ID)
+		if err != nil {
+			log.Printf("Failed to load items of series %d: %v", series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+			return
+		}
+		if items == nil {
+			items = []*database.SeriesItem{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"series": seriesResponse(series), "items": items})
+	}
+}
+
+// SeriesNavigationHandler returns a video's place in a published series along with
+// the previous and next ready videos, which are null at either end of the series
+func SeriesNavigationHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoID, err := strconv.Atoi(c.Param("video_id"))
+		if err != nil || videoID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+			return
+		}
+
+		series, ok := loadPublishedSeries(c, db)
+		if !ok {
+			return
+		}
+
+		current, previous, next, err := db.GetPublishedSeriesNeighbours(series.ID, videoID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video is not in this series"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load navigation for video %d in series %d: %v", videoID, series.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"series":   seriesResponse(series),
+			"current":  current,
+			"previous": previous,
+			"next":     next,
+		})
+	}
+}
+
+// loadSeries loads the series named by the :id parameter, responding with an error if
+// it cannot
+func loadSeries(c *gin.Context, db *database.DB) (*database.Series, bool) {
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || seriesID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid series ID"})
+		return nil, false
+	}
+	if db == nil {
+		serviceUnavailable(c)
+		return nil, false
+	}
+
+	series, err := db.GetSeries(seriesID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load series %d: %v", seriesID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+		return nil, false
+	}
+	return series, true
+}
+
+// loadPublishedSeries loads the published series named by the :slug parameter, counting
+// only its ready videos. Drafts are reported as not found.
+func loadPublishedSeries(c *gin.Context, db *database.DB) (*database.Series, bool) {
+	if db == nil {
+		serviceUnavailable(c)
+		return nil, false
+	}
+
+	series, err := db.GetPublishedSeriesBySlug(c.Param("slug"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load series %q: %v", c.Param("slug"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+		return nil, false
+	}
+	return series, true
+}
+
+// respondWithSeriesItems responds with the videos of a series after they have changed
+func respondWithSeriesItems(c *gin.Context, db *database.DB, seriesID int) {
+	items, err := db.GetSeriesItems(seriesID)
+	if err != nil {
+		log.Printf("Failed to load items of series %d: %v", seriesID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load series"})
+		return
+	}
+	if items == nil {
+		items = []*database.SeriesItem{}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func seriesPage(c *gin.Context) (int, int) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// seriesResponse builds the API view of a series
+func seriesResponse(series *database.Series) gin.H {
+	response := gin.H{
+		"id":              series.ID,
+		"slug":            series.Slug,
+		"kind":            series.Kind,
+		"title":           series.Title,
+		"description":     series.Description,
+		"cover_image_url": series.CoverImageURL,
+		"status":          series.Status,
+		"item_count":      series.ItemCount,
+		"created_at":      series.CreatedAt,
+		"updated_at":      series.UpdatedAt,
+	}
+	if series.BunnyCollectionID.Valid {
+		response["bunny_collection_id"] = series.BunnyCollectionID.String
+	}
+	return response
+}
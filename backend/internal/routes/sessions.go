@@ -0,0 +1,201 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionResponse builds the public view of a session
+func sessionResponse(session *database.Session, currentFamilyID string) gin.H {
+	return gin.H{
+		"id":            session.ID,
+		"device_info":   session.DeviceInfo,
+		"ip_address":    session.IPAddress,
+		"user_agent":    session.UserAgent,
+		"last_activity": session.LastActivity,
+		"created_at":    session.CreatedAt,
+		"expires_at":    session.ExpiresAt,
+		"current":       currentFamilyID != "" && session.FamilyID == currentFamilyID,
+	}
+}
+
+// ListSessionsHandler lists the authenticated user's active sessions
+func ListSessionsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		sessions, err := db.GetActiveSessions(userID)
+		if err != nil {
+			log.Printf("Failed to get sessions for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+			return
+		}
+
+		currentFamilyID := c.GetString("session_family_id")
+		result := make([]gin.H, 0, len(sessions))
+		for _, session := range sessions {
+			result = append(result, sessionResponse(session, currentFamilyID))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"sessions": result,
+			"total":    len(result),
+		})
+	}
+}
+
+// RevokeSessionHandler revokes one of the authenticated user's sessions
+func RevokeSessionHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		session, err := db.GetUserSession(userID, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		if err := services.RevokeSessionTokens(db, session); err != nil {
+			log.Printf("Failed to revoke session %s: %v", session.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		current := c.GetString("session_family_id") != "" && session.FamilyID == c.GetString("session_family_id")
+		recordSessionAudit(c, db, userID, "session_revoked", "Session revoked by user", map[string]interface{}{
+			"session_id": session.ID,
+			"current":    current,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Session revoked successfully",
+			"session_id": session.ID,
+			"current":    current,
+		})
+	}
+}
+
+// RevokeOtherSessionsHandler revokes every session of the authenticated user except the current one
+func RevokeOtherSessionsHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		if db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable. Please try again later.",
+			})
+			return
+		}
+
+		currentFamilyID := c.GetString("session_family_id")
+		if currentFamilyID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current session could not be identified. Please log in again."})
+			return
+		}
+
+		sessions, err := db.GetActiveSessions(userID)
+		if err != nil {
+			log.Printf("Failed to get sessions for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+			return
+		}
+
+		revoked := []string{}
+		for _, session := range sessions {
+			if session.FamilyID == currentFamilyID {
+				continue
+			}
+			if err := services.RevokeSessionTokens(db, session); err != nil {
+				log.Printf("Failed to revoke session %s: %v", session.ID, err)
+				continue
+			}
+			revoked = append(revoked, session.ID)
+		}
+
+		recordSessionAudit(c, db, userID, "sessions_revoked_others", "All other sessions revoked by user", map[string]interface{}{
+			"revoked_sessions": revoked,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":       "All other sessions revoked successfully",
+			"revoked_count": len(revoked),
+		})
+	}
+}
+
+// revokeFamilySession revokes the user's active session that owns a token family
+func revokeFamilySession(db *database.DB, userID int, familyID string) {
+	sessions, err := db.GetActiveSessions(userID)
+	if err != nil {
+		log.Printf("Failed to load sessions for user %d: %v", userID, err)
+		return
+	}
+	for _, session := range sessions {
+		if session.FamilyID == familyID {
+			if err := services.RevokeSessionTokens(db, session); err != nil {
+				log.Printf("Failed to revoke session %s: %v", session.ID, err)
+			}
+		}
+	}
+}
+
+// recordSessionAudit writes a session management audit log entry for the authenticated user
+func recordSessionAudit(c *gin.Context, db *database.DB, userID int, action, details string, metadata map[string]interface{}) {
+	userEmail := c.GetString("user_email")
+	auditLog := &database.AuditLog{
+		UserID:    &userID,
+		UserEmail: &userEmail,
+		Action:    action,
+		Resource:  "session",
+		IPAddress: services.GetClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), c.GetHeader("X-Real-IP")),
+		UserAgent: c.GetHeader("User-Agent"),
+		Status:    "success",
+		Details:   &details,
+		Metadata:  metadata,
+		Severity:  "medium",
+	}
+	if err := db.CreateAuditLog(auditLog); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
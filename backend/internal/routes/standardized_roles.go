@@ -500,14 +500,36 @@ func GetPermissionByID(permissionID string) *StandardizedPermission {
 	return nil
 }
 
-// HasPermission checks if a role has a specific permission
-func HasPermission(roleID string, permissionID string) bool {
-	role := GetRoleByID(roleID)
+// legacyRoleAliases maps role names that predate the standardized roles to their equivalents
+var legacyRoleAliases = map[string]string{
+	"admin": "super_admin",
+}
+
+// canonicalRoleID maps a legacy role name to its standardized equivalent
+func canonicalRoleID(roleID string) string {
+	if alias, ok := legacyRoleAliases[roleID]; ok {
+		return alias
+	}
+	return roleID
+}
+
+// RolePermissions returns the permissions granted to a role, resolving legacy role names
+func RolePermissions(roleID string) []string {
+	role := GetRoleByID(canonicalRoleID(roleID))
 	if role == nil {
-		return false
+		return nil
 	}
+	return role.Permissions
+}
+
+// StandardizedPermissionResolver resolves a caller's permissions from STANDARDIZED_ROLES
+func StandardizedPermissionResolver(userID int, role string) ([]string, error) {
+	return RolePermissions(role), nil
+}
 
-	for _, perm := range role.Permissions {
+// HasPermission checks if a role has a specific permission
+func HasPermission(roleID string, permissionID string) bool {
+	for _, perm := range RolePermissions(roleID) {
 		if perm == permissionID {
 			return true
 		}
@@ -19,7 +19,6 @@ func GetVideosFromBunnyHandler(db *database.DB, bunnyService *services.BunnyServ
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		sync := c.DefaultQuery("sync", "false") == "true"
 
 		// Validate and limit parameters
 		if limit > 100 {
@@ -73,7 +72,7 @@ func GetVideosFromBunnyHandler(db *database.DB, bunnyService *services.BunnyServ
 				"likeCount":    0, // Bunny.net doesn't provide like counts
 				"category":     bunnyVideo.Category,
 				"tags":         extractTagsFromBunnyVideo(bunnyVideo),
-				"status":       mapBunnyStatus(bunnyVideo.Status),
+				"status":       services.MapBunnyStatus(bunnyVideo.Status),
 				"createdAt":    bunnyVideo.DateUploaded,
 				"updatedAt":    bunnyVideo.DateUploaded,
 				"bunny": gin.H{
@@ -110,15 +109,6 @@ func GetVideosFromBunnyHandler(db *database.DB, bunnyService *services.BunnyServ
 		totalPages := (len(videos) + limit - 1) / limit
 		hasMore := currentPage < totalPages
 
-		// Sync to database if requested
-		if sync {
-			go func() {
-				for _, bunnyVideo := range paginatedVideos {
-					syncVideoToDatabase(db, bunnyService, bunnyVideo)
-				}
-			}()
-		}
-
 		// Enhanced response with bunny.net integration info
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -144,10 +134,9 @@ func GetVideosFromBunnyHandler(db *database.DB, bunnyService *services.BunnyServ
 				}(),
 			},
 			"bunny_integration": gin.H{
-				"library_id":   bunnyService.GetStreamLibrary(),
-				"region":       bunnyService.GetRegion(),
-				"cdn_domain":   "iframe.mediadelivery.net",
-				"sync_enabled": sync,
+				"library_id": bunnyService.GetStreamLibrary(),
+				"region":     bunnyService.GetRegion(),
+				"cdn_domain": "iframe.mediadelivery.net",
 			},
 			"timestamp": time.Now().Format("2006-01-02T15:04:05Z"),
 		})
@@ -183,21 +172,3 @@ func extractTagsFromBunnyVideo(bunnyVideo BunnyVideo) []string {
 
 	return tags
 }
-
-// mapBunnyStatus maps Bunny.net status codes to readable status strings
-func mapBunnyStatus(status int) string {
-	switch status {
-	case 0:
-		return "queued"
-	case 1:
-		return "processing"
-	case 2:
-		return "encoding"
-	case 3:
-		return "ready"
-	case 4:
-		return "error"
-	default:
-		return "unknown"
-	}
-}
@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+	"bome-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,checksum,termination"
+
+	// videoUploadChunkTimeout bounds how long a single PATCH may take to send its chunk
+	videoUploadChunkTimeout = 30 * time.Minute
+)
+
+// setTusHeaders sets the headers every tus response carries
+func setTusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated key and
+// base64 value pairs
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// CreateVideoUploadHandler starts a resumable (tus) video upload. Title, description,
+// category, tags (a JSON array) and filename are passed in Upload-Metadata.
+func CreateVideoUploadHandler(db *database.DB, uploads *services.VideoUploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setTusHeaders(c)
+		if db == nil || uploads == nil {
+			serviceUnavailable(c)
+			return
+		}
+
+		userID := c.GetInt("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+			return
+		}
+		if length > services.VideoUploadMaxSize() {
+			c.Header("Tus-Max-Size", strconv.FormatInt(services.VideoUploadMaxSize(), 10))
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Video is too large"})
+			return
+		}
+
+		rawMetadata := c.GetHeader("Upload-Metadata")
+		metadata, err := parseUploadMetadata(rawMetadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+			return
+		}
+
+		filename := metadata["filename"]
+		if !isValidVideoFile(filename) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video file type. Allowed: mp4, avi, mov, wmv, flv, webm, mkv"})
+			return
+		}
+
+		title := strings.TrimSpace(metadata["title"])
+		if title == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+			return
+		}
+
+		var tags []string
+		if metadata["tags"] != "" {
+			if err := json.Unmarshal([]byte(metadata["tags"]), &tags); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags format"})
+				return
+			}
+		}
+
+		upload, err := uploads.Create(&database.VideoUpload{
+			UserID:       userID,
+			Filename:     filename,
+			Title:        title,
+			Description:  metadata["description"],
+			Category:     metadata["category"],
+			Tags:         tags,
+			Metadata:     rawMetadata,
+			UploadLength: length,
+		})
+		if err != nil {
+			log.Printf("Failed to create video upload: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+			return
+		}
+
+		go db.CreateAdminLog(&userID, "video_upload_started", "video_upload", nil, map[string]interface{}{
+			"upload_id": upload.ID,
+			"title":     upload.Title,
+			"file_size": upload.UploadLength,
+		}, c.ClientIP(), c.GetHeader("User-Agent"))
+
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", tusExtensions)
+		c.Header("Tus-Max-Size", strconv.FormatInt(services.VideoUploadMaxSize(), 10))
+		c.Header("Tus-Checksum-Algorithm", strings.Join(services.VideoUploadChecksumAlgorithms, ","))
+		c.Header("Location", "/api/v1/videos/uploads/"+upload.ID)
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Header("Upload-Offset", "0")
+		c.JSON(http.StatusCreated, gin.H{
+			"upload_id":  upload.ID,
+			"expires_at": upload.ExpiresAt,
+		})
+	}
+}
+
+// VideoUploadStatusHandler reports how much of an upload has been received, so the
+// client knows where to resume. It answers tus HEAD requests and, with GET, includes
+// the upload's processing status and video once handed off.
+func VideoUploadStatusHandler(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setTusHeaders(c)
+		upload, ok := loadVideoUpload(c, db)
+		if !ok {
+			return
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(upload.UploadOffset, 10))
+		c.Header("Upload-Length", strconv.FormatInt(upload.UploadLength, 10))
+		if upload.Status == "uploading" {
+			c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+
+		if c.Request.Method == http.MethodHead {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		response := gin.H{"upload": upload}
+		if upload.VideoID.Valid {
+			response["video_id"] = upload.VideoID.Int64
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// PatchVideoUploadHandler appends a chunk to a resumable upload
+func PatchVideoUploadHandler(db *database.DB, uploads *services.VideoUploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setTusHeaders(c)
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+			return
+		}
+
+		upload, ok := loadVideoUpload(c, db)
+		if !ok {
+			return
+		}
+
+		// Chunks of large recordings take longer than the server's default timeouts
+		controller := http.NewResponseController(c.Writer)
+		deadline := time.Now().Add(videoUploadChunkTimeout)
+		if err := controller.SetReadDeadline(deadline); err != nil {
+			log.Printf("Failed to extend read deadline for upload %s: %v", upload.ID, err)
+		}
+		if err := controller.SetWriteDeadline(deadline); err != nil {
+			log.Printf("Failed to extend write deadline for upload %s: %v", upload.ID, err)
+		}
+
+		newOffset, err := uploads.WriteChunk(upload, offset, c.Request.Body, c.GetHeader("Upload-Checksum"))
+		switch {
+		case errors.Is(err, services.ErrUploadOffsetMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload"})
+			return
+		case errors.Is(err, services.ErrUploadChecksumMismatch):
+			// 460 Checksum Mismatch, from the tus checksum extension
+			c.JSON(460, gin.H{"error": "Checksum mismatch"})
+			return
+		case errors.Is(err, services.ErrUnsupportedChecksum):
+			c.Header("Tus-Checksum-Algorithm", strings.Join(services.VideoUploadChecksumAlgorithms, ","))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported checksum algorithm"})
+			return
+		case errors.Is(err, services.ErrUploadTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds the upload length"})
+			return
+		case errors.Is(err, services.ErrUploadLocked):
+			c.JSON(http.StatusLocked, gin.H{"error": "Upload is already being written to"})
+			return
+		case errors.Is(err, services.ErrUploadClosed):
+			c.JSON(http.StatusGone, gin.H{"error": "Upload is no longer accepting data"})
+			return
+		case errors.Is(err, services.ErrUploadWrongInstance):
+			c.JSON(http.StatusMisdirectedRequest, gin.H{"error": "Upload must be continued on the server that started it"})
+			return
+		case err != nil && newOffset == 0:
+			log.Printf("Failed to write chunk to upload %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write chunk"})
+			return
+		}
+
+		// A chunk cut short by the client is kept; it resumes from the new offset
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		if newOffset < upload.UploadLength {
+			c.Header("Upload-Expires", time.Now().Add(services.VideoUploadExpiry()).UTC().Format(http.TimeFormat))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// TerminateVideoUploadHandler abandons an unfinished upload and discards its data
+func TerminateVideoUploadHandler(db *database.DB, uploads *services.VideoUploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setTusHeaders(c)
+		upload, ok := loadVideoUpload(c, db)
+		if !ok {
+			return
+		}
+
+		switch err := uploads.Terminate(upload); {
+		case errors.Is(err, services.ErrUploadLocked):
+			c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written to"})
+			return
+		case errors.Is(err, services.ErrUploadClosed):
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload has already finished"})
+			return
+		case errors.Is(err, services.ErrUploadWrongInstance):
+			c.JSON(http.StatusMisdirectedRequest, gin.H{"error": "Upload must be terminated on the server that started it"})
+			return
+		case err != nil:
+			log.Printf("Failed to terminate upload %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate upload"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// loadVideoUpload loads the upload named in the path. Uploads belonging to other users
+// are reported as not found.
+func loadVideoUpload(c *gin.Context, db *database.DB) (*database.VideoUpload, bool) {
+	if db == nil {
+		serviceUnavailable(c)
+		return nil, false
+	}
+
+	upload, err := db.GetVideoUpload(c.Param("id"))
+	if err == sql.ErrNoRows || (err == nil && upload.UserID != c.GetInt("user_id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load video upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload"})
+		return nil, false
+	}
+
+	if upload.Status == "expired" {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has expired"})
+		return nil, false
+	}
+	return upload, true
+}
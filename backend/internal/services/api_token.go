@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+// API tokens look like bome_pat_<8 hex id>_<secret> or bome_key_<8 hex id>_<secret>.
+// The part before the secret is stored in clear text and identifies the token.
+const (
+	personalTokenPrefix = "bome_pat_"
+	apiKeyTokenPrefix   = "bome_key_"
+	apiTokenIDLength    = 8
+
+	// DefaultAPITokenLifetime applies when no expiry is requested
+	DefaultAPITokenLifetime = 90 * 24 * time.Hour
+	// MaxAPITokenLifetime is the longest expiry an API token may have
+	MaxAPITokenLifetime = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidAPIToken = errors.New("invalid API token")
+	ErrAPITokenRevoked = errors.New("API token has been revoked")
+	ErrAPITokenExpired = errors.New("API token has expired")
+)
+
+// APITokenPrincipal is the identity an API token authenticates as
+type APITokenPrincipal struct {
+	TokenID       int
+	Kind          string
+	UserID        int
+	Email         string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+// IsAPIToken reports whether a bearer credential is an API token rather than a JWT
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix) || strings.HasPrefix(token, apiKeyTokenPrefix)
+}
+
+// GenerateAPIToken creates a new token of the given kind, returning the token to show
+// once, its public prefix and the hash to store
+func GenerateAPIToken(kind string) (token, prefix, hash string, err error) {
+	switch kind {
+	case database.APITokenKindPersonal:
+		prefix = personalTokenPrefix
+	case database.APITokenKindAPIKey:
+		prefix = apiKeyTokenPrefix
+	default:
+		return "", "", "", fmt.Errorf("unknown API token kind: %s", kind)
+	}
+
+	prefix += GenerateRandomToken(apiTokenIDLength / 2)
+	token = prefix + "_" + GenerateRandomToken(24)
+	return token, prefix, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the stored hash of an API token. Tokens are high-entropy
+// random values, so a fast hash is sufficient.
+func HashAPIToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// apiTokenPrefix extracts the public prefix from an API token
+func apiTokenPrefix(token string) (string, bool) {
+	var kindPrefix string
+	switch {
+	case strings.HasPrefix(token, personalTokenPrefix):
+		kindPrefix = personalTokenPrefix
+	case strings.HasPrefix(token, apiKeyTokenPrefix):
+		kindPrefix = apiKeyTokenPrefix
+	default:
+		return "", false
+	}
+
+	end := len(kindPrefix) + apiTokenIDLength
+	if len(token) <= end+1 || token[end] != '_' {
+		return "", false
+	}
+	return token[:end], true
+}
+
+// AuthenticateAPIToken verifies an API token and records its use
+func AuthenticateAPIToken(db *database.DB, token, ipAddress string) (*APITokenPrincipal, error) {
+	prefix, ok := apiTokenPrefix(token)
+	if !ok {
+		return nil, ErrInvalidAPIToken
+	}
+
+	stored, err := db.GetAPITokenByPrefix(prefix)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashAPIToken(token)), []byte(stored.TokenHash)) != 1 {
+		return nil, ErrInvalidAPIToken
+	}
+	if stored.RevokedAt.Valid {
+		return nil, ErrAPITokenRevoked
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrAPITokenExpired
+	}
+	if !stored.OwnerActive {
+		return nil, ErrInvalidAPIToken
+	}
+
+	go func() {
+		if err := db.TouchAPIToken(stored.ID, ipAddress); err != nil {
+			log.Printf("Failed to record API token use: %v", err)
+		}
+	}()
+
+	return &APITokenPrincipal{
+		TokenID:       stored.ID,
+		Kind:          stored.Kind,
+		UserID:        stored.UserID,
+		Email:         stored.OwnerEmail,
+		Role:          stored.OwnerRole,
+		EmailVerified: stored.OwnerEmailVerified,
+		Scopes:        stored.Scopes,
+	}, nil
+}
@@ -2,13 +2,17 @@ package services
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -23,21 +27,64 @@ type BunnyService struct {
 	region        string
 	webhookSecret string
 	client        *http.Client
+	uploadClient  *http.Client
 }
 
 // BunnyVideo represents a video in Bunny Stream
 type BunnyVideo struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Duration    float64   `json:"duration"`
-	Size        int64     `json:"size"`
-	Thumbnail   string    `json:"thumbnail"`
-	Preview     string    `json:"preview"`
-	LibraryID   string    `json:"library_id"`
+	ID                   string    `json:"guid"`
+	Title                string    `json:"title"`
+	Description          string    `json:"description"`
+	Status               int       `json:"status"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+	Duration             float64   `json:"length"`
+	Size                 int64     `json:"storageSize"`
+	Width                int       `json:"width"`
+	Height               int       `json:"height"`
+	AvailableResolutions string    `json:"availableResolutions"`
+	EncodeProgress       int       `json:"encodeProgress"`
+	ThumbnailFileName    string    `json:"thumbnailFileName"`
+	Category             string    `json:"category"`
+	CollectionID         string    `json:"collectionId"`
+	Views                int       `json:"views"`
+	IsPublic             bool      `json:"isPublic"`
+	HasMP4Fallback       bool      `json:"hasMP4Fallback"`
+	Thumbnail            string    `json:"thumbnail"`
+	Preview              string    `json:"preview"`
+	LibraryID            string    `json:"library_id"`
+	MetaTags             []struct {
+		Property string `json:"property"`
+		Value    string `json:"value"`
+	} `json:"metaTags"`
+	TranscodingMessages []struct {
+		Message string `json:"message"`
+	} `json:"transcodingMessages"`
+}
+
+// BunnyVideoList is a page of videos in a Bunny Stream library
+type BunnyVideoList struct {
+	TotalItems   int          `json:"totalItems"`
+	CurrentPage  int          `json:"currentPage"`
+	ItemsPerPage int          `json:"itemsPerPage"`
+	Items        []BunnyVideo `json:"items"`
+}
+
+// ErrBunnyVideoNotFound is returned when Bunny Stream has no video with the requested ID
+var ErrBunnyVideoNotFound = errors.New("video not found")
+
+// BunnyAPIError is returned when Bunny Stream answers with an unexpected status
+type BunnyAPIError struct {
+	StatusCode int
+}
+
+func (e *BunnyAPIError) Error() string {
+	return fmt.Sprintf("Bunny Stream request failed with status %d", e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed if it is retried
+func (e *BunnyAPIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
 }
 
 // BunnyUploadResponse represents the response from a video upload
@@ -49,12 +96,13 @@ type BunnyUploadResponse struct {
 
 // BunnyCollection represents a collection in Bunny Stream
 type BunnyCollection struct {
-	ID         string    `json:"guid"`
-	Name       string    `json:"name"`
-	VideoCount int       `json:"videoCount"`
-	TotalSize  int64     `json:"totalSize"`
-	CreatedAt  time.Time `json:"dateCreated"`
-	UpdatedAt  time.Time `json:"lastUpdated"`
+	ID               string    `json:"guid"`
+	Name             string    `json:"name"`
+	VideoCount       int       `json:"videoCount"`
+	TotalSize        int64     `json:"totalSize"`
+	CreatedAt        time.Time `json:"dateCreated"`
+	UpdatedAt        time.Time `json:"lastUpdated"`
+	PreviewImageURLs []string  `json:"previewImageUrls"`
 }
 
 // BunnyCollectionsResponse represents the API response for collections
@@ -96,67 +144,120 @@ func NewBunnyService() *BunnyService {
 		region:        os.Getenv("BUNNY_REGION"),
 		webhookSecret: os.Getenv("BUNNY_WEBHOOK_SECRET"),
 		client:        &http.Client{Timeout: 30 * time.Second},
+		// Video uploads can take hours on a slow link
+		uploadClient: &http.Client{Timeout: 12 * time.Hour},
 	}
 }
 
-// UploadVideo uploads a video file to Bunny Stream
-func (b *BunnyService) UploadVideo(file *multipart.FileHeader, title, description string) (*BunnyUploadResponse, error) {
-	// Open the uploaded file
-	src, err := file.Open()
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+// UploadVideo creates a video in Bunny Stream and streams its content there. The
+// content is not buffered in memory, so multi-gigabyte files can be uploaded.
+func (b *BunnyService) UploadVideo(content io.Reader, size int64, title string) (*BunnyUploadResponse, error) {
+	if b.streamLibrary == "" || b.streamAPIKey == "" {
+		return nil, fmt.Errorf("Bunny.net configuration missing (library: %v, key: %v)",
+			b.streamLibrary != "", b.streamAPIKey != "")
 	}
-	defer src.Close()
 
-	// Create the request body
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	// Create the video object
+	createBody, err := json.Marshal(map[string]string{"title": title})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
 
-	// Add the video file
-	part, err := writer.CreateFormFile("video", filepath.Base(file.Filename))
+	url := fmt.Sprintf("https://video.bunnycdn.com/library/%s/videos", b.streamLibrary)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(createBody))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("AccessKey", b.streamAPIKey)
 
-	_, err = io.Copy(part, src)
+	resp, err := b.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy file: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// Add metadata
-	writer.WriteField("title", title)
-	writer.WriteField("description", description)
-	writer.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create video: status %d", resp.StatusCode)
+	}
 
-	// Create the request
-	url := fmt.Sprintf("https://video.bunnycdn.com/library/%s/videos", b.streamLibrary)
-	req, err := http.NewRequest("POST", url, body)
+	var created BunnyVideo
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if created.ID == "" {
+		return nil, fmt.Errorf("invalid response: missing video ID")
+	}
+
+	// Upload the content
+	uploadReq, err := http.NewRequest("PUT", fmt.Sprintf("%s/%s", url, created.ID), content)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	uploadReq.ContentLength = size
+	uploadReq.Header.Set("Content-Type", "application/octet-stream")
+	uploadReq.Header.Set("AccessKey", b.streamAPIKey)
+
+	uploadResp, err := b.uploadClient.Do(uploadReq)
+	if err != nil {
+		b.deleteIncompleteVideo(created.ID)
+		return nil, fmt.Errorf("failed to upload video: %w", err)
+	}
+	defer uploadResp.Body.Close()
+
+	if uploadResp.StatusCode != http.StatusOK && uploadResp.StatusCode != http.StatusCreated {
+		b.deleteIncompleteVideo(created.ID)
+		return nil, fmt.Errorf("upload failed with status: %d", uploadResp.StatusCode)
+	}
+
+	return &BunnyUploadResponse{
+		Success: true,
+		Message: "Video uploaded successfully",
+		VideoID: created.ID,
+	}, nil
+}
+
+// deleteIncompleteVideo removes a video whose upload failed, so an empty video is not
+// left behind in the library
+func (b *BunnyService) deleteIncompleteVideo(videoID string) {
+	if err := b.DeleteVideo(videoID); err != nil {
+		log.Printf("Failed to delete incomplete Bunny video %s: %v", videoID, err)
+	}
+}
+
+// ListVideos retrieves a page of videos in the library, oldest first. Pages start at 1.
+func (b *BunnyService) ListVideos(page, perPage int) (*BunnyVideoList, error) {
+	if b.streamLibrary == "" || b.streamAPIKey == "" {
+		return nil, fmt.Errorf("Bunny.net configuration missing (library: %v, key: %v)",
+			b.streamLibrary != "", b.streamAPIKey != "")
+	}
 
-	// Set headers
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	url := fmt.Sprintf("https://video.bunnycdn.com/library/%s/videos?page=%d&itemsPerPage=%d&orderBy=date",
+		b.streamLibrary, page, perPage)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
 	req.Header.Set("AccessKey", b.streamAPIKey)
 
-	// Make the request
 	resp, err := b.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Parse response
-	var uploadResp BunnyUploadResponse
-	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &BunnyAPIError{StatusCode: resp.StatusCode}
 	}
 
-	if !uploadResp.Success {
-		return nil, fmt.Errorf("upload failed: %s", uploadResp.Message)
+	var list BunnyVideoList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-
-	return &uploadResp, nil
+	return &list, nil
 }
 
 // GetVideo retrieves video information from Bunny Stream
@@ -205,7 +306,7 @@ func (b *BunnyService) GetVideo(videoID string) (*BunnyVideo, error) {
 	case http.StatusForbidden:
 		return nil, fmt.Errorf("forbidden: insufficient permissions")
 	case http.StatusNotFound:
-		return nil, fmt.Errorf("video not found: %s", videoID)
+		return nil, fmt.Errorf("%w: %s", ErrBunnyVideoNotFound, videoID)
 	case http.StatusTooManyRequests:
 		return nil, fmt.Errorf("rate limited by Bunny.net")
 	default:
@@ -274,6 +375,65 @@ func (b *BunnyService) DeleteVideo(videoID string) error {
 	return nil
 }
 
+// UploadCaption adds a caption track to a video in Bunny Stream, replacing any track in
+// the same language
+func (b *BunnyService) UploadCaption(videoID, language, label string, content []byte) error {
+	url := fmt.Sprintf("https://video.bunnycdn.com/library/%s/videos/%s/captions/%s", b.streamLibrary, videoID, language)
+
+	body, err := json.Marshal(map[string]string{
+		"srclang":      language,
+		"label":        label,
+		"captionsFile": base64.StdEncoding.EncodeToString(content),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode caption: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("AccessKey", b.streamAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &BunnyAPIError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// DeleteCaption removes a video's caption track in a language from Bunny Stream
+func (b *BunnyService) DeleteCaption(videoID, language string) error {
+	url := fmt.Sprintf("https://video.bunnycdn.com/library/%s/videos/%s/captions/%s", b.streamLibrary, videoID, language)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("AccessKey", b.streamAPIKey)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return &BunnyAPIError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
 // UploadToStorage uploads a file to Bunny Storage (for thumbnails, etc.)
 func (b *BunnyService) UploadToStorage(filePath, remotePath string) error {
 	file, err := os.Open(filePath)
@@ -310,35 +470,44 @@ func (b *BunnyService) GetCDNURL(path string) string {
 	return fmt.Sprintf("https://%s.b-cdn.net/%s", b.pullZone, strings.TrimPrefix(path, "/"))
 }
 
-// ValidateWebhookSignature validates the webhook signature from Bunny.net
+// ValidateWebhookSignature checks the hex-encoded HMAC-SHA256 of a webhook payload
+// against the configured webhook secret. Without a secret, every webhook is rejected.
 func (b *BunnyService) ValidateWebhookSignature(payload []byte, signature string) bool {
-	// Implement webhook signature validation
-	// This is a simplified version - implement proper HMAC validation
-	return true
-}
+	if b.webhookSecret == "" || signature == "" {
+		return false
+	}
 
-// ProcessWebhook processes webhook events from Bunny.net
-func (b *BunnyService) ProcessWebhook(eventType string, payload []byte) error {
-	switch eventType {
-	case "video.encoded":
-		return b.handleVideoEncoded(payload)
-	case "video.failed":
-		return b.handleVideoFailed(payload)
-	default:
-		return fmt.Errorf("unknown event type: %s", eventType)
+	expected, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil {
+		return false
 	}
+
+	mac := hmac.New(sha256.New, []byte(b.webhookSecret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
 }
 
-func (b *BunnyService) handleVideoEncoded(payload []byte) error {
-	// Handle video encoding completion
-	// Update database with video status
-	return nil
+// WebhooksConfigured reports whether a webhook secret is set
+func (b *BunnyService) WebhooksConfigured() bool {
+	return b != nil && b.webhookSecret != ""
 }
 
-func (b *BunnyService) handleVideoFailed(payload []byte) error {
-	// Handle video encoding failure
-	// Update database with error status
-	return nil
+// MapBunnyStatus maps Bunny.net status codes to readable status strings
+func MapBunnyStatus(status int) string {
+	switch status {
+	case 0:
+		return "queued"
+	case 1:
+		return "processing"
+	case 2:
+		return "encoding"
+	case 3:
+		return "ready"
+	case 4:
+		return "error"
+	default:
+		return "unknown"
+	}
 }
 
 // GetCollections retrieves all collections from Bunny Stream
@@ -361,7 +530,7 @@ func (b *BunnyService) GetCollections(page int, perPage int) (*BunnyCollectionsR
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get collections, status: %d", resp.StatusCode)
+		return nil, &BunnyAPIError{StatusCode: resp.StatusCode}
 	}
 
 	var collections BunnyCollectionsResponse
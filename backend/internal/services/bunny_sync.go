@@ -0,0 +1,614 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	bunnySyncPageSize = 100
+	// bunnySyncRequestAttempts is how many times a page request is tried before the
+	// run is given up
+	bunnySyncRequestAttempts = 4
+	// bunnySyncMaxAttempts is how many times a job is run before it is marked failed
+	bunnySyncMaxAttempts = 3
+	// bunnySyncStaleAfter is how long a running job may go without reporting progress
+	// before it is assumed interrupted and queued again
+	bunnySyncStaleAfter = 10 * time.Minute
+	// maxBunnySyncChanges bounds the changes recorded on a job
+	maxBunnySyncChanges = 500
+)
+
+// ErrBunnySyncUnsafe is returned instead of deleting every synced video when Bunny
+// Stream reports an empty library
+var ErrBunnySyncUnsafe = errors.New("Bunny library is empty; refusing to delete every synced video")
+
+// BunnySyncChange describes a video a sync created, updated or deleted, or a series it
+// mirrored from a collection, or would have in a dry run
+type BunnySyncChange struct {
+	Action            string                          `json:"action"`
+	BunnyVideoID      string                          `json:"bunny_video_id,omitempty"`
+	VideoID           int                             `json:"video_id,omitempty"`
+	BunnyCollectionID string                          `json:"bunny_collection_id,omitempty"`
+	SeriesID          int                             `json:"series_id,omitempty"`
+	Title             string                          `json:"title"`
+	Fields            map[string]BunnySyncFieldChange `json:"fields,omitempty"`
+	Error             string                          `json:"error,omitempty"`
+}
+
+// BunnySyncFieldChange is the old and new value of a field changed by a sync
+type BunnySyncFieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// BunnySyncService reconciles the videos table with the Bunny Stream library. Syncs are
+// queued in the database and run one at a time by a background worker.
+type BunnySyncService struct {
+	db    *database.DB
+	bunny *BunnyService
+}
+
+// NewBunnySyncService creates a library sync service
+func NewBunnySyncService(db *database.DB, bunny *BunnyService) *BunnySyncService {
+	return &BunnySyncService{
+		db:    db,
+		bunny: bunny,
+	}
+}
+
+// Enqueue queues a sync. With mirrorCollections, the sync also mirrors Bunny
+// collections into series. If a sync is already queued or running, that job is
+// returned and created is false.
+func (s *BunnySyncService) Enqueue(dryRun, mirrorCollections bool, requestedBy *int) (*database.BunnySyncJob, bool, error) {
+	return s.db.CreateBunnySyncJob(dryRun, mirrorCollections, requestedBy)
+}
+
+// Start periodically runs queued syncs. Syncs that were interrupted, for example by a
+// restart, are queued again.
+func (s *BunnySyncService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.db.RequeueStaleBunnySyncJobs(bunnySyncStaleAfter); err != nil {
+				log.Printf("Failed to requeue interrupted Bunny syncs: %v", err)
+			}
+			s.RunPending()
+		}
+	}()
+}
+
+// RunPending runs every queued sync that is due
+func (s *BunnySyncService) RunPending() {
+	for {
+		job, err := s.db.ClaimBunnySyncJob()
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim Bunny sync job: %v", err)
+			return
+		}
+
+		log.Printf("Bunny sync %d started (attempt %d, dry run: %v, mirror collections: %v)", job.ID, job.Attempts, job.DryRun, job.MirrorCollections)
+		if err := s.run(job); err != nil {
+			s.handleFailure(job, err)
+			continue
+		}
+		log.Printf("Bunny sync %d completed: %d created, %d updated, %d deleted, %d unchanged, %d failed",
+			job.ID, job.Created, job.Updated, job.Deleted, job.Unchanged, job.Failed)
+	}
+}
+
+// handleFailure queues a failed sync again with exponential backoff, or marks it failed
+// once it has run out of attempts or cannot succeed by retrying
+func (s *BunnySyncService) handleFailure(job *database.BunnySyncJob, runErr error) {
+	if job.Attempts < bunnySyncMaxAttempts && isTemporaryBunnyError(runErr) {
+		nextAttempt := time.Now().Add(time.Minute << (job.Attempts - 1))
+		log.Printf("Bunny sync %d failed, retrying at %s: %v", job.ID, nextAttempt.Format(time.RFC3339), runErr)
+		if err := s.db.RetryBunnySyncJob(job.ID, runErr.Error(), nextAttempt); err != nil {
+			log.Printf("Failed to requeue Bunny sync %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Bunny sync %d failed: %v", job.ID, runErr)
+	if err := s.db.FailBunnySyncJob(job.ID, runErr.Error()); err != nil {
+		log.Printf("Failed to mark Bunny sync %d as failed: %v", job.ID, err)
+	}
+}
+
+// run lists the whole library and creates, updates and deletes videos to match it, then
+// mirrors collections into series if the job asks for it. In a dry run the changes are
+// only recorded.
+func (s *BunnySyncService) run(job *database.BunnySyncJob) error {
+	listingStarted := time.Now()
+	library, err := s.listLibrary(job)
+	if err != nil {
+		return err
+	}
+
+	linked, err := s.db.GetBunnyLinkedVideos()
+	if err != nil {
+		return fmt.Errorf("failed to load videos: %w", err)
+	}
+	if len(library) == 0 && len(linked) > 0 {
+		return ErrBunnySyncUnsafe
+	}
+
+	existing := make(map[string]*database.BunnyLinkedVideo, len(linked))
+	for _, video := range linked {
+		existing[video.BunnyVideoID] = video
+	}
+
+	job.Phase = "reconciling"
+	var changes []BunnySyncChange
+	record := func(change BunnySyncChange) {
+		if len(changes) < maxBunnySyncChanges {
+			changes = append(changes, change)
+		}
+	}
+
+	seen := make(map[string]bool, len(library))
+	videoIDs := make(map[string]int, len(library))
+	for i := range library {
+		bunnyVideo := &library[i]
+		seen[bunnyVideo.ID] = true
+
+		if video, ok := existing[bunnyVideo.ID]; ok {
+			videoIDs[bunnyVideo.ID] = video.ID
+			if change, changed := s.reconcileVideo(job, video, bunnyVideo); changed {
+				record(change)
+			}
+		} else {
+			change := s.createVideo(job, bunnyVideo)
+			if change.VideoID != 0 {
+				videoIDs[bunnyVideo.ID] = change.VideoID
+			}
+			record(change)
+		}
+
+		job.ProcessedItems++
+		if job.ProcessedItems%25 == 0 {
+			s.saveProgress(job)
+		}
+	}
+
+	// Videos created after the listing began may not have appeared in it yet
+	for _, video := range linked {
+		if seen[video.BunnyVideoID] || !video.CreatedAt.Before(listingStarted) {
+			continue
+		}
+		if change, missing := s.confirmMissing(job, video); !missing {
+			if change.Error != "" {
+				record(change)
+			}
+			continue
+		}
+		record(s.deleteVideo(job, video))
+	}
+
+	if job.MirrorCollections {
+		if err := s.mirrorCollections(job, library, videoIDs, record); err != nil {
+			return err
+		}
+	}
+
+	if changes == nil {
+		changes = []BunnySyncChange{}
+	}
+	if job.Changes, err = json.Marshal(changes); err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	return s.db.CompleteBunnySyncJob(job)
+}
+
+// listLibrary fetches every page of the library, retrying each page with backoff
+func (s *BunnySyncService) listLibrary(job *database.BunnySyncJob) ([]BunnyVideo, error) {
+	job.Phase = "listing"
+	s.saveProgress(job)
+
+	var library []BunnyVideo
+	for page := 1; ; page++ {
+		list, err := s.listPage(page)
+		if err != nil {
+			return nil, err
+		}
+
+		library = append(library, list.Items...)
+		job.TotalItems = list.TotalItems
+		s.saveProgress(job)
+
+		if len(list.Items) < bunnySyncPageSize || len(library) >= list.TotalItems {
+			break
+		}
+	}
+
+	if len(library) > job.TotalItems {
+		job.TotalItems = len(library)
+	}
+	return library, nil
+}
+
+// listPage fetches one page, retrying temporary failures with exponential backoff
+func (s *BunnySyncService) listPage(page int) (*BunnyVideoList, error) {
+	var list *BunnyVideoList
+	err := retryBunnyRequest(func() (err error) {
+		list, err = s.bunny.ListVideos(page, bunnySyncPageSize)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list page %d of the Bunny library: %w", page, err)
+	}
+	return list, nil
+}
+
+// retryBunnyRequest runs request until it succeeds, fails permanently or has been tried
+// bunnySyncRequestAttempts times, backing off exponentially between attempts
+func retryBunnyRequest(request func() error) error {
+	var err error
+	for attempt := 1; attempt <= bunnySyncRequestAttempts; attempt++ {
+		if err = request(); err == nil || !isTemporaryBunnyError(err) {
+			return err
+		}
+		if attempt < bunnySyncRequestAttempts {
+			time.Sleep(time.Second << (attempt - 1))
+		}
+	}
+	return err
+}
+
+// reconcileVideo brings the fields Bunny Stream owns up to date. Titles, descriptions
+// and categories are left alone since admins edit them here.
+func (s *BunnySyncService) reconcileVideo(job *database.BunnySyncJob, video *database.BunnyLinkedVideo, bunnyVideo *BunnyVideo) (BunnySyncChange, bool) {
+	change := BunnySyncChange{
+		Action:       "update",
+		BunnyVideoID: bunnyVideo.ID,
+		VideoID:      video.ID,
+		Title:        video.Title,
+		Fields:       make(map[string]BunnySyncFieldChange),
+	}
+
+	status := MapBunnyStatus(bunnyVideo.Status)
+	duration := int(bunnyVideo.Duration)
+	thumbnailURL := s.bunny.GetThumbnailURL(bunnyVideo.ID)
+	resolution := bunnyResolution(bunnyVideo)
+
+	if status != video.Status {
+		change.Fields["status"] = BunnySyncFieldChange{From: video.Status, To: status}
+	}
+	if duration > 0 && duration != video.Duration {
+		change.Fields["duration"] = BunnySyncFieldChange{From: video.Duration, To: duration}
+	}
+	if bunnyVideo.Size > 0 && bunnyVideo.Size != video.FileSize {
+		change.Fields["file_size"] = BunnySyncFieldChange{From: video.FileSize, To: bunnyVideo.Size}
+	}
+	if thumbnailURL != "" && thumbnailURL != video.ThumbnailURL {
+		change.Fields["thumbnail_url"] = BunnySyncFieldChange{From: video.ThumbnailURL, To: thumbnailURL}
+	}
+	if resolution != "" && resolution != video.Resolution {
+		change.Fields["resolution"] = BunnySyncFieldChange{From: video.Resolution, To: resolution}
+	}
+	if bunnyVideo.AvailableResolutions != "" && bunnyVideo.AvailableResolutions != video.AvailableResolutions {
+		change.Fields["available_resolutions"] = BunnySyncFieldChange{From: video.AvailableResolutions, To: bunnyVideo.AvailableResolutions}
+	}
+
+	if len(change.Fields) == 0 {
+		job.Unchanged++
+		return change, false
+	}
+
+	if !job.DryRun {
+		if err := s.db.UpdateVideoFromBunny(video.ID, status, duration, bunnyVideo.Size, thumbnailURL, resolution, bunnyVideo.AvailableResolutions); err != nil {
+			change.Error = err.Error()
+			job.Failed++
+			return change, true
+		}
+	}
+	job.Updated++
+	return change, true
+}
+
+// createVideo adds a video found in the library that is not in the database yet
+func (s *BunnySyncService) createVideo(job *database.BunnySyncJob, bunnyVideo *BunnyVideo) BunnySyncChange {
+	title := bunnyVideo.Title
+	if title == "" {
+		title = bunnyVideo.ID
+	}
+	change := BunnySyncChange{Action: "create", BunnyVideoID: bunnyVideo.ID, Title: title}
+	if job.DryRun {
+		job.Created++
+		return change
+	}
+
+	category := bunnyVideo.Category
+	if category == "" {
+		category = "General"
+	}
+
+	// Videos found by a scheduled sync have no creator
+	video, err := s.db.CreateVideo(title, bunnyVideoDescription(bunnyVideo), bunnyVideo.ID, s.bunny.GetThumbnailURL(bunnyVideo.ID),
+		category, int(bunnyVideo.Duration), bunnyVideo.Size, bunnyVideoTags(bunnyVideo), int(job.RequestedBy.Int64))
+	if err == nil {
+		change.VideoID = video.ID
+		err = s.db.UpdateVideoFromBunny(video.ID, MapBunnyStatus(bunnyVideo.Status), 0, 0, "", bunnyResolution(bunnyVideo), bunnyVideo.AvailableResolutions)
+	}
+	if err == nil && bunnyVideo.Views > 0 {
+		err = s.db.UpdateVideoViews(video.ID, bunnyVideo.Views)
+	}
+	if err != nil {
+		change.Error = err.Error()
+		job.Failed++
+		return change
+	}
+
+	job.Created++
+	return change
+}
+
+// confirmMissing asks Bunny Stream for a video the listing did not include. Paged
+// listings can skip videos when the library changes while it is listed, so a video is
+// only deleted once Bunny Stream reports it does not exist. If that cannot be confirmed
+// the returned change records the error.
+func (s *BunnySyncService) confirmMissing(job *database.BunnySyncJob, video *database.BunnyLinkedVideo) (BunnySyncChange, bool) {
+	err := retryBunnyRequest(func() error {
+		_, err := s.bunny.GetVideo(video.BunnyVideoID)
+		return err
+	})
+	if errors.Is(err, ErrBunnyVideoNotFound) {
+		return BunnySyncChange{}, true
+	}
+	if err == nil {
+		log.Printf("Bunny sync %d: video %d (%s) was missing from the listing but still exists", job.ID, video.ID, video.BunnyVideoID)
+		return BunnySyncChange{}, false
+	}
+
+	job.Failed++
+	return BunnySyncChange{
+		Action:       "delete",
+		BunnyVideoID: video.BunnyVideoID,
+		VideoID:      video.ID,
+		Title:        video.Title,
+		Error:        fmt.Sprintf("could not confirm the video was removed from Bunny Stream: %v", err),
+	}, false
+}
+
+// deleteVideo removes a video whose Bunny Stream video no longer exists
+func (s *BunnySyncService) deleteVideo(job *database.BunnySyncJob, video *database.BunnyLinkedVideo) BunnySyncChange {
+	change := BunnySyncChange{Action: "delete", BunnyVideoID: video.BunnyVideoID, VideoID: video.ID, Title: video.Title}
+	if !job.DryRun {
+		if err := s.db.DeleteVideo(video.ID); err != nil {
+			change.Error = err.Error()
+			job.Failed++
+			return change
+		}
+	}
+	job.Deleted++
+	return change
+}
+
+// mirrorCollections keeps a series in step with each Bunny collection. Series are
+// created as drafts; after that admins own their title, description, cover and status,
+// and only which videos they contain is synced.
+func (s *BunnySyncService) mirrorCollections(job *database.BunnySyncJob, library []BunnyVideo, videoIDs map[string]int, record func(BunnySyncChange)) error {
+	job.Phase = "collections"
+	s.saveProgress(job)
+
+	var collections []BunnyCollection
+	for page := 1; ; page++ {
+		var list *BunnyCollectionsResponse
+		err := retryBunnyRequest(func() (err error) {
+			list, err = s.bunny.GetCollections(page, bunnySyncPageSize)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list page %d of the Bunny collections: %w", page, err)
+		}
+
+		collections = append(collections, list.Items...)
+		if len(list.Items) < bunnySyncPageSize || len(collections) >= list.TotalItems {
+			break
+		}
+	}
+
+	members := make(map[string][]*BunnyVideo)
+	for i := range library {
+		if collectionID := library[i].CollectionID; collectionID != "" {
+			members[collectionID] = append(members[collectionID], &library[i])
+		}
+	}
+
+	for i := range collections {
+		if change, changed := s.mirrorCollection(job, &collections[i], members[collections[i].ID], videoIDs); changed {
+			record(change)
+		}
+	}
+	return nil
+}
+
+// mirrorCollection creates or updates the series for a collection. Videos already in
+// the series keep their order and new ones are added at the end, sorted by title.
+func (s *BunnySyncService) mirrorCollection(job *database.BunnySyncJob, collection *BunnyCollection, members []*BunnyVideo, videoIDs map[string]int) (BunnySyncChange, bool) {
+	change := BunnySyncChange{Action: "update_series", BunnyCollectionID: collection.ID, Title: collection.Name}
+
+	series, err := s.db.GetSeriesByBunnyCollection(collection.ID)
+	if err != nil && err != sql.ErrNoRows {
+		change.Error = err.Error()
+		job.Failed++
+		return change, true
+	}
+
+	var current []int
+	if series != nil {
+		change.SeriesID = series.ID
+		change.Title = series.Title
+		items, err := s.db.GetSeriesItems(series.ID)
+		if err != nil {
+			change.Error = err.Error()
+			job.Failed++
+			return change, true
+		}
+		for _, item := range items {
+			current = append(current, item.VideoID)
+		}
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Title) < strings.ToLower(members[j].Title)
+	})
+	wanted := make(map[int]bool, len(members))
+	for _, video := range members {
+		// Videos a dry run would create have no ID yet
+		if id, ok := videoIDs[video.ID]; ok {
+			wanted[id] = true
+		}
+	}
+
+	order := make([]int, 0, len(wanted))
+	for _, id := range current {
+		if wanted[id] {
+			order = append(order, id)
+			delete(wanted, id)
+		}
+	}
+	for _, video := range members {
+		if id, ok := videoIDs[video.ID]; ok && wanted[id] {
+			order = append(order, id)
+			delete(wanted, id)
+		}
+	}
+
+	if series != nil && equalInts(current, order) {
+		return change, false
+	}
+	if current == nil {
+		current = []int{}
+	}
+	change.Fields = map[string]BunnySyncFieldChange{"videos": {From: current, To: order}}
+
+	if series == nil {
+		change.Action = "create_series"
+	}
+	if job.DryRun {
+		return change, true
+	}
+
+	if series == nil {
+		series = &database.Series{
+			Slug:              Slugify(collection.Name),
+			Kind:              "series",
+			Title:             collection.Name,
+			Status:            "draft",
+			BunnyCollectionID: sql.NullString{String: collection.ID, Valid: true},
+			CreatedBy:         job.RequestedBy,
+		}
+		if series.Slug == "" {
+			series.Slug = "collection-" + collection.ID
+		}
+		if series.Title == "" {
+			series.Title = collection.ID
+		}
+		if len(collection.PreviewImageURLs) > 0 {
+			series.CoverImageURL = collection.PreviewImageURLs[0]
+		}
+		if err := s.db.CreateSeries(series); err != nil {
+			change.Error = err.Error()
+			job.Failed++
+			return change, true
+		}
+		change.SeriesID = series.ID
+	}
+
+	if err := s.db.SetSeriesItems(series.ID, order); err != nil {
+		change.Error = err.Error()
+		job.Failed++
+	}
+	return change, true
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *BunnySyncService) saveProgress(job *database.BunnySyncJob) {
+	if err := s.db.UpdateBunnySyncJobProgress(job); err != nil {
+		log.Printf("Failed to record progress of Bunny sync %d: %v", job.ID, err)
+	}
+}
+
+// isTemporaryBunnyError reports whether a failed request may succeed if retried. Errors
+// other than Bunny API responses, such as timeouts, are treated as temporary.
+func isTemporaryBunnyError(err error) bool {
+	if errors.Is(err, ErrBunnySyncUnsafe) || errors.Is(err, ErrBunnyVideoNotFound) {
+		return false
+	}
+	var apiErr *BunnyAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return !strings.Contains(err.Error(), "configuration missing")
+}
+
+func bunnyResolution(video *BunnyVideo) string {
+	if video.Width > 0 && video.Height > 0 {
+		return fmt.Sprintf("%dx%d", video.Width, video.Height)
+	}
+	return ""
+}
+
+// bunnyVideoTags takes tags from the video's meta tags, or derives them from its
+// properties when it has none
+func bunnyVideoTags(video *BunnyVideo) []string {
+	var tags []string
+	for _, metaTag := range video.MetaTags {
+		if metaTag.Property == "tag" {
+			tags = append(tags, metaTag.Value)
+		}
+	}
+	if len(tags) > 0 {
+		return tags
+	}
+
+	tags = []string{"bunny", "streaming"}
+	if video.HasMP4Fallback {
+		tags = append(tags, "mp4")
+	}
+	if video.IsPublic {
+		tags = append(tags, "public")
+	}
+	return tags
+}
+
+func bunnyVideoDescription(video *BunnyVideo) string {
+	if video.Description != "" {
+		return video.Description
+	}
+
+	description := fmt.Sprintf("Video from Bunny.net library. Duration: %d seconds, Resolution: %dx%d",
+		int(video.Duration), video.Width, video.Height)
+	if len(video.TranscodingMessages) > 0 {
+		description += "\n\nTranscoding notes:"
+		for _, msg := range video.TranscodingMessages {
+			description += fmt.Sprintf("\n- %s", msg.Message)
+		}
+	}
+	return description
+}
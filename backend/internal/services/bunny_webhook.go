@@ -0,0 +1,236 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+// Status codes sent in Bunny Stream webhooks. Codes 0-3 mean the same as the video
+// status codes handled by MapBunnyStatus.
+const (
+	bunnyWebhookFinished           = 3
+	bunnyWebhookResolutionFinished = 4
+	bunnyWebhookFailed             = 5
+	bunnyWebhookUploadStarted      = 6
+	bunnyWebhookUploadFinished     = 7
+	bunnyWebhookUploadFailed       = 8
+)
+
+// bunnyWebhookDedupeWindow is how long a repeated delivery of the same status for a
+// video is treated as a retry of the first rather than a new event
+const bunnyWebhookDedupeWindow = 5 * time.Minute
+
+// ErrInvalidBunnyWebhook is returned for webhook bodies that do not name a video
+var ErrInvalidBunnyWebhook = errors.New("invalid Bunny webhook payload")
+
+// BunnyWebhookPayload is the body Bunny Stream posts when a video's status changes
+type BunnyWebhookPayload struct {
+	VideoLibraryID int    `json:"VideoLibraryId"`
+	VideoGUID      string `json:"VideoGuid"`
+	Status         int    `json:"Status"`
+}
+
+// bunnyWebhookVideoStatus maps a webhook status code onto videos.status. It returns an
+// empty string for events that do not change the status, such as a single resolution
+// finishing or captions being generated.
+func bunnyWebhookVideoStatus(code int) string {
+	switch code {
+	case bunnyWebhookFailed, bunnyWebhookUploadFailed:
+		return "error"
+	case bunnyWebhookUploadStarted, bunnyWebhookUploadFinished:
+		return "queued"
+	case bunnyWebhookResolutionFinished:
+		return ""
+	}
+	if status := MapBunnyStatus(code); status != "unknown" {
+		return status
+	}
+	return ""
+}
+
+// bunnyWebhookEventKey identifies a delivery. Bunny Stream payloads carry no delivery
+// ID, so a status for a video is only deduplicated within the window it was received
+// in; the same status sent again later, after a re-encode for example, is a new event.
+func bunnyWebhookEventKey(body *BunnyWebhookPayload, receivedAt time.Time) string {
+	window := receivedAt.Truncate(bunnyWebhookDedupeWindow).Unix()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%d:%d", body.VideoLibraryID, body.VideoGUID, body.Status, window)))
+	return hex.EncodeToString(sum[:])
+}
+
+// bunnyVideoStatusRank orders the statuses a video passes through while it is encoded
+var bunnyVideoStatusRank = map[string]int{
+	"queued":     1,
+	"processing": 2,
+	"encoding":   3,
+	"ready":      4,
+}
+
+// bunnyWebhookMovesBackwards reports whether applying status to a video in current
+// would undo progress a later webhook already recorded. Webhooks can arrive out of
+// order, so a late "encoding" must not hide a video that is ready. A failed video
+// accepts any status, as it may have been re-encoded since.
+func bunnyWebhookMovesBackwards(current, status string) bool {
+	if status == "" || current == "error" || status == current {
+		return false
+	}
+	currentRank, ok := bunnyVideoStatusRank[current]
+	if !ok {
+		return false
+	}
+	if status == "error" {
+		return current == "ready"
+	}
+	return bunnyVideoStatusRank[status] < currentRank
+}
+
+// BunnyWebhookProcessor stores Bunny Stream webhooks and applies them to videos
+type BunnyWebhookProcessor struct {
+	db    *database.DB
+	bunny *BunnyService
+	email *EmailService
+}
+
+// NewBunnyWebhookProcessor creates a webhook processor. Without an email service,
+// uploaders are not told when encoding fails.
+func NewBunnyWebhookProcessor(db *database.DB, bunny *BunnyService, email *EmailService) *BunnyWebhookProcessor {
+	return &BunnyWebhookProcessor{
+		db:    db,
+		bunny: bunny,
+		email: email,
+	}
+}
+
+// Receive stores a verified webhook and processes it. A retried delivery of an event
+// that was already processed is not applied again; Receive then reports it as a
+// duplicate.
+func (p *BunnyWebhookProcessor) Receive(payload []byte) (*database.BunnyWebhookEvent, bool, error) {
+	var body BunnyWebhookPayload
+	if err := json.Unmarshal(payload, &body); err != nil || body.VideoGUID == "" {
+		return nil, false, ErrInvalidBunnyWebhook
+	}
+
+	event, created, err := p.db.RecordBunnyWebhookEvent(bunnyWebhookEventKey(&body, time.Now()), body.VideoGUID, body.Status, payload)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store webhook: %w", err)
+	}
+	if !created && event.ProcessedAt.Valid {
+		return event, true, nil
+	}
+
+	return event, false, p.process(event)
+}
+
+// Replay processes a stored webhook again, for example after a failure
+func (p *BunnyWebhookProcessor) Replay(eventID int) (*database.BunnyWebhookEvent, error) {
+	event, err := p.db.GetBunnyWebhookEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	processErr := p.process(event)
+	if event, err = p.db.GetBunnyWebhookEvent(eventID); err != nil {
+		return nil, err
+	}
+	return event, processErr
+}
+
+// process applies a stored webhook and records the outcome on the event
+func (p *BunnyWebhookProcessor) process(event *database.BunnyWebhookEvent) error {
+	var body BunnyWebhookPayload
+	if err := json.Unmarshal(event.Payload, &body); err != nil {
+		return fmt.Errorf("failed to decode stored webhook %d: %w", event.ID, err)
+	}
+
+	if err := p.applyEvent(&body); err != nil {
+		if failErr := p.db.FailBunnyWebhookEvent(event.ID, err.Error()); failErr != nil {
+			log.Printf("Failed to record error for Bunny webhook %d: %v", event.ID, failErr)
+		}
+		return err
+	}
+
+	return p.db.CompleteBunnyWebhookEvent(event.ID)
+}
+
+// applyEvent updates the video a webhook refers to. Videos that are not in the
+// database are ignored.
+func (p *BunnyWebhookProcessor) applyEvent(body *BunnyWebhookPayload) error {
+	video, err := p.db.GetVideoByBunnyID(body.VideoGUID)
+	if err == sql.ErrNoRows {
+		log.Printf("Ignoring Bunny webhook for untracked video %s", body.VideoGUID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load video %s: %w", body.VideoGUID, err)
+	}
+
+	status := bunnyWebhookVideoStatus(body.Status)
+	if bunnyWebhookMovesBackwards(video.Status, status) {
+		log.Printf("Ignoring out-of-order Bunny webhook for video %d (%s): %s after %s", video.ID, body.VideoGUID, status, video.Status)
+		return nil
+	}
+
+	if status == "error" {
+		return p.handleVideoFailed(video, body)
+	}
+	return p.handleVideoEncoded(video, body)
+}
+
+// handleVideoEncoded records encoding progress and refreshes the video's duration,
+// size, thumbnail and resolution from Bunny Stream
+func (p *BunnyWebhookProcessor) handleVideoEncoded(video *database.Video, body *BunnyWebhookPayload) error {
+	status := bunnyWebhookVideoStatus(body.Status)
+	if status == "" {
+		status = video.Status
+	}
+
+	bunnyVideo, err := p.bunny.GetVideo(body.VideoGUID)
+	if err != nil {
+		return fmt.Errorf("failed to refresh video %s from Bunny: %w", body.VideoGUID, err)
+	}
+
+	resolution := ""
+	if bunnyVideo.Width > 0 && bunnyVideo.Height > 0 {
+		resolution = fmt.Sprintf("%dx%d", bunnyVideo.Width, bunnyVideo.Height)
+	}
+
+	if err := p.db.UpdateVideoFromBunny(video.ID, status, int(bunnyVideo.Duration), bunnyVideo.Size, p.bunny.GetThumbnailURL(body.VideoGUID), resolution, bunnyVideo.AvailableResolutions); err != nil {
+		return fmt.Errorf("failed to update video %d: %w", video.ID, err)
+	}
+
+	if body.Status == bunnyWebhookFinished {
+		log.Printf("Video %d (%s) finished encoding", video.ID, body.VideoGUID)
+	}
+	return nil
+}
+
+// handleVideoFailed marks the video as failed and tells the uploader the first time
+// encoding fails
+func (p *BunnyWebhookProcessor) handleVideoFailed(video *database.Video, body *BunnyWebhookPayload) error {
+	if err := p.db.UpdateVideoStatus(video.ID, "error"); err != nil {
+		return fmt.Errorf("failed to update video %d: %w", video.ID, err)
+	}
+	log.Printf("Video %d (%s) failed to process (Bunny status %d)", video.ID, body.VideoGUID, body.Status)
+
+	// Replays and repeated failures do not notify the uploader again
+	if video.Status == "error" || p.email == nil || video.CreatedBy == 0 {
+		return nil
+	}
+
+	uploader, err := p.db.GetUserByID(video.CreatedBy)
+	if err != nil {
+		log.Printf("Failed to load uploader %d of video %d: %v", video.CreatedBy, video.ID, err)
+		return nil
+	}
+	if err := p.email.SendVideoProcessingFailedEmail(uploader.FirstName, uploader.Email, video.Title, video.ID); err != nil {
+		log.Printf("Failed to notify uploader of video %d: %v", video.ID, err)
+	}
+	return nil
+}
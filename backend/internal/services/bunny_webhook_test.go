@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBunnyWebhookEventKey(t *testing.T) {
+	receivedAt := time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC)
+	finished := &BunnyWebhookPayload{VideoLibraryID: 1, VideoGUID: "guid", Status: bunnyWebhookFinished}
+	key := bunnyWebhookEventKey(finished, receivedAt)
+
+	tests := []struct {
+		name       string
+		body       *BunnyWebhookPayload
+		receivedAt time.Time
+		duplicate  bool
+	}{
+		{name: "retried delivery", body: finished, receivedAt: receivedAt.Add(time.Minute), duplicate: true},
+		{name: "same status after the window", body: finished, receivedAt: receivedAt.Add(bunnyWebhookDedupeWindow)},
+		{name: "different status", body: &BunnyWebhookPayload{VideoLibraryID: 1, VideoGUID: "guid", Status: bunnyWebhookFailed}, receivedAt: receivedAt},
+		{name: "different video", body: &BunnyWebhookPayload{VideoLibraryID: 1, VideoGUID: "other", Status: bunnyWebhookFinished}, receivedAt: receivedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bunnyWebhookEventKey(tt.body, tt.receivedAt) == key; got != tt.duplicate {
+				t.Errorf("duplicate = %v, want %v", got, tt.duplicate)
+			}
+		})
+	}
+}
+
+func TestBunnyWebhookMovesBackwards(t *testing.T) {
+	tests := []struct {
+		current   string
+		status    string
+		backwards bool
+	}{
+		{current: "queued", status: "encoding"},
+		{current: "encoding", status: "ready"},
+		{current: "ready", status: "ready"},
+		{current: "ready", status: ""},
+		{current: "encoding", status: "error"},
+		{current: "error", status: "ready"},
+		{current: "error", status: "queued"},
+		{current: "pending", status: "queued"},
+		{current: "encoding", status: "processing", backwards: true},
+		{current: "ready", status: "encoding", backwards: true},
+		{current: "ready", status: "error", backwards: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current+" to "+tt.status, func(t *testing.T) {
+			if got := bunnyWebhookMovesBackwards(tt.current, tt.status); got != tt.backwards {
+				t.Errorf("bunnyWebhookMovesBackwards(%q, %q) = %v, want %v", tt.current, tt.status, got, tt.backwards)
+			}
+		})
+	}
+}
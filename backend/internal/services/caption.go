@@ -0,0 +1,436 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Caption file formats
+const (
+	CaptionFormatSRT = "srt"
+	CaptionFormatVTT = "vtt"
+)
+
+// Caption track kinds. Captions describe sounds as well as speech, for viewers who
+// cannot hear the audio; subtitles translate the speech.
+const (
+	CaptionKindSubtitles = "subtitles"
+	CaptionKindCaptions  = "captions"
+)
+
+const (
+	// MaxCaptionFileSize is the largest caption file accepted, in bytes
+	MaxCaptionFileSize = 2 << 20
+	// maxCaptionErrors bounds how many problems are reported for one file
+	maxCaptionErrors = 50
+)
+
+var (
+	vttTimestampPattern = regexp.MustCompile(`^(?:(\d{2,}):)?([0-5]\d):([0-5]\d)\.(\d{3})$`)
+	// SRT files are often written by hand, so single digit fields and a dot are accepted
+	srtTimestampPattern     = regexp.MustCompile(`^(\d+):([0-5]?\d):([0-5]?\d)[,.](\d{1,3})$`)
+	captionTagPattern       = regexp.MustCompile(`<[^<>]*>`)
+	captionAssTagPattern    = regexp.MustCompile(`\{\\[^{}]*\}`)
+	captionEntityPattern    = regexp.MustCompile(`^&(?:amp|lt|gt|nbsp|lrm|rlm|#\d+|#x[0-9a-fA-F]+);`)
+	captionLanguagePattern  = regexp.MustCompile(`^[A-Za-z]{2,3}(?:-[A-Za-z0-9]{1,8})*$`)
+	vttCueTimestampTag      = regexp.MustCompile(`^<(?:\d{2,}:)?[0-5]\d:[0-5]\d\.\d{3}>$`)
+	vttCueSettingNames      = map[string]bool{"vertical": true, "line": true, "position": true, "size": true, "align": true, "region": true}
+	srtAllowedTags          = map[string]bool{"b": true, "i": true, "u": true}
+	vttAllowedTags          = map[string]bool{"b": true, "i": true, "u": true, "c": true, "v": true, "lang": true, "ruby": true, "rt": true}
+	captionTagNameSeparator = regexp.MustCompile(`[\s.]`)
+)
+
+// CaptionCue is a piece of caption text and when it is shown. Lines keep the WebVTT
+// markup allowed in cue text.
+type CaptionCue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Lines    []string
+}
+
+// CaptionTrack is a parsed caption file, with its cues in the order they are shown
+type CaptionTrack struct {
+	Format string
+	Cues   []CaptionCue
+}
+
+// CaptionLineError is a problem found on a line of a caption file
+type CaptionLineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// CaptionParseError lists the problems that stopped a caption file from being parsed
+type CaptionParseError struct {
+	Errors []CaptionLineError
+}
+
+func (e *CaptionParseError) Error() string {
+	if len(e.Errors) == 0 {
+		return "invalid caption file"
+	}
+	message := fmt.Sprintf("line %d: %s", e.Errors[0].Line, e.Errors[0].Message)
+	if len(e.Errors) > 1 {
+		message += fmt.Sprintf(" (and %d more problems)", len(e.Errors)-1)
+	}
+	return message
+}
+
+type captionParser struct {
+	format string
+	lines  []string
+	errors []CaptionLineError
+}
+
+// errorf records a problem on a 1-based line number
+func (p *captionParser) errorf(line int, format string, args ...interface{}) {
+	if len(p.errors) < maxCaptionErrors {
+		p.errors = append(p.errors, CaptionLineError{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// ParseCaptions parses an SRT or WebVTT file. Files starting with WEBVTT are parsed as
+// WebVTT and anything else as SRT. Every problem found is reported with its line
+// number in a *CaptionParseError.
+func ParseCaptions(content []byte) (*CaptionTrack, error) {
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	p := &captionParser{format: CaptionFormatSRT, lines: strings.Split(text, "\n")}
+	for i, line := range p.lines {
+		if !utf8.ValidString(line) {
+			p.errorf(i+1, "line is not valid UTF-8 text")
+		}
+	}
+	if len(p.errors) > 0 {
+		return nil, &CaptionParseError{Errors: p.errors}
+	}
+
+	first := strings.TrimRight(p.lines[0], " \t")
+	if strings.HasPrefix(first, "WEBVTT") {
+		p.format = CaptionFormatVTT
+	}
+
+	var cues []CaptionCue
+	if p.format == CaptionFormatVTT {
+		cues = p.parseVTT()
+	} else {
+		cues = p.parseSRT()
+	}
+	if len(cues) == 0 && len(p.errors) == 0 {
+		p.errorf(1, "file has no captions")
+	}
+	if len(p.errors) > 0 {
+		return nil, &CaptionParseError{Errors: p.errors}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return &CaptionTrack{Format: p.format, Cues: cues}, nil
+}
+
+func (p *captionParser) parseVTT() []CaptionCue {
+	header := strings.TrimRight(p.lines[0], " \t")
+	if header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") {
+		p.errorf(1, "file must start with a WEBVTT line")
+	}
+
+	// The header runs until the first blank line
+	i := 1
+	for i < len(p.lines) && strings.TrimSpace(p.lines[i]) != "" {
+		if strings.Contains(p.lines[i], "-->") {
+			p.errorf(i+1, "a blank line is needed between the WEBVTT header and the first cue")
+		}
+		i++
+	}
+
+	var cues []CaptionCue
+	for _, block := range p.blocks(i) {
+		first := strings.TrimSpace(p.lines[block[0]])
+		switch {
+		case first == "NOTE" || strings.HasPrefix(first, "NOTE ") || strings.HasPrefix(first, "NOTE\t"):
+			continue
+		case first == "STYLE" || first == "REGION":
+			// Styles and regions are player specific and are left out of the normalized track
+			continue
+		}
+		if cue, ok := p.parseCue(block[0], block[1]); ok {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+func (p *captionParser) parseSRT() []CaptionCue {
+	var cues []CaptionCue
+	for _, block := range p.blocks(0) {
+		if cue, ok := p.parseCue(block[0], block[1]); ok {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+// blocks splits the lines from start into blocks separated by blank lines, as
+// [first, end) index pairs
+func (p *captionParser) blocks(start int) [][2]int {
+	var blocks [][2]int
+	for i := start; i < len(p.lines); {
+		if strings.TrimSpace(p.lines[i]) == "" {
+			i++
+			continue
+		}
+		end := i
+		for end < len(p.lines) && strings.TrimSpace(p.lines[end]) != "" {
+			end++
+		}
+		blocks = append(blocks, [2]int{i, end})
+		i = end
+	}
+	return blocks
+}
+
+// parseCue parses a cue block: an optional identifier, the timing line and the text
+func (p *captionParser) parseCue(start, end int) (CaptionCue, bool) {
+	cue := CaptionCue{}
+	timing := start
+	if !strings.Contains(p.lines[start], "-->") {
+		id := strings.TrimSpace(p.lines[start])
+		if p.format == CaptionFormatSRT {
+			if _, err := strconv.Atoi(id); err != nil {
+				p.errorf(start+1, "expected a caption number, found %q", truncateCaptionText(id))
+				return cue, false
+			}
+		} else {
+			cue.ID = id
+		}
+		timing = start + 1
+	}
+	if timing >= end || !strings.Contains(p.lines[timing], "-->") {
+		p.errorf(timing+1, "expected a timing line like %q", p.exampleTiming())
+		return cue, false
+	}
+
+	ok := p.parseTiming(&cue, timing)
+
+	for i := timing + 1; i < end; i++ {
+		if strings.Contains(p.lines[i], "-->") {
+			p.errorf(i+1, "a blank line is needed before the next caption")
+			return cue, false
+		}
+		if line := p.normalizeText(p.lines[i]); line != "" {
+			cue.Lines = append(cue.Lines, line)
+		}
+	}
+	if len(cue.Lines) == 0 {
+		p.errorf(timing+1, "caption has no text")
+		return cue, false
+	}
+	return cue, ok
+}
+
+func (p *captionParser) parseTiming(cue *CaptionCue, index int) bool {
+	parts := strings.SplitN(p.lines[index], "-->", 2)
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		p.errorf(index+1, "timing line has no end time")
+		return false
+	}
+
+	var ok bool
+	startText := strings.TrimSpace(parts[0])
+	if cue.Start, ok = p.parseTimestamp(startText); !ok {
+		p.errorf(index+1, "invalid start time %q, expected a time like %q", truncateCaptionText(startText), p.exampleTimestamp())
+		return false
+	}
+	if cue.End, ok = p.parseTimestamp(fields[0]); !ok {
+		p.errorf(index+1, "invalid end time %q, expected a time like %q", truncateCaptionText(fields[0]), p.exampleTimestamp())
+		return false
+	}
+	if cue.End <= cue.Start {
+		p.errorf(index+1, "caption ends at %s, which is not after it starts at %s", formatVTTTimestamp(cue.End), formatVTTTimestamp(cue.Start))
+		return false
+	}
+
+	// SRT files may put screen coordinates after the end time, which have no WebVTT
+	// equivalent. Unknown WebVTT settings are dropped as players would ignore them.
+	if p.format == CaptionFormatVTT {
+		var settings []string
+		for _, setting := range fields[1:] {
+			name, value, found := strings.Cut(setting, ":")
+			if found && value != "" && vttCueSettingNames[name] {
+				settings = append(settings, setting)
+			}
+		}
+		cue.Settings = strings.Join(settings, " ")
+	}
+	return true
+}
+
+func (p *captionParser) parseTimestamp(text string) (time.Duration, bool) {
+	pattern := srtTimestampPattern
+	if p.format == CaptionFormatVTT {
+		pattern = vttTimestampPattern
+	}
+	match := pattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, false
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.Atoi(match[3])
+	// A short fraction is tenths or hundredths of a second
+	millis, _ := strconv.Atoi((match[4] + "00")[:3])
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond, true
+}
+
+func (p *captionParser) exampleTimestamp() string {
+	if p.format == CaptionFormatVTT {
+		return "00:01:02.500"
+	}
+	return "00:01:02,500"
+}
+
+func (p *captionParser) exampleTiming() string {
+	return p.exampleTimestamp() + " --> " + strings.Replace(p.exampleTimestamp(), "02", "05", 1)
+}
+
+// normalizeText turns a line of cue text into WebVTT cue text. Tags WebVTT does not
+// support, such as SRT font tags and SubStation override codes, are removed, and
+// stray ampersands and angle brackets are escaped.
+func (p *captionParser) normalizeText(line string) string {
+	line = captionAssTagPattern.ReplaceAllString(strings.TrimSpace(line), "")
+
+	allowed := srtAllowedTags
+	if p.format == CaptionFormatVTT {
+		allowed = vttAllowedTags
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range captionTagPattern.FindAllStringIndex(line, -1) {
+		out.WriteString(escapeCaptionText(line[last:loc[0]]))
+		tag := line[loc[0]:loc[1]]
+		name := strings.ToLower(captionTagNameSeparator.Split(strings.TrimPrefix(tag[1:len(tag)-1], "/"), 2)[0])
+		if allowed[name] || (p.format == CaptionFormatVTT && vttCueTimestampTag.MatchString(tag)) {
+			out.WriteString(tag)
+		}
+		last = loc[1]
+	}
+	out.WriteString(escapeCaptionText(line[last:]))
+	return strings.TrimSpace(out.String())
+}
+
+// escapeCaptionText escapes text outside tags, leaving existing character references
+func escapeCaptionText(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '&':
+			if captionEntityPattern.MatchString(text[i:]) {
+				out.WriteByte('&')
+			} else {
+				out.WriteString("&amp;")
+			}
+		case '<':
+			out.WriteString("&lt;")
+		case '>':
+			out.WriteString("&gt;")
+		default:
+			out.WriteByte(text[i])
+		}
+	}
+	return out.String()
+}
+
+func truncateCaptionText(text string) string {
+	if utf8.RuneCountInString(text) > 40 {
+		return string([]rune(text)[:40]) + "..."
+	}
+	return text
+}
+
+// WebVTT writes the track as a normalized WebVTT file
+func (t *CaptionTrack) WebVTT() []byte {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	for _, cue := range t.Cues {
+		out.WriteString("\n")
+		if cue.ID != "" {
+			out.WriteString(cue.ID + "\n")
+		}
+		out.WriteString(formatVTTTimestamp(cue.Start) + " --> " + formatVTTTimestamp(cue.End))
+		if cue.Settings != "" {
+			out.WriteString(" " + cue.Settings)
+		}
+		out.WriteString("\n" + strings.Join(cue.Lines, "\n") + "\n")
+	}
+	return []byte(out.String())
+}
+
+// Text returns the spoken text of the track without markup, for searching. Lines
+// repeated by roll-up captions are only included once.
+func (t *CaptionTrack) Text() string {
+	var words []string
+	previous := ""
+	for _, cue := range t.Cues {
+		for _, line := range cue.Lines {
+			line = strings.TrimSpace(html.UnescapeString(captionTagPattern.ReplaceAllString(line, "")))
+			if line == "" || line == previous {
+				continue
+			}
+			words = append(words, line)
+			previous = line
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Duration is when the last caption stops being shown
+func (t *CaptionTrack) Duration() time.Duration {
+	var end time.Duration
+	for _, cue := range t.Cues {
+		if cue.End > end {
+			end = cue.End
+		}
+	}
+	return end
+}
+
+func formatVTTTimestamp(d time.Duration) string {
+	millis := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// NormalizeCaptionLanguage checks a BCP 47 language tag such as "en" or "pt-BR" and
+// returns it in its conventional case
+func NormalizeCaptionLanguage(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if len(tag) > 35 || !captionLanguagePattern.MatchString(tag) {
+		return "", false
+	}
+
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCaptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format string
+		cues   []CaptionCue
+	}{
+		{
+			name:   "srt",
+			input:  "1\n00:00:01,000 --> 00:00:02,500\nHello\nthere\n\n2\n00:00:03,000 --> 00:00:04,000\nAgain\n",
+			format: CaptionFormatSRT,
+			cues: []CaptionCue{
+				{Start: time.Second, End: 2500 * time.Millisecond, Lines: []string{"Hello", "there"}},
+				{Start: 3 * time.Second, End: 4 * time.Second, Lines: []string{"Again"}},
+			},
+		},
+		{
+			name:   "srt with byte order mark, CRLF and short fields",
+			input:  "\ufeff1\r\n0:0:1.5 --> 0:0:2.25 X1:10 X2:20\r\nHello\r\n",
+			format: CaptionFormatSRT,
+			cues:   []CaptionCue{{Start: 1500 * time.Millisecond, End: 2250 * time.Millisecond, Lines: []string{"Hello"}}},
+		},
+		{
+			name:   "srt without numbers, sorted by start",
+			input:  "00:00:05,000 --> 00:00:06,000\nLater\n\n00:00:01,000 --> 00:00:02,000\nEarlier\n",
+			format: CaptionFormatSRT,
+			cues: []CaptionCue{
+				{Start: time.Second, End: 2 * time.Second, Lines: []string{"Earlier"}},
+				{Start: 5 * time.Second, End: 6 * time.Second, Lines: []string{"Later"}},
+			},
+		},
+		{
+			name:   "srt markup",
+			input:  "1\n00:00:01,000 --> 00:00:02,000\n<font color=\"red\">{\\an8}<i>Fish & chips</i></font> <3\n",
+			format: CaptionFormatSRT,
+			cues:   []CaptionCue{{Start: time.Second, End: 2 * time.Second, Lines: []string{"<i>Fish &amp; chips</i> &lt;3"}}},
+		},
+		{
+			name:   "vtt",
+			input:  "WEBVTT - lesson one\nKind: captions\n\nNOTE written by hand\n\nSTYLE\n::cue { color: red }\n\nintro\n01:00.000 --> 01:02.000 align:start bogus:1 line:\n<v Teacher>Welcome &amp; hello</v>\n",
+			format: CaptionFormatVTT,
+			cues:   []CaptionCue{{ID: "intro", Start: time.Minute, End: time.Minute + 2*time.Second, Settings: "align:start", Lines: []string{"<v Teacher>Welcome &amp; hello</v>"}}},
+		},
+		{
+			name:   "vtt with hours and timestamp tags",
+			input:  "WEBVTT\n\n01:00:00.000 --> 01:00:01.000\nOne <00:00:00.500>two\n",
+			format: CaptionFormatVTT,
+			cues:   []CaptionCue{{Start: time.Hour, End: time.Hour + time.Second, Lines: []string{"One <00:00:00.500>two"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := ParseCaptions([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("ParseCaptions failed: %v", err)
+			}
+			if track.Format != tt.format {
+				t.Errorf("format = %s, want %s", track.Format, tt.format)
+			}
+			if !reflect.DeepEqual(track.Cues, tt.cues) {
+				t.Errorf("cues = %+v, want %+v", track.Cues, tt.cues)
+			}
+		})
+	}
+}
+
+func TestParseCaptionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		errors []CaptionLineError
+	}{
+		{
+			name:   "empty file",
+			input:  "",
+			errors: []CaptionLineError{{Line: 1, Message: "file has no captions"}},
+		},
+		{
+			name:   "invalid UTF-8",
+			input:  "1\n00:00:01,000 --> 00:00:02,000\nbad \xff byte\n",
+			errors: []CaptionLineError{{Line: 3, Message: "line is not valid UTF-8 text"}},
+		},
+		{
+			name:   "srt caption number",
+			input:  "one\n00:00:01,000 --> 00:00:02,000\nHello\n",
+			errors: []CaptionLineError{{Line: 1, Message: `expected a caption number, found "one"`}},
+		},
+		{
+			name:   "missing timing line",
+			input:  "1\nHello\n",
+			errors: []CaptionLineError{{Line: 2, Message: `expected a timing line like "00:01:02,500 --> 00:01:05,500"`}},
+		},
+		{
+			name:   "invalid start time",
+			input:  "1\n00:00:01,000 --> 00:00:02,000\nFine\n\n2\n1 minute --> 00:01:02,000\nBroken\n",
+			errors: []CaptionLineError{{Line: 6, Message: `invalid start time "1 minute", expected a time like "00:01:02,500"`}},
+		},
+		{
+			name:   "missing end time",
+			input:  "1\n00:00:01,000 -->\nHello\n",
+			errors: []CaptionLineError{{Line: 2, Message: "timing line has no end time"}},
+		},
+		{
+			name:   "ends before it starts",
+			input:  "1\n00:00:02,000 --> 00:00:01,000\nHello\n",
+			errors: []CaptionLineError{{Line: 2, Message: "caption ends at 00:00:01.000, which is not after it starts at 00:00:02.000"}},
+		},
+		{
+			name:   "no text",
+			input:  "1\n00:00:01,000 --> 00:00:02,000\n<font color=\"red\"></font>\n",
+			errors: []CaptionLineError{{Line: 2, Message: "caption has no text"}},
+		},
+		{
+			name:   "missing blank line between captions",
+			input:  "1\n00:00:01,000 --> 00:00:02,000\nHello\n00:00:03,000 --> 00:00:04,000\nAgain\n",
+			errors: []CaptionLineError{{Line: 4, Message: "a blank line is needed before the next caption"}},
+		},
+		{
+			name:  "every problem reported",
+			input: "1\n00:00:02,000 --> 00:00:01,000\nBackwards\n\nx\n00:00:03,000 --> 00:00:04,000\nBad number\n\n3\n00:00:05,000 --> 00:00:06,000\nFine\n",
+			errors: []CaptionLineError{
+				{Line: 2, Message: "caption ends at 00:00:01.000, which is not after it starts at 00:00:02.000"},
+				{Line: 5, Message: `expected a caption number, found "x"`},
+			},
+		},
+		{
+			name:   "vtt timestamps need milliseconds",
+			input:  "WEBVTT\n\n00:01,000 --> 00:02.000\nHello\n",
+			errors: []CaptionLineError{{Line: 3, Message: `invalid start time "00:01,000", expected a time like "00:01:02.500"`}},
+		},
+		{
+			name:   "vtt header without a blank line",
+			input:  "WEBVTT\n00:00:01.000 --> 00:00:02.000\nHello\n",
+			errors: []CaptionLineError{{Line: 2, Message: "a blank line is needed between the WEBVTT header and the first cue"}},
+		},
+		{
+			name:   "vtt with an invalid header",
+			input:  "WEBVTTX\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+			errors: []CaptionLineError{{Line: 1, Message: "file must start with a WEBVTT line"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCaptions([]byte(tt.input))
+			var parseErr *CaptionParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseCaptions error = %v, want a *CaptionParseError", err)
+			}
+			if !reflect.DeepEqual(parseErr.Errors, tt.errors) {
+				t.Errorf("errors = %+v, want %+v", parseErr.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestParseCaptionsLimitsErrors(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < maxCaptionErrors+10; i++ {
+		input.WriteString("x\n00:00:01,000 --> 00:00:02,000\nHello\n\n")
+	}
+
+	_, err := ParseCaptions([]byte(input.String()))
+	var parseErr *CaptionParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseCaptions error = %v, want a *CaptionParseError", err)
+	}
+	if len(parseErr.Errors) != maxCaptionErrors {
+		t.Errorf("reported %d errors, want %d", len(parseErr.Errors), maxCaptionErrors)
+	}
+	if want := `line 1: expected a caption number, found "x" (and 49 more problems)`; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestCaptionTrackWebVTT(t *testing.T) {
+	track, err := ParseCaptions([]byte("1\n00:00:01,500 --> 00:00:03,000\n<b>Hello</b> & welcome\n\n2\n00:00:04,000 --> 00:00:05,000\nHello & welcome\n"))
+	if err != nil {
+		t.Fatalf("ParseCaptions failed: %v", err)
+	}
+
+	want := "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\n<b>Hello</b> &amp; welcome\n\n00:00:04.000 --> 00:00:05.000\nHello &amp; welcome\n"
+	if got := string(track.WebVTT()); got != want {
+		t.Errorf("WebVTT() = %q, want %q", got, want)
+	}
+
+	// The normalized file parses back to the same cues
+	reparsed, err := ParseCaptions(track.WebVTT())
+	if err != nil {
+		t.Fatalf("normalized file does not parse: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed.Cues, track.Cues) {
+		t.Errorf("normalized cues = %+v, want %+v", reparsed.Cues, track.Cues)
+	}
+
+	if got := track.Text(); got != "Hello & welcome" {
+		t.Errorf("Text() = %q, want repeated lines once", got)
+	}
+	if got := track.Duration(); got != 5*time.Second {
+		t.Errorf("Duration() = %s, want 5s", got)
+	}
+}
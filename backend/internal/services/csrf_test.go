@@ -0,0 +1,87 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// tamperFirstChar changes the first character of a base64 string. Every bit of the
+// first character is significant, unlike the last, so the decoded bytes always change.
+func tamperFirstChar(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
+
+func TestCSRFToken(t *testing.T) {
+	t.Setenv("CSRF_SECRET", "test-csrf-secret")
+
+	binding := CSRFBinding("fam_1", "access_1")
+	token, err := GenerateCSRFToken(binding)
+	if err != nil {
+		t.Fatalf("GenerateCSRFToken failed: %v", err)
+	}
+	nonce, signature, _ := strings.Cut(token, ".")
+	other, _ := GenerateCSRFToken(binding)
+
+	tests := []struct {
+		name    string
+		binding string
+		token   string
+		want    bool
+	}{
+		{name: "issued for the session", binding: binding, token: token, want: true},
+		{name: "second token for the session", binding: binding, token: other, want: true},
+		{name: "another session", binding: CSRFBinding("fam_2", "access_1"), token: token},
+		{name: "token binding instead of family", binding: CSRFBinding("", "access_1"), token: token},
+		{name: "tampered nonce", binding: binding, token: tamperFirstChar(nonce) + "." + signature},
+		{name: "tampered signature", binding: binding, token: nonce + "." + tamperFirstChar(signature)},
+		{name: "signature only", binding: binding, token: "." + signature},
+		{name: "nonce only", binding: binding, token: nonce + "."},
+		{name: "no separator", binding: binding, token: nonce + signature},
+		{name: "empty", binding: binding, token: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateCSRFToken(tt.binding, tt.token); got != tt.want {
+				t.Errorf("ValidateCSRFToken(%q, %q) = %v, want %v", tt.binding, tt.token, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("different secret", func(t *testing.T) {
+		t.Setenv("CSRF_SECRET", "another-csrf-secret")
+		if ValidateCSRFToken(binding, token) {
+			t.Error("a token verified with a different secret")
+		}
+	})
+}
+
+func TestCSRFTokenRequiresSecret(t *testing.T) {
+	t.Setenv("CSRF_SECRET", "test-csrf-secret")
+	token, err := GenerateCSRFToken("family:fam_1")
+	if err != nil {
+		t.Fatalf("GenerateCSRFToken failed: %v", err)
+	}
+
+	// JWT_SECRET is not a fallback
+	t.Setenv("CSRF_SECRET", "")
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	if _, err := GenerateCSRFToken("family:fam_1"); err == nil {
+		t.Error("GenerateCSRFToken succeeded without CSRF_SECRET")
+	}
+	if ValidateCSRFToken("family:fam_1", token) {
+		t.Error("ValidateCSRFToken accepted a token without CSRF_SECRET")
+	}
+}
+
+func TestCSRFBinding(t *testing.T) {
+	if got := CSRFBinding("fam_1", "access_1"); got != "family:fam_1" {
+		t.Errorf("CSRFBinding with a family = %q, want family:fam_1", got)
+	}
+	if got := CSRFBinding("", "access_1"); got != "token:access_1" {
+		t.Errorf("CSRFBinding without a family = %q, want token:access_1", got)
+	}
+}
@@ -138,6 +138,108 @@ func (e *EmailService) SendEmailVerification(name, email, verificationToken stri
 	return e.SendTemplateEmail(email, "email_verification", data)
 }
 
+// SendMagicLinkEmail sends a single-use passwordless sign-in link
+func (e *EmailService) SendMagicLinkEmail(name, email, loginToken string, expiresAt time.Time) error {
+	minutes := int(time.Until(expiresAt).Round(time.Minute).Minutes())
+	data := EmailData{
+		Subject:   "Your Sign-In Link",
+		Content:   fmt.Sprintf("Click the link below to sign in. This link can only be used once and will expire in %d minutes. If you did not request it, you can ignore this email.", minutes),
+		ActionURL: fmt.Sprintf("%s/magic-link?token=%s", e.baseURL, loginToken),
+		ExpiresAt: expiresAt,
+	}
+	data.User.Name = name
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "magic_link", data)
+}
+
+// SendNewDeviceLoginAlert warns a user about a sign-in from a device they have not used
+// before. The link lets them sign out everywhere if it was not them.
+func (e *EmailService) SendNewDeviceLoginAlert(name, email, ipAddress, userAgent string, loginAt time.Time, revokeToken string) error {
+	data := EmailData{
+		Subject: "New Sign-In to Your Account",
+		Content: fmt.Sprintf("Your account was signed in to from a new device on %s.\n\nIP address: %s\nDevice: %s\n\nIf this was you, no action is needed. If it wasn't, use the link below to sign out of every device, then reset your password.",
+			loginAt.UTC().Format("January 2, 2006 at 15:04 UTC"), ipAddress, userAgent),
+		ActionURL: fmt.Sprintf("%s/security/not-me?token=%s", e.baseURL, revokeToken),
+		ExpiresAt: time.Now().Add(LoginAlertTokenLifetime),
+	}
+	data.User.Name = name
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "new_device_login", data)
+}
+
+// SendDataExportEmail sends the download link for a personal data export
+func (e *EmailService) SendDataExportEmail(name, email, downloadURL string, expiresAt time.Time) error {
+	data := EmailData{
+		Subject:   "Your Data Export Is Ready",
+		Content:   fmt.Sprintf("The copy of your data you requested is ready. The download link below expires on %s.", expiresAt.UTC().Format("January 2, 2006")),
+		ActionURL: downloadURL,
+		ExpiresAt: expiresAt,
+	}
+	data.User.Name = name
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "data_export", data)
+}
+
+// SendAccountDeletionScheduledEmail confirms that an account will be erased and
+// explains how to cancel during the grace period
+func (e *EmailService) SendAccountDeletionScheduledEmail(name, email string, scheduledFor time.Time) error {
+	data := EmailData{
+		Subject:   "Your Account Is Scheduled for Deletion",
+		Content:   fmt.Sprintf("Your account and personal data will be permanently deleted on %s. If you change your mind, sign in and cancel the deletion from your account settings before then.", scheduledFor.UTC().Format("January 2, 2006")),
+		ActionURL: fmt.Sprintf("%s/account", e.baseURL),
+		ExpiresAt: scheduledFor,
+	}
+	data.User.Name = name
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "account_deletion", data)
+}
+
+// SendEmailChangeVerification asks the user to confirm a new email address. The change
+// is only applied once the link is used.
+func (e *EmailService) SendEmailChangeVerification(name, newEmail, changeToken string, expiresAt time.Time) error {
+	data := EmailData{
+		Subject:   "Confirm Your New Email Address",
+		Content:   fmt.Sprintf("Click the link below to start using this address for your account. The link expires on %s. If you did not request this change, you can ignore this email.", expiresAt.UTC().Format("January 2, 2006 at 15:04 UTC")),
+		ActionURL: fmt.Sprintf("%s/confirm-email-change?token=%s", e.baseURL, changeToken),
+		ExpiresAt: expiresAt,
+	}
+	data.User.Name = name
+	data.User.Email = newEmail
+
+	return e.SendTemplateEmail(newEmail, "email_change_verification", data)
+}
+
+// SendEmailChangeNotice tells the current address that a change to a new address was requested
+func (e *EmailService) SendEmailChangeNotice(name, currentEmail, newEmail string) error {
+	data := EmailData{
+		Subject:   "Your Email Address Is Being Changed",
+		Content:   fmt.Sprintf("A request was made to change the email address on your account to %s. The change takes effect once the new address is confirmed. If this wasn't you, cancel the change from your account settings and reset your password.", newEmail),
+		ActionURL: fmt.Sprintf("%s/account", e.baseURL),
+	}
+	data.User.Name = name
+	data.User.Email = currentEmail
+
+	return e.SendTemplateEmail(currentEmail, "email_change_notice", data)
+}
+
+// SendInvitationEmail invites someone to create an account with a pre-assigned role.
+// Accepting the link sets their password and verifies their email.
+func (e *EmailService) SendInvitationEmail(inviterName, email, roleName, inviteToken string, expiresAt time.Time) error {
+	data := EmailData{
+		Subject:   "You Have Been Invited to Book of Mormon Evidences",
+		Content:   fmt.Sprintf("%s has invited you to join Book of Mormon Evidences as %s. Click the link below to choose a password and activate your account. The invitation expires on %s.", inviterName, roleName, expiresAt.UTC().Format("January 2, 2006")),
+		ActionURL: fmt.Sprintf("%s/accept-invite?token=%s", e.baseURL, inviteToken),
+		ExpiresAt: expiresAt,
+	}
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "invitation", data)
+}
+
 // SendSubscriptionConfirmation sends a subscription confirmation email
 func (e *EmailService) SendSubscriptionConfirmation(name, email, planName string, amount float64) error {
 	data := EmailData{
@@ -208,6 +310,23 @@ func (e *EmailService) SendNewVideoNotification(name, email, videoTitle, videoUR
 	return e.SendTemplateEmail(email, "subscription", data)
 }
 
+// SendVideoProcessingFailedEmail tells an uploader that Bunny Stream could not encode their video
+func (e *EmailService) SendVideoProcessingFailedEmail(name, email, videoTitle string, videoID int) error {
+	data := EmailData{
+		Subject:   "Video Processing Failed: " + videoTitle,
+		Content:   fmt.Sprintf("We could not process your video '%s'. Please check the file and upload it again, or contact support if the problem continues.", videoTitle),
+		ActionURL: fmt.Sprintf("%s/admin/videos/%d", e.baseURL, videoID),
+		CustomData: map[string]interface{}{
+			"video_title": videoTitle,
+			"video_id":    videoID,
+		},
+	}
+	data.User.Name = name
+	data.User.Email = email
+
+	return e.SendTemplateEmail(email, "video_processing_failed", data)
+}
+
 // SendAdminNotification sends a notification to admin users
 func (e *EmailService) SendAdminNotification(subject, content string) error {
 	adminEmail := os.Getenv("ADMIN_EMAIL")
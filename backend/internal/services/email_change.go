@@ -0,0 +1,49 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	emailChangeTokenType       = "email_change"
+	defaultEmailChangeLifetime = 24 * time.Hour
+	maxEmailChangeLifetime     = 72 * time.Hour
+)
+
+// EmailChangeLifetime returns how long the link confirming a new email address stays
+// valid, read from EMAIL_CHANGE_EXPIRY and capped at three days
+func EmailChangeLifetime() time.Duration {
+	lifetime, err := time.ParseDuration(os.Getenv("EMAIL_CHANGE_EXPIRY"))
+	if err != nil || lifetime <= 0 {
+		return defaultEmailChangeLifetime
+	}
+	if lifetime > maxEmailChangeLifetime {
+		return maxEmailChangeLifetime
+	}
+	return lifetime
+}
+
+// GenerateEmailChangeToken generates a signed token confirming that the user controls
+// the new email address. The returned token ID must be recorded with the pending change
+// so only the latest link works.
+func GenerateEmailChangeToken(userID int, newEmail, role string) (string, string, time.Time, error) {
+	if err := initializeSecrets(); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+
+	lifetime := EmailChangeLifetime()
+	tokenID := fmt.Sprintf("ec_%d_%s", userID, GenerateRandomToken(16))
+	token, err := generateToken(userID, newEmail, role, false, emailChangeTokenType, lifetime, tokenID)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, tokenID, time.Now().Add(lifetime), nil
+}
+
+// ParseEmailChangeToken parses and validates an email change token. It does not check
+// whether the change is still pending; that is recorded in the database.
+func ParseEmailChangeToken(tokenString string) (*Claims, error) {
+	return parseTypedToken(tokenString, emailChangeTokenType)
+}
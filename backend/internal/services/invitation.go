@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	invitationTokenType       = "invitation"
+	defaultInvitationLifetime = 72 * time.Hour
+	// maxInvitationLifetime keeps invitations within the window a retired signing key
+	// still verifies tokens, so key rotation does not break unexpired invitations
+	maxInvitationLifetime = maxTokenLifetime
+)
+
+// InvitationLifetime returns how long an invitation link stays valid, read from
+// INVITATION_EXPIRY and capped at seven days
+func InvitationLifetime() time.Duration {
+	lifetime, err := time.ParseDuration(os.Getenv("INVITATION_EXPIRY"))
+	if err != nil || lifetime <= 0 {
+		return defaultInvitationLifetime
+	}
+	if lifetime > maxInvitationLifetime {
+		return maxInvitationLifetime
+	}
+	return lifetime
+}
+
+// GenerateInvitationToken generates a signed invitation for an email address to create
+// an account with the given role. The returned token ID must be recorded so the
+// invitation can be listed, revoked and only accepted once.
+func GenerateInvitationToken(email, role string) (string, string, time.Time, error) {
+	if err := initializeSecrets(); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+
+	lifetime := InvitationLifetime()
+	tokenID := fmt.Sprintf("inv_%s", GenerateRandomToken(16))
+	token, err := generateToken(0, email, role, true, invitationTokenType, lifetime, tokenID)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, tokenID, time.Now().Add(lifetime), nil
+}
+
+// ParseInvitationToken parses and validates an invitation token. It does not check
+// whether the invitation was revoked or accepted; that is recorded in the database.
+func ParseInvitationToken(tokenString string) (*Claims, error) {
+	return parseTypedToken(tokenString, invitationTokenType)
+}
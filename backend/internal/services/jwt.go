@@ -3,73 +3,24 @@ package services
 import (
 	"errors"
 	"fmt"
+	"log"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	accessTokenLifetime  = 15 * time.Minute
+	refreshTokenLifetime = 7 * 24 * time.Hour
+)
+
+// HMAC secrets from the environment. They sign tokens until a keyring is configured
+// and verify tokens issued before the keyring, which carry no kid header.
 var jwtSecret []byte
 var jwtRefreshSecret []byte
 var secretsInitialized bool
 
-// TokenBlacklist manages blacklisted tokens
-type TokenBlacklist struct {
-	tokens map[string]time.Time
-	mutex  sync.RWMutex
-}
-
-// Global token blacklist instance
-var tokenBlacklist = &TokenBlacklist{
-	tokens: make(map[string]time.Time),
-}
-
-// BlacklistToken adds a token to the blacklist
-func (tb *TokenBlacklist) BlacklistToken(tokenID string, expiry time.Time) {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-	tb.tokens[tokenID] = expiry
-}
-
-// IsBlacklisted checks if a token is blacklisted
-func (tb *TokenBlacklist) IsBlacklisted(tokenID string) bool {
-	tb.mutex.RLock()
-	defer tb.mutex.RUnlock()
-	if expiry, exists := tb.tokens[tokenID]; exists {
-		if time.Now().Before(expiry) {
-			return true
-		}
-		// Clean up expired token
-		tb.mutex.RUnlock()
-		tb.mutex.Lock()
-		delete(tb.tokens, tokenID)
-		tb.mutex.Unlock()
-		tb.mutex.RLock()
-	}
-	return false
-}
-
-// CleanupExpiredTokens removes expired tokens from blacklist
-func (tb *TokenBlacklist) CleanupExpiredTokens() {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-
-	now := time.Now()
-	for tokenID, expiry := range tb.tokens {
-		if now.After(expiry) {
-			delete(tb.tokens, tokenID)
-		}
-	}
-}
-
-// GetBlacklistSize returns the number of blacklisted tokens
-func (tb *TokenBlacklist) GetBlacklistSize() int {
-	tb.mutex.RLock()
-	defer tb.mutex.RUnlock()
-	return len(tb.tokens)
-}
-
 // initializeSecrets ensures JWT secrets are loaded from environment variables
 func initializeSecrets() error {
 	if secretsInitialized {
@@ -98,8 +49,13 @@ type Claims struct {
 	Email         string `json:"email"`
 	Role          string `json:"role"`
 	EmailVerified bool   `json:"email_verified"`
-	TokenType     string `json:"token_type"` // "access" or "refresh"
-	TokenID       string `json:"token_id"`   // Unique token identifier for blacklisting
+	TokenType     string `json:"token_type"`          // "access" or "refresh"
+	TokenID       string `json:"token_id"`            // Unique token identifier for blacklisting
+	FamilyID      string `json:"family_id,omitempty"` // Refresh token family, identifies the session
+
+	// Set on impersonation tokens: the staff member acting as the user above
+	ImpersonatorID    int    `json:"impersonator_id,omitempty"`
+	ImpersonatorEmail string `json:"impersonator_email,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -109,35 +65,52 @@ type TokenPair struct {
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+
+	// Identifiers used for session and rotation tracking, never serialized
+	AccessTokenID    string    `json:"-"`
+	RefreshTokenID   string    `json:"-"`
+	FamilyID         string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
-// GenerateTokenPair generates both access and refresh tokens
+// GenerateTokenPair generates both access and refresh tokens, starting a new refresh token family
 func GenerateTokenPair(userID int, email, role string, emailVerified bool) (*TokenPair, error) {
+	familyID := fmt.Sprintf("fam_%s", GenerateRandomToken(16))
+	return generateTokenPair(userID, email, role, emailVerified, familyID)
+}
+
+// generateTokenPair generates an access and refresh token whose refresh token belongs to the given family
+func generateTokenPair(userID int, email, role string, emailVerified bool, familyID string) (*TokenPair, error) {
 	if err := initializeSecrets(); err != nil {
 		return nil, fmt.Errorf("failed to initialize JWT secrets: %w", err)
 	}
 
-	// Generate unique token IDs
-	accessTokenID := fmt.Sprintf("access_%d_%s", userID, time.Now().Format("20060102150405"))
-	refreshTokenID := fmt.Sprintf("refresh_%d_%s", userID, time.Now().Format("20060102150405"))
+	// Generate unique token IDs (random suffix so rotations within the same second don't collide)
+	now := time.Now()
+	accessTokenID := fmt.Sprintf("access_%d_%s_%s", userID, now.Format("20060102150405"), GenerateRandomToken(8))
+	refreshTokenID := fmt.Sprintf("refresh_%d_%s_%s", userID, now.Format("20060102150405"), GenerateRandomToken(8))
 
 	// Generate access token (short-lived: 15 minutes)
-	accessToken, err := generateToken(userID, email, role, emailVerified, "access", 15*time.Minute, jwtSecret, accessTokenID)
+	accessToken, err := generateSessionToken(userID, email, role, emailVerified, "access", accessTokenLifetime, accessTokenID, familyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
 	// Generate refresh token (long-lived: 7 days)
-	refreshToken, err := generateToken(userID, email, role, emailVerified, "refresh", 7*24*time.Hour, jwtRefreshSecret, refreshTokenID)
+	refreshToken, err := generateSessionToken(userID, email, role, emailVerified, "refresh", refreshTokenLifetime, refreshTokenID, familyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
 	return &TokenPair{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64((15 * time.Minute).Seconds()),
-		TokenType:    "Bearer",
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int64(accessTokenLifetime.Seconds()),
+		TokenType:        "Bearer",
+		AccessTokenID:    accessTokenID,
+		RefreshTokenID:   refreshTokenID,
+		FamilyID:         familyID,
+		RefreshExpiresAt: now.Add(refreshTokenLifetime),
 	}, nil
 }
 
@@ -147,11 +120,18 @@ func GenerateToken(userID int, email, role string, expiry time.Duration) (string
 		return "", fmt.Errorf("failed to initialize JWT secrets: %w", err)
 	}
 	tokenID := fmt.Sprintf("legacy_%d_%s", userID, time.Now().Format("20060102150405"))
-	return generateToken(userID, email, role, true, "access", expiry, jwtSecret, tokenID)
+	return generateToken(userID, email, role, true, "access", expiry, tokenID)
 }
 
 // generateToken internal helper to generate tokens
-func generateToken(userID int, email, role string, emailVerified bool, tokenType string, expiry time.Duration, secret []byte, tokenID string) (string, error) {
+func generateToken(userID int, email, role string, emailVerified bool, tokenType string, expiry time.Duration, tokenID string) (string, error) {
+	return generateSessionToken(userID, email, role, emailVerified, tokenType, expiry, tokenID, "")
+}
+
+// generateSessionToken generates a token tied to a refresh token family (session).
+// Access tokens carry the family ID so requests can be matched to their session.
+// Tokens are signed with the keyring's active key and carry its kid header.
+func generateSessionToken(userID int, email, role string, emailVerified bool, tokenType string, expiry time.Duration, tokenID, familyID string) (string, error) {
 	now := time.Now()
 	claims := &Claims{
 		UserID:        userID,
@@ -160,9 +140,10 @@ func generateToken(userID int, email, role string, emailVerified bool, tokenType
 		EmailVerified: emailVerified,
 		TokenType:     tokenType,
 		TokenID:       tokenID,
+		FamilyID:      familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
-			IssuedAt:  jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(tokenIssuedAt(userID, now)),
 			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "bome-backend",
 			Subject:   fmt.Sprintf("user:%d", userID),
@@ -170,8 +151,62 @@ func generateToken(userID int, email, role string, emailVerified bool, tokenType
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return signToken(claims)
+}
+
+// GenerateMFAPendingToken issues a short-lived token proving the password step of a
+// two-factor login succeeded. It can only be exchanged at the MFA endpoints.
+func GenerateMFAPendingToken(userID int, email, role string, emailVerified bool) (string, error) {
+	if err := initializeSecrets(); err != nil {
+		return "", fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+	tokenID := fmt.Sprintf("mfa_%d_%s", userID, GenerateRandomToken(8))
+	return generateToken(userID, email, role, emailVerified, "mfa_pending", 5*time.Minute, tokenID)
+}
+
+// ParseMFAPendingToken parses and validates an MFA pending token
+func ParseMFAPendingToken(tokenString string) (*Claims, error) {
+	if err := initializeSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+
+	if tokenString == "" {
+		return nil, errors.New("mfa token is required")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKeyFunc(jwtSecret))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mfa token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid mfa token")
+	}
+
+	if claims.TokenType != "mfa_pending" {
+		return nil, errors.New("invalid token type for mfa")
+	}
+
+	if claims.Issuer != "bome-backend" {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	if err := checkRevocation(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// RevokeClaims blacklists the token described by already-validated claims
+func RevokeClaims(claims *Claims) {
+	if claims != nil && claims.TokenID != "" && claims.ExpiresAt != nil {
+		if err := RevokeTokenID(claims.TokenID, claims.ExpiresAt.Time); err != nil {
+			log.Printf("Failed to revoke token %s: %v", claims.TokenID, err)
+		}
+	}
 }
 
 // ParseToken parses and validates a JWT
@@ -184,13 +219,7 @@ func ParseToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("token is required")
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKeyFunc(jwtSecret))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -211,9 +240,9 @@ func ParseToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token issuer")
 	}
 
-	// Check if token is blacklisted
-	if tokenBlacklist.IsBlacklisted(claims.TokenID) {
-		return nil, errors.New("token has been revoked")
+	// Check if token or all of the user's earlier tokens have been revoked
+	if err := checkRevocation(claims); err != nil {
+		return nil, err
 	}
 
 	return claims, nil
@@ -221,6 +250,22 @@ func ParseToken(tokenString string) (*Claims, error) {
 
 // ParseRefreshToken parses and validates a refresh token
 func ParseRefreshToken(tokenString string) (*Claims, error) {
+	claims, err := parseRefreshClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if token or all of the user's earlier tokens have been revoked
+	if err := checkRevocation(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// parseRefreshClaims verifies a refresh token's signature, type and issuer without
+// consulting the revocation store, so rotation can detect reuse of revoked tokens
+func parseRefreshClaims(tokenString string) (*Claims, error) {
 	if err := initializeSecrets(); err != nil {
 		return nil, fmt.Errorf("failed to initialize JWT secrets: %w", err)
 	}
@@ -229,12 +274,7 @@ func ParseRefreshToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("refresh token is required")
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtRefreshSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKeyFunc(jwtRefreshSecret))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse refresh token: %w", err)
@@ -255,15 +295,11 @@ func ParseRefreshToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token issuer")
 	}
 
-	// Check if token is blacklisted
-	if tokenBlacklist.IsBlacklisted(claims.TokenID) {
-		return nil, errors.New("refresh token has been revoked")
-	}
-
 	return claims, nil
 }
 
-// RefreshTokenPair generates new tokens from a valid refresh token
+// RefreshTokenPair generates new tokens from a valid refresh token without
+// persistent family tracking. Use RotateRefreshToken when a database is available.
 func RefreshTokenPair(refreshToken string) (*TokenPair, error) {
 	claims, err := ParseRefreshToken(refreshToken)
 	if err != nil {
@@ -272,11 +308,17 @@ func RefreshTokenPair(refreshToken string) (*TokenPair, error) {
 
 	// Blacklist the old refresh token
 	if claims.TokenID != "" {
-		tokenBlacklist.BlacklistToken(claims.TokenID, claims.ExpiresAt.Time)
+		if err := RevokeTokenID(claims.TokenID, claims.ExpiresAt.Time); err != nil {
+			return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
 	}
 
-	// Generate new token pair
-	return GenerateTokenPair(claims.UserID, claims.Email, claims.Role, claims.EmailVerified)
+	// Generate new token pair in the same family
+	familyID := claims.FamilyID
+	if familyID == "" {
+		familyID = fmt.Sprintf("fam_%s", GenerateRandomToken(16))
+	}
+	return generateTokenPair(claims.UserID, claims.Email, claims.Role, claims.EmailVerified, familyID)
 }
 
 // BlacklistToken adds a token to the blacklist
@@ -292,7 +334,9 @@ func BlacklistToken(tokenString string) error {
 	}
 
 	if claims.TokenID != "" {
-		tokenBlacklist.BlacklistToken(claims.TokenID, claims.ExpiresAt.Time)
+		if err := RevokeTokenID(claims.TokenID, claims.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("failed to revoke token: %w", err)
+		}
 	}
 
 	return nil
@@ -332,7 +376,9 @@ func StartTokenBlacklistCleanup() {
 		defer ticker.Stop()
 
 		for range ticker.C {
-			tokenBlacklist.CleanupExpiredTokens()
+			if err := getRevocationStore().CleanupExpired(); err != nil {
+				log.Printf("Failed to clean up revoked tokens: %v", err)
+			}
 		}
 	}()
 }
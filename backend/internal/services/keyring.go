@@ -0,0 +1,786 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms supported by the keyring
+const (
+	SigningAlgorithmHS256 = "HS256"
+	SigningAlgorithmEdDSA = "EdDSA"
+	SigningAlgorithmRS256 = "RS256"
+)
+
+const (
+	// DefaultKeyRotationInterval is how long a key signs tokens before it is rotated
+	DefaultKeyRotationInterval = 30 * 24 * time.Hour
+
+	// keyReloadInterval is how often replicas pick up keys rotated elsewhere
+	keyReloadInterval = 5 * time.Minute
+
+	// unknownKeyReloadBackoff limits store reloads triggered by tokens with an unknown kid
+	unknownKeyReloadBackoff = 30 * time.Second
+
+	// retiredKeyGracePeriod covers clock skew between replicas on top of the longest token lifetime
+	retiredKeyGracePeriod = 5 * time.Minute
+
+	// JWKSCacheMaxAge is how long verifiers may cache the JWKS document
+	JWKSCacheMaxAge = 5 * time.Minute
+
+	// nextKeyPublishLead is how long a scheduled rotation publishes the next key before
+	// it signs anything: every replica reloads it and serves it in the JWKS, and
+	// verifiers' cached copies of the JWKS expire
+	nextKeyPublishLead = keyReloadInterval + JWKSCacheMaxAge
+
+	rsaKeyBits = 2048
+)
+
+// SigningKey is one key in the keyring, identified by the kid header of the tokens it signs
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  interface{} // ed25519.PrivateKey, *rsa.PrivateKey or []byte for HS256
+	PublicKey   interface{} // ed25519.PublicKey, *rsa.PublicKey or []byte for HS256
+	CreatedAt   time.Time
+	ActivatesAt time.Time // when the key starts signing; zero if it did on creation
+	RetiredAt   time.Time // when the key stops signing; zero until the next key is created
+	VerifyUntil time.Time // zero until the next key is created
+}
+
+// isActive reports whether the key signs tokens at the given time
+func (k *SigningKey) isActive(now time.Time) bool {
+	return !k.ActivatesAt.After(now) && (k.RetiredAt.IsZero() || k.RetiredAt.After(now))
+}
+
+// signingMethod returns the JWT signing method for the key's algorithm
+func (k *SigningKey) signingMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case SigningAlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	case SigningAlgorithmRS256:
+		return jwt.SigningMethodRS256
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// JSONWebKey is the public part of a signing key as published in a JWKS document,
+// either our own or an OpenID Connect provider's
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// SigningKeyStore persists signing keys so every replica signs and verifies with the same keyring
+type SigningKeyStore interface {
+	// LoadKeys returns the active key, the next key if one has been published and
+	// retired keys still valid for verification
+	LoadKeys() ([]*SigningKey, error)
+	// RotateKey stores a new key that becomes active at activatesAt and retires the keys
+	// in use at that time, keeping them valid for verification until verifyUntil. It
+	// returns false if previousID is no longer the newest unretired key.
+	RotateKey(key *SigningKey, previousID string, activatesAt, verifyUntil time.Time) (bool, error)
+	// CleanupExpired removes retired keys past their verification window
+	CleanupExpired() error
+}
+
+// Keyring holds the signing keys and rotates the active key on a schedule
+type Keyring struct {
+	store            SigningKeyStore
+	algorithm        string
+	rotationInterval time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]*SigningKey
+	loadedAt time.Time
+}
+
+// Active keyring, created lazily from JWT_SECRET until SetKeyring is called
+var (
+	keyring      *Keyring
+	keyringMutex sync.Mutex
+)
+
+// legacyTokensUntil is when tokens issued before the keyring, which carry no kid, stop
+// being accepted. The zero time rejects them.
+var (
+	legacyTokensUntil time.Time
+	legacyTokensMutex sync.RWMutex
+)
+
+// SetLegacyTokenCutover accepts tokens issued before the keyring until cutover. Those
+// tokens were all issued before this deploy, so none is still valid later than the
+// longest token lifetime from now, and a later cutover is refused.
+func SetLegacyTokenCutover(cutover time.Time) error {
+	if latest := time.Now().Add(maxTokenLifetime); cutover.After(latest) {
+		return fmt.Errorf("legacy token cutover %s is later than the longest token lifetime from now (%s)", cutover.Format(time.RFC3339), latest.Format(time.RFC3339))
+	}
+
+	legacyTokensMutex.Lock()
+	defer legacyTokensMutex.Unlock()
+	legacyTokensUntil = cutover
+	return nil
+}
+
+// legacyTokensAccepted reports whether tokens without a kid are still accepted
+func legacyTokensAccepted() bool {
+	legacyTokensMutex.RLock()
+	defer legacyTokensMutex.RUnlock()
+	return time.Now().Before(legacyTokensUntil)
+}
+
+// SetKeyring replaces the keyring used to sign and verify tokens
+func SetKeyring(k *Keyring) {
+	keyringMutex.Lock()
+	defer keyringMutex.Unlock()
+	keyring = k
+}
+
+// getKeyring returns the active keyring. Without a configured keyring tokens are
+// signed with HS256 using JWT_SECRET, matching the behaviour before key rotation.
+func getKeyring() (*Keyring, error) {
+	keyringMutex.Lock()
+	defer keyringMutex.Unlock()
+
+	if keyring != nil {
+		return keyring, nil
+	}
+
+	if err := initializeSecrets(); err != nil {
+		return nil, err
+	}
+	k, err := NewKeyring(NewMemorySigningKeyStore(EnvironmentSigningKey()), SigningAlgorithmHS256, 0)
+	if err != nil {
+		return nil, err
+	}
+	keyring = k
+	return keyring, nil
+}
+
+// NewKeyring loads the keyring from the store, creating a key for the configured algorithm
+// if there is no active key or the active key uses a different algorithm.
+// A rotation interval of zero disables scheduled rotation.
+func NewKeyring(store SigningKeyStore, algorithm string, rotationInterval time.Duration) (*Keyring, error) {
+	switch algorithm {
+	case SigningAlgorithmHS256, SigningAlgorithmEdDSA, SigningAlgorithmRS256:
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", algorithm)
+	}
+
+	k := &Keyring{
+		store:            store,
+		algorithm:        algorithm,
+		rotationInterval: rotationInterval,
+		keys:             make(map[string]*SigningKey),
+	}
+	if err := k.reload(); err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	if active := k.activeKey(); active == nil || active.Algorithm != algorithm {
+		if err := k.Rotate(); err != nil {
+			return nil, fmt.Errorf("failed to create signing key: %w", err)
+		}
+	}
+
+	return k, nil
+}
+
+// reload replaces the in-memory keys with the keys in the store
+func (k *Keyring) reload() error {
+	keys, err := k.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+
+	k.mu.Lock()
+	k.keys = byID
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// activeKey returns the key currently used for signing. A published next key takes
+// over from it once its activation time passes, without waiting for a reload.
+func (k *Keyring) activeKey() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	var active *SigningKey
+	for _, key := range k.keys {
+		if key.isActive(now) && (active == nil || key.CreatedAt.After(active.CreatedAt)) {
+			active = key
+		}
+	}
+	return active
+}
+
+// newestKey returns the most recently created key that has not been retired, which is
+// either the active key or a published next key
+func (k *Keyring) newestKey() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var newest *SigningKey
+	for _, key := range k.keys {
+		if key.RetiredAt.IsZero() && (newest == nil || key.CreatedAt.After(newest.CreatedAt)) {
+			newest = key
+		}
+	}
+	return newest
+}
+
+// ActiveKey returns the key currently used for signing
+func (k *Keyring) ActiveKey() (*SigningKey, error) {
+	key := k.activeKey()
+	if key == nil {
+		return nil, errors.New("no active signing key")
+	}
+	return key, nil
+}
+
+// Key returns the key with the given kid if it can still verify tokens.
+// Unknown kids trigger a store reload so keys rotated by another replica are found.
+func (k *Keyring) Key(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	loadedAt := k.loadedAt
+	k.mu.RUnlock()
+
+	if !ok && time.Since(loadedAt) > unknownKeyReloadBackoff {
+		if err := k.reload(); err != nil {
+			log.Printf("Failed to reload signing keys: %v", err)
+			return nil, false
+		}
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if !ok || (!key.VerifyUntil.IsZero() && time.Now().After(key.VerifyUntil)) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Rotate creates a new key that signs from now on, e.g. after a suspected compromise.
+// The previous key keeps verifying tokens until every token it signed has expired.
+// Verifiers that cache the JWKS only see the new key once they refetch it.
+func (k *Keyring) Rotate() error {
+	return k.rotate(time.Now())
+}
+
+// rotate creates a new key that starts signing at activatesAt
+func (k *Keyring) rotate(activatesAt time.Time) error {
+	key, err := generateSigningKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	previousID := ""
+	if newest := k.newestKey(); newest != nil {
+		previousID = newest.ID
+	}
+
+	verifyUntil := activatesAt.Add(maxTokenLifetime + retiredKeyGracePeriod)
+	rotated, err := k.store.RotateKey(key, previousID, activatesAt, verifyUntil)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		if activatesAt.After(time.Now()) {
+			log.Printf("Published next JWT signing key: %s (%s), signing from %s", key.ID, key.Algorithm, activatesAt.Format(time.RFC3339))
+		} else {
+			log.Printf("Rotated JWT signing key: %s (%s)", key.ID, key.Algorithm)
+		}
+	}
+
+	// Another replica may have rotated first; either way pick up the current keys
+	return k.reload()
+}
+
+// RotateIfDue publishes the next key once the active key is older than the rotation
+// interval. The next key is served in the JWKS for nextKeyPublishLead before it signs
+// anything, so verifiers that cache the JWKS already know it.
+func (k *Keyring) RotateIfDue() error {
+	if k.rotationInterval <= 0 {
+		return nil
+	}
+	active := k.activeKey()
+	if newest := k.newestKey(); newest != nil && newest != active {
+		// The next key has been published and is waiting to become active
+		return nil
+	}
+	if active == nil {
+		return k.Rotate()
+	}
+	if time.Since(active.CreatedAt) < k.rotationInterval {
+		return nil
+	}
+	return k.rotate(time.Now().Add(nextKeyPublishLead))
+}
+
+// JWKS returns the public keys of all asymmetric keys that can still verify tokens,
+// including a published next key
+func (k *Keyring) JWKS() []JSONWebKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := []JSONWebKey{}
+	for _, key := range k.keys {
+		switch publicKey := key.PublicKey.(type) {
+		case ed25519.PublicKey:
+			jwks = append(jwks, JSONWebKey{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		case *rsa.PublicKey:
+			jwks = append(jwks, JSONWebKey{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		}
+	}
+	return jwks
+}
+
+// PublicJWKS returns the JWKS document for the active keyring
+func PublicJWKS() ([]JSONWebKey, error) {
+	k, err := getKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return k.JWKS(), nil
+}
+
+// RotateSigningKey rotates the active keyring's signing key immediately
+func RotateSigningKey() (*SigningKey, error) {
+	k, err := getKeyring()
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k.ActiveKey()
+}
+
+// StartKeyRotation starts a background goroutine that picks up keys rotated by other
+// replicas, rotates the active key when it is due and removes expired keys
+func StartKeyRotation() {
+	go func() {
+		ticker := time.NewTicker(keyReloadInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			k, err := getKeyring()
+			if err != nil {
+				log.Printf("Failed to get signing keyring: %v", err)
+				continue
+			}
+			if err := k.reload(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+				continue
+			}
+			if err := k.RotateIfDue(); err != nil {
+				log.Printf("Failed to rotate signing key: %v", err)
+			}
+			if err := k.store.CleanupExpired(); err != nil {
+				log.Printf("Failed to clean up expired signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// signToken signs claims with the active key and sets the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	k, err := getKeyring()
+	if err != nil {
+		return "", err
+	}
+	key, err := k.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKeyFunc resolves the key that verifies a token from its kid header.
+// Tokens issued before the keyring carry no kid and are verified with legacySecret
+// until the legacy token cutover.
+func verificationKeyFunc(legacySecret []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if !legacyTokensAccepted() {
+				return nil, errors.New("tokens without a signing key ID are no longer accepted")
+			}
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return legacySecret, nil
+		}
+
+		k, err := getKeyring()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+		// The algorithm must match the key, so an attacker cannot choose how a key is used
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	}
+}
+
+// generateSigningKey creates a new random key for the algorithm
+func generateSigningKey(algorithm string) (*SigningKey, error) {
+	now := time.Now()
+	key := &SigningKey{
+		ID:        fmt.Sprintf("%s_%s_%s", strings.ToLower(algorithm), now.Format("20060102"), GenerateRandomToken(6)),
+		Algorithm: algorithm,
+		CreatedAt: now,
+	}
+
+	switch algorithm {
+	case SigningAlgorithmEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = privateKey, publicKey
+	case SigningAlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = privateKey, &privateKey.PublicKey
+	case SigningAlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = secret, secret
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", algorithm)
+	}
+
+	return key, nil
+}
+
+// EnvironmentSigningKey returns an HS256 key derived from JWT_SECRET, so replicas
+// without a shared key store still sign with the same key
+func EnvironmentSigningKey() *SigningKey {
+	if err := initializeSecrets(); err != nil {
+		return nil
+	}
+	digest := sha256.Sum256(jwtSecret)
+	return &SigningKey{
+		ID:         "hs256_env_" + hex.EncodeToString(digest[:6]),
+		Algorithm:  SigningAlgorithmHS256,
+		PrivateKey: jwtSecret,
+		PublicKey:  jwtSecret,
+	}
+}
+
+// MemorySigningKeyStore keeps signing keys in process memory. Keys are lost on restart
+// and are not shared between replicas.
+type MemorySigningKeyStore struct {
+	mu   sync.Mutex
+	keys []*SigningKey
+}
+
+// NewMemorySigningKeyStore creates an in-memory key store, optionally seeded with an active key
+func NewMemorySigningKeyStore(initial *SigningKey) *MemorySigningKeyStore {
+	store := &MemorySigningKeyStore{}
+	if initial != nil {
+		store.keys = append(store.keys, initial)
+	}
+	return store
+}
+
+// LoadKeys returns the active key, the next key if one has been published and retired
+// keys still valid for verification
+func (s *MemorySigningKeyStore) LoadKeys() ([]*SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.VerifyUntil.IsZero() || key.VerifyUntil.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// RotateKey stores a new key and retires the keys in use when it becomes active
+func (s *MemorySigningKeyStore) RotateKey(key *SigningKey, previousID string, activatesAt, verifyUntil time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newestID := ""
+	for _, existing := range s.keys {
+		if existing.RetiredAt.IsZero() {
+			newestID = existing.ID
+		}
+	}
+	if newestID != previousID {
+		return false, nil
+	}
+
+	for _, existing := range s.keys {
+		if existing.RetiredAt.IsZero() || existing.RetiredAt.After(activatesAt) {
+			existing.RetiredAt = activatesAt
+			existing.VerifyUntil = verifyUntil
+		}
+	}
+	key.CreatedAt = time.Now()
+	key.ActivatesAt = activatesAt
+	s.keys = append(s.keys, key)
+	return true, nil
+}
+
+// CleanupExpired removes retired keys past their verification window
+func (s *MemorySigningKeyStore) CleanupExpired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	kept := s.keys[:0]
+	for _, key := range s.keys {
+		if key.VerifyUntil.IsZero() || key.VerifyUntil.After(now) {
+			kept = append(kept, key)
+		}
+	}
+	s.keys = kept
+	return nil
+}
+
+// PostgresSigningKeyStore stores signing keys in the jwt_signing_keys table.
+// Private keys are encrypted with a key derived from JWT_KEY_ENCRYPTION_SECRET or JWT_SECRET.
+type PostgresSigningKeyStore struct {
+	db            *database.DB
+	encryptionKey []byte
+}
+
+// NewPostgresSigningKeyStore creates a PostgreSQL-backed key store
+func NewPostgresSigningKeyStore(db *database.DB) (*PostgresSigningKeyStore, error) {
+	secret := os.Getenv("JWT_KEY_ENCRYPTION_SECRET")
+	if secret == "" {
+		if err := initializeSecrets(); err != nil {
+			return nil, err
+		}
+		secret = string(jwtSecret)
+	}
+	digest := sha256.Sum256([]byte("bome-jwt-signing-keys:" + secret))
+	return &PostgresSigningKeyStore{db: db, encryptionKey: digest[:]}, nil
+}
+
+// LoadKeys returns the active key, the next key if one has been published and retired
+// keys still valid for verification
+func (s *PostgresSigningKeyStore) LoadKeys() ([]*SigningKey, error) {
+	records, err := s.db.GetSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(records))
+	for _, record := range records {
+		key, err := s.decodeKey(record)
+		if err != nil {
+			// A key that cannot be decrypted (e.g. after the encryption secret changed) is skipped
+			log.Printf("Skipping unreadable signing key %s: %v", record.KID, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RotateKey stores a new key and retires the keys in use when it becomes active
+func (s *PostgresSigningKeyStore) RotateKey(key *SigningKey, previousID string, activatesAt, verifyUntil time.Time) (bool, error) {
+	record, err := s.encodeKey(key)
+	if err != nil {
+		return false, err
+	}
+	rotated, err := s.db.RotateSigningKey(record, previousID, activatesAt, verifyUntil)
+	if err != nil {
+		return false, err
+	}
+	key.CreatedAt = record.CreatedAt
+	key.ActivatesAt = record.ActivatesAt.Time
+	return rotated, nil
+}
+
+// CleanupExpired removes retired keys past their verification window
+func (s *PostgresSigningKeyStore) CleanupExpired() error {
+	return s.db.CleanupExpiredSigningKeys()
+}
+
+// encodeKey serializes and encrypts a key for storage
+func (s *PostgresSigningKeyStore) encodeKey(key *SigningKey) (*database.SigningKeyRecord, error) {
+	var privateDER []byte
+	var publicKey sql.NullString
+	var err error
+
+	switch privateKey := key.PrivateKey.(type) {
+	case []byte:
+		privateDER = privateKey
+	default:
+		privateDER, err = x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = sql.NullString{String: base64.StdEncoding.EncodeToString(publicDER), Valid: true}
+	}
+
+	encrypted, err := s.encrypt(privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.SigningKeyRecord{
+		KID:        key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: encrypted,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// decodeKey decrypts and parses a stored key
+func (s *PostgresSigningKeyStore) decodeKey(record *database.SigningKeyRecord) (*SigningKey, error) {
+	privateDER, err := s.decrypt(record.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:        record.KID,
+		Algorithm: record.Algorithm,
+		CreatedAt: record.CreatedAt,
+	}
+	if record.ActivatesAt.Valid {
+		key.ActivatesAt = record.ActivatesAt.Time
+	}
+	if record.RetiredAt.Valid {
+		key.RetiredAt = record.RetiredAt.Time
+	}
+	if record.VerifyUntil.Valid {
+		key.VerifyUntil = record.VerifyUntil.Time
+	}
+
+	switch record.Algorithm {
+	case SigningAlgorithmHS256:
+		key.PrivateKey, key.PublicKey = privateDER, privateDER
+	case SigningAlgorithmEdDSA, SigningAlgorithmRS256:
+		privateKey, err := x509.ParsePKCS8PrivateKey(privateDER)
+		if err != nil {
+			return nil, err
+		}
+		switch privateKey := privateKey.(type) {
+		case ed25519.PrivateKey:
+			key.PrivateKey, key.PublicKey = privateKey, privateKey.Public()
+		case *rsa.PrivateKey:
+			key.PrivateKey, key.PublicKey = privateKey, &privateKey.PublicKey
+		default:
+			return nil, fmt.Errorf("unexpected private key type %T", privateKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", record.Algorithm)
+	}
+
+	return key, nil
+}
+
+// encrypt seals key material with AES-GCM
+func (s *PostgresSigningKeyStore) encrypt(plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// decrypt opens key material sealed by encrypt
+func (s *PostgresSigningKeyStore) decrypt(encoded string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useTestKeyring installs a keyring with an in-memory store for the duration of a test
+func useTestKeyring(t *testing.T, algorithm string) (*Keyring, *MemorySigningKeyStore) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	SetRevocationStore(NewMemoryRevocationStore())
+
+	store := NewMemorySigningKeyStore(nil)
+	k, err := NewKeyring(store, algorithm, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	SetKeyring(k)
+	t.Cleanup(func() { SetKeyring(nil) })
+	return k, store
+}
+
+// testAccessClaims returns claims for a valid access token
+func testAccessClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		UserID:    7,
+		Email:     "person@example.com",
+		Role:      "user",
+		TokenType: "access",
+		TokenID:   "access_" + GenerateRandomToken(8),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "bome-backend",
+		},
+	}
+}
+
+func TestKeyringSignsAndVerifies(t *testing.T) {
+	for _, algorithm := range []string{SigningAlgorithmEdDSA, SigningAlgorithmRS256, SigningAlgorithmHS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			k, _ := useTestKeyring(t, algorithm)
+			active, err := k.ActiveKey()
+			if err != nil {
+				t.Fatalf("ActiveKey failed: %v", err)
+			}
+			if active.Algorithm != algorithm {
+				t.Fatalf("active key algorithm = %s, want %s", active.Algorithm, algorithm)
+			}
+
+			token, err := signToken(testAccessClaims())
+			if err != nil {
+				t.Fatalf("signToken failed: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatalf("failed to read token: %v", err)
+			}
+			if parsed.Header["kid"] != active.ID {
+				t.Errorf("kid = %v, want %s", parsed.Header["kid"], active.ID)
+			}
+
+			claims, err := ParseToken(token)
+			if err != nil {
+				t.Fatalf("ParseToken failed: %v", err)
+			}
+			if claims.UserID != 7 {
+				t.Errorf("user = %d, want 7", claims.UserID)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	k, store := useTestKeyring(t, SigningAlgorithmEdDSA)
+
+	oldKey, _ := k.ActiveKey()
+	oldToken, err := signToken(testAccessClaims())
+	if err != nil {
+		t.Fatalf("signToken failed: %v", err)
+	}
+
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	newKey, _ := k.ActiveKey()
+	if newKey.ID == oldKey.ID {
+		t.Fatal("rotation kept the same active key")
+	}
+
+	retired, ok := k.Key(oldKey.ID)
+	if !ok {
+		t.Fatal("the retired key no longer verifies")
+	}
+	if retired.RetiredAt.IsZero() || retired.VerifyUntil.Before(time.Now().Add(maxTokenLifetime)) {
+		t.Errorf("retired key verifies until %s, want at least the longest token lifetime", retired.VerifyUntil)
+	}
+	if _, err := ParseToken(oldToken); err != nil {
+		t.Errorf("a token signed before rotation no longer verifies: %v", err)
+	}
+
+	newToken, err := signToken(testAccessClaims())
+	if err != nil {
+		t.Fatalf("signToken failed: %v", err)
+	}
+	if _, err := ParseToken(newToken); err != nil {
+		t.Errorf("a token signed after rotation does not verify: %v", err)
+	}
+
+	// A replica that rotated with a stale view of the active key changes nothing
+	if rotated, err := store.RotateKey(&SigningKey{ID: "stale", Algorithm: SigningAlgorithmEdDSA}, oldKey.ID, time.Now(), time.Now().Add(time.Hour)); err != nil || rotated {
+		t.Errorf("RotateKey with a stale previous key = %v, %v, want false", rotated, err)
+	}
+
+	// Once its verification window ends the retired key is dropped
+	retired.VerifyUntil = time.Now().Add(-time.Second)
+	if _, ok := k.Key(oldKey.ID); ok {
+		t.Error("a key past its verification window still verifies")
+	}
+	if _, err := ParseToken(oldToken); err == nil {
+		t.Error("a token signed by an expired key still verifies")
+	}
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("CleanupExpired failed: %v", err)
+	}
+	keys, _ := store.LoadKeys()
+	if len(keys) != 1 || keys[0].ID != newKey.ID {
+		t.Errorf("store kept %d keys after cleanup, want only the active key", len(keys))
+	}
+}
+
+func TestKeyringRotateIfDue(t *testing.T) {
+	k, _ := useTestKeyring(t, SigningAlgorithmEdDSA)
+	active, _ := k.ActiveKey()
+
+	if err := k.RotateIfDue(); err != nil {
+		t.Fatalf("RotateIfDue failed: %v", err)
+	}
+	if next := k.newestKey(); next.ID != active.ID {
+		t.Fatal("a key younger than the rotation interval was rotated")
+	}
+
+	active.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := k.RotateIfDue(); err != nil {
+		t.Fatalf("RotateIfDue failed: %v", err)
+	}
+	next := k.newestKey()
+	if next.ID == active.ID {
+		t.Fatal("a key older than the rotation interval was not rotated")
+	}
+
+	// The next key is published before it signs anything
+	if current, _ := k.ActiveKey(); current.ID != active.ID {
+		t.Fatal("the next key signs before it was published for long enough")
+	}
+	published := false
+	for _, jwk := range k.JWKS() {
+		published = published || jwk.KeyID == next.ID
+	}
+	if !published {
+		t.Error("the next key is not in the JWKS")
+	}
+	if !next.ActivatesAt.After(time.Now().Add(nextKeyPublishLead - time.Minute)) {
+		t.Errorf("next key activates at %s, want about %s from now", next.ActivatesAt, nextKeyPublishLead)
+	}
+
+	// Nothing more is rotated while the next key waits to become active
+	if err := k.RotateIfDue(); err != nil {
+		t.Fatalf("RotateIfDue failed: %v", err)
+	}
+	if newest := k.newestKey(); newest.ID != next.ID {
+		t.Fatal("a second next key was published")
+	}
+
+	// Once its activation time passes the next key signs and the previous key retires
+	activatesAt := time.Now().Add(-time.Second)
+	next.ActivatesAt, active.RetiredAt = activatesAt, activatesAt
+	if current, _ := k.ActiveKey(); current.ID != next.ID {
+		t.Fatal("the next key did not become active")
+	}
+	if _, ok := k.Key(active.ID); !ok {
+		t.Error("the previous key no longer verifies")
+	}
+}
+
+func TestKeyringRotateReplacesNextKey(t *testing.T) {
+	k, _ := useTestKeyring(t, SigningAlgorithmEdDSA)
+	active, _ := k.ActiveKey()
+	active.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := k.RotateIfDue(); err != nil {
+		t.Fatalf("RotateIfDue failed: %v", err)
+	}
+	next := k.newestKey()
+
+	// An immediate rotation, e.g. after a compromise, takes over from both keys at once
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	current, _ := k.ActiveKey()
+	if current.ID == active.ID || current.ID == next.ID {
+		t.Fatal("an immediate rotation did not replace the active key")
+	}
+	if !next.RetiredAt.Before(time.Now().Add(time.Second)) || !active.RetiredAt.Before(time.Now().Add(time.Second)) {
+		t.Error("the previous keys were not retired immediately")
+	}
+	if !next.ActivatesAt.After(time.Now()) {
+		t.Error("the replaced next key was activated")
+	}
+}
+
+func TestKeyringRejectsForgedTokens(t *testing.T) {
+	k, _ := useTestKeyring(t, SigningAlgorithmEdDSA)
+	active, _ := k.ActiveKey()
+	otherKey, err := generateSigningKey(SigningAlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("generateSigningKey failed: %v", err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, testAccessClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "unknown kid", token: sign(jwt.SigningMethodEdDSA, "eddsa_unknown", otherKey.PrivateKey)},
+		{name: "active kid signed by another key", token: sign(jwt.SigningMethodEdDSA, active.ID, otherKey.PrivateKey)},
+		{name: "public key used as an HMAC secret", token: sign(jwt.SigningMethodHS256, active.ID, []byte(active.PublicKey.(ed25519.PublicKey)))},
+		{name: "unsigned", token: sign(jwt.SigningMethodNone, active.ID, jwt.UnsafeAllowNoneSignatureType)},
+		{name: "no kid signed with an asymmetric key", token: sign(jwt.SigningMethodEdDSA, "", otherKey.PrivateKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseToken(tt.token); err == nil {
+				t.Error("a forged token was accepted")
+			}
+		})
+	}
+}
+
+func TestLegacyTokenCutover(t *testing.T) {
+	useTestKeyring(t, SigningAlgorithmEdDSA)
+	t.Cleanup(func() { SetLegacyTokenCutover(time.Time{}) })
+
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testAccessClaims()).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("failed to sign legacy token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cutover    time.Time
+		wantSetErr bool
+		accepted   bool
+	}{
+		{name: "no cutover configured"},
+		{name: "before the cutover", cutover: time.Now().Add(time.Hour), accepted: true},
+		{name: "after the cutover", cutover: time.Now().Add(-time.Second)},
+		{name: "cutover at the longest token lifetime", cutover: time.Now().Add(maxTokenLifetime - time.Minute), accepted: true},
+		{name: "cutover beyond the longest token lifetime", cutover: time.Now().Add(maxTokenLifetime + time.Hour), wantSetErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetLegacyTokenCutover(time.Time{})
+			if err := SetLegacyTokenCutover(tt.cutover); (err != nil) != tt.wantSetErr {
+				t.Fatalf("SetLegacyTokenCutover() error = %v, wantErr %v", err, tt.wantSetErr)
+			}
+
+			_, err := ParseToken(legacy)
+			if accepted := err == nil; accepted != tt.accepted {
+				t.Errorf("legacy token accepted = %v, want %v (%v)", accepted, tt.accepted, err)
+			}
+		})
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	k, _ := useTestKeyring(t, SigningAlgorithmRS256)
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	jwks := k.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS has %d keys, want the active and retired keys", len(jwks))
+	}
+	for _, jwk := range jwks {
+		key, ok := k.Key(jwk.KeyID)
+		if !ok {
+			t.Fatalf("JWKS lists unknown key %s", jwk.KeyID)
+		}
+		parsed, err := parseJSONWebKey(jwk)
+		if err != nil {
+			t.Fatalf("JWKS key %s does not parse: %v", jwk.KeyID, err)
+		}
+		if !key.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(parsed) {
+			t.Errorf("JWKS key %s does not match the signing key", jwk.KeyID)
+		}
+	}
+
+	hmacKeyring, _ := useTestKeyring(t, SigningAlgorithmHS256)
+	if jwks := hmacKeyring.JWKS(); len(jwks) != 0 {
+		t.Errorf("JWKS published %d HMAC keys", len(jwks))
+	}
+}
+
+func TestPostgresSigningKeyStoreEncoding(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_SECRET", "test-encryption-secret")
+	store, err := NewPostgresSigningKeyStore(nil)
+	if err != nil {
+		t.Fatalf("NewPostgresSigningKeyStore failed: %v", err)
+	}
+
+	for _, algorithm := range []string{SigningAlgorithmEdDSA, SigningAlgorithmRS256, SigningAlgorithmHS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := generateSigningKey(algorithm)
+			if err != nil {
+				t.Fatalf("generateSigningKey failed: %v", err)
+			}
+			record, err := store.encodeKey(key)
+			if err != nil {
+				t.Fatalf("encodeKey failed: %v", err)
+			}
+
+			decoded, err := store.decodeKey(record)
+			if err != nil {
+				t.Fatalf("decodeKey failed: %v", err)
+			}
+			if decoded.ID != key.ID || decoded.Algorithm != algorithm {
+				t.Errorf("decoded key %s (%s), want %s (%s)", decoded.ID, decoded.Algorithm, key.ID, algorithm)
+			}
+
+			token, err := jwt.NewWithClaims(key.signingMethod(), testAccessClaims()).SignedString(key.PrivateKey)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return decoded.PublicKey, nil }); err != nil {
+				t.Errorf("decoded key does not verify the original key's tokens: %v", err)
+			}
+
+			t.Setenv("JWT_KEY_ENCRYPTION_SECRET", "a-different-secret")
+			other, _ := NewPostgresSigningKeyStore(nil)
+			if _, err := other.decodeKey(record); err == nil {
+				t.Error("a key decrypted with the wrong secret")
+			}
+		})
+	}
+}
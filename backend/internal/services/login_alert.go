@@ -0,0 +1,29 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	loginAlertTokenType = "login_alert"
+
+	// LoginAlertTokenLifetime is how long the "this wasn't me" link in a new-device email works
+	LoginAlertTokenLifetime = 7 * 24 * time.Hour
+)
+
+// GenerateLoginAlertToken generates the token behind the "this wasn't me" link sent when
+// a user signs in from a new device. Using it revokes every session, including the
+// token itself, so it works once.
+func GenerateLoginAlertToken(userID int, email, role string, emailVerified bool) (string, error) {
+	if err := initializeSecrets(); err != nil {
+		return "", fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+	tokenID := fmt.Sprintf("alert_%d_%s", userID, GenerateRandomToken(16))
+	return generateToken(userID, email, role, emailVerified, loginAlertTokenType, LoginAlertTokenLifetime, tokenID)
+}
+
+// ParseLoginAlertToken parses and validates a "this wasn't me" token
+func ParseLoginAlertToken(tokenString string) (*Claims, error) {
+	return parseTypedToken(tokenString, loginAlertTokenType)
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultIPBlockThreshold = 10
+	defaultIPBlockWindow    = time.Hour
+	defaultIPBlockDuration  = time.Hour
+)
+
+// LoginAttemptStore persists failed-attempt counters and lockouts for EnhancedRateLimiter.
+// Keys are scoped by the limiter; IP-level locks use "ip:<address>".
+type LoginAttemptStore interface {
+	// RecordFailure counts a failed attempt and returns the failures in the current window
+	RecordFailure(key string, window time.Duration) (int, error)
+	// Failures returns the failures in the current window
+	Failures(key string) (int, error)
+	// Lock blocks the key until the given time
+	Lock(key string, until time.Time) error
+	// LockedUntil returns when the key's lock ends, or the zero time
+	LockedUntil(key string) (time.Time, error)
+	// Reset clears the key's failures and lock
+	Reset(key string) error
+	// RecordIPAccountFailure records a failed attempt against an account from an IP and
+	// returns how many distinct accounts have failed from that IP within the window
+	RecordIPAccountFailure(ip, account string, window time.Duration) (int, error)
+	// ResetIPAccountFailures forgets the accounts that failed from an IP
+	ResetIPAccountFailures(ip string) error
+	// CleanupExpired removes counters and locks that no longer apply
+	CleanupExpired() error
+}
+
+// IPBlockListener is notified when an IP address is blocked for failing against many accounts
+type IPBlockListener func(ip string, accounts int, until time.Time)
+
+// Active login attempt store, in-memory until SetLoginAttemptStore is called
+var (
+	loginAttemptStore      LoginAttemptStore = NewMemoryLoginAttemptStore()
+	loginAttemptStoreMutex sync.RWMutex
+	ipBlockListener        IPBlockListener
+)
+
+// SetLoginAttemptStore replaces the backend used by the enhanced rate limiters
+func SetLoginAttemptStore(store LoginAttemptStore) {
+	loginAttemptStoreMutex.Lock()
+	defer loginAttemptStoreMutex.Unlock()
+	loginAttemptStore = store
+}
+
+// getLoginAttemptStore returns the active login attempt backend
+func getLoginAttemptStore() LoginAttemptStore {
+	loginAttemptStoreMutex.RLock()
+	defer loginAttemptStoreMutex.RUnlock()
+	return loginAttemptStore
+}
+
+// SetIPBlockListener sets the function called when an IP address is blocked
+func SetIPBlockListener(listener IPBlockListener) {
+	loginAttemptStoreMutex.Lock()
+	defer loginAttemptStoreMutex.Unlock()
+	ipBlockListener = listener
+}
+
+// IPBlockPolicy returns how many distinct accounts may fail from one IP within the
+// window before the IP is blocked, and for how long. Read from LOGIN_IP_BLOCK_THRESHOLD,
+// LOGIN_IP_BLOCK_WINDOW and LOGIN_IP_BLOCK_DURATION; a threshold of 0 disables IP blocks.
+func IPBlockPolicy() (threshold int, window, duration time.Duration) {
+	threshold = defaultIPBlockThreshold
+	if value, err := strconv.Atoi(os.Getenv("LOGIN_IP_BLOCK_THRESHOLD")); err == nil && value >= 0 {
+		threshold = value
+	}
+	window = defaultIPBlockWindow
+	if value, err := time.ParseDuration(os.Getenv("LOGIN_IP_BLOCK_WINDOW")); err == nil && value > 0 {
+		window = value
+	}
+	duration = defaultIPBlockDuration
+	if value, err := time.ParseDuration(os.Getenv("LOGIN_IP_BLOCK_DURATION")); err == nil && value > 0 {
+		duration = value
+	}
+	return threshold, window, duration
+}
+
+// ipLockKey returns the store key for an IP-level block
+func ipLockKey(ip string) string {
+	return "ip:" + ip
+}
+
+// recordIPFailure tracks a failed attempt from an IP and blocks the IP once it has
+// failed against too many distinct accounts
+func recordIPFailure(ip, account string) {
+	threshold, window, duration := IPBlockPolicy()
+	if ip == "" || threshold == 0 {
+		return
+	}
+
+	store := getLoginAttemptStore()
+	accounts, err := store.RecordIPAccountFailure(ip, account, window)
+	if err != nil {
+		log.Printf("Failed to record failed attempt from %s: %v", ip, err)
+		return
+	}
+	if accounts < threshold || RemainingIPBlockTime(ip) > 0 {
+		return
+	}
+
+	until := time.Now().Add(duration)
+	if err := store.Lock(ipLockKey(ip), until); err != nil {
+		log.Printf("Failed to block %s: %v", ip, err)
+		return
+	}
+	log.Printf("IP blocked: %s failed against %d accounts, blocked for %v", ip, accounts, duration)
+
+	loginAttemptStoreMutex.RLock()
+	listener := ipBlockListener
+	loginAttemptStoreMutex.RUnlock()
+	if listener != nil {
+		listener(ip, accounts, until)
+	}
+}
+
+// RemainingIPBlockTime returns how long an IP address remains blocked
+func RemainingIPBlockTime(ip string) time.Duration {
+	if ip == "" {
+		return 0
+	}
+	lockedUntil, err := getLoginAttemptStore().LockedUntil(ipLockKey(ip))
+	if err != nil {
+		log.Printf("Failed to check IP block for %s: %v", ip, err)
+		return 0
+	}
+	if remaining := time.Until(lockedUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// UnblockIP lifts an IP-level block and forgets the IP's failed attempts
+func UnblockIP(ip string) error {
+	store := getLoginAttemptStore()
+	if err := store.Reset(ipLockKey(ip)); err != nil {
+		return err
+	}
+	return store.ResetIPAccountFailures(ip)
+}
+
+// StartLoginAttemptCleanup periodically removes expired counters and locks
+func StartLoginAttemptCleanup() {
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := getLoginAttemptStore().CleanupExpired(); err != nil {
+				log.Printf("Failed to clean up login attempts: %v", err)
+			}
+		}
+	}()
+}
+
+// loginAttemptCounter is a failure count for one key in the in-memory store
+type loginAttemptCounter struct {
+	failures    int
+	windowEnds  time.Time
+	lockedUntil time.Time
+}
+
+// MemoryLoginAttemptStore keeps counters in process memory. Lockouts are lost on
+// restart and not shared between instances, so it is only suitable for development.
+type MemoryLoginAttemptStore struct {
+	counters   map[string]*loginAttemptCounter
+	ipAccounts map[string]map[string]time.Time
+	mutex      sync.Mutex
+}
+
+// NewMemoryLoginAttemptStore creates an in-memory login attempt store
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		counters:   make(map[string]*loginAttemptCounter),
+		ipAccounts: make(map[string]map[string]time.Time),
+	}
+}
+
+// RecordFailure implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) RecordFailure(key string, window time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &loginAttemptCounter{}
+		s.counters[key] = counter
+	}
+	if now.After(counter.windowEnds) {
+		counter.failures = 0
+		counter.windowEnds = now.Add(window)
+	}
+	counter.failures++
+	return counter.failures, nil
+}
+
+// Failures implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Failures(key string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || time.Now().After(counter.windowEnds) {
+		return 0, nil
+	}
+	return counter.failures, nil
+}
+
+// Lock implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &loginAttemptCounter{}
+		s.counters[key] = counter
+	}
+	counter.lockedUntil = until
+	return nil
+}
+
+// LockedUntil implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if counter, ok := s.counters[key]; ok {
+		return counter.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// Reset implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+// RecordIPAccountFailure implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) RecordIPAccountFailure(ip, account string, window time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	accounts, ok := s.ipAccounts[ip]
+	if !ok {
+		accounts = make(map[string]time.Time)
+		s.ipAccounts[ip] = accounts
+	}
+	accounts[account] = now
+
+	count := 0
+	for name, failedAt := range accounts {
+		if now.Sub(failedAt) > window {
+			delete(accounts, name)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ResetIPAccountFailures implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) ResetIPAccountFailures(ip string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.ipAccounts, ip)
+	return nil
+}
+
+// CleanupExpired implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) CleanupExpired() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, counter := range s.counters {
+		if now.After(counter.windowEnds) && now.After(counter.lockedUntil) {
+			delete(s.counters, key)
+		}
+	}
+
+	_, window, _ := IPBlockPolicy()
+	for ip, accounts := range s.ipAccounts {
+		for name, failedAt := range accounts {
+			if now.Sub(failedAt) > window {
+				delete(accounts, name)
+			}
+		}
+		if len(accounts) == 0 {
+			delete(s.ipAccounts, ip)
+		}
+	}
+	return nil
+}
+
+// PostgresLoginAttemptStore keeps counters in the login_attempts and
+// login_ip_failures tables so lockouts survive restarts and are shared by replicas
+type PostgresLoginAttemptStore struct {
+	db *database.DB
+}
+
+// NewPostgresLoginAttemptStore creates a Postgres-backed login attempt store
+func NewPostgresLoginAttemptStore(db *database.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+// RecordFailure implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) RecordFailure(key string, window time.Duration) (int, error) {
+	return s.db.RecordLoginFailure(key, time.Now().Add(window))
+}
+
+// Failures implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) Failures(key string) (int, error) {
+	return s.db.GetLoginFailures(key)
+}
+
+// Lock implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) Lock(key string, until time.Time) error {
+	return s.db.LockLoginKey(key, until)
+}
+
+// LockedUntil implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	return s.db.GetLoginLockedUntil(key)
+}
+
+// Reset implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) Reset(key string) error {
+	return s.db.ResetLoginAttempts(key)
+}
+
+// RecordIPAccountFailure implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) RecordIPAccountFailure(ip, account string, window time.Duration) (int, error) {
+	return s.db.RecordIPAccountFailure(ip, account, time.Now().Add(-window))
+}
+
+// ResetIPAccountFailures implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) ResetIPAccountFailures(ip string) error {
+	return s.db.ResetIPAccountFailures(ip)
+}
+
+// CleanupExpired implements LoginAttemptStore
+func (s *PostgresLoginAttemptStore) CleanupExpired() error {
+	_, window, _ := IPBlockPolicy()
+	return s.db.CleanupLoginAttempts(time.Now().Add(-window))
+}
+
+// RedisLoginAttemptStore keeps counters in Redis keys that expire on their own
+type RedisLoginAttemptStore struct {
+	redis   *database.Redis
+	timeout time.Duration
+}
+
+// NewRedisLoginAttemptStore creates a Redis-backed login attempt store
+func NewRedisLoginAttemptStore(r *database.Redis) *RedisLoginAttemptStore {
+	return &RedisLoginAttemptStore{redis: r, timeout: 2 * time.Second}
+}
+
+func redisLoginFailuresKey(key string) string {
+	return "auth:login_failures:" + key
+}
+
+func redisLoginLockKey(key string) string {
+	return "auth:login_lock:" + key
+}
+
+func redisIPAccountsKey(ip string) string {
+	return "auth:ip_accounts:" + ip
+}
+
+// redisCountFailure increments the counter in KEYS[1] and starts its window of ARGV[1]
+// milliseconds on the first failure, in one step so a counter is never left without
+// an expiry. A counter found without one is given one.
+var redisCountFailure = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 or redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return failures
+`)
+
+// RecordFailure implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) RecordFailure(key string, window time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	failures, err := redisCountFailure.Run(ctx, s.redis, []string{redisLoginFailuresKey(key)}, window.Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// Failures implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Failures(key string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	failures, err := s.redis.Get(ctx, redisLoginFailuresKey(key)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return failures, err
+}
+
+// Lock implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Lock(key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.redis.Set(ctx, redisLoginLockKey(key), until.Unix(), ttl).Err()
+}
+
+// LockedUntil implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	seconds, err := s.redis.Get(ctx, redisLoginLockKey(key)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// Reset implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.redis.Del(ctx, redisLoginFailuresKey(key), redisLoginLockKey(key)).Err()
+}
+
+// RecordIPAccountFailure implements LoginAttemptStore. Accounts are kept in a sorted
+// set scored by the time of their latest failure.
+func (s *RedisLoginAttemptStore) RecordIPAccountFailure(ip, account string, window time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	now := time.Now()
+	redisKey := redisIPAccountsKey(ip)
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, redisKey, redis.Z{Score: float64(now.Unix()), Member: account})
+	pipe.ZRemRangeByScore(ctx, redisKey, "-inf", fmt.Sprintf("(%d", now.Add(-window).Unix()))
+	count := pipe.ZCard(ctx, redisKey)
+	pipe.Expire(ctx, redisKey, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// ResetIPAccountFailures implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) ResetIPAccountFailures(ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.redis.Del(ctx, redisIPAccountsKey(ip)).Err()
+}
+
+// CleanupExpired implements LoginAttemptStore. Redis expires keys by TTL, so there is nothing to do.
+func (s *RedisLoginAttemptStore) CleanupExpired() error {
+	return nil
+}
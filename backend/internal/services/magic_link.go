@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	magicLinkTokenType       = "magic_link"
+	defaultMagicLinkLifetime = 15 * time.Minute
+	maxMagicLinkLifetime     = time.Hour
+)
+
+// MagicLinkLifetime returns how long a magic link stays valid, read from
+// MAGIC_LINK_EXPIRY and capped at one hour
+func MagicLinkLifetime() time.Duration {
+	lifetime, err := time.ParseDuration(os.Getenv("MAGIC_LINK_EXPIRY"))
+	if err != nil || lifetime <= 0 {
+		return defaultMagicLinkLifetime
+	}
+	if lifetime > maxMagicLinkLifetime {
+		return maxMagicLinkLifetime
+	}
+	return lifetime
+}
+
+// MagicLinkAllowed reports whether a user with the given roles, primary and additional,
+// and effective permissions may sign in with a magic link. MAGIC_LINK_DISABLED_ROLES
+// lists the excluded roles; "admin" stands for every administrator role and for full
+// system access. Administrators are excluded when the variable is unset.
+func MagicLinkAllowed(roles, permissions []string) bool {
+	disabled, ok := os.LookupEnv("MAGIC_LINK_DISABLED_ROLES")
+	if !ok {
+		disabled = "admin"
+	}
+
+	for _, entry := range strings.Split(disabled, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "admin" && hasAdminAccess(roles, permissions) {
+			return false
+		}
+		for _, role := range roles {
+			if entry == role {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GenerateMagicLinkToken generates a short-lived signed login token for a user.
+// The returned token ID must be recorded so the link can only be used once.
+func GenerateMagicLinkToken(userID int, email, role string, emailVerified bool) (string, string, time.Time, error) {
+	if err := initializeSecrets(); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+
+	lifetime := MagicLinkLifetime()
+	tokenID := fmt.Sprintf("ml_%d_%s", userID, GenerateRandomToken(16))
+	token, err := generateToken(userID, email, role, emailVerified, magicLinkTokenType, lifetime, tokenID)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, tokenID, time.Now().Add(lifetime), nil
+}
+
+// ParseMagicLinkToken parses and validates a magic-link token. It does not check
+// whether the link has already been used; that is recorded in the database.
+func ParseMagicLinkToken(tokenString string) (*Claims, error) {
+	return parseTypedToken(tokenString, magicLinkTokenType)
+}
+
+// parseTypedToken parses and validates a single-purpose token of the given type,
+// such as a magic link or login alert token
+func parseTypedToken(tokenString, tokenType string) (*Claims, error) {
+	if err := initializeSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to initialize JWT secrets: %w", err)
+	}
+
+	if tokenString == "" {
+		return nil, errors.New("token is required")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKeyFunc(jwtSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s token: %w", tokenType, err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid %s token", tokenType)
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("invalid token type for %s", tokenType)
+	}
+
+	if claims.Issuer != "bome-backend" {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	if err := checkRevocation(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
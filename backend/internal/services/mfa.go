@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30 // seconds per time step (RFC 6238 default)
+	totpDigits        = 6
+	totpSkewSteps     = 1 // accept one step either side to tolerate clock drift
+	recoveryCodeCount = 10
+)
+
+// AdminRoles lists the roles treated as administrators (level 7+ plus legacy admin).
+// These roles must have two-factor authentication enabled before they can log in.
+var AdminRoles = []string{
+	"super_admin",           // Level 10: Super Administrator
+	"system_admin",          // Level 9: System Administrator
+	"content_manager",       // Level 8: Content Manager
+	"articles_manager",      // Level 7: Articles Manager
+	"youtube_manager",       // Level 7: YouTube Manager
+	"streaming_manager",     // Level 7: Video Streaming Manager
+	"events_manager",        // Level 7: Events Manager
+	"advertisement_manager", // Level 7: Advertisement Manager
+	"user_manager",          // Level 7: User Account Manager
+	"analytics_manager",     // Level 7: Analytics Manager
+	"financial_admin",       // Level 7: Financial Administrator
+	"admin",                 // Legacy admin role
+}
+
+// IsAdminRole reports whether the role is one of the administrator roles
+func IsAdminRole(role string) bool {
+	for _, adminRole := range AdminRoles {
+		if role == adminRole {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAdminAccess reports whether any of the roles is an administrator role or the
+// permissions include full system access
+func hasAdminAccess(roles, permissions []string) bool {
+	for _, role := range roles {
+		if IsAdminRole(role) {
+			return true
+		}
+	}
+	for _, permission := range permissions {
+		if permission == "system:full_access" {
+			return true
+		}
+	}
+	return false
+}
+
+// MFARequired reports whether two-factor authentication is mandatory for a user with
+// the given roles, primary and additional, and effective permissions
+func MFARequired(roles, permissions []string) bool {
+	return hasAdminAccess(roles, permissions)
+}
+
+// GenerateTOTPSecret generates a new base32-encoded TOTP shared secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI that authenticator apps read from a QR code
+func TOTPProvisioningURI(secret, accountEmail string) string {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "BOME"
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + accountEmail)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// ValidateTOTPCode checks a code against the secret and returns the matched time step.
+// Codes from a step at or before lastUsedStep are rejected to prevent replay.
+func ValidateTOTPCode(secret, code string, lastUsedStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	currentStep := time.Now().Unix() / totpPeriod
+	for offset := int64(-totpSkewSteps); offset <= totpSkewSteps; offset++ {
+		step := currentStep + offset
+		if step <= lastUsedStep {
+			continue
+		}
+		expected := generateHOTP(key, step)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateHOTP computes an RFC 4226 HOTP value for the given counter
+func generateHOTP(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// GenerateRecoveryCodes returns a fresh set of plaintext recovery codes and their hashes
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+		code := encoded[:4] + "-" + encoded[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalizes and hashes a recovery code for storage and lookup
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"encoding/base32"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc4226Secret is the shared secret of the RFC 4226 test vectors
+const rfc4226Secret = "12345678901234567890"
+
+func TestGenerateHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, expected := range want {
+		if got := generateHOTP([]byte(rfc4226Secret), int64(counter)); got != expected {
+			t.Errorf("generateHOTP(%d) = %s, want %s", counter, got, expected)
+		}
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(rfc4226Secret))
+	key := []byte(rfc4226Secret)
+	step := time.Now().Unix() / totpPeriod
+
+	tests := []struct {
+		name         string
+		secret       string
+		code         string
+		lastUsedStep int64
+		wantStep     int64
+		wantOK       bool
+	}{
+		{name: "current step", secret: secret, code: generateHOTP(key, step), wantStep: step, wantOK: true},
+		{name: "previous step within skew", secret: secret, code: generateHOTP(key, step-1), wantStep: step - 1, wantOK: true},
+		{name: "lowercase secret", secret: strings.ToLower(secret), code: generateHOTP(key, step), wantStep: step, wantOK: true},
+		{name: "code with spaces", secret: secret, code: " " + generateHOTP(key, step)[:3] + " " + generateHOTP(key, step)[3:] + " ", wantStep: step, wantOK: true},
+		{name: "too old", secret: secret, code: generateHOTP(key, step-3)},
+		{name: "too far ahead", secret: secret, code: generateHOTP(key, step+3)},
+		{name: "replayed step", secret: secret, code: generateHOTP(key, step), lastUsedStep: step + 1},
+		{name: "earlier step after a later one was used", secret: secret, code: generateHOTP(key, step-1), lastUsedStep: step},
+		{name: "wrong length", secret: secret, code: generateHOTP(key, step)[:5]},
+		{name: "empty", secret: secret, code: ""},
+		{name: "invalid secret", secret: "not base32!", code: generateHOTP(key, step)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTPCode(tt.secret, tt.code, tt.lastUsedStep)
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTPCode() ok = %v, want %v", ok, tt.wantOK)
+			}
+			// The step can move on between computing the code and validating it
+			if ok && gotStep != tt.wantStep && gotStep != tt.wantStep+1 {
+				t.Errorf("ValidateTOTPCode() step = %d, want %d", gotStep, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not unpadded base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+
+	code := generateHOTP(key, time.Now().Unix()/totpPeriod)
+	if _, ok := ValidateTOTPCode(secret, code, 0); !ok {
+		t.Error("a code for the generated secret did not validate")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	t.Setenv("MFA_ISSUER", "BOME Test")
+
+	uri, err := url.Parse(TOTPProvisioningURI("SECRET", "admin@example.com"))
+	if err != nil {
+		t.Fatalf("invalid provisioning URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("URI = %s, want otpauth://totp/...", uri)
+	}
+	if uri.Path != "/BOME Test:admin@example.com" {
+		t.Errorf("label = %q, want issuer and account", uri.Path)
+	}
+
+	query := uri.Query()
+	for param, want := range map[string]string{"secret": "SECRET", "issuer": "BOME Test", "digits": "6", "period": "30", "algorithm": "SHA1"} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not match xxxx-xxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was generated twice", code)
+		}
+		seen[code] = true
+
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash %d does not match its code", i)
+		}
+		if strings.Contains(hashes[i], code) {
+			t.Errorf("hash %d contains the plaintext code", i)
+		}
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcd-efgh")
+
+	tests := []struct {
+		name  string
+		code  string
+		match bool
+	}{
+		{name: "as issued", code: "abcd-efgh", match: true},
+		{name: "uppercase", code: "ABCD-EFGH", match: true},
+		{name: "without dash", code: "abcdefgh", match: true},
+		{name: "surrounding whitespace", code: "  abcd-efgh\n", match: true},
+		{name: "different code", code: "abcd-efgi"},
+		{name: "truncated", code: "abcd-efg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashRecoveryCode(tt.code) == want; got != tt.match {
+				t.Errorf("HashRecoveryCode(%q) matches = %v, want %v", tt.code, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestMFARequired(t *testing.T) {
+	tests := []struct {
+		name        string
+		roles       []string
+		permissions []string
+		required    bool
+	}{
+		{name: "user", roles: []string{"user"}, permissions: []string{"content:read"}},
+		{name: "admin primary role", roles: []string{"super_admin"}, required: true},
+		{name: "legacy admin role", roles: []string{"admin"}, required: true},
+		{name: "admin additional role", roles: []string{"user", "system_admin"}, required: true},
+		{name: "full access override", roles: []string{"user"}, permissions: []string{"system:full_access"}, required: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MFARequired(tt.roles, tt.permissions); got != tt.required {
+				t.Errorf("MFARequired(%v, %v) = %v, want %v", tt.roles, tt.permissions, got, tt.required)
+			}
+		})
+	}
+}
+
+func TestMagicLinkAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		disabled    string
+		roles       []string
+		permissions []string
+		allowed     bool
+	}{
+		{name: "user", disabled: "admin", roles: []string{"user"}, allowed: true},
+		{name: "admin additional role", disabled: "admin", roles: []string{"user", "content_manager"}},
+		{name: "full access override", disabled: "admin", roles: []string{"user"}, permissions: []string{"system:full_access"}},
+		{name: "listed additional role", disabled: "moderator", roles: []string{"user", "moderator"}},
+		{name: "nothing disabled", disabled: "", roles: []string{"super_admin"}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAGIC_LINK_DISABLED_ROLES", tt.disabled)
+			if got := MagicLinkAllowed(tt.roles, tt.permissions); got != tt.allowed {
+				t.Errorf("MagicLinkAllowed(%v, %v) = %v, want %v", tt.roles, tt.permissions, got, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// OIDCAuthStateLifetime is how long a user has to complete sign-in at the provider
+	OIDCAuthStateLifetime = 10 * time.Minute
+
+	oidcDiscoveryTTL      = time.Hour
+	oidcKeyRefreshBackoff = 30 * time.Second
+	oidcMaxResponseSize   = 1 << 20
+)
+
+// OIDCProvider is a configured OpenID Connect sign-in provider. The discovery
+// document and signing keys are fetched from the issuer and cached.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+
+	mutex         sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// OIDCIdentity is the identity asserted by a verified ID token
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// oidcDiscovery is the subset of the provider's openid-configuration document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcIDTokenClaims are the ID token claims we read. email_verified is a
+// boolean for most providers but a string for some (e.g. Apple).
+type oidcIDTokenClaims struct {
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+	Nonce           string      `json:"nonce"`
+	AuthorizedParty string      `json:"azp"`
+	Name            string      `json:"name"`
+	GivenName       string      `json:"given_name"`
+	FamilyName      string      `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+var (
+	oidcMutex      sync.RWMutex
+	oidcProviders  = map[string]*OIDCProvider{}
+	oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// NewOIDCProvider creates a provider. The redirect URL is the callback registered with the provider.
+func NewOIDCProvider(name, issuer, clientID, clientSecret string, scopes []string, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// SetOIDCProviders replaces the configured sign-in providers
+func SetOIDCProviders(providers []*OIDCProvider) {
+	registry := make(map[string]*OIDCProvider, len(providers))
+	for _, provider := range providers {
+		registry[provider.Name] = provider
+	}
+
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	oidcProviders = registry
+}
+
+// GetOIDCProvider returns a configured provider by name
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	oidcMutex.RLock()
+	defer oidcMutex.RUnlock()
+	provider, ok := oidcProviders[name]
+	return provider, ok
+}
+
+// OIDCProviderNames returns the names of the configured providers in sorted order
+func OIDCProviderNames() []string {
+	oidcMutex.RLock()
+	defer oidcMutex.RUnlock()
+
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewOIDCAuthRequest generates the state, nonce and PKCE code verifier for an authorization request
+func NewOIDCAuthRequest() (state, nonce, codeVerifier string) {
+	return GenerateRandomToken(32), GenerateRandomToken(32), GenerateRandomToken(32)
+}
+
+// AuthorizationURL builds the URL the user is sent to in order to sign in at the provider
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the provider's token endpoint and
+// returns the identity from the verified ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return nil, fmt.Errorf("token request rejected with status %d: %s %s", resp.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("token response did not include an id_token")
+	}
+
+	return p.verifyIDToken(ctx, discovery, tokenResponse.IDToken, nonce)
+}
+
+// verifyIDToken checks the ID token signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, rawToken, nonce string) (*OIDCIdentity, error) {
+	claims := &oidcIDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, discovery, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce does not match")
+	}
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("id token was issued to another client")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	emailVerified := false
+	switch value := claims.EmailVerified.(type) {
+	case bool:
+		emailVerified = value
+	case string:
+		emailVerified = value == "true"
+	}
+
+	return &OIDCIdentity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: emailVerified,
+		Name:          claims.Name,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// discover returns the provider's discovery document, fetching it when the cached copy is stale
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := fetchOIDCJSON(ctx, p.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", p.Name, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match configured issuer %q", discovery.Issuer, p.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document for %s is missing endpoints", p.Name)
+	}
+
+	p.discovery = discovery
+	p.discoveredAt = time.Now()
+	return discovery, nil
+}
+
+// signingKey returns the provider's public key with the given kid. The key set is
+// refetched when the kid is unknown, at most once per backoff period.
+func (p *OIDCProvider) signingKey(ctx context.Context, discovery *oidcDiscovery, kid string) (interface{}, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := lookupOIDCKey(p.keys, kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < oidcKeyRefreshBackoff {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var document struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := fetchOIDCJSON(ctx, discovery.JWKSURI, &document); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys for %s: %w", p.Name, err)
+	}
+
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := lookupOIDCKey(keys, kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupOIDCKey finds a key by kid; a token without a kid matches a key set with a single key
+func lookupOIDCKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// parseJSONWebKey converts an RSA or EC JSON web key into a public key
+func parseJSONWebKey(jwk JSONWebKey) (interface{}, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(data) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
+
+// fetchOIDCJSON fetches a JSON document from the provider
+func fetchOIDCJSON(ctx context.Context, endpoint string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(dest)
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testOIDCClientID = "bome-test-client"
+	testOIDCKeyID    = "test-key"
+	testOIDCNonce    = "test-nonce"
+)
+
+// stubOIDCIssuer is an OpenID Connect provider serving discovery, a key set and a token
+// endpoint that answers with whatever ID token the test sets
+type stubOIDCIssuer struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+
+	tokenRequest url.Values
+}
+
+func newStubOIDCIssuer(t *testing.T) *stubOIDCIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	issuer := &stubOIDCIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]JSONWebKey{"keys": {{
+			KeyType:   "RSA",
+			KeyID:     testOIDCKeyID,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		issuer.tokenRequest = r.PostForm
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken, "token_type": "Bearer"})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (s *stubOIDCIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider("stub", s.server.URL, testOIDCClientID, "secret", []string{"openid", "email"}, "https://bome.test/auth/callback")
+}
+
+// claims returns valid ID token claims for the stub issuer
+func (s *stubOIDCIssuer) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            s.server.URL,
+		"aud":            testOIDCClientID,
+		"sub":            "subject-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          testOIDCNonce,
+		"email":          " Person@Example.com ",
+		"email_verified": true,
+		"given_name":     "Test",
+		"family_name":    "Person",
+	}
+}
+
+func (s *stubOIDCIssuer) sign(t *testing.T, claims jwt.MapClaims, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatalf("failed to sign id token: %v", err)
+	}
+	return signed
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	provider := issuer.provider()
+
+	authorizationURL, err := provider.AuthorizationURL(context.Background(), "state-1", testOIDCNonce, "verifier")
+	if err != nil {
+		t.Fatalf("AuthorizationURL failed: %v", err)
+	}
+	if !strings.HasPrefix(authorizationURL, issuer.server.URL+"/authorize?") {
+		t.Fatalf("authorization URL %q does not use the discovered endpoint", authorizationURL)
+	}
+
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testOIDCClientID,
+		"state":                 "state-1",
+		"nonce":                 testOIDCNonce,
+		"scope":                 "openid email",
+		"code_challenge_method": "S256",
+		"code_challenge":        "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	provider := NewOIDCProvider("stub", issuer.server.URL+"/other", testOIDCClientID, "secret", nil, "")
+
+	if _, err := provider.AuthorizationURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("expected discovery to fail for a different issuer")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	provider := issuer.provider()
+	issuer.idToken = issuer.sign(t, issuer.claims(), testOIDCKeyID)
+
+	identity, err := provider.Exchange(context.Background(), "good-code", "verifier", testOIDCNonce)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+
+	if issuer.tokenRequest.Get("code_verifier") != "verifier" || issuer.tokenRequest.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected token request %v", issuer.tokenRequest)
+	}
+	if identity.Provider != "stub" || identity.Subject != "subject-1" {
+		t.Errorf("identity = %+v, want stub/subject-1", identity)
+	}
+	if identity.Email != "person@example.com" || !identity.EmailVerified {
+		t.Errorf("email = %q verified %v, want normalized verified email", identity.Email, identity.EmailVerified)
+	}
+	if identity.GivenName != "Test" || identity.FamilyName != "Person" {
+		t.Errorf("name = %q %q, want Test Person", identity.GivenName, identity.FamilyName)
+	}
+
+	if _, err := provider.Exchange(context.Background(), "bad-code", "verifier", testOIDCNonce); err == nil {
+		t.Error("expected a rejected code to fail")
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		modify        func(jwt.MapClaims)
+		kid           string
+		token         func(claims jwt.MapClaims) string
+		wantErr       bool
+		emailVerified bool
+	}{
+		{name: "valid", emailVerified: true},
+		{name: "valid without kid", kid: "-", emailVerified: true},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: true},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: true},
+		{name: "authorized party is another client", modify: func(c jwt.MapClaims) { c["azp"] = "another-client" }, wantErr: true},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, wantErr: true},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{name: "expired within leeway", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, emailVerified: true},
+		{name: "wrong nonce", modify: func(c jwt.MapClaims) { c["nonce"] = "replayed" }, wantErr: true},
+		{name: "missing nonce", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: true},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+		{name: "unknown kid", kid: "rotated-away", wantErr: true},
+		{name: "unverified email", modify: func(c jwt.MapClaims) { c["email_verified"] = false }},
+		{name: "email verified missing", modify: func(c jwt.MapClaims) { delete(c, "email_verified") }},
+		{name: "email verified as string", modify: func(c jwt.MapClaims) { c["email_verified"] = "true" }, emailVerified: true},
+		{name: "email unverified as string", modify: func(c jwt.MapClaims) { c["email_verified"] = "false" }},
+		{
+			name: "signed by another key",
+			token: func(c jwt.MapClaims) string {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+				token.Header["kid"] = testOIDCKeyID
+				signed, _ := token.SignedString(otherKey)
+				return signed
+			},
+			wantErr: true,
+		},
+		{
+			name: "HMAC signed with the client secret",
+			token: func(c jwt.MapClaims) string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+				token.Header["kid"] = testOIDCKeyID
+				signed, _ := token.SignedString([]byte("secret"))
+				return signed
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsigned",
+			token: func(c jwt.MapClaims) string {
+				signed, _ := jwt.NewWithClaims(jwt.SigningMethodNone, c).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return signed
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := issuer.provider()
+			discovery, err := provider.discover(context.Background())
+			if err != nil {
+				t.Fatalf("discovery failed: %v", err)
+			}
+
+			claims := issuer.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			var rawToken string
+			switch {
+			case tt.token != nil:
+				rawToken = tt.token(claims)
+			case tt.kid == "-":
+				rawToken = issuer.sign(t, claims, "")
+			case tt.kid != "":
+				rawToken = issuer.sign(t, claims, tt.kid)
+			default:
+				rawToken = issuer.sign(t, claims, testOIDCKeyID)
+			}
+
+			identity, err := provider.verifyIDToken(context.Background(), discovery, rawToken, testOIDCNonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected verification to fail, got identity %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verification failed: %v", err)
+			}
+			if identity.EmailVerified != tt.emailVerified {
+				t.Errorf("EmailVerified = %v, want %v", identity.EmailVerified, tt.emailVerified)
+			}
+		})
+	}
+}
+
+func TestParseJSONWebKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		jwk     JSONWebKey
+		wantErr bool
+	}{
+		{name: "RSA", jwk: JSONWebKey{KeyType: "RSA", N: "AQAB", E: "AQAB"}},
+		{name: "RSA without modulus", jwk: JSONWebKey{KeyType: "RSA", E: "AQAB"}, wantErr: true},
+		{name: "EC P-256", jwk: JSONWebKey{KeyType: "EC", Curve: "P-256", X: "AQ", Y: "AQ"}},
+		{name: "EC unknown curve", jwk: JSONWebKey{KeyType: "EC", Curve: "secp256k1", X: "AQ", Y: "AQ"}, wantErr: true},
+		{name: "symmetric", jwk: JSONWebKey{KeyType: "oct"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSONWebKey(tt.jwk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseJSONWebKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	// playbackCompletionRatio is how far through a video counts as having finished it
+	playbackCompletionRatio = 0.95
+	// maxHeartbeatWatchedSeconds bounds the watch time a single heartbeat can add
+	maxHeartbeatWatchedSeconds = 120
+	// watchHistoryPausedTTL is how long a user's watch history setting is cached
+	watchHistoryPausedTTL = time.Minute
+)
+
+var (
+	// ErrUnsupportedMediaType is returned for media types other than videos and YouTube videos
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrMediaNotFound is returned when the video a heartbeat refers to does not exist
+	ErrMediaNotFound = errors.New("video not found")
+)
+
+// PlaybackHeartbeat is a player's periodic report of where the viewer is in a video.
+// WatchedSeconds is how long the video played since the previous heartbeat.
+type PlaybackHeartbeat struct {
+	MediaType       string
+	MediaID         string
+	PositionSeconds int
+	DurationSeconds int
+	WatchedSeconds  int
+	Ended           bool
+}
+
+type playbackKey struct {
+	userID    int
+	mediaType string
+	mediaID   string
+}
+
+type watchHistorySetting struct {
+	paused    bool
+	checkedAt time.Time
+}
+
+// PlaybackService tracks where users are in each video and passes watch time on to the
+// view counter. Heartbeats are coalesced in memory and written periodically, except
+// when a video is completed, which is written straight away and recorded as user
+// activity. Nothing is recorded for users who have paused their watch history.
+type PlaybackService struct {
+	db      *database.DB
+	youtube *YouTubeService
+	views   *ViewCounter
+
+	mu      sync.Mutex
+	pending map[playbackKey]*database.PlaybackProgress
+	paused  map[int]watchHistorySetting
+}
+
+// NewPlaybackService creates a playback tracking service
+func NewPlaybackService(db *database.DB, youtube *YouTubeService, views *ViewCounter) *PlaybackService {
+	return &PlaybackService{
+		db:      db,
+		youtube: youtube,
+		views:   views,
+		pending: make(map[playbackKey]*database.PlaybackProgress),
+		paused:  make(map[int]watchHistorySetting),
+	}
+}
+
+// Start periodically writes coalesced heartbeats
+func (s *PlaybackService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.Flush()
+		}
+	}()
+}
+
+// Heartbeat records a heartbeat and returns the viewer's progress through the video.
+// userID is zero for anonymous viewers, whose progress is not recorded. viewerKey
+// identifies the viewer for view counting; heartbeats without one are not counted.
+func (s *PlaybackService) Heartbeat(userID int, viewerKey string, hb PlaybackHeartbeat) (*database.PlaybackProgress, error) {
+	hb.MediaType = strings.ToLower(hb.MediaType)
+	key := playbackKey{userID: userID, mediaType: hb.MediaType, mediaID: hb.MediaID}
+
+	// Heartbeats for a video already being tracked skip the lookup. The view counter
+	// keeps the looked up duration, so views never depend on the duration the player reports.
+	var tracked bool
+	if viewerKey != "" {
+		tracked = s.views.Watching(viewerKey, hb.MediaType, hb.MediaID)
+	} else {
+		s.mu.Lock()
+		_, tracked = s.pending[key]
+		s.mu.Unlock()
+	}
+
+	duration := 0
+	if !tracked {
+		var err error
+		duration, err = s.lookupMedia(hb.MediaType, hb.MediaID)
+		if err != nil {
+			return nil, err
+		}
+		if hb.DurationSeconds <= 0 {
+			hb.DurationSeconds = duration
+		}
+	}
+
+	if viewerKey != "" {
+		s.views.Observe(viewerKey, hb.MediaType, hb.MediaID, clampSeconds(hb.WatchedSeconds, maxHeartbeatWatchedSeconds), duration)
+	}
+
+	record := userID != 0
+	if record {
+		paused, err := s.historyPaused(userID)
+		if err != nil {
+			return nil, err
+		}
+		record = !paused
+	}
+	if !record {
+		progress := &database.PlaybackProgress{UserID: userID, MediaType: hb.MediaType, MediaID: hb.MediaID}
+		applyHeartbeat(progress, hb)
+		return progress, nil
+	}
+
+	progress := s.merge(key, hb)
+	if !progress.Completed {
+		snapshot := *progress
+		return &snapshot, nil
+	}
+
+	// Completion is written through so it is never lost and only recorded once
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+
+	if err := s.save(progress); err != nil {
+		s.requeue(progress)
+		return nil, err
+	}
+	return progress, nil
+}
+
+// merge folds a heartbeat into the pending progress for a user and video
+func (s *PlaybackService) merge(key playbackKey, hb PlaybackHeartbeat) *database.PlaybackProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress, ok := s.pending[key]
+	if !ok {
+		progress = &database.PlaybackProgress{
+			UserID:    key.userID,
+			MediaType: key.mediaType,
+			MediaID:   key.mediaID,
+		}
+		s.pending[key] = progress
+	}
+
+	applyHeartbeat(progress, hb)
+	return progress
+}
+
+// applyHeartbeat updates progress with a heartbeat
+func applyHeartbeat(progress *database.PlaybackProgress, hb PlaybackHeartbeat) {
+	position := clampSeconds(hb.PositionSeconds, -1)
+	duration := clampSeconds(hb.DurationSeconds, -1)
+
+	progress.PositionSeconds = position
+	if duration > progress.DurationSeconds {
+		progress.DurationSeconds = duration
+	}
+	progress.WatchedSeconds += clampSeconds(hb.WatchedSeconds, maxHeartbeatWatchedSeconds)
+	progress.LastWatchedAt = time.Now()
+	progress.Completed = hb.Ended ||
+		(progress.DurationSeconds > 0 && float64(position) >= float64(progress.DurationSeconds)*playbackCompletionRatio)
+}
+
+// historyPaused reports whether a user has paused their watch history. The setting is
+// cached briefly, since it is checked on every heartbeat.
+func (s *PlaybackService) historyPaused(userID int) (bool, error) {
+	s.mu.Lock()
+	setting, ok := s.paused[userID]
+	s.mu.Unlock()
+	if ok && time.Since(setting.checkedAt) < watchHistoryPausedTTL {
+		return setting.paused, nil
+	}
+
+	paused, err := s.db.IsWatchHistoryPaused(userID)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.paused[userID] = watchHistorySetting{paused: paused, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return paused, nil
+}
+
+// Flush writes every coalesced heartbeat
+func (s *PlaybackService) Flush() {
+	s.flushWhere(func(playbackKey) bool { return true })
+}
+
+// FlushUser writes a user's coalesced heartbeats, so reads see their latest position
+func (s *PlaybackService) FlushUser(userID int) {
+	s.flushWhere(func(key playbackKey) bool { return key.userID == userID })
+}
+
+func (s *PlaybackService) flushWhere(match func(playbackKey) bool) {
+	s.mu.Lock()
+	var batch []*database.PlaybackProgress
+	for key, progress := range s.pending {
+		if match(key) {
+			batch = append(batch, progress)
+			delete(s.pending, key)
+		}
+	}
+	for userID, setting := range s.paused {
+		if time.Since(setting.checkedAt) >= watchHistoryPausedTTL {
+			delete(s.paused, userID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, progress := range batch {
+		if err := s.save(progress); err != nil {
+			log.Printf("Failed to save playback progress for user %d (%s %s): %v", progress.UserID, progress.MediaType, progress.MediaID, err)
+			s.requeue(progress)
+		}
+	}
+}
+
+// requeue puts back progress that could not be saved, keeping any newer heartbeat
+func (s *PlaybackService) requeue(progress *database.PlaybackProgress) {
+	key := playbackKey{userID: progress.UserID, mediaType: progress.MediaType, mediaID: progress.MediaID}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if newer, ok := s.pending[key]; ok {
+		newer.WatchedSeconds += progress.WatchedSeconds
+		return
+	}
+	s.pending[key] = progress
+}
+
+// save writes progress and records the first completion of a viewing as user activity
+func (s *PlaybackService) save(progress *database.PlaybackProgress) error {
+	newlyCompleted, err := s.db.SavePlaybackProgress(progress)
+	if err != nil {
+		return err
+	}
+	if !newlyCompleted {
+		return nil
+	}
+
+	var videoID *int
+	if progress.MediaType == database.PlaybackMediaVideo {
+		if id, err := strconv.Atoi(progress.MediaID); err == nil {
+			videoID = &id
+		}
+	}
+	if err := s.db.RecordUserActivity(progress.UserID, "video_completed", videoID, map[string]interface{}{
+		"media_type":       progress.MediaType,
+		"media_id":         progress.MediaID,
+		"duration_seconds": progress.DurationSeconds,
+	}); err != nil {
+		log.Printf("Failed to record completion of %s %s by user %d: %v", progress.MediaType, progress.MediaID, progress.UserID, err)
+	}
+	return nil
+}
+
+// lookupMedia checks that a video exists and returns its duration in seconds, when known
+func (s *PlaybackService) lookupMedia(mediaType, mediaID string) (int, error) {
+	switch mediaType {
+	case database.PlaybackMediaVideo:
+		id, err := strconv.Atoi(mediaID)
+		if err != nil || id <= 0 {
+			return 0, ErrMediaNotFound
+		}
+		video, err := s.db.GetVideoByID(id)
+		if err == sql.ErrNoRows {
+			return 0, ErrMediaNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+		return video.Duration, nil
+	case database.PlaybackMediaYouTube:
+		if mediaID == "" {
+			return 0, ErrMediaNotFound
+		}
+		video, err := s.youtube.GetVideoByID(mediaID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				return 0, ErrMediaNotFound
+			}
+			return 0, err
+		}
+		return youTubeDurationSeconds(video.Duration), nil
+	}
+	return 0, ErrUnsupportedMediaType
+}
+
+// youTubeDurationSeconds converts a YouTube duration, either clock style ("1:02:03",
+// "15:42") or ISO 8601 ("PT1H2M3S"), to seconds. Unrecognised durations are zero.
+func youTubeDurationSeconds(duration string) int {
+	duration = strings.TrimSpace(duration)
+	if rest, ok := strings.CutPrefix(strings.ToUpper(duration), "PT"); ok {
+		total := 0
+		for _, unit := range []struct {
+			suffix  string
+			seconds int
+		}{{"H", 3600}, {"M", 60}, {"S", 1}} {
+			value, after, found := strings.Cut(rest, unit.suffix)
+			if !found {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0
+			}
+			total += n * unit.seconds
+			rest = after
+		}
+		if rest != "" {
+			return 0
+		}
+		return total
+	}
+
+	total := 0
+	for _, part := range strings.Split(duration, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
+
+// clampSeconds bounds a reported number of seconds to zero and, when max is not
+// negative, to max
+func clampSeconds(seconds, max int) int {
+	if seconds < 0 {
+		return 0
+	}
+	if max >= 0 && seconds > max {
+		return max
+	}
+	return seconds
+}
+
+// ContinueWatching lists the videos a user has started but not finished, most recently
+// watched first, with titles and thumbnails filled in for YouTube videos
+func (s *PlaybackService) ContinueWatching(userID, limit, offset int) ([]*database.WatchHistoryItem, error) {
+	s.FlushUser(userID)
+
+	items, err := s.db.GetContinueWatching(userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	s.fillYouTubeDetails(items)
+	return items, nil
+}
+
+// History lists every video a user has watched, most recently watched first
+func (s *PlaybackService) History(userID, limit, offset int) ([]*database.WatchHistoryItem, error) {
+	s.FlushUser(userID)
+
+	items, err := s.db.GetWatchHistory(userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	s.fillYouTubeDetails(items)
+	return items, nil
+}
+
+func (s *PlaybackService) fillYouTubeDetails(items []*database.WatchHistoryItem) {
+	for _, item := range items {
+		if item.MediaType != database.PlaybackMediaYouTube {
+			continue
+		}
+		if video, err := s.youtube.GetVideoByID(item.MediaID); err == nil {
+			item.Title = video.Title
+			item.ThumbnailURL = video.ThumbnailURL
+		}
+	}
+}
+
+// ClearHistory removes all of a user's watch history, including heartbeats not yet written
+func (s *PlaybackService) ClearHistory(userID int) (int64, error) {
+	s.discardWhere(func(key playbackKey) bool { return key.userID == userID })
+	return s.db.ClearWatchHistory(userID)
+}
+
+// DeleteHistoryItem removes one video from a user's watch history
+func (s *PlaybackService) DeleteHistoryItem(userID int, mediaType, mediaID string) error {
+	mediaType = strings.ToLower(mediaType)
+	discarded := s.discardWhere(func(key playbackKey) bool {
+		return key == playbackKey{userID: userID, mediaType: mediaType, mediaID: mediaID}
+	})
+
+	err := s.db.DeleteWatchHistoryItem(userID, mediaType, mediaID)
+	if err == sql.ErrNoRows && discarded > 0 {
+		return nil
+	}
+	return err
+}
+
+// discardWhere drops coalesced heartbeats without writing them
+func (s *PlaybackService) discardWhere(match func(playbackKey) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	discarded := 0
+	for key := range s.pending {
+		if match(key) {
+			delete(s.pending, key)
+			discarded++
+		}
+	}
+	return discarded
+}
+
+// HistoryPaused reports whether a user has paused their watch history
+func (s *PlaybackService) HistoryPaused(userID int) (bool, error) {
+	return s.historyPaused(userID)
+}
+
+// SetHistoryPaused pauses or resumes a user's watch history. Heartbeats received
+// before pausing are still written.
+func (s *PlaybackService) SetHistoryPaused(userID int, paused bool) error {
+	if paused {
+		s.FlushUser(userID)
+	}
+	if err := s.db.SetWatchHistoryPaused(userID, paused); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.paused[userID] = watchHistorySetting{paused: paused, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return nil
+}
+
+// Progress returns a user's progress through a video, including heartbeats not yet written
+func (s *PlaybackService) Progress(userID int, mediaType, mediaID string) (*database.PlaybackProgress, error) {
+	s.FlushUser(userID)
+	return s.db.GetPlaybackProgress(userID, strings.ToLower(mediaType), mediaID)
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	// DataExportLinkLifetime is how long the emailed download link for an export works.
+	// Signed URLs cannot be valid for longer than seven days.
+	DataExportLinkLifetime = 7 * 24 * time.Hour
+
+	defaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+	// dataExportStaleAfter is how long an export can be processing before it is
+	// considered interrupted
+	dataExportStaleAfter = 30 * time.Minute
+	// dataExportMaxAttempts is how many times an interrupted export is tried
+	dataExportMaxAttempts = 3
+)
+
+// ErrDataExportsUnavailable is returned when exports cannot be stored
+var ErrDataExportsUnavailable = errors.New("data exports are not available")
+
+// AccountDeletionGracePeriod returns how long a scheduled account deletion can be
+// cancelled, read from ACCOUNT_DELETION_GRACE_PERIOD
+func AccountDeletionGracePeriod() time.Duration {
+	period, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"))
+	if err != nil || period < 0 {
+		return defaultAccountDeletionGracePeriod
+	}
+	return period
+}
+
+// PrivacyService builds personal data exports and erases accounts
+type PrivacyService struct {
+	db     *database.DB
+	spaces *SpacesService
+	stripe *StripeService
+	email  *EmailService
+}
+
+// NewPrivacyService creates a privacy service. Without Spaces, exports are unavailable;
+// without Stripe, subscriptions are not cancelled when an account is erased.
+func NewPrivacyService(db *database.DB, spaces *SpacesService, stripe *StripeService, email *EmailService) *PrivacyService {
+	return &PrivacyService{
+		db:     db,
+		spaces: spaces,
+		stripe: stripe,
+		email:  email,
+	}
+}
+
+// ExportsAvailable reports whether data exports can be built and stored
+func (p *PrivacyService) ExportsAvailable() bool {
+	return p != nil && p.db != nil && p.spaces != nil
+}
+
+// Start periodically builds queued exports, removes expired archives and erases
+// accounts whose grace period has ended. Exports that were interrupted, for example by
+// a restart, are queued again.
+func (p *PrivacyService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := p.db.RequeueStaleDataExports(dataExportStaleAfter, dataExportMaxAttempts); err != nil {
+				log.Printf("Failed to requeue interrupted data exports: %v", err)
+			}
+			p.ProcessPendingExports()
+			p.CleanupExpiredExports()
+			p.ProcessDueDeletions()
+		}
+	}()
+}
+
+// ProcessPendingExports builds every queued export. It is safe to run concurrently.
+func (p *PrivacyService) ProcessPendingExports() {
+	if !p.ExportsAvailable() {
+		return
+	}
+
+	for {
+		export, err := p.db.ClaimPendingDataExport()
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim data export: %v", err)
+			return
+		}
+
+		if err := p.buildDataExport(export); err != nil {
+			log.Printf("Data export %d for user %d failed: %v", export.ID, export.UserID, err)
+			if err := p.db.FailDataExport(export.ID, err.Error()); err != nil {
+				log.Printf("Failed to mark data export %d as failed: %v", export.ID, err)
+			}
+		}
+	}
+}
+
+// buildDataExport writes the user's data to a zip of JSON files, stores it privately
+// in Spaces and emails a signed download link
+func (p *PrivacyService) buildDataExport(export *database.DataExport) error {
+	user, err := p.db.GetUserByID(export.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	sections, err := p.db.ExportUserData(export.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := zipDataExport(sections)
+	if err != nil {
+		return fmt.Errorf("failed to build archive: %w", err)
+	}
+
+	key := fmt.Sprintf("exports/%d/%d-%s.zip", export.UserID, export.ID, GenerateRandomToken(16))
+	if err := p.spaces.UploadPrivateObject(key, archive, "application/zip"); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(DataExportLinkLifetime)
+	downloadURL, err := p.spaces.GetSignedURL(key, DataExportLinkLifetime)
+	if err != nil {
+		return err
+	}
+
+	if err := p.db.CompleteDataExport(export.ID, key, expiresAt); err != nil {
+		return err
+	}
+
+	if p.email != nil {
+		if err := p.email.SendDataExportEmail(user.FirstName, user.Email, downloadURL, expiresAt); err != nil {
+			log.Printf("Failed to send data export email to user %d: %v", user.ID, err)
+		}
+	}
+
+	log.Printf("Data export %d for user %d completed", export.ID, export.UserID)
+	return nil
+}
+
+// zipDataExport packs each section into its own JSON file
+func zipDataExport(sections map[string]json.RawMessage) ([]byte, error) {
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, name := range names {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, sections[name], "", "  "); err != nil {
+			return nil, err
+		}
+
+		file, err := writer.Create(name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(indented.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ExportDownloadURL returns a fresh signed link for a completed, unexpired export
+func (p *PrivacyService) ExportDownloadURL(export *database.DataExport) (string, error) {
+	if !p.ExportsAvailable() {
+		return "", ErrDataExportsUnavailable
+	}
+	if export.Status != "completed" || export.FileKey == "" || !export.ExpiresAt.Valid {
+		return "", errors.New("export is not ready")
+	}
+
+	remaining := time.Until(export.ExpiresAt.Time)
+	if remaining <= 0 {
+		return "", errors.New("export has expired")
+	}
+	return p.spaces.GetSignedURL(export.FileKey, remaining)
+}
+
+// CleanupExpiredExports removes archives whose download link has expired
+func (p *PrivacyService) CleanupExpiredExports() {
+	if !p.ExportsAvailable() {
+		return
+	}
+
+	exports, err := p.db.GetExpiredDataExports()
+	if err != nil {
+		log.Printf("Failed to get expired data exports: %v", err)
+		return
+	}
+
+	for _, export := range exports {
+		if err := p.spaces.DeleteFile(export.FileKey); err != nil {
+			log.Printf("Failed to delete data export %d: %v", export.ID, err)
+			continue
+		}
+		if err := p.db.ExpireDataExport(export.ID); err != nil {
+			log.Printf("Failed to mark data export %d as expired: %v", export.ID, err)
+		}
+	}
+}
+
+// ProcessDueDeletions erases accounts whose deletion grace period has ended
+func (p *PrivacyService) ProcessDueDeletions() {
+	if p.db == nil {
+		return
+	}
+
+	userIDs, err := p.db.GetDueAccountDeletions()
+	if err != nil {
+		log.Printf("Failed to get due account deletions: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		anonymized, err := p.EraseAccount(userID)
+		if err != nil {
+			log.Printf("Failed to erase account %d: %v", userID, err)
+			continue
+		}
+
+		log.Printf("Erased account %d after its deletion grace period (anonymized: %t)", userID, anonymized)
+	}
+}
+
+// EraseAccount cancels the user's subscription renewal, removes their export archives,
+// revokes their tokens and erases their data. It reports whether the account was
+// anonymized rather than deleted because billing records refer to it.
+func (p *PrivacyService) EraseAccount(userID int) (bool, error) {
+	if p.db == nil {
+		return false, errors.New("database not available")
+	}
+
+	if p.stripe != nil {
+		if sub, err := p.db.GetSubscriptionByUserID(userID); err == nil && sub.Status == "active" {
+			if err := p.stripe.CancelSubscription(sub.StripeSubscriptionID, true); err != nil {
+				return false, fmt.Errorf("failed to cancel subscription: %w", err)
+			}
+		}
+	}
+
+	if p.spaces != nil {
+		keys, err := p.db.GetUserDataExportFiles(userID)
+		if err != nil {
+			return false, err
+		}
+		for _, key := range keys {
+			if err := p.spaces.DeleteFile(key); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	anonymized, err := p.db.DeleteUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := RevokeAllUserTokens(userID); err != nil {
+		log.Printf("Failed to revoke tokens for erased user %d: %v", userID, err)
+	}
+
+	return anonymized, nil
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	// relatedVideosPerVideo is how many related videos are kept for each video
+	relatedVideosPerVideo = 20
+	// recommendationSeedVideos is how many recently watched videos personal
+	// recommendations are drawn from
+	recommendationSeedVideos = 20
+	// coWatchWindow is how far back engagement counts as a co-watch signal
+	coWatchWindow = 180 * 24 * time.Hour
+	// minCoWatchers is how many users must share two videos before it counts, so a
+	// single viewer's habits do not relate videos
+	minCoWatchers = 2
+	// recencyHalfLife is the age at which a video's recency score halves
+	recencyHalfLife = 90 * 24 * time.Hour
+	// newVideoAge is how recent a video must be to be explained as new
+	newVideoAge = 30 * 24 * time.Hour
+
+	tagWeight      = 0.35
+	categoryWeight = 0.2
+	coWatchWeight  = 0.35
+	recencyWeight  = 0.1
+)
+
+// coWatchActivityTypes are the user activities that show a user engaged with a video.
+// Likes are read from the likes table instead, so unliked videos do not count.
+var coWatchActivityTypes = []string{"video_completed", "video_favorited", "comment_added"}
+
+// genericVideoTags are tags applied to every imported video, which say nothing about
+// how videos are related
+var genericVideoTags = map[string]bool{"bunny": true, "streaming": true, "mp4": true, "public": true}
+
+// SubscriptionTier returns the tier of videos a user can play. Anonymous users are on
+// the free tier, administrators can play everything, and subscribers to the yearly
+// plan are premium while other active subscribers are basic.
+func SubscriptionTier(db *database.DB, userID int, role string) (string, error) {
+	if userID == 0 {
+		return database.TierFree, nil
+	}
+	if IsAdminRole(role) {
+		return database.TierPremium, nil
+	}
+
+	subscription, err := db.GetSubscriptionByUserID(userID)
+	if err == sql.ErrNoRows {
+		return database.TierFree, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if yearly := os.Getenv("STRIPE_PRICE_ID_YEARLY"); yearly != "" && subscription.StripePriceID == yearly {
+		return database.TierPremium, nil
+	}
+	return database.TierBasic, nil
+}
+
+// PlayableTiers lists the video tiers a subscription tier can play
+func PlayableTiers(tier string) []string {
+	switch tier {
+	case database.TierPremium:
+		return []string{database.TierFree, database.TierBasic, database.TierPremium}
+	case database.TierBasic:
+		return []string{database.TierFree, database.TierBasic}
+	default:
+		return []string{database.TierFree}
+	}
+}
+
+// RecommendationService recommends related videos. Related videos are precomputed on a
+// schedule by combining tag and category similarity, how many users engaged with both
+// videos, and how recent each video is. The signals behind each score are kept so
+// recommendations can be explained.
+type RecommendationService struct {
+	db *database.DB
+
+	// mu stops a scheduled run and a manual refresh computing at the same time
+	mu sync.Mutex
+}
+
+// NewRecommendationService creates a recommendation service
+func NewRecommendationService(db *database.DB) *RecommendationService {
+	return &RecommendationService{db: db}
+}
+
+// Start computes related videos now and then periodically
+func (s *RecommendationService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Refresh(); err != nil {
+				log.Printf("Failed to compute related videos: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Refresh recomputes every video's related videos
+func (s *RecommendationService) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	started := time.Now()
+	candidates, err := s.db.GetRecommendationCandidates()
+	if err != nil {
+		return err
+	}
+	coWatch, err := s.db.GetCoWatchCounts(coWatchActivityTypes, time.Now().Add(-coWatchWindow))
+	if err != nil {
+		return err
+	}
+
+	scores := scoreRelatedVideos(candidates, coWatch, time.Now())
+	if err := s.db.ReplaceVideoRecommendations(scores); err != nil {
+		return err
+	}
+
+	log.Printf("Computed %d related videos for %d videos in %s", len(scores), len(candidates), time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// Related returns the videos related to a video that the tier can play, leaving out
+// videos the user has finished. If the video is in a published series, the next video
+// in the series is returned as up next; otherwise up next is the best related video.
+func (s *RecommendationService) Related(videoID, userID int, tier string, limit int) (upNext *database.RelatedVideo, related []*database.RelatedVideo, err error) {
+	tiers := PlayableTiers(tier)
+
+	related, err = s.db.GetRelatedVideos(videoID, userID, tiers, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, video := range related {
+		explainRelatedVideo(video, false)
+	}
+
+	next, seriesTitle, err := s.db.GetNextInSeries(videoID, tiers)
+	switch {
+	case err == nil:
+		next.Reasons = []string{fmt.Sprintf("Next in %s", seriesTitle)}
+		upNext = next
+	case err != sql.ErrNoRows:
+		return nil, nil, err
+	case len(related) > 0:
+		upNext = related[0]
+	}
+
+	return upNext, related, nil
+}
+
+// ForUser recommends videos related to what a user watched recently that the tier can
+// play, each explained by the watched video it is most related to
+func (s *RecommendationService) ForUser(userID int, tier string, limit int) ([]*database.RelatedVideo, error) {
+	videos, err := s.db.GetRecommendedVideosForUser(userID, PlayableTiers(tier), recommendationSeedVideos, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, video := range videos {
+		explainRelatedVideo(video, true)
+	}
+	return videos, nil
+}
+
+// explainRelatedVideo describes the signals that related a video to its source. A
+// personal recommendation is also explained by the video the user watched.
+func explainRelatedVideo(video *database.RelatedVideo, personal bool) {
+	if personal {
+		video.Because = fmt.Sprintf("Because you watched %s", video.SourceTitle)
+	}
+
+	reasons := []string{}
+	if video.CoWatchScore > 0 {
+		reasons = append(reasons, fmt.Sprintf("Viewers who watched %s also watched this", video.SourceTitle))
+	}
+	if len(video.SharedTags) > 0 {
+		reasons = append(reasons, fmt.Sprintf("Also about %s", strings.Join(video.SharedTags, ", ")))
+	}
+	if video.CategoryScore > 0 && video.Category != "" {
+		reasons = append(reasons, fmt.Sprintf("More in %s", video.Category))
+	}
+	if time.Since(video.CreatedAt) < newVideoAge {
+		reasons = append(reasons, "Recently added")
+	}
+	video.Reasons = reasons
+}
+
+type recommendationProfile struct {
+	candidate *database.RecommendationCandidate
+	category  string
+	tags      map[string]string
+}
+
+// scoreRelatedVideos scores every pair of candidates and keeps the best related videos
+// for each. Videos only related by recency are not related at all.
+func scoreRelatedVideos(candidates []*database.RecommendationCandidate, coWatch *database.CoWatchCounts, now time.Time) []database.VideoRecommendationScore {
+	profiles := make([]recommendationProfile, len(candidates))
+	for i, candidate := range candidates {
+		tags := make(map[string]string)
+		for _, tag := range candidate.Tags {
+			key := strings.ToLower(strings.TrimSpace(tag))
+			if key != "" && !genericVideoTags[key] {
+				tags[key] = strings.TrimSpace(tag)
+			}
+		}
+		category := strings.ToLower(strings.TrimSpace(candidate.Category))
+		if category == "general" {
+			category = ""
+		}
+		profiles[i] = recommendationProfile{candidate: candidate, category: category, tags: tags}
+	}
+
+	var scores []database.VideoRecommendationScore
+	for _, source := range profiles {
+		var neighbours []database.VideoRecommendationScore
+		for _, target := range profiles {
+			if source.candidate.ID == target.candidate.ID {
+				continue
+			}
+
+			score := database.VideoRecommendationScore{VideoID: source.candidate.ID, RelatedVideoID: target.candidate.ID}
+			score.TagScore, score.SharedTags = tagSimilarity(source.tags, target.tags)
+			if source.category != "" && source.category == target.category {
+				score.CategoryScore = 1
+			}
+			score.CoWatchers, score.CoWatchScore = coWatchSimilarity(coWatch, source.candidate.ID, target.candidate.ID)
+			if score.TagScore == 0 && score.CategoryScore == 0 && score.CoWatchScore == 0 {
+				continue
+			}
+
+			age := now.Sub(target.candidate.CreatedAt)
+			if age < 0 {
+				age = 0
+			}
+			score.RecencyScore = math.Exp2(-float64(age) / float64(recencyHalfLife))
+			score.Score = tagWeight*score.TagScore + categoryWeight*score.CategoryScore +
+				coWatchWeight*score.CoWatchScore + recencyWeight*score.RecencyScore
+			neighbours = append(neighbours, score)
+		}
+
+		sort.Slice(neighbours, func(i, j int) bool {
+			if neighbours[i].Score != neighbours[j].Score {
+				return neighbours[i].Score > neighbours[j].Score
+			}
+			return neighbours[i].RelatedVideoID > neighbours[j].RelatedVideoID
+		})
+		if len(neighbours) > relatedVideosPerVideo {
+			neighbours = neighbours[:relatedVideosPerVideo]
+		}
+		scores = append(scores, neighbours...)
+	}
+	return scores
+}
+
+// tagSimilarity is the Jaccard similarity of two tag sets, with the shared tags
+func tagSimilarity(a, b map[string]string) (float64, []string) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, nil
+	}
+
+	var shared []string
+	for key, tag := range a {
+		if _, ok := b[key]; ok {
+			shared = append(shared, tag)
+		}
+	}
+	if len(shared) == 0 {
+		return 0, nil
+	}
+	sort.Strings(shared)
+	return float64(len(shared)) / float64(len(a)+len(b)-len(shared)), shared
+}
+
+// coWatchSimilarity is the cosine similarity of the users who engaged with two videos,
+// with how many users engaged with both
+func coWatchSimilarity(coWatch *database.CoWatchCounts, a, b int) (int, float64) {
+	pair := database.VideoPair{A: a, B: b}
+	if b < a {
+		pair = database.VideoPair{A: b, B: a}
+	}
+	shared := coWatch.Pairs[pair]
+	if shared < minCoWatchers {
+		return 0, 0
+	}
+	return shared, float64(shared) / math.Sqrt(float64(coWatch.Viewers[a]*coWatch.Viewers[b]))
+}
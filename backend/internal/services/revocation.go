@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxTokenLifetime is the longest lifetime of any issued token (the refresh token).
+// Revocation watermarks older than this can no longer affect a valid token.
+const maxTokenLifetime = 7 * 24 * time.Hour
+
+// RevocationStore persists revoked token IDs and per-user revocation watermarks.
+// Implementations backed by shared storage let every replica see the same revocations.
+type RevocationStore interface {
+	// RevokeToken revokes a single token ID until its expiry
+	RevokeToken(tokenID string, expiresAt time.Time) error
+	// IsTokenRevoked reports whether a token ID has been revoked
+	IsTokenRevoked(tokenID string) (bool, error)
+	// RevokeUserTokensBefore revokes every token issued to the user up to and including
+	// the given time. The watermark never moves backwards.
+	RevokeUserTokensBefore(userID int, before time.Time) error
+	// UserTokensRevokedBefore returns the user's revocation watermark, or the zero time
+	UserTokensRevokedBefore(userID int) (time.Time, error)
+	// CleanupExpired removes revocations that can no longer match a valid token
+	CleanupExpired() error
+}
+
+// Active revocation store, in-memory until SetRevocationStore is called
+var (
+	revocationStore      RevocationStore = NewMemoryRevocationStore()
+	revocationStoreMutex sync.RWMutex
+)
+
+// SetRevocationStore replaces the revocation backend used by token parsing and revocation
+func SetRevocationStore(store RevocationStore) {
+	revocationStoreMutex.Lock()
+	defer revocationStoreMutex.Unlock()
+	revocationStore = store
+}
+
+// getRevocationStore returns the active revocation backend
+func getRevocationStore() RevocationStore {
+	revocationStoreMutex.RLock()
+	defer revocationStoreMutex.RUnlock()
+	return revocationStore
+}
+
+// isClaimsRevoked checks both the token ID and the user's revocation watermark.
+// Errors are returned so callers fail closed when the backend is unavailable.
+func isClaimsRevoked(claims *Claims) (bool, error) {
+	store := getRevocationStore()
+
+	if claims.TokenID != "" {
+		revoked, err := store.IsTokenRevoked(claims.TokenID)
+		if err != nil {
+			return false, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+		if revoked {
+			return true, nil
+		}
+	}
+
+	revokedBefore, err := store.UserTokensRevokedBefore(claims.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token watermark: %w", err)
+	}
+	if !revokedBefore.IsZero() && claims.IssuedAt != nil && !claims.IssuedAt.Time.After(revokedBefore) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// RevokeTokenID revokes a token by ID until the given expiry
+func RevokeTokenID(tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return errors.New("token ID is required")
+	}
+	return getRevocationStore().RevokeToken(tokenID, expiresAt)
+}
+
+// RevokeAllUserTokens revokes every token issued to the user up to now,
+// for example after a password change or a "log out everywhere" request
+func RevokeAllUserTokens(userID int) error {
+	// JWT issued-at times have second precision, so every token issued in the current
+	// second is revoked. Tokens issued afterwards get a later issued-at time from
+	// tokenIssuedAt.
+	return getRevocationStore().RevokeUserTokensBefore(userID, time.Now().Truncate(time.Second))
+}
+
+// tokenIssuedAt returns the issued-at time for a new token of the user. A token issued
+// in the same second as a revocation would be revoked by it, so it is dated the
+// following second instead.
+func tokenIssuedAt(userID int, now time.Time) time.Time {
+	revokedBefore, err := getRevocationStore().UserTokensRevokedBefore(userID)
+	if err != nil {
+		log.Printf("Failed to check token watermark for user %d: %v", userID, err)
+		return now
+	}
+	if issuedAt := now.Truncate(time.Second); !issuedAt.After(revokedBefore) {
+		return revokedBefore.Truncate(time.Second).Add(time.Second)
+	}
+	return now
+}
+
+// TokenBlacklist is the in-memory revocation store. It is process-local, so it
+// is only suitable for development or single-instance deployments.
+type TokenBlacklist struct {
+	tokens     map[string]time.Time
+	watermarks map[int]time.Time
+	mutex      sync.RWMutex
+}
+
+// NewMemoryRevocationStore creates an in-memory revocation store
+func NewMemoryRevocationStore() *TokenBlacklist {
+	return &TokenBlacklist{
+		tokens:     make(map[string]time.Time),
+		watermarks: make(map[int]time.Time),
+	}
+}
+
+// BlacklistToken adds a token to the blacklist
+func (tb *TokenBlacklist) BlacklistToken(tokenID string, expiry time.Time) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	tb.tokens[tokenID] = expiry
+}
+
+// IsBlacklisted checks if a token is blacklisted
+func (tb *TokenBlacklist) IsBlacklisted(tokenID string) bool {
+	tb.mutex.RLock()
+	defer tb.mutex.RUnlock()
+	expiry, exists := tb.tokens[tokenID]
+	return exists && time.Now().Before(expiry)
+}
+
+// CleanupExpiredTokens removes expired tokens from blacklist
+func (tb *TokenBlacklist) CleanupExpiredTokens() {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	now := time.Now()
+	for tokenID, expiry := range tb.tokens {
+		if now.After(expiry) {
+			delete(tb.tokens, tokenID)
+		}
+	}
+
+	cutoff := now.Add(-maxTokenLifetime)
+	for userID, watermark := range tb.watermarks {
+		if watermark.Before(cutoff) {
+			delete(tb.watermarks, userID)
+		}
+	}
+}
+
+// GetBlacklistSize returns the number of blacklisted tokens
+func (tb *TokenBlacklist) GetBlacklistSize() int {
+	tb.mutex.RLock()
+	defer tb.mutex.RUnlock()
+	return len(tb.tokens)
+}
+
+// RevokeToken implements RevocationStore
+func (tb *TokenBlacklist) RevokeToken(tokenID string, expiresAt time.Time) error {
+	tb.BlacklistToken(tokenID, expiresAt)
+	return nil
+}
+
+// IsTokenRevoked implements RevocationStore
+func (tb *TokenBlacklist) IsTokenRevoked(tokenID string) (bool, error) {
+	return tb.IsBlacklisted(tokenID), nil
+}
+
+// RevokeUserTokensBefore implements RevocationStore
+func (tb *TokenBlacklist) RevokeUserTokensBefore(userID int, before time.Time) error {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	if before.After(tb.watermarks[userID]) {
+		tb.watermarks[userID] = before
+	}
+	return nil
+}
+
+// UserTokensRevokedBefore implements RevocationStore
+func (tb *TokenBlacklist) UserTokensRevokedBefore(userID int) (time.Time, error) {
+	tb.mutex.RLock()
+	defer tb.mutex.RUnlock()
+	return tb.watermarks[userID], nil
+}
+
+// CleanupExpired implements RevocationStore
+func (tb *TokenBlacklist) CleanupExpired() error {
+	tb.CleanupExpiredTokens()
+	return nil
+}
+
+// PostgresRevocationStore keeps revocations in the revoked_tokens and
+// user_token_watermarks tables so they survive restarts and are shared by replicas
+type PostgresRevocationStore struct {
+	db *database.DB
+}
+
+// NewPostgresRevocationStore creates a Postgres-backed revocation store
+func NewPostgresRevocationStore(db *database.DB) *PostgresRevocationStore {
+	return &PostgresRevocationStore{db: db}
+}
+
+// RevokeToken implements RevocationStore
+func (s *PostgresRevocationStore) RevokeToken(tokenID string, expiresAt time.Time) error {
+	return s.db.RevokeToken(tokenID, expiresAt)
+}
+
+// IsTokenRevoked implements RevocationStore
+func (s *PostgresRevocationStore) IsTokenRevoked(tokenID string) (bool, error) {
+	return s.db.IsTokenRevoked(tokenID)
+}
+
+// RevokeUserTokensBefore implements RevocationStore
+func (s *PostgresRevocationStore) RevokeUserTokensBefore(userID int, before time.Time) error {
+	return s.db.SetUserTokenWatermark(userID, before)
+}
+
+// UserTokensRevokedBefore implements RevocationStore
+func (s *PostgresRevocationStore) UserTokensRevokedBefore(userID int) (time.Time, error) {
+	return s.db.GetUserTokenWatermark(userID)
+}
+
+// CleanupExpired implements RevocationStore
+func (s *PostgresRevocationStore) CleanupExpired() error {
+	return s.db.CleanupRevokedTokens(maxTokenLifetime)
+}
+
+// RedisRevocationStore keeps revocations in Redis keys that expire on their own
+type RedisRevocationStore struct {
+	redis   *database.Redis
+	timeout time.Duration
+}
+
+// NewRedisRevocationStore creates a Redis-backed revocation store
+func NewRedisRevocationStore(r *database.Redis) *RedisRevocationStore {
+	return &RedisRevocationStore{redis: r, timeout: 2 * time.Second}
+}
+
+func redisRevokedTokenKey(tokenID string) string {
+	return "auth:revoked_token:" + tokenID
+}
+
+func redisWatermarkKey(userID int) string {
+	return fmt.Sprintf("auth:token_watermark:%d", userID)
+}
+
+// RevokeToken implements RevocationStore
+func (s *RedisRevocationStore) RevokeToken(tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.redis.Set(ctx, redisRevokedTokenKey(tokenID), expiresAt.Unix(), ttl).Err()
+}
+
+// IsTokenRevoked implements RevocationStore
+func (s *RedisRevocationStore) IsTokenRevoked(tokenID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	count, err := s.redis.Exists(ctx, redisRevokedTokenKey(tokenID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// redisRaiseWatermark sets the watermark in KEYS[1] to ARGV[1] unless it is already
+// later, so concurrent revocations never move it backwards
+var redisRaiseWatermark = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+end
+return 1
+`)
+
+// RevokeUserTokensBefore implements RevocationStore
+func (s *RedisRevocationStore) RevokeUserTokensBefore(userID int, before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return redisRaiseWatermark.Run(ctx, s.redis, []string{redisWatermarkKey(userID)}, before.Unix(), int64(maxTokenLifetime.Seconds())).Err()
+}
+
+// UserTokensRevokedBefore implements RevocationStore
+func (s *RedisRevocationStore) UserTokensRevokedBefore(userID int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	value, err := s.redis.Get(ctx, redisWatermarkKey(userID)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid token watermark for user %d: %w", userID, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// CleanupExpired implements RevocationStore. Redis expires keys by TTL, so there is nothing to do.
+func (s *RedisRevocationStore) CleanupExpired() error {
+	return nil
+}
+
+// checkRevocation returns an error if the claims have been revoked or if the
+// revocation backend cannot be reached, so token parsing fails closed
+func checkRevocation(claims *Claims) error {
+	revoked, err := isClaimsRevoked(claims)
+	if err != nil {
+		log.Printf("Token revocation check failed: %v", err)
+		return errors.New("unable to verify token revocation status")
+	}
+	if revoked {
+		return errors.New("token has been revoked")
+	}
+	return nil
+}
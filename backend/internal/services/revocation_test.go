@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevokeAllUserTokens(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	SetRevocationStore(NewMemoryRevocationStore())
+	t.Cleanup(func() { SetRevocationStore(NewMemoryRevocationStore()) })
+
+	before, err := GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+	other, err := GenerateTokenPair(8, "other@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+
+	if err := RevokeAllUserTokens(7); err != nil {
+		t.Fatalf("RevokeAllUserTokens failed: %v", err)
+	}
+	// Issued in the same second as the revocation
+	after, err := GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+
+	if _, err := ParseToken(before.AccessToken); err == nil {
+		t.Error("an access token issued before the revocation is still valid")
+	}
+	if _, err := ParseRefreshToken(before.RefreshToken); err == nil {
+		t.Error("a refresh token issued before the revocation is still valid")
+	}
+	if _, err := ParseToken(after.AccessToken); err != nil {
+		t.Errorf("an access token issued after the revocation was rejected: %v", err)
+	}
+	if _, err := ParseToken(other.AccessToken); err != nil {
+		t.Errorf("another user's token was revoked: %v", err)
+	}
+}
+
+func TestTokenIssuedAt(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	SetRevocationStore(store)
+	t.Cleanup(func() { SetRevocationStore(NewMemoryRevocationStore()) })
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 400*int(time.Millisecond), time.UTC)
+	if got := tokenIssuedAt(7, now); !got.Equal(now) {
+		t.Errorf("without a watermark, issued at = %s, want %s", got, now)
+	}
+
+	store.RevokeUserTokensBefore(7, now.Truncate(time.Second))
+	if got, want := tokenIssuedAt(7, now), now.Truncate(time.Second).Add(time.Second); !got.Equal(want) {
+		t.Errorf("in the revoked second, issued at = %s, want %s", got, want)
+	}
+	if later := now.Add(time.Second); !tokenIssuedAt(7, later).Equal(later) {
+		t.Error("a token issued after the revoked second was moved")
+	}
+
+	// The watermark never moves backwards
+	store.RevokeUserTokensBefore(7, now.Add(-time.Hour))
+	if watermark, _ := store.UserTokensRevokedBefore(7); !watermark.Equal(now.Truncate(time.Second)) {
+		t.Errorf("watermark = %s, want it kept at %s", watermark, now.Truncate(time.Second))
+	}
+}
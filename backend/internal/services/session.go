@@ -0,0 +1,46 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxSessions = 5
+
+// Session limit policies applied when a login would exceed the limit
+const (
+	SessionLimitEvictOldest = "evict_oldest"
+	SessionLimitReject      = "reject"
+)
+
+// MaxSessionsForRole returns the maximum number of concurrent sessions for a role.
+// Limits are read from SESSION_LIMITS ("role=n,role=n"), falling back to
+// SESSION_LIMIT_DEFAULT and then to 5.
+func MaxSessionsForRole(role string) int {
+	limit := defaultMaxSessions
+	if value, err := strconv.Atoi(os.Getenv("SESSION_LIMIT_DEFAULT")); err == nil && value > 0 {
+		limit = value
+	}
+
+	for _, entry := range strings.Split(os.Getenv("SESSION_LIMITS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != role {
+			continue
+		}
+		if value, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && value > 0 {
+			limit = value
+		}
+	}
+
+	return limit
+}
+
+// SessionLimitPolicy returns what to do when a login exceeds the session limit:
+// evict the least recently active session (default) or reject the login
+func SessionLimitPolicy() string {
+	if os.Getenv("SESSION_LIMIT_POLICY") == SessionLimitReject {
+		return SessionLimitReject
+	}
+	return SessionLimitEvictOldest
+}
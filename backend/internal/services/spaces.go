@@ -178,6 +178,45 @@ func (s *SpacesService) UploadFileFromPath(localPath, key string) (*UploadResult
 	}, nil
 }
 
+// UploadPublicObject uploads content that anyone can read, such as files generated by
+// the server rather than uploaded as-is
+func (s *SpacesService) UploadPublicObject(key string, content []byte, contentType string) (*UploadResult, error) {
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(contentType),
+		ACL:         "public-read",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return &UploadResult{
+		Key:         key,
+		URL:         fmt.Sprintf("https://%s.%s/%s", s.bucket, s.endpoint, key),
+		CDNURL:      fmt.Sprintf("%s/%s", s.cdnURL, key),
+		Size:        int64(len(content)),
+		ContentType: contentType,
+	}, nil
+}
+
+// UploadPrivateObject uploads content that is only reachable through a signed URL
+func (s *SpacesService) UploadPrivateObject(key string, content []byte, contentType string) error {
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(contentType),
+		ACL:         "private",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
 // GetFile retrieves file information
 func (s *SpacesService) GetFile(key string) (*FileInfo, error) {
 	result, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
@@ -138,6 +138,19 @@ func (s *StripeService) GetCustomer(customerID string) (*Customer, error) {
 	}, nil
 }
 
+// UpdateCustomerEmail changes the email address Stripe uses for a customer's receipts and invoices
+func (s *StripeService) UpdateCustomerEmail(customerID, email string) error {
+	params := &stripe.CustomerParams{
+		Email: stripe.String(email),
+	}
+
+	if _, err := customer.Update(customerID, params); err != nil {
+		return fmt.Errorf("failed to update customer email: %w", err)
+	}
+
+	return nil
+}
+
 // CreateSubscription creates a new subscription for a customer
 func (s *StripeService) CreateSubscription(customerID, priceID string) (*Subscription, error) {
 	params := &stripe.SubscriptionParams{
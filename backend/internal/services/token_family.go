@@ -0,0 +1,158 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+// ErrUnknownRefreshToken is returned when a refresh token has no family record
+var ErrUnknownRefreshToken = errors.New("refresh token is not recognized")
+
+// RefreshTokenReuseError is returned when a refresh token that was already rotated
+// is presented again. The whole family has been revoked by the time it is returned.
+type RefreshTokenReuseError struct {
+	UserID    int
+	FamilyID  string
+	TokenID   string
+	SessionID string
+	Revoked   int
+}
+
+func (e *RefreshTokenReuseError) Error() string {
+	return fmt.Sprintf("refresh token reuse detected for family %s", e.FamilyID)
+}
+
+// StartRefreshTokenFamily records the first refresh token of a newly issued pair
+// and links the family to the session it belongs to
+func StartRefreshTokenFamily(db *database.DB, userID int, sessionID string, pair *TokenPair) error {
+	return db.CreateRefreshToken(&database.RefreshToken{
+		TokenID:       pair.RefreshTokenID,
+		FamilyID:      pair.FamilyID,
+		UserID:        userID,
+		SessionID:     sql.NullString{String: sessionID, Valid: sessionID != ""},
+		AccessTokenID: sql.NullString{String: pair.AccessTokenID, Valid: pair.AccessTokenID != ""},
+		ExpiresAt:     pair.RefreshExpiresAt,
+	})
+}
+
+// RotateRefreshToken exchanges a refresh token for a new pair in the same family.
+// The presented token can never be used again. If it had already been rotated, every
+// token in its family is revoked and a *RefreshTokenReuseError is returned.
+func RotateRefreshToken(db *database.DB, refreshToken string) (*TokenPair, *Claims, error) {
+	// Verify the signature first, but defer the revocation check: a rotated token is
+	// already revoked, and we need to know that it was presented again
+	claims, err := parseRefreshClaims(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record, err := db.GetRefreshToken(claims.TokenID)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrUnknownRefreshToken
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load refresh token: %w", err)
+	}
+
+	if record.RotatedAt.Valid || record.RevokedAt.Valid {
+		return nil, nil, revokeRefreshTokenFamily(db, record)
+	}
+
+	if err := checkRevocation(claims); err != nil {
+		return nil, nil, err
+	}
+
+	pair, err := generateTokenPair(claims.UserID, claims.Email, claims.Role, claims.EmailVerified, record.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rotated, err := db.RotateRefreshToken(record.TokenID, &database.RefreshToken{
+		TokenID:       pair.RefreshTokenID,
+		FamilyID:      record.FamilyID,
+		UserID:        record.UserID,
+		SessionID:     record.SessionID,
+		AccessTokenID: sql.NullString{String: pair.AccessTokenID, Valid: true},
+		ExpiresAt:     pair.RefreshExpiresAt,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		// Another request rotated this token first, so it is being replayed
+		return nil, nil, revokeRefreshTokenFamily(db, record)
+	}
+
+	if err := RevokeTokenID(claims.TokenID, claims.ExpiresAt.Time); err != nil {
+		log.Printf("Failed to revoke rotated refresh token %s: %v", claims.TokenID, err)
+	}
+
+	return pair, claims, nil
+}
+
+// revokeRefreshTokenFamily revokes every live token in the record's family, including
+// the access tokens issued alongside them, and returns the resulting reuse error
+func revokeRefreshTokenFamily(db *database.DB, record *database.RefreshToken) error {
+	revoked, err := revokeFamilyTokens(db, record.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	return &RefreshTokenReuseError{
+		UserID:    record.UserID,
+		FamilyID:  record.FamilyID,
+		TokenID:   record.TokenID,
+		SessionID: record.SessionID.String,
+		Revoked:   revoked,
+	}
+}
+
+// RevokeSessionTokens deactivates a session and immediately revokes every refresh
+// and access token issued for it
+func RevokeSessionTokens(db *database.DB, session *database.Session) error {
+	if err := db.DeactivateSession(session.ID); err != nil {
+		return fmt.Errorf("failed to deactivate session: %w", err)
+	}
+
+	// Sessions created before token families existed only know their refresh token ID
+	if session.TokenID != "" {
+		if err := RevokeTokenID(session.TokenID, session.ExpiresAt); err != nil {
+			log.Printf("Failed to revoke token %s: %v", session.TokenID, err)
+		}
+	}
+
+	if session.FamilyID != "" {
+		if _, err := revokeFamilyTokens(db, session.FamilyID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revokeFamilyTokens marks a family revoked and adds its refresh and access token IDs
+// to the revocation store. It returns the number of refresh tokens revoked.
+func revokeFamilyTokens(db *database.DB, familyID string) (int, error) {
+	tokens, err := db.RevokeRefreshTokenFamily(familyID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	for _, token := range tokens {
+		if err := RevokeTokenID(token.TokenID, token.ExpiresAt); err != nil {
+			log.Printf("Failed to revoke refresh token %s: %v", token.TokenID, err)
+		}
+		if token.AccessTokenID.Valid {
+			if err := RevokeTokenID(token.AccessTokenID.String, time.Now().Add(accessTokenLifetime)); err != nil {
+				log.Printf("Failed to revoke access token %s: %v", token.AccessTokenID.String, err)
+			}
+		}
+	}
+
+	return len(tokens), nil
+}
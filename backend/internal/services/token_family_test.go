@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const testJWTSecret = "test-jwt-secret-for-unit-tests-only"
+
+// fakeSQLHandler answers a statement with result rows and the number of rows affected
+type fakeSQLHandler func(query string, args []driver.Value) ([][]driver.Value, int64)
+
+// fakeSQL is a database/sql driver that passes every statement to a handler, so tests
+// can keep the few tables they need in memory
+type fakeSQL struct {
+	mu     sync.Mutex
+	handle fakeSQLHandler
+}
+
+func newFakeDB(t *testing.T, handle fakeSQLHandler) *database.DB {
+	t.Helper()
+	db := sql.OpenDB(&fakeSQL{handle: handle})
+	t.Cleanup(func() { db.Close() })
+	return &database.DB{DB: db}
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) { return &fakeSQLConn{fake: f}, nil }
+func (f *fakeSQL) Driver() driver.Driver                        { return nil }
+
+func (f *fakeSQL) run(query string, args []driver.Value) ([][]driver.Value, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.handle(query, args)
+}
+
+type fakeSQLConn struct{ fake *fakeSQL }
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{fake: c.fake, query: query}, nil
+}
+func (c *fakeSQLConn) Close() error              { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) { return fakeSQLTx{}, nil }
+
+type fakeSQLTx struct{}
+
+func (fakeSQLTx) Commit() error   { return nil }
+func (fakeSQLTx) Rollback() error { return nil }
+
+type fakeSQLStmt struct {
+	fake  *fakeSQL
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, affected := s.fake.run(s.query, args)
+	return driver.RowsAffected(affected), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, _ := s.fake.run(s.query, args)
+	return &fakeSQLRows{rows: rows}, nil
+}
+
+type fakeSQLRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *fakeSQLRows) Close() error { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// refreshTokenTable keeps the refresh_tokens table in memory
+type refreshTokenTable struct {
+	tokens map[string]*database.RefreshToken
+	// beforeRotate runs before a rotation is applied, to simulate a concurrent request
+	beforeRotate func(tokenID string)
+}
+
+func newRefreshTokenTable() *refreshTokenTable {
+	return &refreshTokenTable{tokens: make(map[string]*database.RefreshToken)}
+}
+
+func nullString(value driver.Value) sql.NullString {
+	s, ok := value.(string)
+	return sql.NullString{String: s, Valid: ok}
+}
+
+func (r *refreshTokenTable) handle(query string, args []driver.Value) ([][]driver.Value, int64) {
+	now := time.Now()
+	switch {
+	case strings.Contains(query, "INSERT INTO refresh_tokens"):
+		r.tokens[args[0].(string)] = &database.RefreshToken{
+			TokenID:       args[0].(string),
+			FamilyID:      args[1].(string),
+			UserID:        int(args[2].(int64)),
+			SessionID:     nullString(args[3]),
+			AccessTokenID: nullString(args[4]),
+			ExpiresAt:     args[5].(time.Time),
+			CreatedAt:     now,
+		}
+		return nil, 1
+	case strings.Contains(query, "FROM refresh_tokens WHERE token_id"):
+		token, ok := r.tokens[args[0].(string)]
+		if !ok {
+			return nil, 0
+		}
+		return [][]driver.Value{{token.TokenID, token.FamilyID, int64(token.UserID), nullValue(token.SessionID), nullValue(token.AccessTokenID),
+			nullValue(token.ReplacedBy), nullTime(token.RotatedAt), nullTime(token.RevokedAt), token.ExpiresAt, token.CreatedAt}}, 1
+	case strings.Contains(query, "SET rotated_at"):
+		if r.beforeRotate != nil {
+			r.beforeRotate(args[1].(string))
+		}
+		token, ok := r.tokens[args[1].(string)]
+		if !ok || token.RotatedAt.Valid || token.RevokedAt.Valid {
+			return nil, 0
+		}
+		token.RotatedAt = sql.NullTime{Time: now, Valid: true}
+		token.ReplacedBy = sql.NullString{String: args[0].(string), Valid: true}
+		return nil, 1
+	case strings.Contains(query, "SET revoked_at"):
+		var rows [][]driver.Value
+		for _, token := range r.tokens {
+			if token.FamilyID != args[0].(string) || token.RevokedAt.Valid || !token.ExpiresAt.After(now) {
+				continue
+			}
+			token.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			rows = append(rows, []driver.Value{token.TokenID, token.FamilyID, int64(token.UserID), nullValue(token.SessionID), nullValue(token.AccessTokenID), token.ExpiresAt})
+		}
+		return rows, int64(len(rows))
+	}
+	// user_sessions bookkeeping is not needed here
+	return nil, 0
+}
+
+func nullValue(value sql.NullString) driver.Value {
+	if !value.Valid {
+		return nil
+	}
+	return value.String
+}
+
+func nullTime(value sql.NullTime) driver.Value {
+	if !value.Valid {
+		return nil
+	}
+	return value.Time
+}
+
+// startTestRefreshTokenFamily signs in a user and records the family's first refresh token
+func startTestRefreshTokenFamily(t *testing.T, db *database.DB) *TokenPair {
+	t.Helper()
+	pair, err := GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+	if err := StartRefreshTokenFamily(db, 7, "", pair); err != nil {
+		t.Fatalf("StartRefreshTokenFamily failed: %v", err)
+	}
+	return pair
+}
+
+func tokenRevoked(t *testing.T, tokenID string) bool {
+	t.Helper()
+	revoked, err := getRevocationStore().IsTokenRevoked(tokenID)
+	if err != nil {
+		t.Fatalf("IsTokenRevoked failed: %v", err)
+	}
+	return revoked
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	SetRevocationStore(NewMemoryRevocationStore())
+	table := newRefreshTokenTable()
+	db := newFakeDB(t, table.handle)
+
+	first := startTestRefreshTokenFamily(t, db)
+
+	second, claims, err := RotateRefreshToken(db, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if claims.UserID != 7 {
+		t.Errorf("claims user = %d, want 7", claims.UserID)
+	}
+	if second.FamilyID != first.FamilyID {
+		t.Errorf("rotated pair is in family %s, want %s", second.FamilyID, first.FamilyID)
+	}
+	if second.RefreshTokenID == first.RefreshTokenID {
+		t.Error("rotation reused the refresh token ID")
+	}
+	if old := table.tokens[first.RefreshTokenID]; !old.RotatedAt.Valid || old.ReplacedBy.String != second.RefreshTokenID {
+		t.Errorf("old token was not marked replaced by the new one: %+v", old)
+	}
+	if !tokenRevoked(t, first.RefreshTokenID) {
+		t.Error("rotated refresh token was not revoked")
+	}
+
+	third, _, err := RotateRefreshToken(db, second.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotating the new refresh token failed: %v", err)
+	}
+	if third.FamilyID != first.FamilyID {
+		t.Errorf("second rotation left the family")
+	}
+}
+
+func TestRotateRefreshTokenReuse(t *testing.T) {
+	tests := []struct {
+		name string
+		// replay presents a token that was already used and returns it
+		replay func(t *testing.T, db *database.DB, table *refreshTokenTable, first *TokenPair) string
+	}{
+		{
+			name: "rotated token presented again",
+			replay: func(t *testing.T, db *database.DB, table *refreshTokenTable, first *TokenPair) string {
+				if _, _, err := RotateRefreshToken(db, first.RefreshToken); err != nil {
+					t.Fatalf("first rotation failed: %v", err)
+				}
+				return first.RefreshToken
+			},
+		},
+		{
+			name: "concurrent rotation wins the race",
+			replay: func(t *testing.T, db *database.DB, table *refreshTokenTable, first *TokenPair) string {
+				table.beforeRotate = func(tokenID string) {
+					table.beforeRotate = nil
+					token := table.tokens[tokenID]
+					token.RotatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+					table.tokens["concurrent"] = &database.RefreshToken{TokenID: "concurrent", FamilyID: token.FamilyID, UserID: token.UserID, ExpiresAt: token.ExpiresAt}
+				}
+				return first.RefreshToken
+			},
+		},
+		{
+			name: "token from a revoked family",
+			replay: func(t *testing.T, db *database.DB, table *refreshTokenTable, first *TokenPair) string {
+				if _, err := revokeFamilyTokens(db, first.FamilyID); err != nil {
+					t.Fatalf("revokeFamilyTokens failed: %v", err)
+				}
+				return first.RefreshToken
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", testJWTSecret)
+			SetRevocationStore(NewMemoryRevocationStore())
+			table := newRefreshTokenTable()
+			db := newFakeDB(t, table.handle)
+
+			first := startTestRefreshTokenFamily(t, db)
+			replayed := tt.replay(t, db, table, first)
+
+			_, _, err := RotateRefreshToken(db, replayed)
+			var reuse *RefreshTokenReuseError
+			if !errors.As(err, &reuse) {
+				t.Fatalf("RotateRefreshToken() error = %v, want *RefreshTokenReuseError", err)
+			}
+			if reuse.UserID != 7 || reuse.FamilyID != first.FamilyID || reuse.TokenID != first.RefreshTokenID {
+				t.Errorf("reuse error = %+v, want user 7 in family %s for token %s", reuse, first.FamilyID, first.RefreshTokenID)
+			}
+
+			for id, token := range table.tokens {
+				if !token.RevokedAt.Valid {
+					t.Errorf("token %s in the family is still live", id)
+				}
+				if !tokenRevoked(t, id) {
+					t.Errorf("token %s is not in the revocation store", id)
+				}
+				if token.AccessTokenID.Valid && !tokenRevoked(t, token.AccessTokenID.String) {
+					t.Errorf("access token %s issued with %s was not revoked", token.AccessTokenID.String, id)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateRefreshTokenRejectsInvalidTokens(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	SetRevocationStore(NewMemoryRevocationStore())
+	table := newRefreshTokenTable()
+	db := newFakeDB(t, table.handle)
+
+	first := startTestRefreshTokenFamily(t, db)
+	unrecorded, err := GenerateTokenPair(7, "person@example.com", "user", true)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "access token", token: first.AccessToken},
+		{name: "garbage", token: "not-a-token"},
+		{name: "refresh token without a family record", token: unrecorded.RefreshToken, wantErr: ErrUnknownRefreshToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair, _, err := RotateRefreshToken(db, tt.token)
+			if err == nil {
+				t.Fatalf("RotateRefreshToken() = %+v, want an error", pair)
+			}
+			var reuse *RefreshTokenReuseError
+			if errors.As(err, &reuse) {
+				t.Fatalf("RotateRefreshToken() reported reuse for an invalid token")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("RotateRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if table.tokens[first.RefreshTokenID].RevokedAt.Valid {
+		t.Error("an invalid token revoked the family")
+	}
+}
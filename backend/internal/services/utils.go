@@ -207,6 +207,17 @@ func SanitizeString(input string) string {
 	return strings.TrimSpace(cleaned)
 }
 
+var nonSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a title into a lowercase, hyphen-separated slug for use in URLs
+func Slugify(title string) string {
+	slug := strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > 200 {
+		slug = strings.TrimRight(slug[:200], "-")
+	}
+	return slug
+}
+
 // GetClientIP extracts the real client IP from request
 func GetClientIP(remoteAddr, xForwardedFor, xRealIP string) string {
 	// Check X-Real-IP header first
@@ -317,162 +328,138 @@ func (rl *RateLimiter) cleanup() {
 
 // Global rate limiters for different operations
 var (
-	LoginRateLimiter    = NewRateLimiter(5, 15*time.Minute) // 5 attempts per 15 minutes
-	RegisterRateLimiter = NewRateLimiter(10, 1*time.Hour)   // 10 registrations per hour (increased for development)
-	PasswordRateLimiter = NewRateLimiter(3, 1*time.Hour)    // 3 password resets per hour
+	LoginRateLimiter     = NewRateLimiter(5, 15*time.Minute) // 5 attempts per 15 minutes
+	RegisterRateLimiter  = NewRateLimiter(10, 1*time.Hour)   // 10 registrations per hour (increased for development)
+	PasswordRateLimiter  = NewRateLimiter(3, 1*time.Hour)    // 3 password resets per hour
+	MagicLinkRateLimiter = NewRateLimiter(5, 1*time.Hour)    // 5 magic-link requests per hour
+	HeartbeatRateLimiter = NewRateLimiter(120, time.Minute)  // 120 anonymous playback heartbeats per minute
 )
 
-// EnhancedRateLimiter provides advanced rate limiting with account lockout
+// EnhancedRateLimiter provides advanced rate limiting with account lockout. Failed-attempt
+// counters and lockouts are kept in the active LoginAttemptStore, so they survive restarts
+// and are shared by replicas when the store is backed by PostgreSQL or Redis.
 type EnhancedRateLimiter struct {
-	loginAttempts  map[string][]time.Time
-	failedAttempts map[string]int
-	lockoutUntil   map[string]time.Time
-	mutex          sync.RWMutex
-	window         time.Duration
-	maxAttempts    int
-	lockoutTime    time.Duration
+	scope       string
+	window      time.Duration
+	maxAttempts int
+	lockoutTime time.Duration
+	blockIPs    bool
 }
 
-// NewEnhancedRateLimiter creates a new enhanced rate limiter
-func NewEnhancedRateLimiter(maxAttempts int, window, lockoutTime time.Duration) *EnhancedRateLimiter {
-	limiter := &EnhancedRateLimiter{
-		loginAttempts:  make(map[string][]time.Time),
-		failedAttempts: make(map[string]int),
-		lockoutUntil:   make(map[string]time.Time),
-		window:         window,
-		maxAttempts:    maxAttempts,
-		lockoutTime:    lockoutTime,
+// NewEnhancedRateLimiter creates a new enhanced rate limiter. The scope keeps the
+// counters of different limiters apart in the shared store.
+func NewEnhancedRateLimiter(scope string, maxAttempts int, window, lockoutTime time.Duration) *EnhancedRateLimiter {
+	return &EnhancedRateLimiter{
+		scope:       scope,
+		window:      window,
+		maxAttempts: maxAttempts,
+		lockoutTime: lockoutTime,
 	}
+}
 
-	// Start cleanup goroutine
-	go limiter.cleanup()
+// withIPBlocking makes failures also count toward blocking the client IP. Only
+// limiters whose failures are failed credential checks should block IPs.
+func (rl *EnhancedRateLimiter) withIPBlocking() *EnhancedRateLimiter {
+	rl.blockIPs = true
+	return rl
+}
 
-	return limiter
+// key returns the store key for an account in this limiter's scope
+func (rl *EnhancedRateLimiter) key(email string) string {
+	return rl.scope + ":" + email
 }
 
-// CheckLoginAttempt checks if a login attempt is allowed
+// CheckLoginAttempt checks if a login attempt is allowed. It fails open if the
+// store is unavailable so an outage does not lock every user out.
 func (rl *EnhancedRateLimiter) CheckLoginAttempt(email, ip string) bool {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+	if RemainingIPBlockTime(ip) > 0 {
+		return false
+	}
+
+	store := getLoginAttemptStore()
+	key := rl.key(email)
 
 	// Check if account is locked out
-	if lockoutTime, exists := rl.lockoutUntil[email]; exists && time.Now().Before(lockoutTime) {
+	lockedUntil, err := store.LockedUntil(key)
+	if err != nil {
+		log.Printf("Failed to check lockout for %s: %v", email, err)
+		return true
+	}
+	if time.Now().Before(lockedUntil) {
 		return false
 	}
 
 	// Check failed attempts
-	if failed := rl.failedAttempts[email]; failed >= rl.maxAttempts {
-		rl.lockoutUntil[email] = time.Now().Add(rl.lockoutTime)
+	failed, err := store.Failures(key)
+	if err != nil {
+		log.Printf("Failed to check failed attempts for %s: %v", email, err)
+		return true
+	}
+	if failed >= rl.maxAttempts {
+		if err := store.Lock(key, time.Now().Add(rl.lockoutTime)); err != nil {
+			log.Printf("Failed to lock out %s: %v", email, err)
+		}
 		return false
 	}
 
 	return true
 }
 
-// RecordFailedAttempt records a failed login attempt
+// RecordFailedAttempt records a failed attempt against the account, and against the
+// client IP for limiters that block IPs
 func (rl *EnhancedRateLimiter) RecordFailedAttempt(email, ip string) {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+	store := getLoginAttemptStore()
+	key := rl.key(email)
 
-	now := time.Now()
-	rl.failedAttempts[email]++
-
-	// Record attempt with timestamp
-	if rl.loginAttempts[email] == nil {
-		rl.loginAttempts[email] = make([]time.Time, 0)
+	failed, err := store.RecordFailure(key, rl.window)
+	if err != nil {
+		log.Printf("Failed to record failed attempt for %s: %v", email, err)
+	} else if failed >= rl.maxAttempts {
+		// If max attempts reached, set lockout
+		if err := store.Lock(key, time.Now().Add(rl.lockoutTime)); err != nil {
+			log.Printf("Failed to lock out %s: %v", email, err)
+		}
+		log.Printf("Account locked out: %s from %s for %v", email, ip, rl.lockoutTime)
 	}
-	rl.loginAttempts[email] = append(rl.loginAttempts[email], now)
 
-	// If max attempts reached, set lockout
-	if rl.failedAttempts[email] >= rl.maxAttempts {
-		rl.lockoutUntil[email] = now.Add(rl.lockoutTime)
-		log.Printf("Account locked out: %s from %s for %v", email, ip, rl.lockoutTime)
+	if rl.blockIPs {
+		recordIPFailure(ip, email)
 	}
 }
 
 // RecordSuccessfulAttempt resets failed attempts for successful login
 func (rl *EnhancedRateLimiter) RecordSuccessfulAttempt(email string) {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
-
-	rl.failedAttempts[email] = 0
-	delete(rl.lockoutUntil, email)
-
-	// Clear old attempts
-	if attempts, exists := rl.loginAttempts[email]; exists {
-		cutoff := time.Now().Add(-rl.window)
-		var validAttempts []time.Time
-		for _, attempt := range attempts {
-			if attempt.After(cutoff) {
-				validAttempts = append(validAttempts, attempt)
-			}
-		}
-		rl.loginAttempts[email] = validAttempts
+	if err := getLoginAttemptStore().Reset(rl.key(email)); err != nil {
+		log.Printf("Failed to reset failed attempts for %s: %v", email, err)
 	}
 }
 
 // GetRemainingLockoutTime returns remaining lockout time for an account
 func (rl *EnhancedRateLimiter) GetRemainingLockoutTime(email string) time.Duration {
-	rl.mutex.RLock()
-	defer rl.mutex.RUnlock()
-
-	if lockoutTime, exists := rl.lockoutUntil[email]; exists {
-		if remaining := time.Until(lockoutTime); remaining > 0 {
-			return remaining
-		}
+	lockedUntil, err := getLoginAttemptStore().LockedUntil(rl.key(email))
+	if err != nil {
+		return 0
+	}
+	if remaining := time.Until(lockedUntil); remaining > 0 {
+		return remaining
 	}
 	return 0
 }
 
 // GetFailedAttempts returns the number of failed attempts for an account
 func (rl *EnhancedRateLimiter) GetFailedAttempts(email string) int {
-	rl.mutex.RLock()
-	defer rl.mutex.RUnlock()
-	return rl.failedAttempts[email]
-}
-
-// cleanup removes old entries periodically
-func (rl *EnhancedRateLimiter) cleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		rl.mutex.Lock()
-		cutoff := time.Now().Add(-rl.window * 2)
-
-		// Clean up expired lockouts
-		for email, lockoutTime := range rl.lockoutUntil {
-			if lockoutTime.Before(cutoff) {
-				delete(rl.lockoutUntil, email)
-				delete(rl.failedAttempts, email)
-				delete(rl.loginAttempts, email)
-			}
-		}
-
-		// Clean up old attempts
-		for email, attempts := range rl.loginAttempts {
-			var validAttempts []time.Time
-			for _, attempt := range attempts {
-				if attempt.After(cutoff) {
-					validAttempts = append(validAttempts, attempt)
-				}
-			}
-			if len(validAttempts) == 0 {
-				delete(rl.loginAttempts, email)
-			} else {
-				rl.loginAttempts[email] = validAttempts
-			}
-		}
-
-		rl.mutex.Unlock()
+	failed, err := getLoginAttemptStore().Failures(rl.key(email))
+	if err != nil {
+		return 0
 	}
+	return failed
 }
 
 // Global enhanced rate limiters
 var (
-	EnhancedLoginRateLimiter    = NewEnhancedRateLimiter(5, 15*time.Minute, 15*time.Minute)
-	EnhancedRegisterRateLimiter = NewEnhancedRateLimiter(3, 1*time.Hour, 1*time.Hour)
-	EnhancedPasswordRateLimiter = NewEnhancedRateLimiter(3, 1*time.Hour, 1*time.Hour)
+	EnhancedLoginRateLimiter    = NewEnhancedRateLimiter("login", 5, 15*time.Minute, 15*time.Minute).withIPBlocking()
+	EnhancedRegisterRateLimiter = NewEnhancedRateLimiter("register", 3, 1*time.Hour, 1*time.Hour)
+	EnhancedPasswordRateLimiter = NewEnhancedRateLimiter("password", 3, 1*time.Hour, 1*time.Hour)
 )
 
 // GenerateDeviceFingerprint creates a device fingerprint from request headers
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	defaultVideoUploadExpiry  = 24 * time.Hour
+	maxVideoUploadExpiry      = 7 * 24 * time.Hour
+	defaultVideoUploadMaxSize = 20 << 30
+
+	// videoUploadHandOffHeartbeat is how often a running hand-off records progress
+	videoUploadHandOffHeartbeat = time.Minute
+	// videoUploadHandOffStaleAfter is how long a hand-off can go without recording
+	// progress before it is considered interrupted and started again
+	videoUploadHandOffStaleAfter = 10 * time.Minute
+	// videoUploadMaxHandOffs is how many times an upload is handed off before it fails
+	videoUploadMaxHandOffs = 3
+	// videoUploadBunnyAttempts is how many times a hand-off sends the upload to Bunny
+	// Stream before it is left to be resumed later
+	videoUploadBunnyAttempts = 3
+	// videoUploadBunnyRetryDelay is the wait before the first retry, doubled each time
+	videoUploadBunnyRetryDelay = 30 * time.Second
+)
+
+var (
+	// ErrUploadOffsetMismatch is returned when a chunk does not start where the upload left off
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	// ErrUploadChecksumMismatch is returned when a chunk does not match its checksum
+	ErrUploadChecksumMismatch = errors.New("upload checksum does not match")
+	// ErrUnsupportedChecksum is returned for checksum algorithms that are not supported
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	// ErrUploadTooLarge is returned when an upload exceeds its declared or permitted size
+	ErrUploadTooLarge = errors.New("upload exceeds its size")
+	// ErrUploadLocked is returned when another request is writing to the same upload
+	ErrUploadLocked = errors.New("upload is locked by another request")
+	// ErrUploadClosed is returned when an upload no longer accepts data
+	ErrUploadClosed = errors.New("upload is no longer accepting data")
+	// ErrUploadWrongInstance is returned when an upload's chunks are staged on another instance
+	ErrUploadWrongInstance = errors.New("upload is staged on another instance")
+)
+
+// VideoUploadChecksumAlgorithms lists the algorithms accepted in Upload-Checksum
+var VideoUploadChecksumAlgorithms = []string{"sha1", "sha256", "md5"}
+
+// VideoUploadExpiry returns how long an upload may go without receiving data before
+// it is abandoned, read from VIDEO_UPLOAD_EXPIRY and capped at seven days
+func VideoUploadExpiry() time.Duration {
+	expiry, err := time.ParseDuration(os.Getenv("VIDEO_UPLOAD_EXPIRY"))
+	if err != nil || expiry <= 0 {
+		return defaultVideoUploadExpiry
+	}
+	if expiry > maxVideoUploadExpiry {
+		return maxVideoUploadExpiry
+	}
+	return expiry
+}
+
+// VideoUploadMaxSize returns the largest upload accepted, in bytes, read from
+// VIDEO_UPLOAD_MAX_SIZE
+func VideoUploadMaxSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("VIDEO_UPLOAD_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultVideoUploadMaxSize
+	}
+	return size
+}
+
+// VideoUploadService stages resumable uploads on disk and hands completed uploads to
+// Bunny Stream. Staged chunks are only on the disk of the instance that created the
+// upload, so every request for an upload must reach that instance; requests that
+// reach another instance are rejected with ErrUploadWrongInstance.
+type VideoUploadService struct {
+	db    *database.DB
+	bunny *BunnyService
+	dir   string
+	host  string
+	locks sync.Map
+}
+
+// NewVideoUploadService creates an upload service that stages chunks in
+// VIDEO_UPLOAD_DIR, or a directory under the system temp directory. The instance is
+// identified by VIDEO_UPLOAD_INSTANCE_ID, or its hostname.
+func NewVideoUploadService(db *database.DB, bunny *BunnyService) *VideoUploadService {
+	dir := os.Getenv("VIDEO_UPLOAD_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "bome-uploads")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Printf("Failed to create video upload directory %s: %v", dir, err)
+	}
+
+	host := os.Getenv("VIDEO_UPLOAD_INSTANCE_ID")
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to read hostname for video uploads: %v", err)
+		}
+	}
+
+	return &VideoUploadService{
+		db:    db,
+		bunny: bunny,
+		dir:   dir,
+		host:  host,
+	}
+}
+
+// Start periodically resumes interrupted hand-offs, expires abandoned uploads and
+// removes old upload records
+func (s *VideoUploadService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.ResumeInterruptedHandOffs()
+			s.CleanupExpired()
+		}
+	}()
+}
+
+// stagedHere reports whether the upload's chunks are staged on this instance. Uploads
+// from before staging hosts were recorded are treated as local.
+func (s *VideoUploadService) stagedHere(upload *database.VideoUpload) bool {
+	return upload.StagingHost == "" || upload.StagingHost == s.host
+}
+
+// Create records a new upload of the given length and creates its staging file
+func (s *VideoUploadService) Create(upload *database.VideoUpload) (*database.VideoUpload, error) {
+	if upload.UploadLength > VideoUploadMaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	upload.ID = GenerateRandomToken(16)
+	upload.StagingHost = s.host
+	upload.ExpiresAt = time.Now().Add(VideoUploadExpiry())
+
+	file, err := os.OpenFile(s.path(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	file.Close()
+
+	created, err := s.db.CreateVideoUpload(upload)
+	if err != nil {
+		os.Remove(s.path(upload.ID))
+		return nil, err
+	}
+	return created, nil
+}
+
+// WriteChunk appends a chunk to an upload starting at offset and returns the new
+// offset. When checksum is set ("<algorithm> <base64 digest>"), a chunk that does not
+// match it is discarded. Without a checksum, data received before the client
+// disconnects is kept so the upload can resume from it. Once the last byte arrives,
+// the upload is handed off to Bunny Stream in the background.
+func (s *VideoUploadService) WriteChunk(upload *database.VideoUpload, offset int64, body io.Reader, checksum string) (int64, error) {
+	lock, _ := s.locks.LoadOrStore(upload.ID, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		return 0, ErrUploadLocked
+	}
+	defer mu.Unlock()
+
+	// Reload under the lock so a concurrent chunk that just finished is accounted for
+	current, err := s.db.GetVideoUpload(upload.ID)
+	if err != nil {
+		return 0, err
+	}
+	if !s.stagedHere(current) {
+		return 0, ErrUploadWrongInstance
+	}
+	if current.Status != "uploading" || current.ExpiresAt.Before(time.Now()) {
+		return 0, ErrUploadClosed
+	}
+	if offset != current.UploadOffset {
+		return 0, ErrUploadOffsetMismatch
+	}
+
+	var hasher hash.Hash
+	var expected []byte
+	if checksum != "" {
+		if hasher, expected, err = parseUploadChecksum(checksum); err != nil {
+			return 0, err
+		}
+	}
+
+	file, err := os.OpenFile(s.path(current.ID), os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open staging file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek staging file: %w", err)
+	}
+
+	// Read one byte past the remaining length to detect chunks that overrun the upload
+	remaining := current.UploadLength - offset
+	var dst io.Writer = file
+	if hasher != nil {
+		dst = io.MultiWriter(file, hasher)
+	}
+	written, copyErr := io.Copy(dst, io.LimitReader(body, remaining+1))
+
+	discard := func(cause error) (int64, error) {
+		if err := file.Truncate(offset); err != nil {
+			log.Printf("Failed to truncate upload %s: %v", current.ID, err)
+		}
+		return 0, cause
+	}
+
+	if written > remaining {
+		return discard(ErrUploadTooLarge)
+	}
+	if hasher != nil {
+		if copyErr != nil {
+			return discard(copyErr)
+		}
+		if string(hasher.Sum(nil)) != string(expected) {
+			return discard(ErrUploadChecksumMismatch)
+		}
+	}
+	if written == 0 {
+		return offset, copyErr
+	}
+
+	newOffset := offset + written
+	if err := s.db.AdvanceVideoUpload(current.ID, offset, newOffset, time.Now().Add(VideoUploadExpiry())); err != nil {
+		if err == sql.ErrNoRows {
+			return discard(ErrUploadOffsetMismatch)
+		}
+		return discard(err)
+	}
+
+	if newOffset == current.UploadLength {
+		if err := s.db.StartVideoUploadHandOff(current.ID, time.Now().Add(VideoUploadExpiry())); err != nil {
+			return newOffset, err
+		}
+		current.UploadOffset = newOffset
+		current.HandOffs++
+		go s.handOff(current)
+	}
+	return newOffset, copyErr
+}
+
+// Terminate abandons an upload that has not finished and removes its staged data
+func (s *VideoUploadService) Terminate(upload *database.VideoUpload) error {
+	lock, _ := s.locks.LoadOrStore(upload.ID, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		return ErrUploadLocked
+	}
+	defer mu.Unlock()
+
+	if !s.stagedHere(upload) {
+		return ErrUploadWrongInstance
+	}
+	if upload.Status == "processing" || upload.Status == "completed" {
+		return ErrUploadClosed
+	}
+
+	s.removeStagingFile(upload.ID)
+	return s.db.DeleteVideoUpload(upload.ID)
+}
+
+// ResumeInterruptedHandOffs hands off again the uploads staged here whose hand-off
+// stopped making progress, for example because of a restart or because Bunny Stream
+// kept failing. Uploads that were handed off too many times fail and their staged data
+// is removed.
+func (s *VideoUploadService) ResumeInterruptedHandOffs() {
+	uploads, err := s.db.ClaimStaleVideoUploadHandOffs(s.host, videoUploadHandOffStaleAfter, time.Now().Add(VideoUploadExpiry()))
+	if err != nil {
+		log.Printf("Failed to claim interrupted video upload hand-offs: %v", err)
+		return
+	}
+
+	for _, upload := range uploads {
+		if upload.HandOffs > videoUploadMaxHandOffs {
+			log.Printf("Video upload %s failed: hand-off did not complete in %d attempts", upload.ID, upload.HandOffs-1)
+			if err := s.db.SetVideoUploadStatus(upload.ID, "failed", "hand-off to Bunny Stream did not complete"); err != nil {
+				log.Printf("Failed to mark video upload %s as failed: %v", upload.ID, err)
+			}
+			s.removeStagingFile(upload.ID)
+			continue
+		}
+
+		log.Printf("Resuming interrupted hand-off of video upload %s (attempt %d)", upload.ID, upload.HandOffs)
+		go s.handOff(upload)
+	}
+}
+
+// CleanupExpired marks uploads staged here that stopped receiving data, or whose
+// hand-off stopped making progress, as expired, removes their staged data and deletes
+// old upload records
+func (s *VideoUploadService) CleanupExpired() {
+	uploads, err := s.db.GetExpiredVideoUploads(s.host)
+	if err != nil {
+		log.Printf("Failed to list expired video uploads: %v", err)
+		return
+	}
+
+	for _, upload := range uploads {
+		lock, _ := s.locks.LoadOrStore(upload.ID, &sync.Mutex{})
+		mu := lock.(*sync.Mutex)
+		if !mu.TryLock() {
+			continue
+		}
+
+		if err := s.db.SetVideoUploadStatus(upload.ID, "expired", ""); err != nil {
+			log.Printf("Failed to expire video upload %s: %v", upload.ID, err)
+		} else {
+			s.removeStagingFile(upload.ID)
+		}
+		mu.Unlock()
+	}
+
+	if err := s.db.CleanupFinishedVideoUploads(); err != nil {
+		log.Printf("Failed to cleanup finished video uploads: %v", err)
+	}
+}
+
+// handOff streams a completed upload to Bunny Stream and creates its video in the
+// processing state. Temporary Bunny Stream failures are retried with backoff; if they
+// persist, the staged data is kept and the hand-off is resumed later by
+// ResumeInterruptedHandOffs. The staged data is removed once the hand-off succeeds or
+// fails for good. While it runs, progress is recorded so the hand-off is not claimed
+// again as interrupted.
+func (s *VideoUploadService) handOff(upload *database.VideoUpload) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(videoUploadHandOffHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.db.TouchVideoUploadHandOff(upload.ID, time.Now().Add(VideoUploadExpiry())); err != nil {
+					log.Printf("Failed to record hand-off progress of video upload %s: %v", upload.ID, err)
+				}
+			}
+		}
+	}()
+
+	fail := func(reason string, err error) {
+		log.Printf("Video upload %s failed: %s: %v", upload.ID, reason, err)
+		if err := s.db.SetVideoUploadStatus(upload.ID, "failed", reason); err != nil {
+			log.Printf("Failed to mark video upload %s as failed: %v", upload.ID, err)
+		}
+		s.removeStagingFile(upload.ID)
+	}
+
+	file, err := os.Open(s.path(upload.ID))
+	if err != nil {
+		fail("staged upload is missing", err)
+		return
+	}
+	defer file.Close()
+
+	var uploadResp *BunnyUploadResponse
+	for attempt := 1; ; attempt++ {
+		if _, err = file.Seek(0, io.SeekStart); err == nil {
+			uploadResp, err = s.bunny.UploadVideo(file, upload.UploadLength, upload.Title)
+		}
+		if err == nil || !isTemporaryBunnyError(err) || attempt == videoUploadBunnyAttempts {
+			break
+		}
+		log.Printf("Retrying hand-off of video upload %s to Bunny Stream: %v", upload.ID, err)
+		time.Sleep(videoUploadBunnyRetryDelay << (attempt - 1))
+	}
+	if err != nil {
+		if isTemporaryBunnyError(err) && upload.HandOffs < videoUploadMaxHandOffs {
+			log.Printf("Hand-off of video upload %s to Bunny Stream failed, keeping it staged to try again: %v", upload.ID, err)
+			return
+		}
+		fail("failed to upload video to Bunny Stream", err)
+		return
+	}
+
+	video, err := s.db.CreateVideo(upload.Title, upload.Description, uploadResp.VideoID, "", upload.Category, 0, upload.UploadLength, upload.Tags, upload.UserID)
+	if err != nil {
+		fail("failed to save video metadata", err)
+		return
+	}
+
+	if err := s.db.CompleteVideoUpload(upload.ID, video.ID); err != nil {
+		log.Printf("Failed to mark video upload %s as completed: %v", upload.ID, err)
+	}
+	s.removeStagingFile(upload.ID)
+	log.Printf("Video upload %s handed off to Bunny Stream as video %d", upload.ID, video.ID)
+}
+
+func (s *VideoUploadService) path(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+func (s *VideoUploadService) removeStagingFile(id string) {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove staged upload %s: %v", id, err)
+	}
+	s.locks.Delete(id)
+}
+
+// parseUploadChecksum parses an Upload-Checksum value of the form
+// "<algorithm> <base64 digest>"
+func parseUploadChecksum(value string) (hash.Hash, []byte, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return nil, nil, ErrUnsupportedChecksum
+	}
+
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, nil, ErrUnsupportedChecksum
+	}
+
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New(), digest, nil
+	case "sha256":
+		return sha256.New(), digest, nil
+	case "md5":
+		return md5.New(), digest, nil
+	}
+	return nil, nil, ErrUnsupportedChecksum
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"bome-backend/internal/database"
+)
+
+const (
+	defaultViewQualifySeconds = 30
+	defaultViewDedupWindow    = 24 * time.Hour
+	maxViewDedupWindow        = 30 * 24 * time.Hour
+	// viewTallyIdleTimeout is how long watch time toward a view is kept without a heartbeat
+	viewTallyIdleTimeout = time.Hour
+	// viewClaimCleanupInterval is how often expired view claims are removed
+	viewClaimCleanupInterval = time.Hour
+)
+
+// ViewQualifySeconds returns how many seconds of a video must be watched for a view to
+// count, read from VIEW_QUALIFY_SECONDS
+func ViewQualifySeconds() int {
+	seconds, err := strconv.Atoi(os.Getenv("VIEW_QUALIFY_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultViewQualifySeconds
+	}
+	return seconds
+}
+
+// ViewDedupWindow returns how long a viewer's view of a video counts once, read from
+// VIEW_DEDUP_WINDOW and capped at thirty days
+func ViewDedupWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("VIEW_DEDUP_WINDOW"))
+	if err != nil || window <= 0 {
+		return defaultViewDedupWindow
+	}
+	if window > maxViewDedupWindow {
+		return maxViewDedupWindow
+	}
+	return window
+}
+
+// UserViewerKey identifies a signed-in viewer for view counting
+func UserViewerKey(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// AnonymousViewerKey identifies an anonymous viewer for view counting by a hash of their
+// IP address and user agent, so neither is stored
+func AnonymousViewerKey(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "\n" + userAgent))
+	return "anon:" + hex.EncodeToString(sum[:16])
+}
+
+type viewKey struct {
+	viewer    string
+	mediaType string
+	mediaID   string
+}
+
+type viewTally struct {
+	seconds  int
+	duration int
+	queued   bool
+	lastSeen time.Time
+}
+
+// ViewCounter counts qualified views. Watch time reported by heartbeats is tallied per
+// viewer and video, and once a viewer has watched long enough the view is queued.
+// Queued views are written in batches, counting each viewer once per video per
+// dedup window.
+type ViewCounter struct {
+	db             *database.DB
+	qualifySeconds int
+	window         time.Duration
+
+	mu      sync.Mutex
+	tallies map[viewKey]*viewTally
+	queue   map[viewKey]struct{}
+}
+
+// NewViewCounter creates a view counter using the configured qualifying watch time and
+// dedup window
+func NewViewCounter(db *database.DB) *ViewCounter {
+	return &ViewCounter{
+		db:             db,
+		qualifySeconds: ViewQualifySeconds(),
+		window:         ViewDedupWindow(),
+		tallies:        make(map[viewKey]*viewTally),
+		queue:          make(map[viewKey]struct{}),
+	}
+}
+
+// Start periodically writes queued views and removes expired view claims
+func (v *ViewCounter) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastCleanup := time.Now()
+		for range ticker.C {
+			v.Flush()
+
+			if time.Since(lastCleanup) >= viewClaimCleanupInterval {
+				lastCleanup = time.Now()
+				if removed, err := v.db.CleanupVideoViewClaims(v.window); err != nil {
+					log.Printf("Failed to clean up view claims: %v", err)
+				} else if removed > 0 {
+					log.Printf("Removed %d expired view claims", removed)
+				}
+			}
+		}
+	}()
+}
+
+// Watching reports whether a viewer's watch time on a video is being tallied
+func (v *ViewCounter) Watching(viewer, mediaType, mediaID string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, ok := v.tallies[viewKey{viewer: viewer, mediaType: mediaType, mediaID: mediaID}]
+	return ok
+}
+
+// Observe adds watched seconds to a viewer's tally for a video and queues a view once
+// the tally qualifies. A heartbeat is credited no more than the time since the viewer's
+// previous heartbeat, so the first heartbeat only starts the tally. durationSeconds is
+// the video's stored duration, remembered from the first heartbeat; videos shorter than
+// twice the qualifying time qualify at half their duration.
+func (v *ViewCounter) Observe(viewer, mediaType, mediaID string, watchedSeconds, durationSeconds int) {
+	key := viewKey{viewer: viewer, mediaType: mediaType, mediaID: mediaID}
+	now := time.Now()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	tally, ok := v.tallies[key]
+	if !ok {
+		v.tallies[key] = &viewTally{duration: durationSeconds, lastSeen: now}
+		return
+	}
+
+	if elapsed := int(now.Sub(tally.lastSeen) / time.Second); watchedSeconds > elapsed {
+		watchedSeconds = elapsed
+	}
+	if watchedSeconds > 0 {
+		tally.seconds += watchedSeconds
+	}
+	tally.lastSeen = now
+
+	threshold := v.qualifySeconds
+	if tally.duration > 0 && tally.duration/2 < threshold {
+		threshold = tally.duration / 2
+		if threshold < 1 {
+			threshold = 1
+		}
+	}
+
+	if !tally.queued && tally.seconds >= threshold {
+		tally.queued = true
+		v.queue[key] = struct{}{}
+	}
+}
+
+// Flush writes queued views and forgets tallies that have gone idle
+func (v *ViewCounter) Flush() {
+	v.mu.Lock()
+	queue := v.queue
+	v.queue = make(map[viewKey]struct{})
+	for key, tally := range v.tallies {
+		if time.Since(tally.lastSeen) > viewTallyIdleTimeout {
+			delete(v.tallies, key)
+		}
+	}
+	v.mu.Unlock()
+
+	if len(queue) == 0 {
+		return
+	}
+
+	claims := make([]database.VideoViewClaim, 0, len(queue))
+	for key := range queue {
+		claims = append(claims, database.VideoViewClaim{ViewerKey: key.viewer, MediaType: key.mediaType, MediaID: key.mediaID})
+	}
+
+	if _, err := v.db.RecordVideoViews(claims, v.window); err != nil {
+		log.Printf("Failed to record %d views: %v", len(claims), err)
+
+		v.mu.Lock()
+		for key := range queue {
+			v.queue[key] = struct{}{}
+		}
+		v.mu.Unlock()
+	}
+}
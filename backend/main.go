@@ -17,6 +17,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -65,10 +66,43 @@ func main() {
 		}
 	}
 
-	// Skip Redis for development (not needed for basic functionality)
+	// Initialize Redis if enabled (optional in development)
 	var redis *database.Redis
-	log.Println("Skipping Redis for development...")
-	redis = nil
+	if cfg.RedisEnabled {
+		redis, err = database.NewRedis(cfg)
+		if err != nil {
+			log.Printf("Failed to connect to Redis: %v", err)
+			log.Println("Continuing without Redis...")
+			redis = nil
+		} else {
+			defer redis.Close()
+		}
+	} else {
+		log.Println("Redis disabled, skipping...")
+	}
+
+	// Select the token revocation backend
+	services.SetRevocationStore(newRevocationStore(cfg.TokenRevocationBackend, db, redis))
+
+	// Select where failed logins and lockouts are kept
+	services.SetLoginAttemptStore(newLoginAttemptStore(cfg.LoginAttemptBackend, db, redis))
+
+	// Set up the JWT signing keyring
+	keyring, err := newKeyring(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize JWT signing keys: %v", err)
+	}
+	services.SetKeyring(keyring)
+	if cfg.JWTLegacyTokensUntil != "" {
+		if cutover, err := time.Parse(time.RFC3339, cfg.JWTLegacyTokensUntil); err != nil {
+			log.Printf("Invalid JWT_LEGACY_TOKENS_UNTIL %q, tokens without a key ID are rejected: %v", cfg.JWTLegacyTokensUntil, err)
+		} else if err := services.SetLegacyTokenCutover(cutover); err != nil {
+			log.Printf("Tokens without a key ID are rejected: %v", err)
+		}
+	}
+
+	// Register OpenID Connect sign-in providers
+	services.SetOIDCProviders(newOIDCProviders(cfg))
 
 	// Initialize services
 	bunnyService := services.NewBunnyService()
@@ -80,7 +114,15 @@ func main() {
 		spacesService = nil
 	}
 	emailService := services.NewEmailService()
+	privacyService := services.NewPrivacyService(db, spacesService, stripeService, emailService)
+	videoUploads := services.NewVideoUploadService(db, bunnyService)
+	bunnySync := services.NewBunnySyncService(db, bunnyService)
+	viewCounter := services.NewViewCounter(db)
+	playback := services.NewPlaybackService(db, services.NewYouTubeService(db), viewCounter)
+	recommendations := services.NewRecommendationService(db)
 	services.StartTokenBlacklistCleanup()
+	services.StartLoginAttemptCleanup()
+	services.StartKeyRotation()
 
 	// Start database cleanup tasks if database is available
 	if db != nil {
@@ -99,10 +141,58 @@ func main() {
 					log.Printf("Failed to cleanup expired tokens: %v", err)
 				}
 
+				// Clean up expired refresh token families
+				if err := db.CleanupExpiredRefreshTokens(); err != nil {
+					log.Printf("Failed to cleanup expired refresh tokens: %v", err)
+				}
+
+				// Clean up expired role assignments and permission overrides
+				if err := db.CleanupExpiredRoleGrants(); err != nil {
+					log.Printf("Failed to cleanup expired role grants: %v", err)
+				}
+
+				// Clean up expired magic links
+				if err := db.CleanupExpiredMagicLinkTokens(); err != nil {
+					log.Printf("Failed to cleanup expired magic link tokens: %v", err)
+				}
+
+				// Clean up email changes that were never confirmed
+				if err := db.CleanupExpiredEmailChangeRequests(); err != nil {
+					log.Printf("Failed to cleanup expired email change requests: %v", err)
+				}
+
+				// Clean up abandoned OpenID Connect sign-in requests
+				if err := db.CleanupExpiredOIDCAuthStates(); err != nil {
+					log.Printf("Failed to cleanup expired OIDC states: %v", err)
+				}
+
 				log.Println("Database cleanup completed")
 			}
 		}()
 		log.Println("Database cleanup tasks started")
+
+		// Build data exports and erase accounts whose deletion grace period has ended
+		privacyService.Start(time.Minute)
+
+		// Expire abandoned resumable uploads and discard their staged chunks
+		videoUploads.Start(15 * time.Minute)
+
+		// Run queued Bunny library syncs
+		bunnySync.Start(15 * time.Second)
+
+		// Write coalesced playback heartbeats
+		playback.Start(30 * time.Second)
+
+		// Write qualified views in batches
+		viewCounter.Start(time.Minute)
+
+		// Precompute related videos
+		recommendations.Start(6 * time.Hour)
+	}
+
+	// Cookie sessions can only make changes with CSRF tokens signed by a dedicated secret
+	if services.AuthCookiesEnabled() && os.Getenv("CSRF_SECRET") == "" {
+		log.Fatal("CSRF_SECRET is required when AUTH_COOKIES_ENABLED is set")
 	}
 
 	// Create Gin router
@@ -113,10 +203,11 @@ func main() {
 	router.Use(middleware.SecurityHeaders())
 	router.Use(middleware.CORS())
 	router.Use(middleware.RateLimiting())
+	router.Use(middleware.CSRFProtection())
 
 	// Setup routes
 	log.Println("Setting up routes...")
-	routes.SetupRoutes(router, cfg, db, redis, bunnyService, stripeService, spacesService, emailService)
+	routes.SetupRoutes(router, cfg, db, redis, bunnyService, stripeService, spacesService, emailService, privacyService, videoUploads, bunnySync, playback, recommendations)
 	log.Println("Routes setup completed successfully")
 
 	// Create HTTP server
@@ -150,5 +241,102 @@ func main() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	// Save playback positions and views that have not been written yet
+	if db != nil {
+		playback.Flush()
+		viewCounter.Flush()
+	}
+
 	log.Println("Server exited")
 }
+
+// newRevocationStore picks the token revocation backend. In "auto" mode Redis is
+// preferred, then PostgreSQL, falling back to the in-memory store.
+func newRevocationStore(backend string, db *database.DB, redis *database.Redis) services.RevocationStore {
+	switch backend {
+	case "redis", "auto":
+		if redis != nil {
+			log.Println("Using Redis token revocation store")
+			return services.NewRedisRevocationStore(redis)
+		}
+		if backend == "redis" {
+			log.Println("Redis token revocation store requested but Redis is unavailable")
+		}
+		fallthrough
+	case "postgres":
+		if db != nil {
+			log.Println("Using PostgreSQL token revocation store")
+			return services.NewPostgresRevocationStore(db)
+		}
+		if backend == "postgres" {
+			log.Println("PostgreSQL token revocation store requested but database is unavailable")
+		}
+	}
+
+	log.Println("Using in-memory token revocation store (revocations are not shared between instances)")
+	return services.NewMemoryRevocationStore()
+}
+
+// newLoginAttemptStore picks the login attempt backend. In "auto" mode Redis is
+// preferred, then PostgreSQL, falling back to the in-memory store.
+func newLoginAttemptStore(backend string, db *database.DB, redis *database.Redis) services.LoginAttemptStore {
+	switch backend {
+	case "redis", "auto":
+		if redis != nil {
+			log.Println("Using Redis login attempt store")
+			return services.NewRedisLoginAttemptStore(redis)
+		}
+		if backend == "redis" {
+			log.Println("Redis login attempt store requested but Redis is unavailable")
+		}
+		fallthrough
+	case "postgres":
+		if db != nil {
+			log.Println("Using PostgreSQL login attempt store")
+			return services.NewPostgresLoginAttemptStore(db)
+		}
+		if backend == "postgres" {
+			log.Println("PostgreSQL login attempt store requested but database is unavailable")
+		}
+	}
+
+	log.Println("Using in-memory login attempt store (lockouts are lost on restart)")
+	return services.NewMemoryLoginAttemptStore()
+}
+
+// newKeyring creates the JWT signing keyring. Keys are stored in PostgreSQL so every
+// replica shares them; without a database they are kept in memory and never rotated.
+func newKeyring(cfg *config.Config, db *database.DB) (*services.Keyring, error) {
+	rotationInterval := services.DefaultKeyRotationInterval
+	if cfg.JWTKeyRotationInterval == "0" {
+		rotationInterval = 0
+	} else if interval, err := time.ParseDuration(cfg.JWTKeyRotationInterval); err == nil {
+		rotationInterval = interval
+	} else {
+		log.Printf("Invalid JWT_KEY_ROTATION_INTERVAL %q, using %s", cfg.JWTKeyRotationInterval, rotationInterval)
+	}
+
+	if db != nil {
+		store, err := services.NewPostgresSigningKeyStore(db)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using PostgreSQL JWT signing key store (%s)", cfg.JWTSigningAlgorithm)
+		return services.NewKeyring(store, cfg.JWTSigningAlgorithm, rotationInterval)
+	}
+
+	log.Println("Using in-memory JWT signing keys; keys are not shared between instances or rotated")
+	return services.NewKeyring(services.NewMemorySigningKeyStore(services.EnvironmentSigningKey()), cfg.JWTSigningAlgorithm, 0)
+}
+
+// newOIDCProviders creates the configured OpenID Connect providers. Each provider
+// redirects back to OIDC_REDIRECT_URL followed by its name, e.g. /auth/callback/google.
+func newOIDCProviders(cfg *config.Config) []*services.OIDCProvider {
+	providers := make([]*services.OIDCProvider, 0, len(cfg.OIDCProviders))
+	for _, provider := range cfg.OIDCProviders {
+		redirectURL := strings.TrimSuffix(cfg.OIDCRedirectURL, "/") + "/" + provider.Name
+		providers = append(providers, services.NewOIDCProvider(provider.Name, provider.Issuer, provider.ClientID, provider.ClientSecret, provider.Scopes, redirectURL))
+		log.Printf("OIDC sign-in provider enabled: %s (%s)", provider.Name, provider.Issuer)
+	}
+	return providers
+}
@@ -442,11 +442,12 @@ export const videoService = {
 		}
 	},
 
-	// Sync Bunny.net videos (admin only)
-	syncBunnyVideos: async (): Promise<any> => {
+	// Queue a Bunny.net library sync (admin only); poll /admin/bunny-sync/jobs/:id for progress
+	syncBunnyVideos: async (dryRun = false): Promise<any> => {
 		try {
-			const response = await apiRequestWithRetry('/sync-bunny-videos', {
+			const response = await apiRequestWithRetry('/admin/bunny-sync/jobs', {
 				method: 'POST',
+				body: JSON.stringify({ dry_run: dryRun }),
 			});
 			
 			if (!response.ok) {
@@ -23,26 +23,31 @@ try {
     Write-Host "Error checking Bunny.net config: $($_.Exception.Message)" -ForegroundColor Red
 }
 
-# Test 3: Try to sync videos
-Write-Host "`n3. Attempting to sync videos from Bunny.net..." -ForegroundColor Yellow
+# Test 3: Queue a library sync and wait for it (needs an admin token in $env:BOME_ADMIN_TOKEN)
+Write-Host "`n3. Syncing videos from Bunny.net..." -ForegroundColor Yellow
 try {
-    $syncResult = Invoke-RestMethod -Uri "http://localhost:8080/api/v1/test/sync-bunny-videos" -Method POST
-    Write-Host "Sync result: $($syncResult.message)" -ForegroundColor Green
-    Write-Host "Total videos found: $($syncResult.total_videos)"
-    Write-Host "Synced: $($syncResult.synced)"
-    Write-Host "Skipped: $($syncResult.skipped)"
-    Write-Host "Errors: $($syncResult.errors)"
+    $headers = @{ Authorization = "Bearer $env:BOME_ADMIN_TOKEN" }
+    $started = Invoke-RestMethod -Uri "http://localhost:8080/api/v1/admin/bunny-sync/jobs" -Method POST -Headers $headers
+    $jobId = $started.job.id
+    Write-Host "Sync job $jobId queued"
+
+    do {
+        Start-Sleep -Seconds 2
+        $job = (Invoke-RestMethod -Uri "http://localhost:8080/api/v1/admin/bunny-sync/jobs/$jobId" -Headers $headers).job
+        Write-Host "  $($job.status) $($job.phase) $($job.progress)%"
+    } while ($job.status -eq "queued" -or $job.status -eq "running")
+
+    Write-Host "Sync result: $($job.status)" -ForegroundColor Green
+    Write-Host "Total videos found: $($job.total_items)"
+    Write-Host "Created: $($job.created)"
+    Write-Host "Updated: $($job.updated)"
+    Write-Host "Deleted: $($job.deleted)"
+    Write-Host "Failed: $($job.failed)"
+    if ($job.last_error) {
+        Write-Host "Error: $($job.last_error)" -ForegroundColor Red
+    }
 } catch {
     Write-Host "Error syncing videos: $($_.Exception.Message)" -ForegroundColor Red
-    Write-Host "Trying alternative endpoint..." -ForegroundColor Yellow
-    
-    # Try the admin endpoint
-    try {
-        $syncResult = Invoke-RestMethod -Uri "http://localhost:8080/api/v1/admin/sync-bunny-videos" -Method POST
-        Write-Host "Admin sync result: $($syncResult.message)" -ForegroundColor Green
-    } catch {
-        Write-Host "Admin sync also failed: $($_.Exception.Message)" -ForegroundColor Red
-    }
 }
 
 # Test 4: Check videos after sync